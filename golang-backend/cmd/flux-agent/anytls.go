@@ -18,8 +18,8 @@ import (
 	"log"
 	"math/big"
 	"net"
+	"net/http"
 	"net/netip"
-	"net/url"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -31,7 +31,6 @@ import (
 	"time"
 
 	"anytls/proxy"
-	"anytls/proxy/padding"
 	"anytls/proxy/session"
 	"github.com/sagernet/sing/common/buf"
 	"github.com/sagernet/sing/common/bufio"
@@ -56,18 +55,35 @@ type anytlsConfig struct {
 	// AllowFallback enables IPv4/IPv6 fallback when exitIp family doesn't have DNS record.
 	AllowFallback bool             `json:"allowFallback,omitempty"`
 	Users         []anytlsUserRule `json:"users,omitempty"`
+	// Decoy serves clients that fail authentication (see anytls_decoy.go).
+	Decoy *anytlsDecoy `json:"decoy,omitempty"`
+	// Outbound blocks or reroutes destinations (see anytls_outbound.go).
+	Outbound *exitOutbound `json:"outbound,omitempty"`
+	// TLS assigns a domain with an ACME-managed certificate (see anytls_acme.go).
+	TLS *anytlsTLS `json:"tls,omitempty"`
+	// Padding is the padding scheme of the primary listener; empty: default.
+	Padding string `json:"padding,omitempty"`
+	// Inbounds are extra listeners (see anytls_inbound.go).
+	Inbounds []anytlsInbound `json:"inbounds,omitempty"`
 }
 
 type anytlsUserRule struct {
 	UserID   int64  `json:"userId"`
 	Password string `json:"password"`
 	SpeedBps int64  `json:"speedBps,omitempty"`
+	// session/IP limits, see anytls_limit.go
+	MaxSessions int    `json:"maxSessions,omitempty"`
+	MaxIPs      int    `json:"maxIps,omitempty"`
+	LimitMode   string `json:"limitMode,omitempty"` // reject | evict
 }
 
 type anytlsAuthRule struct {
-	userID   int64
-	hash     []byte
-	speedBps int64
+	userID      int64
+	hash        []byte
+	speedBps    int64
+	maxSessions int
+	maxIPs      int
+	limitMode   string
 }
 
 type anytlsServer struct {
@@ -76,6 +92,9 @@ type anytlsServer struct {
 	localTCPAddr  *net.TCPAddr
 	localUDPAddr  *net.UDPAddr
 	allowFallback bool
+	decoy         http.Handler
+	outbound      *outboundRules
+	padding       *anytlsPaddingRef
 }
 
 var (
@@ -115,7 +134,7 @@ func saveAnyTLSConfig(cfg anytlsConfig) error {
 	return os.WriteFile(anytlsConfigPath, b, 0o600)
 }
 
-func applyAnyTLSConfig(port int, password string, exitIP string, allowFallback bool, baseUserID int64, users []anytlsUserRule) error {
+func applyAnyTLSConfig(port int, password string, exitIP string, allowFallback bool, baseUserID int64, users []anytlsUserRule, decoy *anytlsDecoy, outbound *exitOutbound, tlsSpec *anytlsTLS, paddingScheme string, inbounds []anytlsInbound) error {
 	cfg := anytlsConfig{
 		Port:          port,
 		Password:      password,
@@ -123,6 +142,11 @@ func applyAnyTLSConfig(port int, password string, exitIP string, allowFallback b
 		ExitIP:        strings.TrimSpace(exitIP),
 		AllowFallback: allowFallback,
 		Users:         users,
+		Decoy:         decoy,
+		Outbound:      outbound,
+		TLS:           tlsSpec,
+		Padding:       paddingScheme,
+		Inbounds:      inbounds,
 	}
 	if err := startAnyTLS(cfg); err != nil {
 		return err
@@ -141,9 +165,26 @@ func startAnyTLS(cfg anytlsConfig) error {
 		cfg.Password == anytlsCurrent.Password &&
 		cfg.ExitIP == anytlsCurrent.ExitIP &&
 		cfg.AllowFallback == anytlsCurrent.AllowFallback &&
-		reflect.DeepEqual(cfg.Users, anytlsCurrent.Users) {
+		reflect.DeepEqual(cfg.Users, anytlsCurrent.Users) &&
+		reflect.DeepEqual(cfg.Decoy, anytlsCurrent.Decoy) &&
+		reflect.DeepEqual(cfg.Outbound, anytlsCurrent.Outbound) &&
+		reflect.DeepEqual(cfg.TLS, anytlsCurrent.TLS) &&
+		cfg.Padding == anytlsCurrent.Padding &&
+		reflect.DeepEqual(cfg.Inbounds, anytlsCurrent.Inbounds) {
 		return nil
 	}
+	decoy, err := buildDecoyHandler(cfg.Decoy)
+	if err != nil {
+		return err
+	}
+	outbound, err := compileOutbound(cfg.Outbound)
+	if err != nil {
+		return err
+	}
+	pad, err := anytlsPadding(cfg.Padding)
+	if err != nil {
+		return err
+	}
 	stopAnyTLSLocked()
 
 	var localTCP *net.TCPAddr
@@ -170,25 +211,37 @@ func startAnyTLS(cfg anytlsConfig) error {
 	if err != nil {
 		return err
 	}
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{*cert}}
+	acme, err := ensureACME(cfg.TLS, cert)
+	if err != nil {
+		return err
+	}
+	if acme != nil {
+		tlsConfig = acme.tlsConfig()
+	}
 	lns, err := listenAnyTLSSockets(cfg.ExitIP, cfg.Port)
 	if err != nil {
 		return err
 	}
 	server := &anytlsServer{
-		tlsConfig:     &tls.Config{Certificates: []tls.Certificate{*cert}},
-		authRules:     buildAnyTLSRules(cfg),
+		tlsConfig:     tlsConfig,
+		authRules:     buildAnyTLSRules(cfg.Password, cfg.BaseUserID, cfg.Users),
 		localTCPAddr:  localTCP,
 		localUDPAddr:  localUDP,
 		allowFallback: cfg.AllowFallback,
+		decoy:         decoy,
+		outbound:      outbound,
+		padding:       pad,
 	}
 	ctx, cancel := context.WithCancel(context.Background())
-	anytlsListeners = lns
-	anytlsCancel = cancel
-	anytlsCurrent = cfg
-	anytlsServerRef = server
 	for _, ln := range lns {
 		go anytlsAcceptLoop(ctx, ln, server)
 	}
+	extra := startAnyTLSInbounds(ctx, cfg, *server)
+	anytlsListeners = append(lns, extra...)
+	anytlsCancel = cancel
+	anytlsCurrent = cfg
+	anytlsServerRef = server
 	listenerNets := make([]string, 0, len(lns))
 	for _, ln := range lns {
 		if ln == nil || ln.Addr() == nil {
@@ -267,23 +320,23 @@ func setAnyTLSPanelContext(addr, secret, scheme string) {
 	anytlsPanelScheme = strings.TrimSpace(scheme)
 }
 
-func buildAnyTLSRules(cfg anytlsConfig) []anytlsAuthRule {
-	rules := make([]anytlsAuthRule, 0, 1+len(cfg.Users))
-	if cfg.Password != "" {
-		sum := sha256.Sum256([]byte(cfg.Password))
-		uid := cfg.BaseUserID
+func buildAnyTLSRules(password string, baseUserID int64, users []anytlsUserRule) []anytlsAuthRule {
+	rules := make([]anytlsAuthRule, 0, 1+len(users))
+	if password != "" {
+		sum := sha256.Sum256([]byte(password))
+		uid := baseUserID
 		if uid < 0 {
 			uid = 0
 		}
 		rules = append(rules, anytlsAuthRule{userID: uid, hash: sum[:], speedBps: 0})
 	}
-	for _, u := range cfg.Users {
+	for _, u := range users {
 		pass := strings.TrimSpace(u.Password)
 		if pass == "" {
 			continue
 		}
 		sum := sha256.Sum256([]byte(pass))
-		rules = append(rules, anytlsAuthRule{userID: u.UserID, hash: sum[:], speedBps: u.SpeedBps})
+		rules = append(rules, anytlsAuthRule{userID: u.UserID, hash: sum[:], speedBps: u.SpeedBps, maxSessions: u.MaxSessions, maxIPs: u.MaxIPs, limitMode: strings.ToLower(strings.TrimSpace(u.LimitMode))})
 	}
 	return rules
 }
@@ -331,13 +384,13 @@ func (s *anytlsServer) handleConn(ctx context.Context, c net.Conn) {
 	rule, ok := s.matchRule(by)
 	if err != nil || !ok {
 		b.Resize(0, n)
-		anytlsFallback(ctx, c)
+		anytlsFallback(ctx, c, s.decoy)
 		return
 	}
 	by, err = b.ReadBytes(2)
 	if err != nil {
 		b.Resize(0, n)
-		anytlsFallback(ctx, c)
+		anytlsFallback(ctx, c, s.decoy)
 		return
 	}
 	paddingLen := binary.BigEndian.Uint16(by)
@@ -345,10 +398,15 @@ func (s *anytlsServer) handleConn(ctx context.Context, c net.Conn) {
 		_, err = b.ReadBytes(int(paddingLen))
 		if err != nil {
 			b.Resize(0, n)
-			anytlsFallback(ctx, c)
+			anytlsFallback(ctx, c, s.decoy)
 			return
 		}
 	}
+	release, admitted := anytlsAdmit(rule, anytlsRemoteIP(c), c)
+	if !admitted {
+		return
+	}
+	defer release()
 
 	sess := session.NewServerSession(c, func(stream *session.Stream) {
 		defer func() {
@@ -369,16 +427,11 @@ func (s *anytlsServer) handleConn(ctx context.Context, c net.Conn) {
 		} else {
 			_ = s.proxyOutboundTCP(ctx, stream, destination, rule)
 		}
-	}, &padding.DefaultPaddingFactory)
+	}, s.padding)
 	sess.Run()
 	sess.Close()
 }
 
-func anytlsFallback(ctx context.Context, c net.Conn) {
-	_ = ctx
-	_ = c
-}
-
 func (s *anytlsServer) matchRule(hash []byte) (anytlsAuthRule, bool) {
 	if len(hash) == 0 {
 		return anytlsAuthRule{}, false
@@ -392,8 +445,23 @@ func (s *anytlsServer) matchRule(hash []byte) (anytlsAuthRule, bool) {
 }
 
 func (s *anytlsServer) proxyOutboundTCP(ctx context.Context, conn net.Conn, destination M.Socksaddr, rule anytlsAuthRule) error {
+	route, err := s.outbound.decide(ctx, destination)
+	if err != nil {
+		return E.Errors(err, N.ReportHandshakeFailure(conn, err))
+	}
+	if route != nil {
+		c, err := route.dial(ctx, s.outbound, destination)
+		if err != nil {
+			return E.Errors(err, N.ReportHandshakeFailure(conn, err))
+		}
+		if err = N.ReportHandshakeSuccess(conn); err != nil {
+			return err
+		}
+		_, _, copyErr := copyConnWithLimiter(ctx, conn, c, rule.speedBps, rule.userID)
+		return copyErr
+	}
 	if s.localTCPAddr != nil {
-		d := &net.Dialer{LocalAddr: s.localTCPAddr}
+		d := s.outbound.guard(&net.Dialer{LocalAddr: s.localTCPAddr})
 		if ip4 := s.localTCPAddr.IP.To4(); ip4 != nil {
 			if destination.IsIPv6() {
 				return fmt.Errorf("destination is ipv6 but exitIp is ipv4")
@@ -437,7 +505,7 @@ func (s *anytlsServer) proxyOutboundTCP(ctx context.Context, conn net.Conn, dest
 		if ip6 := s.localTCPAddr.IP.To16(); ip6 != nil {
 			if destination.IsIPv4() {
 				if s.allowFallback {
-					c, err := proxy.SystemDialer.DialContext(ctx, "tcp4", destination.String())
+					c, err := s.outbound.guard(proxy.SystemDialer).DialContext(ctx, "tcp4", destination.String())
 					if err != nil {
 						err = E.Errors(err, N.ReportHandshakeFailure(conn, err))
 						return err
@@ -461,7 +529,7 @@ func (s *anytlsServer) proxyOutboundTCP(ctx context.Context, conn net.Conn, dest
 						var lastErr error
 						for _, ip := range ip4s {
 							addr := net.JoinHostPort(ip.String(), strconv.Itoa(int(destination.Port)))
-							c, err := proxy.SystemDialer.DialContext(ctx, "tcp4", addr)
+							c, err := s.outbound.guard(proxy.SystemDialer).DialContext(ctx, "tcp4", addr)
 							if err != nil {
 								lastErr = err
 								continue
@@ -513,7 +581,7 @@ func (s *anytlsServer) proxyOutboundTCP(ctx context.Context, conn net.Conn, dest
 		}
 		return fmt.Errorf("invalid exitIp")
 	}
-	c, err := proxy.SystemDialer.DialContext(ctx, "tcp", destination.String())
+	c, err := s.outbound.guard(proxy.SystemDialer).DialContext(ctx, "tcp", destination.String())
 	if err != nil {
 		err = E.Errors(err, N.ReportHandshakeFailure(conn, err))
 		return err
@@ -532,9 +600,23 @@ func (s *anytlsServer) proxyOutboundUoT(ctx context.Context, conn net.Conn, dest
 		return err
 	}
 
+	route, err := s.outbound.decide(ctx, destination)
+	if err == nil && route != nil && route.socks != nil {
+		err = fmt.Errorf("udp to %s not supported via socks5 route", destination)
+	}
+	if err != nil {
+		return E.Errors(err, N.ReportHandshakeFailure(conn, err))
+	}
+
 	addr := ""
 	network := "udp"
-	if s.localUDPAddr != nil {
+	if route != nil {
+		network = "udp6"
+		if route.local.To4() != nil {
+			network = "udp4"
+		}
+		addr = net.JoinHostPort(route.local.String(), "0")
+	} else if s.localUDPAddr != nil {
 		if ip4 := s.localUDPAddr.IP.To4(); ip4 != nil {
 			if destination.IsIPv6() {
 				return fmt.Errorf("destination is ipv6 but exitIp is ipv4")
@@ -602,7 +684,7 @@ func (s *anytlsServer) proxyOutboundUoT(ctx context.Context, conn net.Conn, dest
 	if err = N.ReportHandshakeSuccess(conn); err != nil {
 		return err
 	}
-	_, _, copyErr := copyPacketConnWithLimiter(ctx, uot.NewConn(conn, *request), bufio.NewPacketConn(c), rule.speedBps, rule.userID)
+	_, _, copyErr := copyPacketConnWithLimiter(ctx, uot.NewConn(conn, *request), wrapOutboundPacketConn(c, s.outbound), rule.speedBps, rule.userID)
 	return copyErr
 }
 
@@ -621,6 +703,7 @@ func newRateLimiter(bps int64) (*rate.Limiter, int) {
 }
 
 func copyConnWithLimiter(ctx context.Context, client net.Conn, remote net.Conn, bps int64, userID int64) (int64, int64, error) {
+	defer metricsSessionOpen(userID, "tcp")()
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 	limUp, bufUp := newRateLimiter(bps)
@@ -718,6 +801,7 @@ func copyStreamLimited(ctx context.Context, dst net.Conn, src net.Conn, limiter
 }
 
 func copyPacketConnWithLimiter(ctx context.Context, source N.PacketConn, destination N.PacketConn, bps int64, userID int64) (int64, int64, error) {
+	defer metricsSessionOpen(userID, "udp")()
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 	defer func() {
@@ -806,37 +890,18 @@ func copyPacketLimited(ctx context.Context, source N.PacketReader, destination N
 	}
 }
 
+// reportAnyTLSFlow hands a session's traffic delta to the flow spool, which
+// batches and delivers it to the panel.
 func reportAnyTLSFlow(userID int64, inBytes int64, outBytes int64) {
 	if userID <= 0 || (inBytes <= 0 && outBytes <= 0) {
 		return
 	}
-	if anytlsPanelAddr == "" || anytlsPanelSecret == "" {
-		return
-	}
-	debug := strings.EqualFold(strings.TrimSpace(os.Getenv("ANYTLS_FLOW_DEBUG")), "1") ||
-		strings.EqualFold(strings.TrimSpace(os.Getenv("ANYTLS_FLOW_DEBUG")), "true")
-	payload := map[string]any{
-		"userId":   userID,
-		"inBytes":  inBytes,
-		"outBytes": outBytes,
-	}
-	u := apiURL(anytlsPanelScheme, anytlsPanelAddr, "/api/v1/flow/anytls")
-	u = u + "?secret=" + url.QueryEscape(anytlsPanelSecret)
-	if debug {
-		log.Printf("{\"event\":\"anytls_flow_report_try\",\"userId\":%d,\"inBytes\":%d,\"outBytes\":%d,\"url\":%q}", userID, inBytes, outBytes, u)
-	}
-	code, body, err := httpPostJSON(u, payload)
-	if err != nil {
-		log.Printf("{\"event\":\"anytls_flow_report_err\",\"error\":%q}", err.Error())
-		return
-	}
-	if code/100 != 2 {
-		log.Printf("{\"event\":\"anytls_flow_report_err\",\"code\":%d,\"body\":%q}", code, string(body))
-		return
-	}
-	if debug {
-		log.Printf("{\"event\":\"anytls_flow_report_ok\",\"code\":%d,\"body\":%q}", code, string(body))
+	metricsAnyTLSBytes(userID, inBytes, outBytes)
+	if strings.EqualFold(strings.TrimSpace(os.Getenv("ANYTLS_FLOW_DEBUG")), "1") ||
+		strings.EqualFold(strings.TrimSpace(os.Getenv("ANYTLS_FLOW_DEBUG")), "true") {
+		log.Printf("{\"event\":\"anytls_flow_spool\",\"userId\":%d,\"inBytes\":%d,\"outBytes\":%d}", userID, inBytes, outBytes)
 	}
+	spoolFlow("anytls", userID, 0, inBytes, outBytes)
 }
 
 func ipToNetipAddr(ip net.IP) (netip.Addr, bool) {
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/certmagic"
+	"github.com/libdns/alidns"
+	"github.com/libdns/cloudflare"
+)
+
+// ---- ACME certificates for AnyTLS ----
+//
+// Pushed by the panel as "tls" with SetAnyTLS: when a domain is set the agent
+// obtains and renews a certificate for it through ACME (HTTP-01, TLS-ALPN-01
+// or DNS-01) and serves it on the AnyTLS listener. Until the first certificate
+// is issued, and for clients whose SNI does not match, the self-signed
+// certificate is served as before. The PEM files are also exported to
+// /etc/gost/certs so other TLS listeners on the node can reuse them. The
+// certificate state is reported to the panel, which only switches
+// subscriptions to verified TLS once it has seen a valid certificate.
+
+type anytlsTLS struct {
+	Domain string `json:"domain"`
+	Email  string `json:"email,omitempty"`
+	// CA is the ACME directory URL; empty means Let's Encrypt.
+	CA string `json:"ca,omitempty"`
+	// CARoot is a PEM bundle trusted for the ACME server itself (e.g. Pebble).
+	CARoot string `json:"caRoot,omitempty"`
+	// Challenge is http | tls-alpn | dns; empty lets certmagic try http and tls-alpn.
+	Challenge      string            `json:"challenge,omitempty"`
+	DNSProvider    string            `json:"dnsProvider,omitempty"`
+	DNSCredentials map[string]string `json:"dnsCredentials,omitempty"`
+	AltHTTPPort    int               `json:"altHttpPort,omitempty"`
+	AltTLSALPNPort int               `json:"altTlsAlpnPort,omitempty"`
+}
+
+const (
+	acmeStorageDir = "/etc/gost/acme"
+	acmeCertDir    = "/etc/gost/certs"
+	acmeALPNProto  = "acme-tls/1"
+)
+
+// acmeDNSProviders maps a provider name to a constructor of an empty libdns
+// provider; credentials are decoded into it by their JSON field names (e.g.
+// cloudflare: api_token, alidns: access_key_id/access_key_secret).
+var acmeDNSProviders = map[string]func() certmagic.DNSProvider{}
+
+func registerACMEDNSProvider(name string, fn func() certmagic.DNSProvider) {
+	acmeDNSProviders[name] = fn
+}
+
+func init() {
+	registerACMEDNSProvider("cloudflare", func() certmagic.DNSProvider { return &cloudflare.Provider{} })
+	registerACMEDNSProvider("alidns", func() certmagic.DNSProvider { return &alidns.Provider{} })
+}
+
+func newACMEDNSProvider(name string, creds map[string]string) (certmagic.DNSProvider, error) {
+	fn := acmeDNSProviders[strings.ToLower(strings.TrimSpace(name))]
+	if fn == nil {
+		return nil, fmt.Errorf("unsupported dns provider %q", name)
+	}
+	p := fn()
+	b, _ := json.Marshal(creds)
+	if err := json.Unmarshal(b, p); err != nil {
+		return nil, fmt.Errorf("invalid dns credentials: %v", err)
+	}
+	return p, nil
+}
+
+type acmeManager struct {
+	spec     anytlsTLS
+	magic    *certmagic.Config
+	cache    *certmagic.Cache
+	cancel   context.CancelFunc
+	alpn     bool
+	fallback *tls.Certificate
+	kick     chan struct{}
+
+	mu       sync.Mutex
+	lastErr  string
+	exported [32]byte
+}
+
+var (
+	acmeMu  sync.Mutex
+	acmeCur *acmeManager
+)
+
+// ensureACME returns the manager for spec, starting a new one when spec
+// changed. A nil spec (or empty domain) stops certificate management.
+func ensureACME(spec *anytlsTLS, fallback *tls.Certificate) (*acmeManager, error) {
+	acmeMu.Lock()
+	defer acmeMu.Unlock()
+	if spec == nil || strings.TrimSpace(spec.Domain) == "" {
+		stopACMELocked()
+		return nil, nil
+	}
+	s := *spec
+	s.Domain = strings.ToLower(strings.TrimSpace(s.Domain))
+	if acmeCur != nil && reflect.DeepEqual(acmeCur.spec, s) {
+		return acmeCur, nil
+	}
+	m, err := newACMEManager(s, fallback)
+	if err != nil {
+		return nil, err
+	}
+	stopACMELocked()
+	acmeCur = m
+	return m, nil
+}
+
+func stopACMELocked() {
+	if acmeCur == nil {
+		return
+	}
+	acmeCur.cancel()
+	acmeCur.cache.Stop()
+	acmeCur = nil
+}
+
+func newACMEManager(spec anytlsTLS, fallback *tls.Certificate) (*acmeManager, error) {
+	issuer := certmagic.ACMEIssuer{
+		CA:             strings.TrimSpace(spec.CA),
+		Email:          strings.TrimSpace(spec.Email),
+		Agreed:         true,
+		AltHTTPPort:    spec.AltHTTPPort,
+		AltTLSALPNPort: spec.AltTLSALPNPort,
+	}
+	if issuer.CA == "" {
+		issuer.CA = certmagic.LetsEncryptProductionCA
+	}
+	if strings.TrimSpace(spec.CARoot) != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(spec.CARoot)) {
+			return nil, fmt.Errorf("invalid caRoot")
+		}
+		issuer.TrustedRoots = pool
+	}
+	switch spec.Challenge {
+	case "":
+	case "http":
+		issuer.DisableTLSALPNChallenge = true
+	case "tls-alpn":
+		issuer.DisableHTTPChallenge = true
+	case "dns":
+		p, err := newACMEDNSProvider(spec.DNSProvider, spec.DNSCredentials)
+		if err != nil {
+			return nil, err
+		}
+		issuer.DisableHTTPChallenge = true
+		issuer.DisableTLSALPNChallenge = true
+		issuer.DNS01Solver = &certmagic.DNS01Solver{DNSManager: certmagic.DNSManager{DNSProvider: p}}
+	default:
+		return nil, fmt.Errorf("unsupported challenge %q", spec.Challenge)
+	}
+
+	m := &acmeManager{
+		spec:     spec,
+		alpn:     !issuer.DisableTLSALPNChallenge,
+		fallback: fallback,
+		kick:     make(chan struct{}, 1),
+	}
+	var magic *certmagic.Config
+	m.cache = certmagic.NewCache(certmagic.CacheOptions{
+		GetConfigForCert: func(certmagic.Certificate) (*certmagic.Config, error) { return magic, nil },
+	})
+	magic = certmagic.New(m.cache, certmagic.Config{
+		Storage:           &certmagic.FileStorage{Path: acmeStorageDir},
+		DefaultServerName: spec.Domain,
+		OnEvent:           m.onEvent,
+	})
+	magic.Issuers = []certmagic.Issuer{certmagic.NewACMEIssuer(magic, issuer)}
+	m.magic = magic
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+	if err := magic.ManageAsync(ctx, []string{spec.Domain}); err != nil {
+		cancel()
+		m.cache.Stop()
+		return nil, err
+	}
+	go m.reportLoop(ctx)
+	log.Printf("{\"event\":\"acme_manage\",\"domain\":%q,\"ca\":%q,\"challenge\":%q}", spec.Domain, issuer.CA, spec.Challenge)
+	return m, nil
+}
+
+func (m *acmeManager) onEvent(_ context.Context, event string, data map[string]any) error {
+	switch event {
+	case "cert_obtained", "cached_managed_cert":
+		m.mu.Lock()
+		m.lastErr = ""
+		m.mu.Unlock()
+	case "cert_failed":
+		msg := fmt.Sprint(data["error"])
+		m.mu.Lock()
+		m.lastErr = msg
+		m.mu.Unlock()
+		log.Printf("{\"event\":\"acme_failed\",\"domain\":%q,\"error\":%q}", m.spec.Domain, msg)
+	default:
+		return nil
+	}
+	select {
+	case m.kick <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// tlsConfig wraps the AnyTLS listener config: TLS-ALPN-01 validation
+// handshakes are answered by certmagic, everything else gets the managed
+// certificate or, failing that, the self-signed one.
+func (m *acmeManager) tlsConfig() *tls.Config {
+	base := &tls.Config{GetCertificate: m.getCertificate}
+	if !m.alpn {
+		return base
+	}
+	challenge := &tls.Config{GetCertificate: m.magic.GetCertificate, NextProtos: []string{acmeALPNProto}}
+	base.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		for _, p := range hello.SupportedProtos {
+			if p == acmeALPNProto {
+				return challenge, nil
+			}
+		}
+		return nil, nil
+	}
+	return base
+}
+
+func (m *acmeManager) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	// DefaultServerName makes certmagic answer any SNI with the managed cert
+	if cert, err := m.magic.GetCertificate(hello); err == nil {
+		return cert, nil
+	}
+	return m.fallback, nil
+}
+
+// current returns the cached certificate for the domain that expires last.
+func (m *acmeManager) current() *tls.Certificate {
+	var best *tls.Certificate
+	for _, c := range m.cache.AllMatchingCertificates(m.spec.Domain) {
+		if c.Leaf != nil && (best == nil || c.Leaf.NotAfter.After(best.Leaf.NotAfter)) {
+			cert := c.Certificate
+			best = &cert
+		}
+	}
+	return best
+}
+
+func (m *acmeManager) reportLoop(ctx context.Context) {
+	ticker := time.NewTicker(6 * time.Hour)
+	defer ticker.Stop()
+	for {
+		m.report()
+		select {
+		case <-ctx.Done():
+			return
+		case <-m.kick:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *acmeManager) report() {
+	status := map[string]any{"domain": m.spec.Domain, "status": "pending"}
+	m.mu.Lock()
+	if m.lastErr != "" {
+		status["status"], status["error"] = "error", m.lastErr
+	}
+	m.mu.Unlock()
+	if cert := m.current(); cert != nil {
+		status["notAfter"] = cert.Leaf.NotAfter.UnixMilli()
+		status["issuer"] = cert.Leaf.Issuer.CommonName
+		if time.Now().Before(cert.Leaf.NotAfter) {
+			status["status"] = "ok"
+		}
+		certPath, keyPath, err := m.export(cert)
+		if err != nil {
+			log.Printf("{\"event\":\"acme_export_err\",\"domain\":%q,\"error\":%q}", m.spec.Domain, err.Error())
+		} else {
+			status["certPath"], status["keyPath"] = certPath, keyPath
+		}
+	}
+	if anytlsPanelAddr == "" || anytlsPanelSecret == "" {
+		return
+	}
+	status["secret"] = anytlsPanelSecret
+	if code, _, err := httpPostJSON(apiURL(anytlsPanelScheme, anytlsPanelAddr, "/api/v1/agent/report-cert"), status); err != nil || code/100 != 2 {
+		log.Printf("{\"event\":\"acme_report_err\",\"domain\":%q,\"code\":%d}", m.spec.Domain, code)
+	}
+}
+
+// export writes the chain and key to /etc/gost/certs/<domain>.{crt,key}
+// whenever the certificate changes.
+func (m *acmeManager) export(cert *tls.Certificate) (string, string, error) {
+	certPath := filepath.Join(acmeCertDir, m.spec.Domain+".crt")
+	keyPath := filepath.Join(acmeCertDir, m.spec.Domain+".key")
+	sum := sha256.Sum256(cert.Certificate[0])
+	m.mu.Lock()
+	same := sum == m.exported
+	m.mu.Unlock()
+	if same {
+		return certPath, keyPath, nil
+	}
+	var chain []byte
+	for _, der := range cert.Certificate {
+		chain = append(chain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		return "", "", err
+	}
+	if err := os.MkdirAll(acmeCertDir, 0o755); err != nil {
+		return "", "", err
+	}
+	if err := os.WriteFile(certPath, chain, 0o644); err != nil {
+		return "", "", err
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		return "", "", err
+	}
+	m.mu.Lock()
+	m.exported = sum
+	m.mu.Unlock()
+	go nativeExitCertExported(m.spec.Domain)
+	return certPath, keyPath, nil
+}
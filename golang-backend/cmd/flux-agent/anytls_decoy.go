@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ---- AnyTLS fallback ----
+//
+// TLS sessions whose first bytes do not match a user password are served as
+// plain HTTPS so the port looks like an ordinary web server:
+//   static (default): built-in nginx-style welcome page
+//   proxy: reverse proxy to a local or remote http(s) backend
+//   close: drop the connection (previous behaviour)
+
+type anytlsDecoy struct {
+	Mode string `json:"mode,omitempty"`
+	URL  string `json:"url,omitempty"`
+}
+
+var metAnyTLSFallback int64
+
+const decoyStaticPage = `<!DOCTYPE html>
+<html>
+<head>
+<title>Welcome to nginx!</title>
+<style>
+html { color-scheme: light dark; }
+body { width: 35em; margin: 0 auto;
+font-family: Tahoma, Verdana, Arial, sans-serif; }
+</style>
+</head>
+<body>
+<h1>Welcome to nginx!</h1>
+<p>If you see this page, the nginx web server is successfully installed and
+working. Further configuration is required.</p>
+
+<p>For online documentation and support please refer to
+<a href="http://nginx.org/">nginx.org</a>.<br/>
+Commercial support is available at
+<a href="http://nginx.com/">nginx.com</a>.</p>
+
+<p><em>Thank you for using nginx.</em></p>
+</body>
+</html>
+`
+
+func decoyStatus(w http.ResponseWriter, code int) {
+	text := fmt.Sprintf("%d %s", code, http.StatusText(code))
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(code)
+	_, _ = fmt.Fprintf(w, "<html>\r\n<head><title>%s</title></head>\r\n<body>\r\n<center><h1>%s</h1></center>\r\n<hr><center>nginx</center>\r\n</body>\r\n</html>\r\n", text, text)
+}
+
+func decoyStaticHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "nginx")
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			decoyStatus(w, http.StatusMethodNotAllowed)
+			return
+		}
+		if r.URL.Path != "/" && r.URL.Path != "/index.html" {
+			decoyStatus(w, http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte(decoyStaticPage))
+	})
+}
+
+// buildDecoyHandler returns nil when unauthenticated sessions should be dropped.
+func buildDecoyHandler(d *anytlsDecoy) (http.Handler, error) {
+	mode := ""
+	if d != nil {
+		mode = strings.ToLower(strings.TrimSpace(d.Mode))
+	}
+	switch mode {
+	case "", "static":
+		return decoyStaticHandler(), nil
+	case "close":
+		return nil, nil
+	case "proxy":
+	default:
+		return nil, fmt.Errorf("unknown fallback mode %q", mode)
+	}
+	u, err := url.Parse(strings.TrimSpace(d.URL))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid fallback url")
+	}
+	rp := httputil.NewSingleHostReverseProxy(u)
+	director := rp.Director
+	rp.Director = func(r *http.Request) {
+		director(r)
+		r.Host = u.Host
+		// do not reveal the relay to the backend
+		r.Header["X-Forwarded-For"] = nil
+	}
+	// local backends commonly use self-signed certificates
+	insecure := false
+	if ip := net.ParseIP(u.Hostname()); (ip != nil && ip.IsLoopback()) || u.Hostname() == "localhost" {
+		insecure = true
+	}
+	rp.Transport = &http.Transport{
+		Proxy:                 nil,
+		DialContext:           (&net.Dialer{Timeout: 5 * time.Second}).DialContext,
+		TLSClientConfig:       &tls.Config{ServerName: u.Hostname(), InsecureSkipVerify: insecure},
+		TLSHandshakeTimeout:   5 * time.Second,
+		ResponseHeaderTimeout: 15 * time.Second,
+		MaxIdleConnsPerHost:   4,
+		IdleConnTimeout:       60 * time.Second,
+	}
+	rp.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		log.Printf("{\"event\":\"anytls_fallback_proxy_err\",\"error\":%q}", err.Error())
+		w.Header().Set("Server", "nginx")
+		decoyStatus(w, http.StatusBadGateway)
+	}
+	return rp, nil
+}
+
+// anytlsFallback serves HTTP on an unauthenticated TLS session (bytes already
+// read are replayed by the cached conn) and returns when the client leaves.
+func anytlsFallback(ctx context.Context, c net.Conn, h http.Handler) {
+	if h == nil {
+		return
+	}
+	atomic.AddInt64(&metAnyTLSFallback, 1)
+	ln := newSingleConnListener(ctx, c)
+	srv := &http.Server{
+		Handler:           h,
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       60 * time.Second,
+		ErrorLog:          log.New(discardWriter{}, "", 0),
+	}
+	_ = srv.Serve(ln)
+}
+
+type discardWriter struct{}
+
+func (discardWriter) Write(p []byte) (int, error) { return len(p), nil }
+
+// singleConnListener hands out one connection, then blocks until it is
+// closed so http.Server.Serve returns only after the session is over.
+type singleConnListener struct {
+	ctx  context.Context
+	conn net.Conn
+	once sync.Once
+	done chan struct{}
+	used bool
+}
+
+func newSingleConnListener(ctx context.Context, c net.Conn) *singleConnListener {
+	l := &singleConnListener{ctx: ctx, done: make(chan struct{})}
+	l.conn = &notifyCloseConn{Conn: c, onClose: func() { l.once.Do(func() { close(l.done) }) }}
+	return l
+}
+
+func (l *singleConnListener) Accept() (net.Conn, error) {
+	if !l.used {
+		l.used = true
+		return l.conn, nil
+	}
+	select {
+	case <-l.done:
+	case <-l.ctx.Done():
+		_ = l.conn.Close()
+	}
+	return nil, net.ErrClosed
+}
+
+func (l *singleConnListener) Close() error   { return nil }
+func (l *singleConnListener) Addr() net.Addr { return l.conn.LocalAddr() }
+
+type notifyCloseConn struct {
+	net.Conn
+	onClose func()
+}
+
+func (c *notifyCloseConn) Close() error {
+	err := c.Conn.Close()
+	c.onClose()
+	return err
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+
+	"anytls/proxy/padding"
+	"github.com/sagernet/sing/common/atomic"
+)
+
+// ---- additional AnyTLS inbounds ----
+//
+// Besides the primary listener (port/password in anytlsConfig) the panel may
+// push "inbounds": extra listeners on other ports, each with its own base
+// password, padding scheme and user subset. They share the certificate,
+// decoy, outbound rules and exit IP of the primary listener; per-user limits
+// and flow accounting are per user across all of them.
+
+type anytlsInbound struct {
+	ID       int64            `json:"id"`
+	Port     int              `json:"port"`
+	Password string           `json:"password"`
+	Padding  string           `json:"padding,omitempty"`
+	Users    []anytlsUserRule `json:"users,omitempty"`
+}
+
+type anytlsPaddingRef = atomic.TypedValue[*padding.PaddingFactory]
+
+// anytlsPadding compiles a padding scheme ("stop=8\n0=30-30\n..."); empty
+// means the protocol default. Clients with a different scheme receive this
+// one from the server when the session starts.
+func anytlsPadding(scheme string) (*anytlsPaddingRef, error) {
+	scheme = strings.TrimSpace(strings.ReplaceAll(scheme, "\r\n", "\n"))
+	if scheme == "" {
+		return &padding.DefaultPaddingFactory, nil
+	}
+	p := padding.NewPaddingFactory([]byte(scheme))
+	if p == nil {
+		return nil, fmt.Errorf("invalid padding scheme")
+	}
+	v := &anytlsPaddingRef{}
+	v.Store(p)
+	return v, nil
+}
+
+// startAnyTLSInbounds listens on every extra inbound with a copy of base.
+// An inbound that cannot start is logged and skipped so the primary listener
+// keeps serving.
+func startAnyTLSInbounds(ctx context.Context, cfg anytlsConfig, base anytlsServer) []net.Listener {
+	var all []net.Listener
+	for _, in := range cfg.Inbounds {
+		if in.Port <= 0 || in.Port > 65535 || in.Port == cfg.Port || strings.TrimSpace(in.Password) == "" {
+			log.Printf("{\"event\":\"anytls_inbound_invalid\",\"id\":%d,\"port\":%d}", in.ID, in.Port)
+			continue
+		}
+		pad, err := anytlsPadding(in.Padding)
+		if err != nil {
+			log.Printf("{\"event\":\"anytls_inbound_invalid\",\"id\":%d,\"port\":%d,\"error\":%q}", in.ID, in.Port, err.Error())
+			continue
+		}
+		lns, err := listenAnyTLSSockets(cfg.ExitIP, in.Port)
+		if err != nil {
+			log.Printf("{\"event\":\"anytls_inbound_listen_err\",\"id\":%d,\"port\":%d,\"error\":%q}", in.ID, in.Port, err.Error())
+			continue
+		}
+		srv := base
+		srv.authRules = buildAnyTLSRules(in.Password, cfg.BaseUserID, in.Users)
+		srv.padding = pad
+		for _, ln := range lns {
+			go anytlsAcceptLoop(ctx, ln, &srv)
+		}
+		all = append(all, lns...)
+		log.Printf("{\"event\":\"anytls_inbound_start\",\"id\":%d,\"port\":%d,\"users\":%d}", in.ID, in.Port, len(in.Users))
+	}
+	return all
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// ---- AnyTLS per-user limits ----
+//
+// A user may carry maxSessions (concurrent AnyTLS sessions, i.e. TLS
+// connections) and maxIps (distinct client IPs across those sessions). A new
+// session that would exceed either limit is dropped (limitMode "reject",
+// default) or admitted after closing the oldest sessions ("evict"). Hits are
+// summed per user/kind/action and reported to the panel every 30s. Native
+// exit sessions (native_exit.go) are admitted against the same limits.
+
+type anytlsLiveSession struct {
+	id    uint64
+	ip    string
+	start time.Time
+	conn  io.Closer
+}
+
+type anytlsViolationKey struct {
+	userID       int64
+	kind, action string
+}
+
+type anytlsViolation struct {
+	UserID  int64  `json:"userId"`
+	Kind    string `json:"kind"`   // sessions | ips
+	Action  string `json:"action"` // rejected | evicted
+	Limit   int    `json:"limit"`
+	Count   int64  `json:"count"`
+	LastIP  string `json:"lastIp"`
+	FirstMs int64  `json:"firstMs"`
+	LastMs  int64  `json:"lastMs"`
+}
+
+const anytlsViolationMaxKeys = 4096
+
+var (
+	anytlsLiveMu  sync.Mutex
+	anytlsLive    = map[int64][]*anytlsLiveSession{} // per user, oldest first
+	anytlsLiveSeq uint64
+
+	anytlsViolMu     sync.Mutex
+	anytlsViol       = map[anytlsViolationKey]*anytlsViolation{}
+	anytlsViolTotals = map[[2]string]int64{} // {kind, action} -> hits, for metrics
+	anytlsViolOnce   sync.Once
+)
+
+// anytlsAdmit registers a session of rule's user from ip; c is closed when
+// the session is evicted. When ok is false the caller must drop the
+// connection; otherwise it must call release once the session ends.
+func anytlsAdmit(rule anytlsAuthRule, ip string, c io.Closer) (release func(), ok bool) {
+	if rule.maxSessions <= 0 && rule.maxIPs <= 0 {
+		return func() {}, true
+	}
+	evict := rule.limitMode == "evict"
+	var victims []*anytlsLiveSession
+
+	anytlsLiveMu.Lock()
+	live := anytlsLive[rule.userID]
+	if rule.maxIPs > 0 && !anytlsHasIP(live, ip) {
+		for anytlsIPCount(live) >= rule.maxIPs {
+			if !evict {
+				anytlsLiveMu.Unlock()
+				recordAnyTLSViolation(rule.userID, "ips", "rejected", rule.maxIPs, ip)
+				return nil, false
+			}
+			// drop every session of the IP that connected least recently
+			var gone []*anytlsLiveSession
+			live, gone = anytlsSplitIP(live, anytlsStalestIP(live))
+			victims = append(victims, gone...)
+			recordAnyTLSViolation(rule.userID, "ips", "evicted", rule.maxIPs, ip)
+		}
+	}
+	if rule.maxSessions > 0 {
+		for len(live) >= rule.maxSessions {
+			if !evict {
+				anytlsLiveMu.Unlock()
+				recordAnyTLSViolation(rule.userID, "sessions", "rejected", rule.maxSessions, ip)
+				return nil, false
+			}
+			victims = append(victims, live[0])
+			live = append([]*anytlsLiveSession(nil), live[1:]...)
+			recordAnyTLSViolation(rule.userID, "sessions", "evicted", rule.maxSessions, ip)
+		}
+	}
+	anytlsLiveSeq++
+	s := &anytlsLiveSession{id: anytlsLiveSeq, ip: ip, start: time.Now(), conn: c}
+	anytlsLive[rule.userID] = append(live, s)
+	anytlsLiveMu.Unlock()
+
+	for _, v := range victims {
+		_ = v.conn.Close()
+	}
+	return func() { anytlsForget(rule.userID, s.id) }, true
+}
+
+func anytlsForget(userID int64, id uint64) {
+	anytlsLiveMu.Lock()
+	defer anytlsLiveMu.Unlock()
+	live := anytlsLive[userID]
+	out := make([]*anytlsLiveSession, 0, len(live))
+	for _, s := range live {
+		if s.id != id {
+			out = append(out, s)
+		}
+	}
+	if len(out) == 0 {
+		delete(anytlsLive, userID)
+		return
+	}
+	anytlsLive[userID] = out
+}
+
+func anytlsRemoteIP(c net.Conn) string {
+	if c == nil || c.RemoteAddr() == nil {
+		return ""
+	}
+	addr := c.RemoteAddr().String()
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		addr = h
+	}
+	if ip := net.ParseIP(addr); ip != nil {
+		if v4 := ip.To4(); v4 != nil {
+			return v4.String()
+		}
+		return ip.String()
+	}
+	return addr
+}
+
+func anytlsHasIP(live []*anytlsLiveSession, ip string) bool {
+	for _, s := range live {
+		if s.ip == ip {
+			return true
+		}
+	}
+	return false
+}
+
+func anytlsIPCount(live []*anytlsLiveSession) int {
+	seen := map[string]bool{}
+	for _, s := range live {
+		seen[s.ip] = true
+	}
+	return len(seen)
+}
+
+// anytlsStalestIP returns the IP whose newest session is the oldest.
+func anytlsStalestIP(live []*anytlsLiveSession) string {
+	newest := map[string]time.Time{}
+	for _, s := range live {
+		if s.start.After(newest[s.ip]) {
+			newest[s.ip] = s.start
+		}
+	}
+	ip, at := "", time.Time{}
+	for _, s := range live {
+		if t := newest[s.ip]; ip == "" || t.Before(at) {
+			ip, at = s.ip, t
+		}
+	}
+	return ip
+}
+
+func anytlsSplitIP(live []*anytlsLiveSession, ip string) (keep, gone []*anytlsLiveSession) {
+	for _, s := range live {
+		if s.ip == ip {
+			gone = append(gone, s)
+		} else {
+			keep = append(keep, s)
+		}
+	}
+	return keep, gone
+}
+
+func recordAnyTLSViolation(userID int64, kind, action string, limit int, ip string) {
+	now := time.Now().UnixMilli()
+	k := anytlsViolationKey{userID: userID, kind: kind, action: action}
+	anytlsViolMu.Lock()
+	anytlsViolTotals[[2]string{kind, action}]++
+	v := anytlsViol[k]
+	if v == nil && len(anytlsViol) < anytlsViolationMaxKeys {
+		v = &anytlsViolation{UserID: userID, Kind: kind, Action: action, FirstMs: now}
+		anytlsViol[k] = v
+	}
+	if v != nil {
+		v.Limit, v.LastIP, v.LastMs = limit, ip, now
+		v.Count++
+	}
+	anytlsViolMu.Unlock()
+	log.Printf("{\"event\":\"anytls_limit\",\"userId\":%d,\"kind\":%q,\"action\":%q,\"limit\":%d,\"ip\":%q}", userID, kind, action, limit, ip)
+	anytlsViolOnce.Do(func() { go anytlsViolationLoop() })
+}
+
+func anytlsViolationLoop() {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		anytlsViolMu.Lock()
+		if len(anytlsViol) == 0 {
+			anytlsViolMu.Unlock()
+			continue
+		}
+		batch := anytlsViol
+		anytlsViol = map[anytlsViolationKey]*anytlsViolation{}
+		anytlsViolMu.Unlock()
+		if anytlsReportViolations(batch) {
+			continue
+		}
+		// keep the counts for the next round
+		anytlsViolMu.Lock()
+		for k, old := range batch {
+			if v := anytlsViol[k]; v != nil {
+				v.Count += old.Count
+				v.FirstMs = old.FirstMs
+			} else if len(anytlsViol) < anytlsViolationMaxKeys {
+				anytlsViol[k] = old
+			}
+		}
+		anytlsViolMu.Unlock()
+	}
+}
+
+func anytlsReportViolations(batch map[anytlsViolationKey]*anytlsViolation) bool {
+	if anytlsPanelAddr == "" || anytlsPanelSecret == "" {
+		return true
+	}
+	events := make([]*anytlsViolation, 0, len(batch))
+	for _, v := range batch {
+		events = append(events, v)
+	}
+	code, _, err := httpPostJSON(apiURL(anytlsPanelScheme, anytlsPanelAddr, "/api/v1/agent/report-anytls-violations"), map[string]any{"secret": anytlsPanelSecret, "events": events})
+	if err != nil || code/100 != 2 {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/sagernet/sing/common/bufio"
+	M "github.com/sagernet/sing/common/metadata"
+	N "github.com/sagernet/sing/common/network"
+	"github.com/sagernet/sing/protocol/socks"
+)
+
+// ---- AnyTLS outbound rules ----
+//
+// Pushed by the panel as "outbound" with SetAnyTLS: destinations can be
+// blocked (private/LAN ranges, ports, domain/IP/CIDR lists) or routed out of
+// an alternate local exit IP or through an upstream SOCKS5 proxy. Matchers use
+// the gost bypass syntax so SS exits can share the same rule set: IP, CIDR,
+// "example.com" (exact), ".example.com" (domain and subdomains),
+// "*.example.com" (subdomains). SOCKS5 routes are TCP only; UDP to such
+// destinations is refused rather than leaving from the wrong address.
+// IP rules are checked again on the address actually dialed or written to, so
+// a name that resolves differently at connect time (DNS rebinding) cannot
+// reach a blocked address; SOCKS5 upstreams resolve names themselves.
+
+type exitOutbound struct {
+	BlockPrivate bool        `json:"blockPrivate,omitempty"`
+	BlockPorts   []string    `json:"blockPorts,omitempty"`
+	Block        []string    `json:"block,omitempty"`
+	Routes       []exitRoute `json:"routes,omitempty"`
+}
+
+type exitRoute struct {
+	Match  []string `json:"match"`
+	ExitIP string   `json:"exitIp,omitempty"`
+	Socks5 string   `json:"socks5,omitempty"`
+}
+
+type hostMatcher struct {
+	exact    map[string]bool
+	suffixes []string // ".example.com" matches example.com too
+	subs     []string // "*.example.com" only matches below it
+	ips      map[string]bool
+	nets     []*net.IPNet
+}
+
+type outboundRoute struct {
+	match hostMatcher
+	local net.IP
+	socks *socks.Client
+}
+
+type outboundRules struct {
+	blockPrivate bool
+	ports        [][2]uint16
+	block        hostMatcher
+	routes       []*outboundRoute
+}
+
+var cgnatNet = &net.IPNet{IP: net.IPv4(100, 64, 0, 0).To4(), Mask: net.CIDRMask(10, 32)}
+
+func compileHostMatcher(patterns []string) (hostMatcher, error) {
+	m := hostMatcher{exact: map[string]bool{}, ips: map[string]bool{}}
+	for _, p := range patterns {
+		p = strings.ToLower(strings.TrimSpace(p))
+		switch {
+		case p == "":
+		case strings.Contains(p, "/"):
+			_, n, err := net.ParseCIDR(p)
+			if err != nil {
+				return m, fmt.Errorf("invalid cidr %q", p)
+			}
+			m.nets = append(m.nets, n)
+		case net.ParseIP(p) != nil:
+			m.ips[net.ParseIP(p).String()] = true
+		case strings.HasPrefix(p, "*."):
+			m.subs = append(m.subs, p[1:])
+		case strings.HasPrefix(p, "."):
+			m.suffixes = append(m.suffixes, p)
+		default:
+			m.exact[p] = true
+		}
+	}
+	return m, nil
+}
+
+func (m *hostMatcher) empty() bool {
+	return len(m.exact) == 0 && len(m.suffixes) == 0 && len(m.subs) == 0 && len(m.ips) == 0 && len(m.nets) == 0
+}
+
+func (m *hostMatcher) hasNets() bool { return len(m.ips) > 0 || len(m.nets) > 0 }
+
+func (m *hostMatcher) matchDomain(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if m.exact[host] {
+		return true
+	}
+	for _, s := range m.suffixes {
+		if host == s[1:] || strings.HasSuffix(host, s) {
+			return true
+		}
+	}
+	for _, s := range m.subs {
+		if strings.HasSuffix(host, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *hostMatcher) matchIP(ip net.IP) bool {
+	if m.ips[ip.String()] {
+		return true
+	}
+	for _, n := range m.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func compileOutbound(o *exitOutbound) (*outboundRules, error) {
+	if o == nil {
+		return nil, nil
+	}
+	r := &outboundRules{blockPrivate: o.BlockPrivate}
+	for _, p := range o.BlockPorts {
+		lo, hi, isRange := strings.Cut(strings.TrimSpace(p), "-")
+		a, err1 := strconv.ParseUint(lo, 10, 16)
+		b, err2 := a, error(nil)
+		if isRange {
+			b, err2 = strconv.ParseUint(hi, 10, 16)
+		}
+		if err1 != nil || err2 != nil || a > b {
+			return nil, fmt.Errorf("invalid port %q", p)
+		}
+		r.ports = append(r.ports, [2]uint16{uint16(a), uint16(b)})
+	}
+	var err error
+	if r.block, err = compileHostMatcher(o.Block); err != nil {
+		return nil, err
+	}
+	for _, rt := range o.Routes {
+		route := &outboundRoute{}
+		if route.match, err = compileHostMatcher(rt.Match); err != nil {
+			return nil, err
+		}
+		switch {
+		case strings.TrimSpace(rt.Socks5) != "":
+			if route.socks, err = socks.NewClientFromURL(N.SystemDialer, strings.TrimSpace(rt.Socks5)); err != nil {
+				return nil, fmt.Errorf("invalid socks5 upstream: %v", err)
+			}
+		case net.ParseIP(strings.TrimSpace(rt.ExitIP)) != nil:
+			route.local = net.ParseIP(strings.TrimSpace(rt.ExitIP))
+			if v4 := route.local.To4(); v4 != nil {
+				route.local = v4
+			}
+		default:
+			return nil, fmt.Errorf("route needs exitIp or socks5")
+		}
+		r.routes = append(r.routes, route)
+	}
+	return r, nil
+}
+
+func isPrivateDest(ip net.IP) bool {
+	return ip.IsPrivate() || ip.IsLoopback() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsMulticast() || cgnatNet.Contains(ip)
+}
+
+func (r *outboundRules) blockedIP(ip net.IP, port int) bool {
+	if r == nil {
+		return false
+	}
+	if r.blockedPort(port) {
+		return true
+	}
+	return (r.blockPrivate && isPrivateDest(ip)) || r.block.matchIP(ip)
+}
+
+func (r *outboundRules) blockedPort(port int) bool {
+	for _, p := range r.ports {
+		if port >= int(p[0]) && port <= int(p[1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// guard returns a copy of d that refuses connections to blocked addresses.
+func (r *outboundRules) guard(d *net.Dialer) *net.Dialer {
+	if r == nil {
+		return d
+	}
+	g := *d
+	g.Control = func(network, address string, _ syscall.RawConn) error {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		p, _ := strconv.Atoi(port)
+		if ip := net.ParseIP(host); ip == nil || r.blockedIP(ip, p) {
+			return fmt.Errorf("destination %s blocked by exit rules", address)
+		}
+		return nil
+	}
+	return &g
+}
+
+// decide returns an error when dest is blocked and the route to use, if any.
+// Domain destinations are resolved only when IP-based rules need it.
+func (r *outboundRules) decide(ctx context.Context, dest M.Socksaddr) (*outboundRoute, error) {
+	if r == nil {
+		return nil, nil
+	}
+	if r.blockedPort(int(dest.Port)) {
+		return nil, fmt.Errorf("destination port %d blocked by exit rules", dest.Port)
+	}
+	var ips []net.IP
+	if dest.IsFqdn() {
+		if r.block.matchDomain(dest.Fqdn) || (r.blockPrivate && strings.EqualFold(strings.TrimSuffix(dest.Fqdn, "."), "localhost")) {
+			return nil, fmt.Errorf("destination %s blocked by exit rules", dest.Fqdn)
+		}
+		need := r.blockPrivate || r.block.hasNets()
+		for _, rt := range r.routes {
+			need = need || rt.match.hasNets()
+		}
+		if need {
+			addrs, err := net.DefaultResolver.LookupIPAddr(ctx, dest.Fqdn)
+			if err != nil {
+				return nil, fmt.Errorf("resolve %s failed: %v", dest.Fqdn, err)
+			}
+			for _, a := range addrs {
+				ips = append(ips, a.IP)
+			}
+		}
+	} else if dest.IsIP() {
+		ips = []net.IP{dest.Addr.AsSlice()}
+	}
+	for _, ip := range ips {
+		if (r.blockPrivate && isPrivateDest(ip)) || r.block.matchIP(ip) {
+			return nil, fmt.Errorf("destination %s blocked by exit rules", ip)
+		}
+	}
+	for _, rt := range r.routes {
+		if dest.IsFqdn() && rt.match.matchDomain(dest.Fqdn) {
+			return rt, nil
+		}
+		for _, ip := range ips {
+			if rt.match.matchIP(ip) {
+				return rt, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// dial opens a TCP connection to dest through a route.
+func (rt *outboundRoute) dial(ctx context.Context, r *outboundRules, dest M.Socksaddr) (net.Conn, error) {
+	if rt.socks != nil {
+		return rt.socks.DialContext(ctx, N.NetworkTCP, dest)
+	}
+	network := "tcp6"
+	if rt.local.To4() != nil {
+		network = "tcp4"
+	}
+	d := r.guard(&net.Dialer{LocalAddr: &net.TCPAddr{IP: rt.local}})
+	return d.DialContext(ctx, network, dest.String())
+}
+
+// outboundPacketConn drops datagrams to blocked addresses; a UoT session may
+// address any number of destinations after the first one. Domain destinations
+// are resolved here and sent to the checked address.
+type outboundPacketConn struct {
+	net.PacketConn
+	rules *outboundRules
+}
+
+func (c *outboundPacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	dest, ok := c.rules.packetDest(M.SocksaddrFromNet(addr))
+	if !ok {
+		return len(p), nil
+	}
+	return c.PacketConn.WriteTo(p, dest.UDPAddr())
+}
+
+// packetDest checks the destination of one datagram, resolving a domain to
+// the address it is then sent to; ok is false when it must be dropped.
+func (r *outboundRules) packetDest(dest M.Socksaddr) (M.Socksaddr, bool) {
+	if dest.IsFqdn() {
+		if r.block.matchDomain(dest.Fqdn) {
+			return dest, false
+		}
+		u, err := net.ResolveUDPAddr("udp", dest.String())
+		if err != nil {
+			return dest, false
+		}
+		dest = M.SocksaddrFromNet(u)
+	}
+	dest = dest.Unwrap()
+	if !dest.IsIP() || r.blockedIP(dest.Addr.AsSlice(), int(dest.Port)) {
+		return dest, false
+	}
+	return dest, true
+}
+
+func wrapOutboundPacketConn(c net.PacketConn, rules *outboundRules) N.NetPacketConn {
+	if rules == nil {
+		return bufio.NewPacketConn(c)
+	}
+	return bufio.NewPacketConn(&outboundPacketConn{PacketConn: c, rules: rules})
+}
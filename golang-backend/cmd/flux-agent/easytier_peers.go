@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EasyTier peer health: periodically collect `easytier-cli peer` / `route`
+// and report the parsed tables to the panel.
+
+var easyTierCLIPaths = []string{"/opt/easytier/easytier-cli", "/usr/sbin/easytier-cli", "/usr/bin/easytier-cli"}
+
+func easyTierCLI() string {
+	for _, p := range easyTierCLIPaths {
+		if st, err := os.Stat(p); err == nil && !st.IsDir() {
+			return p
+		}
+	}
+	if p, err := exec.LookPath("easytier-cli"); err == nil {
+		return p
+	}
+	return ""
+}
+
+func periodicEasyTierPeers(addr, secret, scheme string, done <-chan struct{}) {
+	sec := 60
+	if v := getenv("AGENT_ET_PEER_SEC", ""); v != "" {
+		if n, _ := strconv.Atoi(v); n > 0 {
+			sec = n
+		}
+	}
+	ticker := time.NewTicker(time.Duration(sec) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			reportEasyTierPeersOnce(addr, secret, scheme)
+		}
+	}
+}
+
+func reportEasyTierPeersOnce(addr, secret, scheme string) {
+	cli := easyTierCLI()
+	if cli == "" {
+		return
+	}
+	peers, err := easyTierTable(cli, "peer")
+	if err != nil {
+		return
+	}
+	routes, _ := easyTierTable(cli, "route")
+	payload := map[string]any{
+		"secret": secret,
+		"timeMs": time.Now().UnixMilli(),
+		"peers":  normalizeEasyTierPeers(peers),
+		"routes": normalizeEasyTierRoutes(routes),
+	}
+	_, _, _ = httpPostJSON(apiURL(scheme, addr, "/api/v1/agent/report-easytier-peers"), payload)
+}
+
+// easyTierTable runs an easytier-cli sub command and returns rows keyed by column name.
+// JSON output is preferred; older CLIs only print an ASCII table.
+func easyTierTable(cli, sub string) ([]map[string]string, error) {
+	rpc := getenv("EASYTIER_RPC", "127.0.0.1:15888")
+	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, cli, "-p", rpc, "-o", "json", sub).Output()
+	if err == nil {
+		var raw []map[string]any
+		if json.Unmarshal(out, &raw) == nil {
+			rows := make([]map[string]string, 0, len(raw))
+			for _, r := range raw {
+				m := map[string]string{}
+				for k, v := range r {
+					m[strings.ToLower(k)] = strings.TrimSpace(strings.Trim(string(mustJSON(v)), "\""))
+				}
+				rows = append(rows, m)
+			}
+			return rows, nil
+		}
+	}
+	ctx2, cancel2 := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel2()
+	out, err = exec.CommandContext(ctx2, cli, "-p", rpc, sub).Output()
+	if err != nil {
+		return nil, err
+	}
+	return parseEasyTierASCIITable(string(out)), nil
+}
+
+// parseEasyTierASCIITable parses tables like:
+//
+//	| ipv4 | hostname | cost | lat_ms | loss_rate | ... |
+//	|------|----------|------|--------|-----------|-----|
+//	| 10.126.126.2 | node-2 | p2p | 35.2 | 0.000 | ... |
+func parseEasyTierASCIITable(s string) []map[string]string {
+	var header []string
+	rows := []map[string]string{}
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "|") {
+			continue
+		}
+		cells := strings.Split(strings.Trim(line, "|"), "|")
+		for i := range cells {
+			cells[i] = strings.TrimSpace(cells[i])
+		}
+		if len(cells) > 0 && strings.Trim(cells[0], "-:+ ") == "" {
+			continue // separator
+		}
+		if header == nil {
+			header = make([]string, len(cells))
+			for i, c := range cells {
+				header[i] = strings.ToLower(c)
+			}
+			continue
+		}
+		m := map[string]string{}
+		for i, c := range cells {
+			if i < len(header) {
+				m[header[i]] = c
+			}
+		}
+		rows = append(rows, m)
+	}
+	return rows
+}
+
+func parseFloatLoose(s string) float64 {
+	s = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(s), "%"))
+	f, _ := strconv.ParseFloat(s, 64)
+	return f
+}
+
+// lossPercent converts the CLI loss column (fraction "0.010" or "1.0%") to percent.
+func lossPercent(s string) float64 {
+	f := parseFloatLoose(s)
+	if !strings.Contains(s, "%") && f <= 1 {
+		f *= 100
+	}
+	return f
+}
+
+func stripCIDR(s string) string {
+	if i := strings.Index(s, "/"); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
+
+func normalizeEasyTierPeers(rows []map[string]string) []map[string]any {
+	out := make([]map[string]any, 0, len(rows))
+	for _, r := range rows {
+		if strings.EqualFold(r["cost"], "local") {
+			continue
+		}
+		out = append(out, map[string]any{
+			"ipv4":        stripCIDR(r["ipv4"]),
+			"hostname":    r["hostname"],
+			"cost":        r["cost"],
+			"latMs":       parseFloatLoose(r["lat_ms"]),
+			"lossPct":     lossPercent(r["loss_rate"]),
+			"tunnelProto": r["tunnel_proto"],
+			"natType":     r["nat_type"],
+			"peerId":      r["id"],
+			"version":     r["version"],
+		})
+	}
+	return out
+}
+
+func normalizeEasyTierRoutes(rows []map[string]string) []map[string]any {
+	out := make([]map[string]any, 0, len(rows))
+	for _, r := range rows {
+		cost, _ := strconv.Atoi(strings.TrimSpace(r["cost"]))
+		out = append(out, map[string]any{
+			"ipv4":            stripCIDR(r["ipv4"]),
+			"hostname":        r["hostname"],
+			"nextHopIpv4":     stripCIDR(r["next_hop_ipv4"]),
+			"nextHopHostname": r["next_hop_hostname"],
+			"nextHopLatMs":    parseFloatLoose(r["next_hop_lat"]),
+			"cost":            cost,
+		})
+	}
+	return out
+}
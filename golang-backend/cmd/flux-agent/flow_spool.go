@@ -0,0 +1,437 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ---- Flow spool ----
+//
+// Billable traffic (AnyTLS sessions and gost observer events) is summed in
+// memory per user/service, cut every FLOW_FLUSH_SEC into a numbered batch and
+// written to disk before it is posted to /api/v1/flow/batch. A batch leaves the
+// spool only after the panel acknowledged it; the panel skips sequence numbers
+// it has already applied, so replays after a crash or a lost response are safe.
+//
+// gost observers are pointed at a loopback receiver (FLOW_SPOOL_LISTEN) instead
+// of the panel so their events go through the same spool.
+
+type flowEntry struct {
+	Kind      string `json:"kind"` // anytls | native | forward | exit
+	UserID    int64  `json:"userId,omitempty"`
+	ForwardID int64  `json:"forwardId,omitempty"`
+	InBytes   int64  `json:"inBytes"`
+	OutBytes  int64  `json:"outBytes"`
+}
+
+type flowBatch struct {
+	// Source tells the panel which spool (agent1/agent2) the sequence belongs to.
+	Source  string      `json:"source"`
+	Seq     int64       `json:"seq"`
+	TimeMs  int64       `json:"timeMs"`
+	Entries []flowEntry `json:"entries"`
+}
+
+type flowSpoolState struct {
+	NextSeq int64       `json:"nextSeq"`
+	Pending []flowBatch `json:"pending"`
+}
+
+type flowKey struct {
+	kind      string
+	userID    int64
+	forwardID int64
+}
+
+var (
+	flowSpoolMu   sync.Mutex
+	flowAgg       = map[flowKey]*flowEntry{}
+	flowState     flowSpoolState
+	flowSpoolOnce sync.Once
+	// loopback address of the observer receiver; empty when it is not running
+	flowObserverAddr string
+
+	metFlowBatchesSent int64
+	metFlowSendErrors  int64
+)
+
+func flowSpoolPath() string {
+	if isAgent2Binary() {
+		return "/etc/gost/flow_spool2.json"
+	}
+	return "/etc/gost/flow_spool.json"
+}
+
+func flowSpoolSource() string {
+	if isAgent2Binary() {
+		return "agent2"
+	}
+	return "agent1"
+}
+
+func flowSpoolEnvInt(k string, def int) int {
+	if v := getenv(k, ""); v != "" {
+		if n, _ := strconv.Atoi(v); n > 0 {
+			return n
+		}
+	}
+	return def
+}
+
+// spoolFlow adds a traffic delta to the current (not yet cut) batch.
+func spoolFlow(kind string, userID, forwardID, inBytes, outBytes int64) {
+	if inBytes < 0 {
+		inBytes = 0
+	}
+	if outBytes < 0 {
+		outBytes = 0
+	}
+	if inBytes == 0 && outBytes == 0 {
+		return
+	}
+	k := flowKey{kind: kind, userID: userID, forwardID: forwardID}
+	flowSpoolMu.Lock()
+	e := flowAgg[k]
+	if e == nil {
+		e = &flowEntry{Kind: kind, UserID: userID, ForwardID: forwardID}
+		flowAgg[k] = e
+	}
+	e.InBytes += inBytes
+	e.OutBytes += outBytes
+	flowSpoolMu.Unlock()
+}
+
+func startFlowSpool() {
+	flowSpoolOnce.Do(func() {
+		flowSpoolMu.Lock()
+		if b, err := os.ReadFile(flowSpoolPath()); err == nil {
+			if err := json.Unmarshal(b, &flowState); err != nil {
+				log.Printf("{\"event\":\"flow_spool_load_err\",\"error\":%q}", err.Error())
+			}
+		}
+		if flowState.NextSeq <= 0 {
+			// Starting from the clock keeps a fresh spool (lost file, reinstall)
+			// above every sequence a previous spool on this node could have used:
+			// batches are cut at most once per second.
+			flowState.NextSeq = time.Now().Unix()
+		}
+		if n := len(flowState.Pending); n > 0 {
+			log.Printf("{\"event\":\"flow_spool_loaded\",\"pending\":%d}", n)
+		}
+		flowSpoolMu.Unlock()
+		startFlowObserverReceiver()
+		go flowSpoolLoop()
+	})
+}
+
+func flowSpoolLoop() {
+	interval := time.Duration(flowSpoolEnvInt("FLOW_FLUSH_SEC", 5)) * time.Second
+	if interval < time.Second {
+		interval = time.Second
+	}
+	var backoff time.Duration
+	var nextTry time.Time
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		flowSpoolCut()
+		if time.Now().Before(nextTry) {
+			continue
+		}
+		if err := flowSpoolSend(); err != nil {
+			atomic.AddInt64(&metFlowSendErrors, 1)
+			backoff *= 2
+			if backoff < interval {
+				backoff = interval
+			}
+			if backoff > 5*time.Minute {
+				backoff = 5 * time.Minute
+			}
+			nextTry = time.Now().Add(backoff)
+			log.Printf("{\"event\":\"flow_spool_send_err\",\"error\":%q,\"pending\":%d,\"retryInSec\":%d}", err.Error(), flowSpoolPending(), int(backoff/time.Second))
+			continue
+		}
+		backoff = 0
+	}
+}
+
+// flowSpoolCut turns the aggregated counters into a numbered batch on disk.
+func flowSpoolCut() {
+	flowSpoolMu.Lock()
+	defer flowSpoolMu.Unlock()
+	if len(flowAgg) == 0 {
+		return
+	}
+	entries := make([]flowEntry, 0, len(flowAgg))
+	for _, e := range flowAgg {
+		entries = append(entries, *e)
+	}
+	sortFlowEntries(entries)
+	flowAgg = map[flowKey]*flowEntry{}
+	flowState.Pending = append(flowState.Pending, flowBatch{Source: flowSpoolSource(), Seq: flowState.NextSeq, TimeMs: time.Now().UnixMilli(), Entries: entries})
+	flowState.NextSeq++
+	// Bound the spool during long outages by folding old batches together.
+	// The head is never touched: it may already be applied with the ack lost.
+	max := flowSpoolEnvInt("FLOW_SPOOL_MAX_BATCHES", 1000)
+	if max < 3 {
+		max = 3
+	}
+	for len(flowState.Pending) > max {
+		flowState.Pending[1].Entries = mergeFlowEntries(flowState.Pending[1].Entries, flowState.Pending[2].Entries)
+		flowState.Pending = append(flowState.Pending[:2], flowState.Pending[3:]...)
+	}
+	if err := saveFlowSpoolLocked(); err != nil {
+		log.Printf("{\"event\":\"flow_spool_save_err\",\"error\":%q}", err.Error())
+	}
+}
+
+// flowSpoolSend posts pending batches oldest first until the spool is empty.
+func flowSpoolSend() error {
+	if anytlsPanelAddr == "" || anytlsPanelSecret == "" {
+		return nil
+	}
+	u := apiURL(anytlsPanelScheme, anytlsPanelAddr, "/api/v1/flow/batch") + "?secret=" + url.QueryEscape(anytlsPanelSecret)
+	for {
+		flowSpoolMu.Lock()
+		if len(flowState.Pending) == 0 {
+			flowSpoolMu.Unlock()
+			return nil
+		}
+		b := flowState.Pending[0]
+		flowSpoolMu.Unlock()
+
+		code, body, err := httpPostJSON(u, b)
+		if err != nil {
+			return err
+		}
+		if code/100 != 2 {
+			return fmt.Errorf("panel returned %d: %s", code, strings.TrimSpace(string(body)))
+		}
+		atomic.AddInt64(&metFlowBatchesSent, 1)
+
+		flowSpoolMu.Lock()
+		if len(flowState.Pending) > 0 && flowState.Pending[0].Seq == b.Seq {
+			flowState.Pending = flowState.Pending[1:]
+		}
+		if err := saveFlowSpoolLocked(); err != nil {
+			log.Printf("{\"event\":\"flow_spool_save_err\",\"error\":%q}", err.Error())
+		}
+		flowSpoolMu.Unlock()
+	}
+}
+
+func flowSpoolPending() int {
+	flowSpoolMu.Lock()
+	defer flowSpoolMu.Unlock()
+	return len(flowState.Pending)
+}
+
+func saveFlowSpoolLocked() error {
+	path := flowSpoolPath()
+	b, err := json.Marshal(flowState)
+	if err != nil {
+		return err
+	}
+	_ = os.MkdirAll(filepath.Dir(path), 0o755)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func mergeFlowEntries(a, b []flowEntry) []flowEntry {
+	idx := map[flowKey]int{}
+	out := make([]flowEntry, 0, len(a)+len(b))
+	for _, list := range [][]flowEntry{a, b} {
+		for _, e := range list {
+			k := flowKey{kind: e.Kind, userID: e.UserID, forwardID: e.ForwardID}
+			if i, ok := idx[k]; ok {
+				out[i].InBytes += e.InBytes
+				out[i].OutBytes += e.OutBytes
+				continue
+			}
+			idx[k] = len(out)
+			out = append(out, e)
+		}
+	}
+	sortFlowEntries(out)
+	return out
+}
+
+func sortFlowEntries(entries []flowEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Kind != entries[j].Kind {
+			return entries[i].Kind < entries[j].Kind
+		}
+		if entries[i].UserID != entries[j].UserID {
+			return entries[i].UserID < entries[j].UserID
+		}
+		return entries[i].ForwardID < entries[j].ForwardID
+	})
+}
+
+// ---- gost observer receiver ----
+
+func startFlowObserverReceiver() {
+	def := "127.0.0.1:18601"
+	if isAgent2Binary() {
+		def = "127.0.0.1:18602"
+	}
+	listen := strings.TrimSpace(getenv("FLOW_SPOOL_LISTEN", def))
+	if listen == "" || listen == "off" {
+		return
+	}
+	ln, err := net.Listen("tcp", listen)
+	if err != nil {
+		// observers keep reporting to the panel directly
+		log.Printf("{\"event\":\"flow_observer_listen_err\",\"error\":%q}", err.Error())
+		return
+	}
+	flowObserverAddr = ln.Addr().String()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/observe", handleFlowObserve)
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		if err := srv.Serve(ln); err != nil {
+			log.Printf("{\"event\":\"flow_observer_serve_err\",\"error\":%q}", err.Error())
+		}
+	}()
+	log.Printf("{\"event\":\"flow_observer_listen\",\"addr\":%q}", flowObserverAddr)
+	// observers created before the spool existed still post to the panel
+	go func() {
+		time.Sleep(5 * time.Second)
+		migrateGostObservers()
+	}()
+}
+
+// handleFlowObserve accepts gost http observer events, same payload the panel's
+// /flow/upload and /flow/exit endpoints read. Like those endpoints it requires
+// the node secret, so other local processes cannot inject billable traffic.
+func handleFlowObserve(w http.ResponseWriter, r *http.Request) {
+	if anytlsPanelSecret == "" || subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("secret")), []byte(anytlsPanelSecret)) != 1 {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	body, _ := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	var payload struct {
+		Events []struct {
+			Service string `json:"service"`
+			Type    string `json:"type"`
+			Stats   struct {
+				InputBytes  int64 `json:"inputBytes"`
+				OutputBytes int64 `json:"outputBytes"`
+			} `json:"stats"`
+		} `json:"events"`
+	}
+	_ = json.Unmarshal(body, &payload)
+	q := r.URL.Query()
+	kind := q.Get("kind")
+	id, _ := strconv.ParseInt(q.Get("id"), 10, 64)
+	uid, _ := strconv.ParseInt(q.Get("userId"), 10, 64)
+	for _, e := range payload.Events {
+		if !strings.EqualFold(e.Type, "stats") {
+			continue
+		}
+		switch kind {
+		case "forward":
+			fid := id
+			if fid == 0 && e.Service != "" {
+				// service name: forwardId_userId_userTunnelId
+				head, _, _ := strings.Cut(e.Service, "_")
+				fid, _ = strconv.ParseInt(head, 10, 64)
+			}
+			if fid > 0 {
+				spoolFlow("forward", 0, fid, e.Stats.InputBytes, e.Stats.OutputBytes)
+			}
+		case "exit":
+			if uid > 0 {
+				spoolFlow("exit", uid, 0, e.Stats.InputBytes, e.Stats.OutputBytes)
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write([]byte(`{"ok":true}`))
+}
+
+// spoolObserverSpec points an http observer plugin that reports to the panel's
+// /flow/upload or /flow/exit at the local receiver, and refreshes local
+// observers that lack the current secret. It reports whether the spec was
+// changed.
+func spoolObserverSpec(o map[string]any) bool {
+	if flowObserverAddr == "" {
+		return false
+	}
+	plugin, _ := o["plugin"].(map[string]any)
+	if plugin == nil {
+		return false
+	}
+	if t, _ := plugin["type"].(string); t != "" && t != "http" {
+		return false
+	}
+	raw, _ := plugin["addr"].(string)
+	pu, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+	q := pu.Query()
+	local := url.Values{}
+	switch {
+	case strings.HasSuffix(pu.Path, "/flow/upload"):
+		local.Set("kind", "forward")
+		if v := q.Get("id"); v != "" {
+			local.Set("id", v)
+		}
+	case strings.HasSuffix(pu.Path, "/flow/exit"):
+		local.Set("kind", "exit")
+		local.Set("userId", q.Get("userId"))
+	case pu.Host == flowObserverAddr && pu.Path == "/observe":
+		if q.Get("secret") == anytlsPanelSecret {
+			return false
+		}
+		for _, k := range []string{"kind", "id", "userId"} {
+			if v := q.Get(k); v != "" {
+				local.Set(k, v)
+			}
+		}
+	default:
+		return false
+	}
+	local.Set("secret", anytlsPanelSecret)
+	plugin["addr"] = "http://" + flowObserverAddr + "/observe?" + local.Encode()
+	return true
+}
+
+// migrateGostObservers rewrites existing panel-bound observers in the running
+// gost config to the local receiver (best-effort).
+func migrateGostObservers() {
+	arr, _ := readGostConfig()["observers"].([]any)
+	changed := make([]map[string]any, 0)
+	for _, it := range arr {
+		if m, ok := it.(map[string]any); ok && spoolObserverSpec(m) {
+			changed = append(changed, m)
+		}
+	}
+	if len(changed) == 0 {
+		return
+	}
+	if err := apiConfigObservers(changed, true); err != nil {
+		log.Printf("{\"event\":\"flow_observer_migrate_err\",\"error\":%q}", err.Error())
+		return
+	}
+	log.Printf("{\"event\":\"flow_observer_migrated\",\"count\":%d}", len(changed))
+}
@@ -343,6 +343,7 @@ func main() {
 	u.RawQuery = q.Encode()
 
 	setAnyTLSPanelContext(addr, secret, scheme)
+	startFlowSpool()
 
 	// 不再自动启用 Web API，仅做报告（前端可手动触发启用）。
 	if cfg, ok := loadAnyTLSConfig(); ok {
@@ -350,11 +351,19 @@ func main() {
 			log.Printf("{\"event\":\"anytls_boot_err\",\"error\":%q}", err.Error())
 		}
 	}
+	if cfg, ok := loadNativeExitConfig(); ok {
+		if err := startNativeExits(cfg, false); err != nil {
+			log.Printf("{\"event\":\"native_exit_boot_err\",\"error\":%q}", err.Error())
+		}
+	}
+
+	startMetricsServer()
 
 	for {
 		if err := runOnce(u.String(), addr, secret, scheme); err != nil {
 			log.Printf("{\"event\":\"agent_error\",\"error\":%q}", err.Error())
 		}
+		atomic.AddInt64(&metWSDisconnects, 1)
 		time.Sleep(3 * time.Second)
 	}
 }
@@ -404,6 +413,9 @@ func runOnce(wsURL, addr, secret, scheme string) error {
 	wsWriteMu.Store(c, &sync.Mutex{})
 	defer wsWriteMu.Delete(c)
 	log.Printf("{\"event\":\"connected\"}")
+	atomic.AddInt64(&metWSConnects, 1)
+	atomic.StoreInt64(&metWSConnected, 1)
+	defer atomic.StoreInt64(&metWSConnected, 0)
 
 	// 不在重连时自动启用/重启 GOST，仅保持心跳与命令通道
 
@@ -412,12 +424,15 @@ func runOnce(wsURL, addr, secret, scheme string) error {
 	go func() { time.Sleep(1200 * time.Millisecond); reconcile(addr, secret, scheme) }()
 	// background probes and system info reporting
 	go periodicProbe(addr, secret, scheme)
+	go periodicMeshProbe(addr, secret, scheme)
 	go periodicSystemInfo(c)
 	// Optional periodic reconcile via RECONCILE_INTERVAL (seconds, <=0 to disable). Default 300s.
 	go periodicReconcile(addr, secret, scheme)
 	// Periodically report local gost services snapshot to server for forward status aggregation
 	done := make(chan struct{})
 	go periodicReportServices(addr, secret, scheme, done)
+	// EasyTier peer/route health (no-op when easytier-cli is absent)
+	go periodicEasyTierPeers(addr, secret, scheme, done)
 	// OpLog forwarder: send queued op logs to server as {type:"OpLog", step, message, data}
 	go func() {
 		for {
@@ -593,10 +608,15 @@ func runOnce(wsURL, addr, secret, scheme string) error {
 				ExitIP        string           `json:"exitIp"`
 				AllowFallback bool             `json:"allowFallback"`
 				Users         []anytlsUserRule `json:"users"`
+				Decoy         *anytlsDecoy     `json:"decoy"`
+				Outbound      *exitOutbound    `json:"outbound"`
+				TLS           *anytlsTLS       `json:"tls"`
+				Padding       string           `json:"padding"`
+				Inbounds      []anytlsInbound  `json:"inbounds"`
 			}
 			_ = json.Unmarshal(m.Data, &req)
 			log.Printf("{\"event\":\"anytls_set\",\"port\":%d,\"exitIp\":%q,\"allowFallback\":%v,\"baseUserId\":%d}", req.Port, req.ExitIP, req.AllowFallback, req.BaseUserID)
-			err := applyAnyTLSConfig(req.Port, req.Password, req.ExitIP, req.AllowFallback, req.BaseUserID, req.Users)
+			err := applyAnyTLSConfig(req.Port, req.Password, req.ExitIP, req.AllowFallback, req.BaseUserID, req.Users, req.Decoy, req.Outbound, req.TLS, req.Padding, req.Inbounds)
 			msg := "ok"
 			if err != nil {
 				msg = err.Error()
@@ -604,6 +624,21 @@ func runOnce(wsURL, addr, secret, scheme string) error {
 			if req.RequestID != "" {
 				_ = wsWriteJSON(c, map[string]any{"type": "SetAnyTLSResult", "requestId": req.RequestID, "data": map[string]any{"success": err == nil, "message": msg}})
 			}
+		case "SetNativeExits":
+			var req struct {
+				RequestID string `json:"requestId"`
+				nativeExitConfig
+			}
+			_ = json.Unmarshal(m.Data, &req)
+			log.Printf("{\"event\":\"native_exit_set\",\"exits\":%d,\"exitIp\":%q}", len(req.Exits), req.ExitIP)
+			err := applyNativeExitConfig(req.nativeExitConfig)
+			msg := "ok"
+			if err != nil {
+				msg = err.Error()
+			}
+			if req.RequestID != "" {
+				_ = wsWriteJSON(c, map[string]any{"type": "SetNativeExitsResult", "requestId": req.RequestID, "data": map[string]any{"success": err == nil, "message": msg}})
+			}
 		case "SingboxTest":
 			var req singboxTestReq
 			_ = json.Unmarshal(m.Data, &req)
@@ -1194,6 +1229,8 @@ func periodicReconcile(addr, secret, scheme string) {
 }
 
 func reconcile(addr, secret, scheme string) {
+	atomic.AddInt64(&metReconcileRuns, 1)
+	atomic.StoreInt64(&metReconcileLastMs, time.Now().UnixMilli())
 	// read local gost.json service names and panel-managed flag
 	present := map[string]struct{}{}
 	managed := map[string]bool{}
@@ -1232,6 +1269,7 @@ func reconcile(addr, secret, scheme string) {
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		log.Printf("{\"event\":\"reconcile_error\",\"step\":\"desired\",\"error\":%q}", err.Error())
+		metricsReconcileErr("desired")
 		return
 	}
 	defer resp.Body.Close()
@@ -1242,6 +1280,7 @@ func reconcile(addr, secret, scheme string) {
 	_ = json.NewDecoder(resp.Body).Decode(&res)
 	if res.Code != 0 {
 		log.Printf("{\"event\":\"reconcile_error\",\"step\":\"desired\",\"code\":%d}", res.Code)
+		metricsReconcileErr("desired")
 		return
 	}
 	missing := make([]map[string]any, 0)
@@ -1260,15 +1299,17 @@ func reconcile(addr, secret, scheme string) {
 	if v := strings.ToLower(getenv("STRICT_RECONCILE", "false")); v == "true" || v == "1" {
 		strict = true
 	}
-	if strict {
-		for n := range present {
-			if _, ok := desiredNames[n]; !ok {
-				if managed[n] {
-					extras = append(extras, n)
-				}
+	drift := 0
+	for n := range present {
+		if _, ok := desiredNames[n]; !ok && managed[n] {
+			drift++
+			if strict {
+				extras = append(extras, n)
 			}
 		}
 	}
+	atomic.StoreInt64(&metReconcileMiss, int64(len(missing)))
+	atomic.StoreInt64(&metReconcileExtra, int64(drift))
 	if len(missing) == 0 && len(extras) == 0 {
 		log.Printf("{\"event\":\"reconcile_ok\",\"missing\":0,\"extras\":0}")
 		return
@@ -1280,8 +1321,10 @@ func reconcile(addr, secret, scheme string) {
 		req2.Header.Set("Content-Type", "application/json")
 		if resp2, err := http.DefaultClient.Do(req2); err != nil {
 			log.Printf("{\"event\":\"reconcile_error\",\"step\":\"push\",\"error\":%q}", err.Error())
+			metricsReconcileErr("push")
 		} else {
 			resp2.Body.Close()
+			atomic.AddInt64(&metReconcilePushed, int64(len(missing)))
 			log.Printf("{\"event\":\"reconcile_push\",\"count\":%d}", len(missing))
 		}
 	}
@@ -1292,8 +1335,10 @@ func reconcile(addr, secret, scheme string) {
 		req3.Header.Set("Content-Type", "application/json")
 		if resp3, err := http.DefaultClient.Do(req3); err != nil {
 			log.Printf("{\"event\":\"reconcile_error\",\"step\":\"remove\",\"error\":%q}", err.Error())
+			metricsReconcileErr("remove")
 		} else {
 			resp3.Body.Close()
+			atomic.AddInt64(&metReconcileRemove, int64(len(extras)))
 			log.Printf("{\"event\":\"reconcile_remove\",\"count\":%d}", len(extras))
 		}
 	}
@@ -2030,6 +2075,40 @@ func apiConfigChains(chains []map[string]any, updateOnly bool) error {
 	return fmt.Errorf("chains api partial/failed: %d/%d", okCount, len(chains))
 }
 
+// apiConfigBypasses upserts bypasses via GOST Web API.
+func apiConfigBypasses(bypasses []map[string]any) error {
+	okCount := 0
+	for _, b := range bypasses {
+		name, _ := b["name"].(string)
+		target := normalizeJSONAny(b)
+		if cur, code, _ := apiGetByName("bypasses", name); code == 200 && cur != nil {
+			if equalBySubset(target, cur) {
+				log.Printf(`{"event":"gost_api_skip_put","res":"bypasses","name":%q}`, name)
+				okCount++
+				continue
+			}
+			body, _ := json.Marshal(b)
+			if code2, _, err := apiDo("PUT", "/config/bypasses/"+url.PathEscape(name), body); err == nil && code2/100 == 2 {
+				okCount++
+				continue
+			}
+		} else {
+			body, _ := json.Marshal(b)
+			if code2, _, err := apiDo("POST", "/config/bypasses", body); err == nil && code2/100 == 2 {
+				okCount++
+				continue
+			}
+		}
+	}
+	if okCount == len(bypasses) {
+		if err := persistGostConfigServer(); err != nil {
+			log.Printf("{\"event\":\"gost_server_persist_err\",\"error\":%q}", err.Error())
+		}
+		return nil
+	}
+	return fmt.Errorf("bypasses api partial/failed: %d/%d", okCount, len(bypasses))
+}
+
 // apiConfigObservers upserts observers via GOST Web API.
 // When updateOnly is true, it will only update existing observers; otherwise upsert.
 func apiConfigObservers(observers []map[string]any, updateOnly bool) error {
@@ -2040,6 +2119,7 @@ func apiConfigObservers(observers []map[string]any, updateOnly bool) error {
 	// Strict single-object per call; GET existence decides PUT or POST
 	okCount := 0
 	for _, o := range observers {
+		spoolObserverSpec(o)
 		name, _ := o["name"].(string)
 		target := normalizeJSONAny(o)
 		if cur, code, _ := apiGetByName("observers", name); code == 200 && cur != nil {
@@ -2328,6 +2408,25 @@ func suggestPorts(base, count int) []int {
 func addOrUpdateServices(services []map[string]any, updateOnly bool) error {
 	// 必须使用 Web API；若不可用，直接报错，提示前端去启用 API
 	if isApiUsable() {
+		// extract bypasses first: chains and services refer to them by name
+		bypasses := make([]map[string]any, 0)
+		for i := range services {
+			if extra, ok := services[i]["_bypasses"]; ok {
+				if arr, ok2 := extra.([]any); ok2 {
+					for _, it := range arr {
+						if m, ok3 := it.(map[string]any); ok3 {
+							bypasses = append(bypasses, m)
+						}
+					}
+				}
+				delete(services[i], "_bypasses")
+			}
+		}
+		if len(bypasses) > 0 {
+			if err := apiConfigBypasses(bypasses); err != nil {
+				return err
+			}
+		}
 		// extract chains
 		chains := make([]map[string]any, 0)
 		for i := range services {
@@ -2785,13 +2884,7 @@ func runIperf3ClientVerbose(host string, port, duration int, reverse bool) (floa
 	return bps / 1e6, "ok"
 }
 
-// ---- Probe targets poll & report ----
-
-type probeTarget struct {
-	ID   int64  `json:"id"`
-	Name string `json:"name"`
-	IP   string `json:"ip"`
-}
+// ---- Panel HTTP helpers (probe/report endpoints) ----
 
 func httpPostJSON(url string, body any) (int, []byte, error) {
 	b, _ := json.Marshal(body)
@@ -2815,47 +2908,6 @@ func apiURL(scheme, addr, path string) string {
 	return u.String()
 }
 
-func periodicProbe(addr, secret, scheme string) {
-	ticker := time.NewTicker(60 * time.Second)
-	defer ticker.Stop()
-	for {
-		doProbeOnce(addr, secret, scheme)
-		<-ticker.C
-	}
-}
-
-func doProbeOnce(addr, secret, scheme string) {
-	// fetch targets
-	url1 := apiURL(scheme, addr, "/api/v1/agent/probe-targets")
-	type resp struct {
-		Code int           `json:"code"`
-		Data []probeTarget `json:"data"`
-	}
-	code, body, err := httpPostJSON(url1, map[string]any{"secret": secret})
-	if err != nil || code != 200 {
-		return
-	}
-	var r resp
-	if json.Unmarshal(body, &r) != nil || r.Code != 0 || len(r.Data) == 0 {
-		return
-	}
-	// ping each
-	results := make([]map[string]any, 0, len(r.Data))
-	for _, t := range r.Data {
-		avg, loss := runICMP(t.IP, 1, 1000)
-		ok := 0
-		if loss < 100 && avg > 0 {
-			ok = 1
-		}
-		results = append(results, map[string]any{"targetId": t.ID, "rttMs": avg, "ok": ok})
-	}
-	if len(results) == 0 {
-		return
-	}
-	url2 := apiURL(scheme, addr, "/api/v1/agent/report-probe")
-	_, _, _ = httpPostJSON(url2, map[string]any{"secret": secret, "results": results})
-}
-
 // selfUpgrade downloads latest agent binary from server and restarts service
 func selfUpgrade(addr, scheme string) error {
 	arch := detectArch()
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ---- Local Prometheus endpoint ----
+//
+// Enabled by METRICS_LISTEN (e.g. 127.0.0.1:9101). When METRICS_TOKEN is set,
+// scrapes must send "Authorization: Bearer <token>". Counters live in memory
+// and restart from zero with the agent.
+
+type anytlsUserStat struct {
+	active   map[string]int64 // by network: tcp / udp
+	sessions map[string]int64
+	inBytes  int64
+	outBytes int64
+}
+
+var (
+	metWSConnects    int64
+	metWSDisconnects int64
+	metWSConnected   int64
+
+	metReconcileRuns   int64
+	metReconcilePushed int64
+	metReconcileRemove int64
+	metReconcileLastMs int64
+	metReconcileMiss   int64
+	metReconcileExtra  int64
+	metReconcileErrMu  sync.Mutex
+	metReconcileErrs   = map[string]int64{}
+
+	metAnyTLSMu sync.Mutex
+	metAnyTLS   = map[int64]*anytlsUserStat{}
+
+	metProbeMu sync.Mutex
+	metProbe   = map[int64]struct {
+		t probeTarget
+		r probeResult
+	}{}
+)
+
+func anytlsUserStatLocked(userID int64) *anytlsUserStat {
+	st := metAnyTLS[userID]
+	if st == nil {
+		st = &anytlsUserStat{active: map[string]int64{}, sessions: map[string]int64{}}
+		metAnyTLS[userID] = st
+	}
+	return st
+}
+
+// metricsSessionOpen tracks an AnyTLS session; call the returned func when it ends.
+func metricsSessionOpen(userID int64, network string) func() {
+	metAnyTLSMu.Lock()
+	st := anytlsUserStatLocked(userID)
+	st.active[network]++
+	st.sessions[network]++
+	metAnyTLSMu.Unlock()
+	return func() {
+		metAnyTLSMu.Lock()
+		st.active[network]--
+		metAnyTLSMu.Unlock()
+	}
+}
+
+func metricsAnyTLSBytes(userID, in, out int64) {
+	metAnyTLSMu.Lock()
+	st := anytlsUserStatLocked(userID)
+	st.inBytes += in
+	st.outBytes += out
+	metAnyTLSMu.Unlock()
+}
+
+func metricsReconcileErr(step string) {
+	metReconcileErrMu.Lock()
+	metReconcileErrs[step]++
+	metReconcileErrMu.Unlock()
+}
+
+func metricsProbeResults(targets []probeTarget, results []probeResult) {
+	metProbeMu.Lock()
+	defer metProbeMu.Unlock()
+	for i, r := range results {
+		if i < len(targets) {
+			metProbe[targets[i].ID] = struct {
+				t probeTarget
+				r probeResult
+			}{targets[i], r}
+		}
+	}
+}
+
+func startMetricsServer() {
+	listen := strings.TrimSpace(getenv("METRICS_LISTEN", ""))
+	if listen == "" {
+		return
+	}
+	token := strings.TrimSpace(getenv("METRICS_TOKEN", ""))
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		if token != "" {
+			given := strings.TrimSpace(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+			if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_, _ = w.Write([]byte(renderMetrics()))
+	})
+	srv := &http.Server{Addr: listen, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		log.Printf("{\"event\":\"metrics_listen\",\"addr\":%q}", listen)
+		if err := srv.ListenAndServe(); err != nil {
+			log.Printf("{\"event\":\"metrics_listen_err\",\"error\":%q}", err.Error())
+		}
+	}()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+type metricFamily struct {
+	b strings.Builder
+}
+
+func (m *metricFamily) head(name, typ, help string) {
+	fmt.Fprintf(&m.b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func (m *metricFamily) sample(name string, v float64, labels ...string) {
+	m.b.WriteString(name)
+	if len(labels) > 1 {
+		m.b.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				m.b.WriteByte(',')
+			}
+			fmt.Fprintf(&m.b, "%s=\"%s\"", labels[i], labelEscaper.Replace(labels[i+1]))
+		}
+		m.b.WriteByte('}')
+	}
+	if math.IsNaN(v) {
+		m.b.WriteString(" NaN\n")
+		return
+	}
+	m.b.WriteString(" " + strconv.FormatFloat(v, 'g', -1, 64) + "\n")
+}
+
+func b2f(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func renderMetrics() string {
+	m := &metricFamily{}
+	m.head("flux_agent_info", "gauge", "Agent version.")
+	m.sample("flux_agent_info", 1, "version", version)
+
+	m.head("flux_agent_gost_running", "gauge", "1 if the gost service is running.")
+	m.sample("flux_agent_gost_running", b2f(gostRunning()))
+	m.head("flux_agent_gost_api", "gauge", "1 if the gost web API is reachable.")
+	m.sample("flux_agent_gost_api", b2f(apiAvailable()))
+
+	m.head("flux_agent_ws_connected", "gauge", "1 while the panel websocket is connected.")
+	m.sample("flux_agent_ws_connected", float64(atomic.LoadInt64(&metWSConnected)))
+	m.head("flux_agent_ws_connects_total", "counter", "Successful panel websocket connections.")
+	m.sample("flux_agent_ws_connects_total", float64(atomic.LoadInt64(&metWSConnects)))
+	m.head("flux_agent_ws_reconnects_total", "counter", "Websocket sessions that ended (or failed) and were retried.")
+	m.sample("flux_agent_ws_reconnects_total", float64(atomic.LoadInt64(&metWSDisconnects)))
+
+	m.head("flux_agent_reconcile_runs_total", "counter", "Reconcile runs against the panel's desired services.")
+	m.sample("flux_agent_reconcile_runs_total", float64(atomic.LoadInt64(&metReconcileRuns)))
+	m.head("flux_agent_reconcile_errors_total", "counter", "Reconcile failures by step.")
+	metReconcileErrMu.Lock()
+	steps := make([]string, 0, len(metReconcileErrs))
+	for k := range metReconcileErrs {
+		steps = append(steps, k)
+	}
+	sort.Strings(steps)
+	for _, k := range steps {
+		m.sample("flux_agent_reconcile_errors_total", float64(metReconcileErrs[k]), "step", k)
+	}
+	metReconcileErrMu.Unlock()
+	m.head("flux_agent_reconcile_drift_services", "gauge", "Drift found by the last reconcile: missing desired services and extra managed services.")
+	m.sample("flux_agent_reconcile_drift_services", float64(atomic.LoadInt64(&metReconcileMiss)), "kind", "missing")
+	m.sample("flux_agent_reconcile_drift_services", float64(atomic.LoadInt64(&metReconcileExtra)), "kind", "extra")
+	m.head("flux_agent_reconcile_pushed_total", "counter", "Services re-pushed by reconcile.")
+	m.sample("flux_agent_reconcile_pushed_total", float64(atomic.LoadInt64(&metReconcilePushed)))
+	m.head("flux_agent_reconcile_removed_total", "counter", "Extra services removed by strict reconcile.")
+	m.sample("flux_agent_reconcile_removed_total", float64(atomic.LoadInt64(&metReconcileRemove)))
+	m.head("flux_agent_reconcile_last_timestamp_seconds", "gauge", "Time of the last reconcile run.")
+	m.sample("flux_agent_reconcile_last_timestamp_seconds", float64(atomic.LoadInt64(&metReconcileLastMs))/1000)
+
+	metAnyTLSMu.Lock()
+	users := make([]int64, 0, len(metAnyTLS))
+	for id := range metAnyTLS {
+		users = append(users, id)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i] < users[j] })
+	m.head("flux_agent_anytls_active_sessions", "gauge", "Open AnyTLS proxy sessions per user.")
+	for _, id := range users {
+		for _, nw := range []string{"tcp", "udp"} {
+			m.sample("flux_agent_anytls_active_sessions", float64(metAnyTLS[id].active[nw]), "user_id", strconv.FormatInt(id, 10), "network", nw)
+		}
+	}
+	m.head("flux_agent_anytls_sessions_total", "counter", "AnyTLS proxy sessions opened per user.")
+	for _, id := range users {
+		for _, nw := range []string{"tcp", "udp"} {
+			m.sample("flux_agent_anytls_sessions_total", float64(metAnyTLS[id].sessions[nw]), "user_id", strconv.FormatInt(id, 10), "network", nw)
+		}
+	}
+	m.head("flux_agent_anytls_bytes_total", "counter", "AnyTLS bytes relayed per user (in = client to remote).")
+	for _, id := range users {
+		m.sample("flux_agent_anytls_bytes_total", float64(metAnyTLS[id].inBytes), "user_id", strconv.FormatInt(id, 10), "direction", "in")
+		m.sample("flux_agent_anytls_bytes_total", float64(metAnyTLS[id].outBytes), "user_id", strconv.FormatInt(id, 10), "direction", "out")
+	}
+	metAnyTLSMu.Unlock()
+	m.head("flux_agent_flow_spool_pending_batches", "gauge", "Flow batches waiting for the panel to acknowledge.")
+	m.sample("flux_agent_flow_spool_pending_batches", float64(flowSpoolPending()))
+	m.head("flux_agent_flow_batches_sent_total", "counter", "Flow batches acknowledged by the panel.")
+	m.sample("flux_agent_flow_batches_sent_total", float64(atomic.LoadInt64(&metFlowBatchesSent)))
+	m.head("flux_agent_flow_send_errors_total", "counter", "Failed flow batch uploads (retried with backoff).")
+	m.sample("flux_agent_flow_send_errors_total", float64(atomic.LoadInt64(&metFlowSendErrors)))
+	m.head("flux_agent_anytls_fallback_total", "counter", "Unauthenticated AnyTLS sessions handed to the fallback site.")
+	m.sample("flux_agent_anytls_fallback_total", float64(atomic.LoadInt64(&metAnyTLSFallback)))
+	m.head("flux_agent_anytls_limit_total", "counter", "AnyTLS sessions rejected or evicted by per-user session/IP limits.")
+	anytlsViolMu.Lock()
+	for _, k := range [][2]string{{"sessions", "rejected"}, {"sessions", "evicted"}, {"ips", "rejected"}, {"ips", "evicted"}} {
+		m.sample("flux_agent_anytls_limit_total", float64(anytlsViolTotals[k]), "kind", k[0], "action", k[1])
+	}
+	anytlsViolMu.Unlock()
+
+	metProbeMu.Lock()
+	ids := make([]int64, 0, len(metProbe))
+	for id := range metProbe {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	type probeGauge struct {
+		name, help string
+		val        func(probeResult) float64
+	}
+	for _, g := range []probeGauge{
+		{"flux_agent_probe_up", "1 if the latest probe succeeded.", func(r probeResult) float64 { return float64(r.OK) }},
+		{"flux_agent_probe_rtt_ms", "Latest probe round-trip time in milliseconds.", func(r probeResult) float64 { return float64(r.RTTMs) }},
+		{"flux_agent_probe_loss_percent", "Latest probe packet loss percent.", func(r probeResult) float64 { return r.LossPct }},
+		{"flux_agent_probe_jitter_ms", "Latest probe jitter in milliseconds.", func(r probeResult) float64 { return r.JitterMs }},
+	} {
+		m.head(g.name, "gauge", g.help)
+		for _, id := range ids {
+			p := metProbe[id]
+			m.sample(g.name, g.val(p.r), "target_id", strconv.FormatInt(id, 10), "target", p.t.Name, "type", p.t.Type)
+		}
+	}
+	metProbeMu.Unlock()
+
+	if ct, err := readCPUTimes(); err == nil {
+		// /proc/stat is in USER_HZ (100 on Linux)
+		m.head("flux_agent_host_cpu_seconds_total", "counter", "Host CPU time from /proc/stat.")
+		m.sample("flux_agent_host_cpu_seconds_total", float64(ct.idle)/100, "mode", "idle")
+		m.sample("flux_agent_host_cpu_seconds_total", float64(ct.total-ct.idle)/100, "mode", "busy")
+	}
+	m.head("flux_agent_host_memory_used_percent", "gauge", "Host memory usage percent.")
+	m.sample("flux_agent_host_memory_used_percent", memUsagePercent())
+	rx, tx := netBytes()
+	m.head("flux_agent_host_network_bytes_total", "counter", "Host bytes over all interfaces from /proc/net/dev.")
+	m.sample("flux_agent_host_network_bytes_total", float64(rx), "direction", "rx")
+	m.sample("flux_agent_host_network_bytes_total", float64(tx), "direction", "tx")
+	m.head("flux_agent_host_uptime_seconds", "gauge", "Host uptime.")
+	m.sample("flux_agent_host_uptime_seconds", float64(uptimeSeconds()))
+	return m.b.String()
+}
@@ -0,0 +1,719 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	box "github.com/sagernet/sing-box"
+	"github.com/sagernet/sing-box/adapter"
+	"github.com/sagernet/sing-box/option"
+	"github.com/sagernet/sing/common/buf"
+	M "github.com/sagernet/sing/common/metadata"
+	N "github.com/sagernet/sing/common/network"
+	"golang.org/x/time/rate"
+)
+
+// ---- Native VLESS / Trojan / Hysteria2 exits ----
+//
+// The panel pushes "SetNativeExits" with every inbound of the node and the
+// users allowed on it. They run in one embedded sing-box instance whose users
+// are named by panel user ID; a connection tracker applies the per-user speed
+// limit and feeds traffic into the flow spool like AnyTLS sessions do.
+// The node's AnyTLS outbound rules become sing-box route rules, and client
+// sessions count against the AnyTLS session/IP limits (anytls_limit.go).
+// Trojan, Hysteria2 and VLESS without Reality serve the ACME certificate of the
+// node domain once it is exported, the self-signed AnyTLS certificate before.
+
+const nativeExitConfigPath = "/etc/gost/native_exits.json"
+
+type nativeExitUser struct {
+	UserID      int64  `json:"userId"`
+	Credential  string `json:"credential"` // UUID (vless) or password
+	SpeedBps    int64  `json:"speedBps,omitempty"`
+	MaxSessions int    `json:"maxSessions,omitempty"`
+	MaxIPs      int    `json:"maxIps,omitempty"`
+	LimitMode   string `json:"limitMode,omitempty"` // reject | evict
+}
+
+type nativeExitReality struct {
+	Handshake  string `json:"handshake"` // host:port
+	ServerName string `json:"serverName,omitempty"`
+	PrivateKey string `json:"privateKey"`
+	ShortID    string `json:"shortId,omitempty"`
+}
+
+type nativeExit struct {
+	ID           int64              `json:"id"`
+	Protocol     string             `json:"protocol"` // vless | trojan | hysteria2
+	Port         int                `json:"port"`
+	Flow         string             `json:"flow,omitempty"`
+	SNI          string             `json:"sni,omitempty"`
+	Reality      *nativeExitReality `json:"reality,omitempty"`
+	ObfsPassword string             `json:"obfsPassword,omitempty"`
+	UpMbps       int                `json:"upMbps,omitempty"`
+	DownMbps     int                `json:"downMbps,omitempty"`
+	Users        []nativeExitUser   `json:"users,omitempty"`
+}
+
+type nativeExitConfig struct {
+	ExitIP string       `json:"exitIp,omitempty"`
+	Domain string       `json:"domain,omitempty"` // node certificate domain (see anytls_acme.go)
+	Exits  []nativeExit `json:"exits,omitempty"`
+	// the AnyTLS outbound rules of the node (see anytls_outbound.go)
+	Outbound *exitOutbound `json:"outbound,omitempty"`
+}
+
+var (
+	nativeExitMu      sync.Mutex
+	nativeExitBox     *box.Box
+	nativeExitCurrent nativeExitConfig
+	// set when the running box uses the self-signed certificate in place of
+	// the domain's ACME one
+	nativeExitSelfSigned bool
+)
+
+func loadNativeExitConfig() (nativeExitConfig, bool) {
+	var cfg nativeExitConfig
+	b, err := os.ReadFile(nativeExitConfigPath)
+	if err != nil || json.Unmarshal(b, &cfg) != nil || len(cfg.Exits) == 0 {
+		return cfg, false
+	}
+	return cfg, true
+}
+
+func saveNativeExitConfig(cfg nativeExitConfig) error {
+	if len(cfg.Exits) == 0 {
+		if err := os.Remove(nativeExitConfigPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(nativeExitConfigPath), 0o755); err != nil {
+		return err
+	}
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(nativeExitConfigPath, b, 0o600)
+}
+
+func applyNativeExitConfig(cfg nativeExitConfig) error {
+	if err := startNativeExits(cfg, false); err != nil {
+		return err
+	}
+	return saveNativeExitConfig(cfg)
+}
+
+// startNativeExits (re)starts the sing-box instance; an empty config stops it.
+func startNativeExits(cfg nativeExitConfig, force bool) error {
+	cfg.ExitIP = strings.TrimSpace(cfg.ExitIP)
+	cfg.Domain = strings.ToLower(strings.TrimSpace(cfg.Domain))
+	nativeExitMu.Lock()
+	defer nativeExitMu.Unlock()
+	if !force && nativeExitBox != nil && reflect.DeepEqual(cfg, nativeExitCurrent) {
+		return nil
+	}
+	if len(cfg.Exits) == 0 {
+		stopNativeExitsLocked()
+		nativeExitCurrent = cfg
+		return nil
+	}
+	rules, err := compileOutbound(cfg.Outbound)
+	if err != nil {
+		return err
+	}
+	raw, selfSigned, err := nativeExitBoxConfig(cfg, rules)
+	if err != nil {
+		return err
+	}
+	ctx := singboxContext()
+	var opts option.Options
+	if err := opts.UnmarshalJSONContext(ctx, raw); err != nil {
+		return err
+	}
+	// the listeners are taken over by the new instance
+	stopNativeExitsLocked()
+	sb, err := box.New(box.Options{Options: opts, Context: ctx})
+	if err != nil {
+		return err
+	}
+	sb.Router().AppendTracker(newNativeExitTracker(cfg, rules))
+	if err := sb.Start(); err != nil {
+		_ = sb.Close()
+		return err
+	}
+	nativeExitBox = sb
+	nativeExitCurrent = cfg
+	nativeExitSelfSigned = selfSigned
+	for _, ex := range cfg.Exits {
+		log.Printf("{\"event\":\"native_exit_start\",\"id\":%d,\"protocol\":%q,\"port\":%d,\"users\":%d}", ex.ID, ex.Protocol, ex.Port, len(ex.Users))
+	}
+	return nil
+}
+
+func stopNativeExitsLocked() {
+	if nativeExitBox != nil {
+		_ = nativeExitBox.Close()
+		nativeExitBox = nil
+	}
+}
+
+// nativeExitCertExported restarts the exits that still serve the self-signed
+// certificate once the ACME certificate of their domain is on disk.
+func nativeExitCertExported(domain string) {
+	nativeExitMu.Lock()
+	cfg, pending := nativeExitCurrent, nativeExitSelfSigned && nativeExitBox != nil
+	nativeExitMu.Unlock()
+	if pending && strings.EqualFold(cfg.Domain, domain) {
+		if err := startNativeExits(cfg, true); err != nil {
+			log.Printf("{\"event\":\"native_exit_restart_err\",\"error\":%q}", err.Error())
+		}
+	}
+}
+
+// nativeExitCertPaths picks the certificate for TLS inbounds.
+func nativeExitCertPaths(domain string) (string, string, bool, error) {
+	if domain != "" {
+		certPath := filepath.Join(acmeCertDir, domain+".crt")
+		keyPath := filepath.Join(acmeCertDir, domain+".key")
+		if _, err := os.Stat(certPath); err == nil {
+			if _, err := os.Stat(keyPath); err == nil {
+				return certPath, keyPath, false, nil
+			}
+		}
+	}
+	if _, err := ensureAnyTLSCert(); err != nil {
+		return "", "", true, err
+	}
+	return anytlsCertPath, anytlsKeyPath, true, nil
+}
+
+func nativeExitBoxConfig(cfg nativeExitConfig, rules *outboundRules) ([]byte, bool, error) {
+	var certPath, keyPath string
+	selfSigned := false
+	inbounds := make([]any, 0, len(cfg.Exits))
+	tags := make([]string, 0, len(cfg.Exits))
+	for _, ex := range cfg.Exits {
+		if ex.Port <= 0 || ex.Port > 65535 {
+			return nil, false, fmt.Errorf("invalid port %d", ex.Port)
+		}
+		in := map[string]any{
+			"type":        ex.Protocol,
+			"tag":         fmt.Sprintf("%s-%d", ex.Protocol, ex.ID),
+			"listen":      "::",
+			"listen_port": ex.Port,
+		}
+		tags = append(tags, in["tag"].(string))
+		users := make([]map[string]any, 0, len(ex.Users))
+		for _, u := range ex.Users {
+			if u.UserID <= 0 || strings.TrimSpace(u.Credential) == "" {
+				continue
+			}
+			user := map[string]any{"name": strconv.FormatInt(u.UserID, 10)}
+			if ex.Protocol == "vless" {
+				user["uuid"] = u.Credential
+				if ex.Flow != "" {
+					user["flow"] = ex.Flow
+				}
+			} else {
+				user["password"] = u.Credential
+			}
+			users = append(users, user)
+		}
+		in["users"] = users
+		tlsOpts := map[string]any{"enabled": true}
+		if ex.SNI != "" {
+			tlsOpts["server_name"] = ex.SNI
+		}
+		switch {
+		case ex.Protocol == "vless" && ex.Reality != nil:
+			host, portStr, err := net.SplitHostPort(ex.Reality.Handshake)
+			port, _ := strconv.Atoi(portStr)
+			if err != nil || host == "" || port <= 0 {
+				return nil, false, fmt.Errorf("invalid reality handshake %q", ex.Reality.Handshake)
+			}
+			if ex.Reality.ServerName != "" {
+				tlsOpts["server_name"] = ex.Reality.ServerName
+			}
+			reality := map[string]any{
+				"enabled":     true,
+				"handshake":   map[string]any{"server": host, "server_port": port},
+				"private_key": ex.Reality.PrivateKey,
+			}
+			if ex.Reality.ShortID != "" {
+				reality["short_id"] = []string{ex.Reality.ShortID}
+			}
+			tlsOpts["reality"] = reality
+		case ex.Protocol == "vless" || ex.Protocol == "trojan" || ex.Protocol == "hysteria2":
+			if certPath == "" {
+				var err error
+				if certPath, keyPath, selfSigned, err = nativeExitCertPaths(cfg.Domain); err != nil {
+					return nil, false, err
+				}
+			}
+			tlsOpts["certificate_path"] = certPath
+			tlsOpts["key_path"] = keyPath
+		default:
+			return nil, false, fmt.Errorf("unsupported protocol %q", ex.Protocol)
+		}
+		if ex.Protocol == "hysteria2" {
+			tlsOpts["alpn"] = []string{"h3"}
+			if ex.UpMbps > 0 {
+				in["up_mbps"] = ex.UpMbps
+			}
+			if ex.DownMbps > 0 {
+				in["down_mbps"] = ex.DownMbps
+			}
+			if ex.ObfsPassword != "" {
+				in["obfs"] = map[string]any{"type": "salamander", "password": ex.ObfsPassword}
+			}
+		}
+		in["tls"] = tlsOpts
+		inbounds = append(inbounds, in)
+	}
+	outbounds, routeRules, err := nativeExitRoutes(cfg, rules, tags)
+	if err != nil {
+		return nil, false, err
+	}
+	route := map[string]any{"final": "direct"}
+	if len(routeRules) > 0 {
+		route["rules"] = routeRules
+	}
+	raw, err := json.Marshal(map[string]any{
+		"log":       map[string]any{"disabled": true},
+		"inbounds":  inbounds,
+		"outbounds": outbounds,
+		"route":     route,
+	})
+	return raw, selfSigned, err
+}
+
+// nativeExitPrivateCIDRs mirrors isPrivateDest.
+var nativeExitPrivateCIDRs = []string{
+	"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7",
+	"127.0.0.0/8", "::1/128", "0.0.0.0/32", "::/128",
+	"169.254.0.0/16", "fe80::/10", "224.0.0.0/4", "ff00::/8", "100.64.0.0/10",
+}
+
+func directOutbound(tag, exitIP string) map[string]any {
+	out := map[string]any{"type": "direct", "tag": tag}
+	if ip := net.ParseIP(exitIP); ip != nil {
+		if ip.To4() != nil {
+			out["inet4_bind_address"] = exitIP
+		} else {
+			out["inet6_bind_address"] = exitIP
+		}
+	}
+	return out
+}
+
+// nativeExitRoutes translates the outbound rules in the order decide checks
+// them: blocked ports and domains, then (after resolving the destination
+// once, so the dialed address is the one checked) blocked IPs, then routes.
+func nativeExitRoutes(cfg nativeExitConfig, rules *outboundRules, tags []string) ([]any, []any, error) {
+	outbounds := []any{directOutbound("direct", cfg.ExitIP)}
+	if rules == nil {
+		return outbounds, nil, nil
+	}
+	var out []any
+	reject := func(rule map[string]any) {
+		rule["action"] = "reject"
+		out = append(out, rule)
+	}
+	if len(rules.ports) > 0 {
+		ranges := make([]string, 0, len(rules.ports))
+		for _, p := range rules.ports {
+			ranges = append(ranges, fmt.Sprintf("%d:%d", p[0], p[1]))
+		}
+		reject(map[string]any{"port_range": ranges})
+	}
+	block := singboxHostRule(rules.block)
+	if rules.blockPrivate {
+		block["domain"] = append(stringsOf(block["domain"]), "localhost")
+		block["ip_cidr"] = append(stringsOf(block["ip_cidr"]), nativeExitPrivateCIDRs...)
+	}
+	need := rules.blockPrivate || rules.block.hasNets()
+	for _, rt := range rules.routes {
+		need = need || rt.match.hasNets()
+	}
+	if d := pick(block, "domain", "domain_suffix"); len(d) > 0 {
+		reject(d)
+	}
+	if need {
+		out = append(out, map[string]any{"inbound": tags, "action": "resolve"})
+	}
+	if ip := pick(block, "ip_cidr"); len(ip) > 0 {
+		reject(ip)
+	}
+	for i, rt := range rules.routes {
+		tag := fmt.Sprintf("route-%d", i)
+		match := singboxHostRule(rt.match)
+		if len(match) == 0 {
+			continue
+		}
+		if rt.socks != nil {
+			u, err := url.Parse(strings.TrimSpace(cfg.Outbound.Routes[i].Socks5))
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid socks5 upstream: %v", err)
+			}
+			port, _ := strconv.Atoi(u.Port())
+			if port == 0 {
+				port = 1080
+			}
+			ob := map[string]any{"type": "socks", "tag": tag, "server": u.Hostname(), "server_port": port, "version": "5"}
+			if u.User != nil {
+				ob["username"] = u.User.Username()
+				ob["password"], _ = u.User.Password()
+			}
+			outbounds = append(outbounds, ob)
+			// SOCKS5 routes are TCP only, like for AnyTLS
+			udp := pick(match, "domain", "domain_suffix", "ip_cidr")
+			udp["network"] = []string{"udp"}
+			reject(udp)
+		} else {
+			outbounds = append(outbounds, directOutbound(tag, rt.local.String()))
+		}
+		match["action"] = "route"
+		match["outbound"] = tag
+		out = append(out, match)
+	}
+	return outbounds, out, nil
+}
+
+// singboxHostRule converts a matcher to sing-box rule conditions, which are
+// ORed within one rule.
+func singboxHostRule(m hostMatcher) map[string]any {
+	rule := map[string]any{}
+	var domains, suffixes, cidrs []string
+	for d := range m.exact {
+		domains = append(domains, d)
+	}
+	for _, s := range m.suffixes {
+		domains = append(domains, s[1:])
+		suffixes = append(suffixes, s)
+	}
+	suffixes = append(suffixes, m.subs...)
+	for ip := range m.ips {
+		if net.ParseIP(ip).To4() != nil {
+			cidrs = append(cidrs, ip+"/32")
+		} else {
+			cidrs = append(cidrs, ip+"/128")
+		}
+	}
+	for _, n := range m.nets {
+		cidrs = append(cidrs, n.String())
+	}
+	for k, v := range map[string][]string{"domain": domains, "domain_suffix": suffixes, "ip_cidr": cidrs} {
+		if len(v) > 0 {
+			sort.Strings(v)
+			rule[k] = v
+		}
+	}
+	return rule
+}
+
+func pick(rule map[string]any, keys ...string) map[string]any {
+	out := map[string]any{}
+	for _, k := range keys {
+		if v, ok := rule[k]; ok {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+func stringsOf(v any) []string {
+	s, _ := v.([]string)
+	return s
+}
+
+// ---- per-user speed limit and flow accounting ----
+
+type nativeExitTracker struct {
+	speed  map[int64]int64 // userID -> bytes/sec
+	limits map[int64]anytlsAuthRule
+	rules  *outboundRules
+}
+
+func newNativeExitTracker(cfg nativeExitConfig, rules *outboundRules) *nativeExitTracker {
+	t := &nativeExitTracker{speed: map[int64]int64{}, limits: map[int64]anytlsAuthRule{}, rules: rules}
+	for _, ex := range cfg.Exits {
+		for _, u := range ex.Users {
+			t.speed[u.UserID] = u.SpeedBps
+			if u.MaxSessions > 0 || u.MaxIPs > 0 {
+				t.limits[u.UserID] = anytlsAuthRule{userID: u.UserID, maxSessions: u.MaxSessions, maxIPs: u.MaxIPs, limitMode: strings.ToLower(strings.TrimSpace(u.LimitMode))}
+			}
+		}
+	}
+	return t
+}
+
+func (t *nativeExitTracker) RoutedConnection(ctx context.Context, conn net.Conn, metadata adapter.InboundContext, _ adapter.Rule, _ adapter.Outbound) net.Conn {
+	uid, _ := strconv.ParseInt(metadata.User, 10, 64)
+	if uid <= 0 {
+		return conn
+	}
+	leave, ok := t.join(uid, metadata.Source, conn)
+	if !ok {
+		_ = conn.Close()
+		return conn
+	}
+	return &nativeExitConn{Conn: conn, flow: newNativeExitFlow(ctx, uid, t.speed[uid], "tcp", leave)}
+}
+
+func (t *nativeExitTracker) RoutedPacketConnection(ctx context.Context, conn N.PacketConn, metadata adapter.InboundContext, _ adapter.Rule, _ adapter.Outbound) N.PacketConn {
+	uid, _ := strconv.ParseInt(metadata.User, 10, 64)
+	if uid <= 0 {
+		return conn
+	}
+	leave, ok := t.join(uid, metadata.Source, conn)
+	if !ok {
+		_ = conn.Close()
+		return conn
+	}
+	return &nativeExitPacketConn{PacketConn: conn, flow: newNativeExitFlow(ctx, uid, t.speed[uid], "udp", leave), rules: t.rules}
+}
+
+// ---- per-user session/IP limits ----
+//
+// A native session is one client connection (source address and port); the
+// streams a multiplexed protocol such as Hysteria2 carries over it share
+// one admission. Evicting a session closes all of its streams.
+
+type nativeExitSession struct {
+	refs    int
+	conns   map[io.Closer]bool
+	release func()
+}
+
+var (
+	nativeExitSessMu sync.Mutex
+	nativeExitSess   = map[string]*nativeExitSession{}
+)
+
+func (s *nativeExitSession) Close() error {
+	nativeExitSessMu.Lock()
+	conns := make([]io.Closer, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	nativeExitSessMu.Unlock()
+	for _, c := range conns {
+		_ = c.Close()
+	}
+	return nil
+}
+
+// join admits conn of user uid; leave must be called when it closes.
+func (t *nativeExitTracker) join(uid int64, source M.Socksaddr, conn io.Closer) (leave func(), ok bool) {
+	rule, limited := t.limits[uid]
+	if !limited {
+		return func() {}, true
+	}
+	key := fmt.Sprintf("%d|%s", uid, source.String())
+	nativeExitSessMu.Lock()
+	s := nativeExitSess[key]
+	if s == nil {
+		nativeExitSessMu.Unlock()
+		fresh := &nativeExitSession{conns: map[io.Closer]bool{}}
+		release, admitted := anytlsAdmit(rule, source.Addr.Unmap().String(), fresh)
+		if !admitted {
+			return nil, false
+		}
+		fresh.release = release
+		nativeExitSessMu.Lock()
+		if s = nativeExitSess[key]; s == nil {
+			s = fresh
+			nativeExitSess[key] = s
+		} else {
+			// another stream of the session got in first
+			defer release()
+		}
+	}
+	s.refs++
+	s.conns[conn] = true
+	nativeExitSessMu.Unlock()
+	return func() {
+		nativeExitSessMu.Lock()
+		delete(s.conns, conn)
+		s.refs--
+		last := s.refs == 0
+		if last && nativeExitSess[key] == s {
+			delete(nativeExitSess, key)
+		}
+		nativeExitSessMu.Unlock()
+		if last {
+			s.release()
+		}
+	}, true
+}
+
+// nativeExitFlow limits and counts one connection; deltas are reported every
+// few seconds and when the connection closes.
+type nativeExitFlow struct {
+	ctx      context.Context
+	userID   int64
+	up, down *rate.Limiter
+	in, out  int64
+	stop     chan struct{}
+	once     sync.Once
+	release  func()
+	leave    func()
+}
+
+func newNativeExitFlow(ctx context.Context, userID, bps int64, network string, leave func()) *nativeExitFlow {
+	f := &nativeExitFlow{ctx: ctx, userID: userID, stop: make(chan struct{}), release: metricsSessionOpen(userID, network), leave: leave}
+	f.up, _ = newRateLimiter(bps)
+	f.down, _ = newRateLimiter(bps)
+	go f.reportLoop()
+	return f
+}
+
+func (f *nativeExitFlow) reportLoop() {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			f.flush()
+		case <-f.stop:
+			return
+		}
+	}
+}
+
+func (f *nativeExitFlow) flush() {
+	reportNativeExitFlow(f.userID, atomic.SwapInt64(&f.in, 0), atomic.SwapInt64(&f.out, 0))
+}
+
+func (f *nativeExitFlow) close() {
+	f.once.Do(func() {
+		close(f.stop)
+		f.flush()
+		f.release()
+		f.leave()
+	})
+}
+
+// wait blocks until n bytes fit the limiter, in chunks of at most one burst.
+func (f *nativeExitFlow) wait(l *rate.Limiter, n int) error {
+	if l == nil {
+		return nil
+	}
+	for n > 0 {
+		chunk := n
+		if b := l.Burst(); chunk > b {
+			chunk = b
+		}
+		if err := l.WaitN(f.ctx, chunk); err != nil {
+			return err
+		}
+		n -= chunk
+	}
+	return nil
+}
+
+type nativeExitConn struct {
+	net.Conn
+	flow *nativeExitFlow
+}
+
+func (c *nativeExitConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		atomic.AddInt64(&c.flow.in, int64(n))
+		if werr := c.flow.wait(c.flow.up, n); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return n, err
+}
+
+func (c *nativeExitConn) Write(p []byte) (int, error) {
+	if err := c.flow.wait(c.flow.down, len(p)); err != nil {
+		return 0, err
+	}
+	n, err := c.Conn.Write(p)
+	atomic.AddInt64(&c.flow.out, int64(n))
+	return n, err
+}
+
+func (c *nativeExitConn) Close() error {
+	c.flow.close()
+	return c.Conn.Close()
+}
+
+func (c *nativeExitConn) Upstream() any { return c.Conn }
+
+// nativeExitPacketConn also drops datagrams to blocked addresses: the route
+// rules only see the first destination of a UDP session.
+type nativeExitPacketConn struct {
+	N.PacketConn
+	flow  *nativeExitFlow
+	rules *outboundRules
+}
+
+func (c *nativeExitPacketConn) ReadPacket(buffer *buf.Buffer) (M.Socksaddr, error) {
+	for {
+		dest, err := c.PacketConn.ReadPacket(buffer)
+		if err != nil {
+			return dest, err
+		}
+		atomic.AddInt64(&c.flow.in, int64(buffer.Len()))
+		if err := c.flow.wait(c.flow.up, buffer.Len()); err != nil {
+			return dest, err
+		}
+		if c.rules == nil {
+			return dest, nil
+		}
+		if dest, ok := c.rules.packetDest(dest); ok {
+			return dest, nil
+		}
+		buffer.Reset()
+	}
+}
+
+func (c *nativeExitPacketConn) WritePacket(buffer *buf.Buffer, dest M.Socksaddr) error {
+	n := buffer.Len()
+	if err := c.flow.wait(c.flow.down, n); err != nil {
+		buffer.Release()
+		return err
+	}
+	if err := c.PacketConn.WritePacket(buffer, dest); err != nil {
+		return err
+	}
+	atomic.AddInt64(&c.flow.out, int64(n))
+	return nil
+}
+
+func (c *nativeExitPacketConn) Close() error {
+	c.flow.close()
+	return c.PacketConn.Close()
+}
+
+func (c *nativeExitPacketConn) Upstream() any { return c.PacketConn }
+
+// reportNativeExitFlow books a traffic delta like reportAnyTLSFlow, under the
+// "native" spool kind.
+func reportNativeExitFlow(userID int64, inBytes int64, outBytes int64) {
+	if userID <= 0 || (inBytes <= 0 && outBytes <= 0) {
+		return
+	}
+	metricsAnyTLSBytes(userID, inBytes, outBytes)
+	spoolFlow("native", userID, 0, inBytes, outBytes)
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ---- Probe targets poll & report ----
+//
+// Targets are fetched from the panel every minute; each one runs on its own
+// interval (icmp / tcp / http(s) / dns) and results are reported in batches.
+
+type probeTarget struct {
+	ID           int64  `json:"id"`
+	Name         string `json:"name"`
+	IP           string `json:"ip"`
+	Type         string `json:"type"`
+	Port         int    `json:"port"`
+	URL          string `json:"url"`
+	ExpectStatus int    `json:"expectStatus"`
+	ExpectBody   string `json:"expectBody"`
+	DNSName      string `json:"dnsName"`
+	DNSType      string `json:"dnsType"`
+	Count        int    `json:"count"`
+	IntervalSec  int    `json:"intervalSec"`
+	TimeoutMs    int    `json:"timeoutMs"`
+}
+
+type probeResult struct {
+	TargetID int64   `json:"targetId"`
+	RTTMs    int     `json:"rttMs"`
+	OK       int     `json:"ok"`
+	LossPct  float64 `json:"lossPct"`
+	JitterMs float64 `json:"jitterMs"`
+	Error    string  `json:"error,omitempty"`
+	TimeMs   int64   `json:"timeMs"`
+}
+
+var probeTypes = []string{"icmp", "tcp", "http", "dns"}
+
+// probeRunning guards against duplicate schedulers after ws reconnects.
+var probeRunning sync.Mutex
+
+func periodicProbe(addr, secret, scheme string) {
+	if !probeRunning.TryLock() {
+		return
+	}
+	defer probeRunning.Unlock()
+	var targets []probeTarget
+	lastFetch := time.Time{}
+	lastRun := map[int64]time.Time{}
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	for {
+		if time.Since(lastFetch) >= 60*time.Second {
+			if list, ok := fetchProbeTargets(addr, secret, scheme); ok {
+				targets = list
+			}
+			lastFetch = time.Now()
+		}
+		due := make([]probeTarget, 0, len(targets))
+		for _, t := range targets {
+			iv := time.Duration(t.IntervalSec) * time.Second
+			if iv <= 0 {
+				iv = 60 * time.Second
+			}
+			if time.Since(lastRun[t.ID]) >= iv {
+				lastRun[t.ID] = time.Now()
+				due = append(due, t)
+			}
+		}
+		if len(due) > 0 {
+			results := runProbes(due)
+			metricsProbeResults(due, results)
+			_, _, _ = httpPostJSON(apiURL(scheme, addr, "/api/v1/agent/report-probe"), map[string]any{"secret": secret, "results": results})
+		}
+		<-ticker.C
+	}
+}
+
+func fetchProbeTargets(addr, secret, scheme string) ([]probeTarget, bool) {
+	code, body, err := httpPostJSON(apiURL(scheme, addr, "/api/v1/agent/probe-targets"), map[string]any{"secret": secret, "types": probeTypes})
+	if err != nil || code != 200 {
+		return nil, false
+	}
+	var r struct {
+		Code int           `json:"code"`
+		Data []probeTarget `json:"data"`
+	}
+	if json.Unmarshal(body, &r) != nil || r.Code != 0 {
+		return nil, false
+	}
+	return r.Data, true
+}
+
+// runProbes executes targets concurrently (bounded) and returns results in input order.
+func runProbes(targets []probeTarget) []probeResult {
+	out := make([]probeResult, len(targets))
+	sem := make(chan struct{}, 8)
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, t probeTarget) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			out[i] = runProbe(t)
+		}(i, t)
+	}
+	wg.Wait()
+	return out
+}
+
+func runProbe(t probeTarget) probeResult {
+	count := t.Count
+	if count <= 0 {
+		count = 1
+	}
+	timeout := time.Duration(t.TimeoutMs) * time.Millisecond
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+	var samples []float64
+	var lastErr string
+	if t.Type == "" || t.Type == "icmp" {
+		samples, lastErr = icmpSamples(t.IP, count, timeout)
+	} else {
+		for i := 0; i < count; i++ {
+			var ms float64
+			var err error
+			switch t.Type {
+			case "tcp":
+				ms, err = tcpProbeOnce(t.IP, t.Port, timeout)
+			case "http":
+				ms, err = httpProbeOnce(t, timeout)
+			case "dns":
+				ms, err = dnsProbeOnce(t, timeout)
+			default:
+				err = fmt.Errorf("unsupported probe type %q", t.Type)
+			}
+			if err != nil {
+				lastErr = err.Error()
+				continue
+			}
+			samples = append(samples, ms)
+		}
+	}
+	res := probeResult{TargetID: t.ID, TimeMs: time.Now().UnixMilli()}
+	res.LossPct = math.Round(float64(count-len(samples))*10000/float64(count)) / 100
+	if len(samples) == 0 {
+		res.LossPct = 100
+		if lastErr == "" {
+			lastErr = "no response"
+		}
+		res.Error = truncateProbeErr(lastErr)
+		return res
+	}
+	sum := 0.0
+	for _, v := range samples {
+		sum += v
+	}
+	res.OK = 1
+	res.RTTMs = int(sum/float64(len(samples)) + 0.5)
+	if res.RTTMs == 0 {
+		res.RTTMs = 1
+	}
+	res.JitterMs = jitterOf(samples)
+	if res.LossPct > 0 {
+		res.Error = truncateProbeErr(lastErr)
+	}
+	return res
+}
+
+// jitterOf returns the mean absolute difference between consecutive RTT samples.
+func jitterOf(samples []float64) float64 {
+	if len(samples) < 2 {
+		return 0
+	}
+	d := 0.0
+	for i := 1; i < len(samples); i++ {
+		d += math.Abs(samples[i] - samples[i-1])
+	}
+	return math.Round(d/float64(len(samples)-1)*100) / 100
+}
+
+func truncateProbeErr(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) > 240 {
+		s = s[:240]
+	}
+	return s
+}
+
+var rePingTime = regexp.MustCompile(`time[=<]([0-9.]+) ?ms`)
+
+// icmpSamples sends count echo requests with the system ping and returns per-reply RTTs.
+func icmpSamples(host string, count int, timeout time.Duration) ([]float64, string) {
+	if host == "" {
+		return nil, "empty host"
+	}
+	timeoutS := strconv.Itoa(int((timeout + time.Second - 1) / time.Second))
+	args := []string{"-c", strconv.Itoa(count), "-W", timeoutS, host}
+	if strings.Contains(host, ":") {
+		args = append([]string{"-6"}, args...)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(count)*(timeout+time.Second)+2*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "ping", args...).CombinedOutput()
+	var samples []float64
+	for _, m := range rePingTime.FindAllStringSubmatch(string(out), -1) {
+		if f, e := strconv.ParseFloat(m[1], 64); e == nil {
+			samples = append(samples, f)
+		}
+	}
+	if len(samples) == 0 && err != nil {
+		msg := strings.TrimSpace(string(out))
+		if i := strings.LastIndex(msg, "\n"); i >= 0 {
+			msg = msg[i+1:]
+		}
+		if msg == "" {
+			msg = err.Error()
+		}
+		return nil, msg
+	}
+	return samples, ""
+}
+
+func tcpProbeOnce(host string, port int, timeout time.Duration) (float64, error) {
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(port)), timeout)
+	if err != nil {
+		return 0, err
+	}
+	ms := float64(time.Since(start).Microseconds()) / 1000
+	_ = conn.Close()
+	return ms, nil
+}
+
+func httpProbeOnce(t probeTarget, timeout time.Duration) (float64, error) {
+	hc := &http.Client{
+		Timeout: timeout,
+		// a fresh transport per attempt so each sample includes connect + TLS
+		Transport: &http.Transport{DisableKeepAlives: true, Proxy: nil},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	req, err := http.NewRequest("GET", t.URL, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", "flux-agent-probe")
+	start := time.Now()
+	resp, err := hc.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	ms := float64(time.Since(start).Microseconds()) / 1000
+	if t.ExpectStatus > 0 {
+		if resp.StatusCode != t.ExpectStatus {
+			return 0, fmt.Errorf("status %d, expected %d", resp.StatusCode, t.ExpectStatus)
+		}
+	} else if resp.StatusCode >= 400 {
+		return 0, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	if t.ExpectBody != "" {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		if !strings.Contains(string(body), t.ExpectBody) {
+			return 0, fmt.Errorf("body does not contain %q", t.ExpectBody)
+		}
+	}
+	return ms, nil
+}
+
+func dnsProbeOnce(t probeTarget, timeout time.Duration) (float64, error) {
+	r := net.DefaultResolver
+	if t.IP != "" {
+		server := t.IP
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+		r = &net.Resolver{PreferGo: true, Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			d := net.Dialer{Timeout: timeout}
+			return d.DialContext(ctx, network, server)
+		}}
+	}
+	network := "ip4"
+	if strings.EqualFold(t.DNSType, "AAAA") {
+		network = "ip6"
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	start := time.Now()
+	ips, err := r.LookupIP(ctx, network, t.DNSName)
+	if err != nil {
+		return 0, err
+	}
+	if len(ips) == 0 {
+		return 0, fmt.Errorf("no %s records", t.DNSType)
+	}
+	return float64(time.Since(start).Microseconds()) / 1000, nil
+}
+
+// ---- Node-to-node mesh latency ----
+
+type meshTarget struct {
+	NodeID int64  `json:"nodeId"`
+	Path   string `json:"path"`
+	IP     string `json:"ip"`
+}
+
+var meshRunning sync.Mutex
+
+// periodicMeshProbe pings every other panel node (public and overlay IP) when
+// the panel has mesh probing enabled. Settings are re-read each round.
+func periodicMeshProbe(addr, secret, scheme string) {
+	if !meshRunning.TryLock() {
+		return
+	}
+	defer meshRunning.Unlock()
+	// stagger agents so the whole fleet doesn't probe at the same second
+	time.Sleep(time.Duration(time.Now().UnixNano()%30) * time.Second)
+	for {
+		wait := 300 * time.Second
+		var r struct {
+			Code int `json:"code"`
+			Data struct {
+				Enabled     bool         `json:"enabled"`
+				IntervalSec int          `json:"intervalSec"`
+				Count       int          `json:"count"`
+				TimeoutMs   int          `json:"timeoutMs"`
+				Targets     []meshTarget `json:"targets"`
+			} `json:"data"`
+		}
+		code, body, err := httpPostJSON(apiURL(scheme, addr, "/api/v1/agent/mesh-targets"), map[string]any{"secret": secret})
+		if err == nil && code == 200 && json.Unmarshal(body, &r) == nil && r.Code == 0 {
+			if r.Data.IntervalSec > 0 {
+				wait = time.Duration(r.Data.IntervalSec) * time.Second
+			}
+			if r.Data.Enabled && len(r.Data.Targets) > 0 {
+				reportMeshOnce(addr, secret, scheme, r.Data.Targets, r.Data.Count, r.Data.TimeoutMs)
+			}
+		}
+		time.Sleep(wait)
+	}
+}
+
+func reportMeshOnce(addr, secret, scheme string, targets []meshTarget, count, timeoutMs int) {
+	pts := make([]probeTarget, len(targets))
+	for i, t := range targets {
+		pts[i] = probeTarget{ID: int64(i), Type: "icmp", IP: t.IP, Count: count, TimeoutMs: timeoutMs}
+	}
+	res := runProbes(pts)
+	results := make([]map[string]any, 0, len(res))
+	for i, r := range res {
+		t := targets[i]
+		results = append(results, map[string]any{"nodeId": t.NodeID, "path": t.Path, "ip": t.IP, "rttMs": r.RTTMs, "ok": r.OK,
+			"lossPct": r.LossPct, "jitterMs": r.JitterMs, "error": r.Error, "timeMs": r.TimeMs})
+	}
+	_, _, _ = httpPostJSON(apiURL(scheme, addr, "/api/v1/agent/report-mesh"), map[string]any{"secret": secret, "results": results})
+}
@@ -316,6 +316,10 @@ func singboxContext() context.Context {
 		protocolWireguard.RegisterOutbound(outboundRegistry)
 
 		inboundRegistry := inbound.NewRegistry()
+		// native exits (see native_exit.go)
+		protocolVless.RegisterInbound(inboundRegistry)
+		protocolTrojan.RegisterInbound(inboundRegistry)
+		protocolHysteria2.RegisterInbound(inboundRegistry)
 		endpointRegistry := endpoint.NewRegistry()
 		serviceRegistry := serviceAdapter.NewRegistry()
 		dnsRegistry := dns.NewTransportRegistry()
@@ -4,9 +4,13 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
 	_ "network-panel/golang-backend/docs" // swag init generated docs
 	app "network-panel/golang-backend/internal/app"
+	"network-panel/golang-backend/internal/app/controller"
 	"network-panel/golang-backend/internal/app/scheduler"
 	"network-panel/golang-backend/internal/app/util"
 	appver "network-panel/golang-backend/internal/app/version"
@@ -29,8 +33,31 @@ func main() {
 	// start schedulerRs
 	scheduler.Start()
 
+	// write buffered flow accounting before exiting
+	go func() {
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+		<-sigCh
+		controller.FlushFlowBuffer()
+		os.Exit(0)
+	}()
+
 	r := gin.Default()
 	gin.SetMode(gin.DebugMode)
+	// X-Forwarded-For is only honoured from these reverse proxies; by
+	// default one on the same host
+	trusted := []string{"127.0.0.1", "::1"}
+	if v := strings.TrimSpace(os.Getenv("TRUSTED_PROXIES")); v != "" {
+		trusted = nil
+		for _, p := range strings.Split(v, ",") {
+			if p = strings.TrimSpace(p); p != "" && p != "none" {
+				trusted = append(trusted, p)
+			}
+		}
+	}
+	if err := r.SetTrustedProxies(trusted); err != nil {
+		log.Fatalf("invalid TRUSTED_PROXIES: %v", err)
+	}
 	app.RegisterRoutes(r)
 
 	port := os.Getenv("PORT")
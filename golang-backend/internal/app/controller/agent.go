@@ -151,11 +151,17 @@ func AgentReconcileNode(c *gin.Context) {
 		c.JSON(http.StatusOK, response.ErrMsg(errMsg))
 		return
 	}
-	services := desiredServices(node.ID)
-	if len(services) > 0 {
-		_ = sendWSCommand(node.ID, "AddService", expandRUDP(services))
+	pushed, _ := reapplyNodeServices(node.ID)
+	c.JSON(http.StatusOK, response.Ok(map[string]any{"pushed": pushed}))
+}
+
+// reapplyNodeServices pushes the desired forward services to a node.
+func reapplyNodeServices(nodeID int64) (int, error) {
+	services := desiredServices(nodeID)
+	if len(services) == 0 {
+		return 0, nil
 	}
-	c.JSON(http.StatusOK, response.Ok(map[string]any{"pushed": len(services)}))
+	return len(services), sendWSCommand(nodeID, "AddService", expandRUDP(services))
 }
 
 // POST /api/v1/agent/report-services {secret, services: [name...], timeMs?}
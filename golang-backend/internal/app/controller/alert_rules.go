@@ -0,0 +1,872 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"network-panel/golang-backend/internal/app/model"
+	"network-panel/golang-backend/internal/app/response"
+	dbpkg "network-panel/golang-backend/internal/db"
+)
+
+// Alert rule engine.
+//
+// Rules are evaluated every ALERT_EVAL_SEC (default 30s) against the latest
+// stored/buffered samples. A (rule, node, subject) becomes pending on the
+// first breach and fires once the breach has lasted DurationSec; while it
+// keeps firing the open incident is only refreshed (dedup). It auto-resolves
+// after the condition has been clear for ResolveSec. Silenced incidents are
+// recorded but produce no alert feed entry or callback.
+
+var alertMetrics = []map[string]any{
+	{"metric": "cpu", "label": "CPU 使用率", "unit": "%"},
+	{"metric": "mem", "label": "内存使用率", "unit": "%"},
+	{"metric": "bw_in", "label": "入站带宽", "unit": "Mbps"},
+	{"metric": "bw_out", "label": "出站带宽", "unit": "Mbps"},
+	{"metric": "probe_rtt", "label": "探测延迟", "unit": "ms"},
+	{"metric": "probe_loss", "label": "探测丢包", "unit": "%"},
+	{"metric": "gost_down", "label": "GOST 未运行", "bool": true},
+	{"metric": "forward_down", "label": "转发服务缺失", "bool": true},
+	{"metric": "offline", "label": "节点离线", "bool": true},
+}
+
+func alertMetricInfo(metric string) (label, unit string, isBool bool, ok bool) {
+	for _, m := range alertMetrics {
+		if m["metric"] == metric {
+			label, _ = m["label"].(string)
+			unit, _ = m["unit"].(string)
+			isBool, _ = m["bool"].(bool)
+			return label, unit, isBool, true
+		}
+	}
+	return "", "", false, false
+}
+
+var severityLabel = map[string]string{"info": "提示", "warning": "警告", "critical": "严重"}
+
+var (
+	alertEngineOnce sync.Once
+	alertEngineMu   sync.Mutex
+	// dedupKey -> first breach / first clear time (ms)
+	alertPending = map[string]int64{}
+	alertClear   = map[string]int64{}
+)
+
+// StartAlertEngine starts the periodic rule evaluator.
+func StartAlertEngine() {
+	alertEngineOnce.Do(func() {
+		go alertEngineLoop()
+	})
+}
+
+func alertEngineLoop() {
+	sec := 30
+	if v := os.Getenv("ALERT_EVAL_SEC"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			sec = n
+		}
+	}
+	ticker := time.NewTicker(time.Duration(sec) * time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		evaluateAlertRulesOnce()
+	}
+}
+
+type alertObs struct {
+	node    model.Node
+	subject string
+	value   float64
+	detail  string
+}
+
+// alertSnapshot lazily loads the data the rules need for one evaluation round.
+type alertSnapshot struct {
+	now      int64
+	nodes    []model.Node
+	sys      map[int64][]model.NodeSysInfo // last two samples per node, oldest first
+	sysDone  bool
+	probes   map[[2]int64]model.NodeProbeResult
+	probDone bool
+}
+
+func (s *alertSnapshot) loadSys() {
+	if s.sysDone {
+		return
+	}
+	s.sysDone = true
+	s.sys = map[int64][]model.NodeSysInfo{}
+	from := s.now - 5*60*1000
+	var rows []model.NodeSysInfo
+	dbpkg.DB.Where("time_ms >= ?", from).Order("time_ms asc").Find(&rows)
+	for _, n := range s.nodes {
+		rows = append(rows, readBufferedSysInfo(n.ID, from)...)
+	}
+	for _, r := range rows {
+		list := append(s.sys[r.NodeID], r)
+		if len(list) > 2 {
+			list = list[len(list)-2:]
+		}
+		s.sys[r.NodeID] = list
+	}
+}
+
+func (s *alertSnapshot) loadProbes() {
+	if s.probDone {
+		return
+	}
+	s.probDone = true
+	s.probes = map[[2]int64]model.NodeProbeResult{}
+	from := s.now - 10*60*1000
+	var rows []model.NodeProbeResult
+	dbpkg.DB.Where("time_ms >= ?", from).Order("time_ms asc").Find(&rows)
+	rows = append(rows, readBufferedProbes(0, from)...)
+	for _, r := range rows {
+		k := [2]int64{r.NodeID, r.TargetID}
+		if cur, ok := s.probes[k]; !ok || r.TimeMs >= cur.TimeMs {
+			s.probes[k] = r
+		}
+	}
+}
+
+func (s *alertSnapshot) node(id int64) (model.Node, bool) {
+	for _, n := range s.nodes {
+		if n.ID == id {
+			return n, true
+		}
+	}
+	return model.Node{}, false
+}
+
+// observe returns the current value per (node, subject) for a rule's metric.
+func (s *alertSnapshot) observe(r model.AlertRule) []alertObs {
+	out := []alertObs{}
+	switch r.Metric {
+	case "cpu", "mem":
+		s.loadSys()
+		for _, n := range s.nodes {
+			list := s.sys[n.ID]
+			if len(list) == 0 || !isNodeConnected(n.ID) {
+				continue
+			}
+			last := list[len(list)-1]
+			v := last.CPU
+			if r.Metric == "mem" {
+				v = last.Mem
+			}
+			out = append(out, alertObs{node: n, value: v})
+		}
+	case "bw_in", "bw_out":
+		s.loadSys()
+		for _, n := range s.nodes {
+			list := s.sys[n.ID]
+			if len(list) < 2 || !isNodeConnected(n.ID) {
+				continue
+			}
+			a, b := list[0], list[1]
+			dt := float64(b.TimeMs-a.TimeMs) / 1000
+			delta := b.BytesRx - a.BytesRx
+			if r.Metric == "bw_out" {
+				delta = b.BytesTx - a.BytesTx
+			}
+			if dt <= 0 || delta < 0 {
+				continue
+			}
+			out = append(out, alertObs{node: n, value: float64(delta) * 8 / dt / 1e6})
+		}
+	case "probe_rtt", "probe_loss":
+		s.loadProbes()
+		for k, p := range s.probes {
+			if r.TargetID != nil && *r.TargetID != k[1] {
+				continue
+			}
+			n, ok := s.node(k[0])
+			if !ok {
+				continue
+			}
+			if r.Metric == "probe_rtt" {
+				if p.OK != 1 {
+					continue
+				}
+				out = append(out, alertObs{node: n, subject: fmt.Sprintf("target:%d", k[1]), value: float64(p.RTTMs)})
+			} else {
+				out = append(out, alertObs{node: n, subject: fmt.Sprintf("target:%d", k[1]), value: p.LossPct, detail: p.Error})
+			}
+		}
+	case "gost_down":
+		healthMu.RLock()
+		for _, n := range s.nodes {
+			h, ok := nodeHealth[n.ID]
+			if !ok || !isNodeConnected(n.ID) {
+				continue
+			}
+			out = append(out, alertObs{node: n, value: ifThen(h.GostRunning, 0.0, 1.0)})
+		}
+		healthMu.RUnlock()
+	case "forward_down":
+		out = append(out, s.observeForwards()...)
+	case "offline":
+		for _, n := range s.nodes {
+			out = append(out, alertObs{node: n, value: ifThen(isNodeConnected(n.ID), 0.0, 1.0)})
+		}
+	}
+	return out
+}
+
+// observeForwards checks that every active forward's services are present in
+// the latest service snapshot of each node it should run on.
+func (s *alertSnapshot) observeForwards() []alertObs {
+	var fwds []model.Forward
+	dbpkg.DB.Where("status IS NULL OR status = 1").Find(&fwds)
+	if len(fwds) == 0 {
+		return nil
+	}
+	tunnels := map[int64]model.Tunnel{}
+	var tl []model.Tunnel
+	dbpkg.DB.Find(&tl)
+	for _, t := range tl {
+		tunnels[t.ID] = t
+	}
+	fresh := func(nodeID int64) (map[string]struct{}, bool) {
+		names, _, ts, ok := getNodeServiceSnapshot(nodeID)
+		if !ok || s.now-ts > 2*60*1000 || !isNodeConnected(nodeID) {
+			return nil, false
+		}
+		return names, true
+	}
+	out := []alertObs{}
+	for _, f := range fwds {
+		t, ok := tunnels[f.TunnelID]
+		if !ok || isDirectExitForward(t, f.InPort) {
+			continue
+		}
+		name := buildServiceName(f.ID, f.UserID, f.TunnelID)
+		expect := map[int64][]string{t.InNodeID: {name}}
+		if t.Type == 2 && t.OutNodeID != nil {
+			expect[*t.OutNodeID] = append(expect[*t.OutNodeID], name)
+			for i, nid := range getTunnelPathNodes(t.ID) {
+				expect[nid] = append(expect[nid], fmt.Sprintf("%s_mid_%d", name, i))
+			}
+		}
+		// attribute to the entry node; report where the service is missing
+		entry, ok := s.node(t.InNodeID)
+		if !ok {
+			continue
+		}
+		missing := []string{}
+		checked := false
+		for nid, svcs := range expect {
+			names, ok := fresh(nid)
+			if !ok {
+				continue
+			}
+			checked = true
+			for _, sv := range svcs {
+				if _, has := names[sv]; !has {
+					n, _ := s.node(nid)
+					missing = append(missing, n.Name+":"+sv)
+				}
+			}
+		}
+		if !checked {
+			continue
+		}
+		out = append(out, alertObs{node: entry, subject: fmt.Sprintf("forward:%d", f.ID), value: ifThen(len(missing) > 0, 1.0, 0.0),
+			detail: ifThen(len(missing) > 0, "转发 "+f.Name+" 缺失服务 "+strings.Join(missing, ", "), "")})
+	}
+	return out
+}
+
+func alertCompare(v float64, op string, thr float64) bool {
+	switch op {
+	case ">=":
+		return v >= thr
+	case "<":
+		return v < thr
+	case "<=":
+		return v <= thr
+	default:
+		return v > thr
+	}
+}
+
+func alertRuleNodes(r model.AlertRule) map[int64]bool {
+	var ids []int64
+	if r.NodeIDs != nil && strings.TrimSpace(*r.NodeIDs) != "" {
+		_ = json.Unmarshal([]byte(*r.NodeIDs), &ids)
+	}
+	hasGroup := r.GroupID != nil && *r.GroupID > 0
+	if len(ids) == 0 && !hasGroup {
+		return nil
+	}
+	m := map[int64]bool{}
+	if hasGroup {
+		for id := range nodeGroupMemberSet(*r.GroupID) {
+			m[id] = true
+		}
+	}
+	for _, id := range ids {
+		m[id] = true
+	}
+	return m
+}
+
+func alertSilenced(silences []model.AlertSilence, ruleID, nodeID int64, metric string, now int64) bool {
+	for _, s := range silences {
+		if now < s.StartMs || now >= s.EndMs {
+			continue
+		}
+		if s.RuleID != nil && *s.RuleID != ruleID {
+			continue
+		}
+		if s.NodeID != nil && *s.NodeID != nodeID {
+			continue
+		}
+		if s.Metric != "" && s.Metric != metric {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+func alertMessage(r model.AlertRule, o alertObs) string {
+	label, unit, isBool, _ := alertMetricInfo(r.Metric)
+	msg := fmt.Sprintf("[%s] %s: ", ifThen(severityLabel[r.Severity] != "", severityLabel[r.Severity], r.Severity), r.Name)
+	if isBool {
+		msg += label
+	} else {
+		msg += fmt.Sprintf("%s %.1f%s %s %.1f%s", label, o.value, unit, r.Op, r.Threshold, unit)
+	}
+	if o.subject != "" {
+		msg += " (" + o.subject + ")"
+	}
+	if r.DurationSec > 0 {
+		msg += fmt.Sprintf("，持续 %ds", r.DurationSec)
+	}
+	if o.detail != "" {
+		msg += "；" + o.detail
+	}
+	if len(msg) > 500 {
+		msg = msg[:500]
+	}
+	return msg
+}
+
+func evaluateAlertRulesOnce() {
+	alertEngineMu.Lock()
+	defer alertEngineMu.Unlock()
+	now := time.Now().UnixMilli()
+	var rules []model.AlertRule
+	dbpkg.DB.Where("enabled = ?", true).Find(&rules)
+	var open []model.AlertIncident
+	dbpkg.DB.Where("status = ?", "firing").Find(&open)
+	openByKey := map[string]*model.AlertIncident{}
+	for i := range open {
+		openByKey[open[i].DedupKey] = &open[i]
+	}
+	ruleByID := map[int64]model.AlertRule{}
+	for _, r := range rules {
+		ruleByID[r.ID] = r
+	}
+	snap := &alertSnapshot{now: now}
+	dbpkg.DB.Find(&snap.nodes)
+	var silences []model.AlertSilence
+	dbpkg.DB.Where("end_ms > ?", now).Find(&silences)
+
+	seen := map[string]bool{}
+	for _, r := range rules {
+		scope := alertRuleNodes(r)
+		for _, o := range snap.observe(r) {
+			if scope != nil && !scope[o.node.ID] {
+				continue
+			}
+			key := fmt.Sprintf("%d:%d:%s", r.ID, o.node.ID, o.subject)
+			seen[key] = true
+			inc := openByKey[key]
+			if alertCompare(o.value, r.Op, r.Threshold) {
+				delete(alertClear, key)
+				if inc != nil {
+					_ = dbpkg.DB.Model(&model.AlertIncident{}).Where("id = ?", inc.ID).Updates(map[string]any{"last_seen_ms": now, "value": o.value}).Error
+					continue
+				}
+				first, ok := alertPending[key]
+				if !ok {
+					alertPending[key] = now
+					first = now
+				}
+				if now-first >= int64(r.DurationSec)*1000 {
+					delete(alertPending, key)
+					fireAlertIncident(r, o, key, first, now, alertSilenced(silences, r.ID, o.node.ID, r.Metric, now))
+				}
+				continue
+			}
+			delete(alertPending, key)
+			if inc == nil {
+				continue
+			}
+			first, ok := alertClear[key]
+			if !ok {
+				alertClear[key] = now
+				first = now
+			}
+			if now-first >= int64(r.ResolveSec)*1000 {
+				delete(alertClear, key)
+				resolveAlertIncident(*inc, now, "已恢复")
+			}
+		}
+	}
+	// incidents whose rule was disabled/removed, node deleted, or that got no
+	// observation (node stopped reporting, subject gone) for resolveSec
+	for key, inc := range openByKey {
+		if seen[key] {
+			continue
+		}
+		r, enabled := ruleByID[inc.RuleID]
+		if !enabled {
+			resolveAlertIncident(*inc, now, "规则已停用")
+		} else if _, ok := snap.node(inc.NodeID); !ok {
+			resolveAlertIncident(*inc, now, "节点已删除")
+		} else if now-inc.LastSeenMs >= int64(r.ResolveSec)*1000 {
+			resolveAlertIncident(*inc, now, "无数据")
+		}
+	}
+	for key := range alertPending {
+		if !seen[key] {
+			delete(alertPending, key)
+		}
+	}
+	for key := range alertClear {
+		if !seen[key] {
+			delete(alertClear, key)
+		}
+	}
+}
+
+func fireAlertIncident(r model.AlertRule, o alertObs, key string, startedMs, now int64, silenced bool) {
+	msg := alertMessage(r, o)
+	inc := model.AlertIncident{RuleID: r.ID, RuleName: r.Name, Metric: r.Metric, Severity: r.Severity, NodeID: o.node.ID, NodeName: o.node.Name,
+		Subject: o.subject, DedupKey: key, Status: "firing", Value: o.value, Threshold: r.Threshold, Message: msg,
+		StartedMs: startedMs, LastSeenMs: now, Silenced: silenced}
+	if err := dbpkg.DB.Create(&inc).Error; err != nil {
+		return
+	}
+	jlog(map[string]any{"event": "alert_firing", "incidentId": inc.ID, "ruleId": r.ID, "nodeId": o.node.ID, "subject": o.subject, "value": o.value, "silenced": silenced})
+	if silenced {
+		return
+	}
+	nid, name := o.node.ID, o.node.Name
+	enqueueAlert(model.Alert{TimeMs: now, Type: "rule_firing", NodeID: &nid, NodeName: &name, Message: msg})
+	go notifyCallback("alert_firing", o.node, alertIncidentExtra(inc))
+}
+
+func resolveAlertIncident(inc model.AlertIncident, now int64, reason string) {
+	_ = dbpkg.DB.Model(&model.AlertIncident{}).Where("id = ?", inc.ID).Updates(map[string]any{"status": "resolved", "resolved_ms": now}).Error
+	jlog(map[string]any{"event": "alert_resolved", "incidentId": inc.ID, "ruleId": inc.RuleID, "nodeId": inc.NodeID, "reason": reason})
+	if inc.Silenced {
+		return
+	}
+	nid, name := inc.NodeID, inc.NodeName
+	msg := fmt.Sprintf("[%s] %s %s，持续 %s", reason, inc.RuleName, inc.Subject, (time.Duration(now-inc.StartedMs) * time.Millisecond).Round(time.Second))
+	enqueueAlert(model.Alert{TimeMs: now, Type: "rule_resolved", NodeID: &nid, NodeName: &name, Message: msg})
+	inc.Status = "resolved"
+	inc.ResolvedMs = &now
+	go notifyCallback("alert_resolved", model.Node{BaseEntity: model.BaseEntity{ID: inc.NodeID}, Name: inc.NodeName}, alertIncidentExtra(inc))
+}
+
+func alertIncidentExtra(inc model.AlertIncident) map[string]any {
+	return map[string]any{
+		"incidentId": inc.ID, "ruleId": inc.RuleID, "ruleName": inc.RuleName, "metric": inc.Metric, "severity": inc.Severity,
+		"subject": inc.Subject, "value": inc.Value, "threshold": inc.Threshold, "message": inc.Message, "startedMs": inc.StartedMs,
+	}
+}
+
+// ---- Rules CRUD ----
+
+type alertRuleReq struct {
+	ID          int64    `json:"id"`
+	Name        *string  `json:"name"`
+	Metric      *string  `json:"metric"`
+	Op          *string  `json:"op"`
+	Threshold   *float64 `json:"threshold"`
+	DurationSec *int     `json:"durationSec"`
+	ResolveSec  *int     `json:"resolveSec"`
+	Severity    *string  `json:"severity"`
+	NodeIDs     []int64  `json:"nodeIds"`
+	GroupID     *int64   `json:"groupId"`
+	TargetID    *int64   `json:"targetId"`
+	Enabled     *bool    `json:"enabled"`
+	Note        *string  `json:"note"`
+}
+
+func (p alertRuleReq) applyTo(r *model.AlertRule) string {
+	if p.Name != nil {
+		r.Name = strings.TrimSpace(*p.Name)
+	}
+	if p.Metric != nil {
+		r.Metric = *p.Metric
+	}
+	if p.Op != nil {
+		r.Op = *p.Op
+	}
+	if p.Threshold != nil {
+		r.Threshold = *p.Threshold
+	}
+	if p.DurationSec != nil {
+		r.DurationSec = *p.DurationSec
+	}
+	if p.ResolveSec != nil {
+		r.ResolveSec = *p.ResolveSec
+	}
+	if p.Severity != nil {
+		r.Severity = *p.Severity
+	}
+	if p.NodeIDs != nil {
+		if len(p.NodeIDs) == 0 {
+			r.NodeIDs = nil
+		} else {
+			b, _ := json.Marshal(p.NodeIDs)
+			s := string(b)
+			r.NodeIDs = &s
+		}
+	}
+	if p.GroupID != nil {
+		if *p.GroupID > 0 {
+			r.GroupID = p.GroupID
+		} else {
+			r.GroupID = nil
+		}
+	}
+	if p.TargetID != nil {
+		if *p.TargetID > 0 {
+			r.TargetID = p.TargetID
+		} else {
+			r.TargetID = nil
+		}
+	}
+	if p.Enabled != nil {
+		r.Enabled = *p.Enabled
+	}
+	if p.Note != nil {
+		r.Note = *p.Note
+	}
+	_, _, isBool, ok := alertMetricInfo(r.Metric)
+	if !ok {
+		return "不支持的指标"
+	}
+	if isBool {
+		r.Op, r.Threshold = ">=", 1
+	}
+	if r.Name == "" {
+		return "名称不能为空"
+	}
+	if r.Op == "" {
+		r.Op = ">"
+	}
+	if r.Op != ">" && r.Op != ">=" && r.Op != "<" && r.Op != "<=" {
+		return "比较符仅支持 > >= < <="
+	}
+	if r.Severity == "" {
+		r.Severity = "warning"
+	}
+	if _, ok := severityLabel[r.Severity]; !ok {
+		return "级别仅支持 info/warning/critical"
+	}
+	if r.DurationSec < 0 || r.ResolveSec < 0 {
+		return "持续时间不能为负"
+	}
+	return ""
+}
+
+// AlertRuleList 告警规则列表
+// @Summary 告警规则列表
+// @Tags alert
+// @Produce json
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/alerts/rules/list [post]
+func AlertRuleList(c *gin.Context) {
+	var list []model.AlertRule
+	dbpkg.DB.Order("id asc").Find(&list)
+	var firing []struct {
+		RuleID int64
+		Cnt    int64
+	}
+	dbpkg.DB.Model(&model.AlertIncident{}).Select("rule_id, count(*) as cnt").Where("status = ?", "firing").Group("rule_id").Scan(&firing)
+	counts := map[int64]int64{}
+	for _, f := range firing {
+		counts[f.RuleID] = f.Cnt
+	}
+	out := make([]map[string]any, 0, len(list))
+	for _, r := range list {
+		out = append(out, map[string]any{"rule": r, "firing": counts[r.ID]})
+	}
+	c.JSON(http.StatusOK, response.Ok(map[string]any{"rules": out, "metrics": alertMetrics}))
+}
+
+// AlertRuleCreate 新建告警规则
+// @Summary 新建告警规则
+// @Tags alert
+// @Accept json
+// @Produce json
+// @Param data body object true "{name, metric, op, threshold, durationSec, resolveSec, severity, nodeIds?, groupId?, targetId?, enabled?, note?}"
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/alerts/rules/create [post]
+func AlertRuleCreate(c *gin.Context) {
+	var p alertRuleReq
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	now := time.Now().UnixMilli()
+	r := model.AlertRule{Enabled: true, DurationSec: 60, CreatedTime: now, UpdatedTime: now}
+	if msg := p.applyTo(&r); msg != "" {
+		c.JSON(http.StatusOK, response.ErrMsg(msg))
+		return
+	}
+	if err := dbpkg.DB.Create(&r).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("保存失败"))
+		return
+	}
+	c.JSON(http.StatusOK, response.Ok(r))
+}
+
+// AlertRuleUpdate 更新告警规则
+// @Summary 更新告警规则
+// @Tags alert
+// @Accept json
+// @Produce json
+// @Param data body object true "{id, ...同新建}"
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/alerts/rules/update [post]
+func AlertRuleUpdate(c *gin.Context) {
+	var p alertRuleReq
+	if err := c.ShouldBindJSON(&p); err != nil || p.ID == 0 {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	var r model.AlertRule
+	if err := dbpkg.DB.First(&r, p.ID).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("规则不存在"))
+		return
+	}
+	if msg := p.applyTo(&r); msg != "" {
+		c.JSON(http.StatusOK, response.ErrMsg(msg))
+		return
+	}
+	r.UpdatedTime = time.Now().UnixMilli()
+	if err := dbpkg.DB.Save(&r).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("保存失败"))
+		return
+	}
+	c.JSON(http.StatusOK, response.Ok(r))
+}
+
+// AlertRuleDelete 删除告警规则（同时恢复其未关闭事件）
+// @Summary 删除告警规则
+// @Tags alert
+// @Accept json
+// @Produce json
+// @Param data body object true "{id}"
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/alerts/rules/delete [post]
+func AlertRuleDelete(c *gin.Context) {
+	var p struct {
+		ID int64 `json:"id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	_ = dbpkg.DB.Delete(&model.AlertRule{}, p.ID).Error
+	now := time.Now().UnixMilli()
+	_ = dbpkg.DB.Model(&model.AlertIncident{}).Where("rule_id = ? AND status = ?", p.ID, "firing").Updates(map[string]any{"status": "resolved", "resolved_ms": now}).Error
+	c.JSON(http.StatusOK, response.OkNoData())
+}
+
+// ---- Incidents ----
+
+// AlertIncidentList 告警事件列表
+// @Summary 告警事件列表
+// @Tags alert
+// @Accept json
+// @Produce json
+// @Param data body object false "{status?: firing|resolved, severity?, nodeId?, ruleId?, acked?, limit?}"
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/alerts/incidents [post]
+func AlertIncidentList(c *gin.Context) {
+	var p struct {
+		Status   string `json:"status"`
+		Severity string `json:"severity"`
+		NodeID   int64  `json:"nodeId"`
+		RuleID   int64  `json:"ruleId"`
+		Acked    *bool  `json:"acked"`
+		Limit    int    `json:"limit"`
+	}
+	_ = c.ShouldBindJSON(&p)
+	if p.Limit <= 0 || p.Limit > 500 {
+		p.Limit = 100
+	}
+	q := dbpkg.DB.Model(&model.AlertIncident{})
+	if p.Status != "" {
+		q = q.Where("status = ?", p.Status)
+	}
+	if p.Severity != "" {
+		q = q.Where("severity = ?", p.Severity)
+	}
+	if p.NodeID > 0 {
+		q = q.Where("node_id = ?", p.NodeID)
+	}
+	if p.RuleID > 0 {
+		q = q.Where("rule_id = ?", p.RuleID)
+	}
+	if p.Acked != nil {
+		if *p.Acked {
+			q = q.Where("acked_ms IS NOT NULL")
+		} else {
+			q = q.Where("acked_ms IS NULL")
+		}
+	}
+	var total int64
+	q.Count(&total)
+	var list []model.AlertIncident
+	q.Order("started_ms desc").Limit(p.Limit).Find(&list)
+	c.JSON(http.StatusOK, response.Ok(map[string]any{"list": list, "total": total}))
+}
+
+// AlertIncidentAck 确认告警事件
+// @Summary 确认告警事件
+// @Tags alert
+// @Accept json
+// @Produce json
+// @Param data body object true "{ids: number[], note?}"
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/alerts/incidents/ack [post]
+func AlertIncidentAck(c *gin.Context) {
+	var p struct {
+		IDs  []int64 `json:"ids" binding:"required"`
+		Note string  `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil || len(p.IDs) == 0 {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	var uid int64
+	if v, ok := c.Get("user_id"); ok {
+		uid, _ = v.(int64)
+	}
+	now := time.Now().UnixMilli()
+	res := dbpkg.DB.Model(&model.AlertIncident{}).Where("id IN ? AND acked_ms IS NULL", p.IDs).
+		Updates(map[string]any{"acked_by": uid, "acked_ms": now, "ack_note": p.Note})
+	c.JSON(http.StatusOK, response.Ok(map[string]any{"acked": res.RowsAffected}))
+}
+
+// ---- Silences ----
+
+// AlertSilenceList 静默列表（含已过期的最近 50 条）
+// @Summary 告警静默列表
+// @Tags alert
+// @Produce json
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/alerts/silences/list [post]
+func AlertSilenceList(c *gin.Context) {
+	var list []model.AlertSilence
+	dbpkg.DB.Order("end_ms desc").Limit(50).Find(&list)
+	now := time.Now().UnixMilli()
+	out := make([]map[string]any, 0, len(list))
+	for _, s := range list {
+		out = append(out, map[string]any{"silence": s, "active": now >= s.StartMs && now < s.EndMs})
+	}
+	c.JSON(http.StatusOK, response.Ok(out))
+}
+
+// AlertSilenceCreate 新建静默
+// @Summary 新建告警静默
+// @Tags alert
+// @Accept json
+// @Produce json
+// @Param data body object true "{ruleId?, nodeId?, metric?, durationMin? | startMs+endMs, reason}"
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/alerts/silences/create [post]
+func AlertSilenceCreate(c *gin.Context) {
+	var p struct {
+		RuleID      *int64 `json:"ruleId"`
+		NodeID      *int64 `json:"nodeId"`
+		Metric      string `json:"metric"`
+		DurationMin int    `json:"durationMin"`
+		StartMs     int64  `json:"startMs"`
+		EndMs       int64  `json:"endMs"`
+		Reason      string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	now := time.Now().UnixMilli()
+	if p.StartMs <= 0 {
+		p.StartMs = now
+	}
+	if p.DurationMin > 0 {
+		p.EndMs = p.StartMs + int64(p.DurationMin)*60*1000
+	}
+	if p.EndMs <= p.StartMs {
+		c.JSON(http.StatusOK, response.ErrMsg("静默结束时间必须晚于开始时间"))
+		return
+	}
+	if p.Metric != "" {
+		if _, _, _, ok := alertMetricInfo(p.Metric); !ok {
+			c.JSON(http.StatusOK, response.ErrMsg("不支持的指标"))
+			return
+		}
+	}
+	var uid int64
+	if v, ok := c.Get("user_id"); ok {
+		uid, _ = v.(int64)
+	}
+	s := model.AlertSilence{RuleID: p.RuleID, NodeID: p.NodeID, Metric: p.Metric, StartMs: p.StartMs, EndMs: p.EndMs, Reason: p.Reason, CreatedBy: uid, CreatedTime: now}
+	if err := dbpkg.DB.Create(&s).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("保存失败"))
+		return
+	}
+	// mark matching open incidents silenced so their resolution stays quiet too
+	if p.StartMs <= now {
+		q := dbpkg.DB.Model(&model.AlertIncident{}).Where("status = ?", "firing")
+		if p.RuleID != nil {
+			q = q.Where("rule_id = ?", *p.RuleID)
+		}
+		if p.NodeID != nil {
+			q = q.Where("node_id = ?", *p.NodeID)
+		}
+		if p.Metric != "" {
+			q = q.Where("metric = ?", p.Metric)
+		}
+		_ = q.Update("silenced", true).Error
+	}
+	c.JSON(http.StatusOK, response.Ok(s))
+}
+
+// AlertSilenceDelete 删除静默
+// @Summary 删除告警静默
+// @Tags alert
+// @Accept json
+// @Produce json
+// @Param data body object true "{id}"
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/alerts/silences/delete [post]
+func AlertSilenceDelete(c *gin.Context) {
+	var p struct {
+		ID int64 `json:"id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	_ = dbpkg.DB.Delete(&model.AlertSilence{}, p.ID).Error
+	c.JSON(http.StatusOK, response.OkNoData())
+}
@@ -0,0 +1,393 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"network-panel/golang-backend/internal/app/model"
+	"network-panel/golang-backend/internal/app/response"
+	dbpkg "network-panel/golang-backend/internal/db"
+)
+
+// normalizeAnyTLSPadding validates a padding scheme in the AnyTLS format:
+// "stop=N" plus "<packet>=<min>-<max>[,c,...]" lines ("c" is a check mark).
+// Empty means the protocol default.
+func normalizeAnyTLSPadding(s string) (string, bool) {
+	s = strings.TrimSpace(strings.ReplaceAll(s, "\r\n", "\n"))
+	if s == "" {
+		return "", true
+	}
+	var lines []string
+	hasStop := false
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(line, "=")
+		k, v = strings.TrimSpace(k), strings.TrimSpace(v)
+		if !ok {
+			return "", false
+		}
+		n, err := strconv.Atoi(k)
+		if k == "stop" {
+			n, err = strconv.Atoi(v)
+			hasStop = err == nil && n >= 0
+			if !hasStop {
+				return "", false
+			}
+		} else if err != nil || n < 0 {
+			return "", false
+		} else {
+			for _, r := range strings.Split(v, ",") {
+				r = strings.TrimSpace(r)
+				if r == "c" {
+					continue
+				}
+				lo, hi, ok := strings.Cut(r, "-")
+				a, err1 := strconv.Atoi(lo)
+				b, err2 := strconv.Atoi(hi)
+				if !ok || err1 != nil || err2 != nil || a <= 0 || b <= 0 || a > 65535 || b > 65535 {
+					return "", false
+				}
+			}
+		}
+		lines = append(lines, k+"="+v)
+	}
+	if !hasStop {
+		return "", false
+	}
+	return strings.Join(lines, "\n"), true
+}
+
+func anytlsInboundUserIDs(raw string) []int64 {
+	var ids []int64
+	if strings.TrimSpace(raw) != "" {
+		_ = json.Unmarshal([]byte(raw), &ids)
+	}
+	return ids
+}
+
+// anytlsInboundAllows reports whether userID may use the inbound.
+func anytlsInboundAllows(in model.AnyTLSInbound, userID int64) bool {
+	ids := anytlsInboundUserIDs(in.UserIDs)
+	if len(ids) == 0 {
+		return true
+	}
+	for _, id := range ids {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// buildAnyTLSInboundsReq is the "inbounds" list of SetAnyTLS.
+func buildAnyTLSInboundsReq(nodeID int64) []map[string]any {
+	var rows []model.AnyTLSInbound
+	dbpkg.DB.Where("node_id = ?", nodeID).Order("id asc").Find(&rows)
+	out := make([]map[string]any, 0, len(rows))
+	for _, in := range rows {
+		users := make([]map[string]any, 0)
+		for _, u := range buildAnyTLSUsersForNode(nodeID, in.Password) {
+			if uid, _ := u["userId"].(int64); anytlsInboundAllows(in, uid) {
+				users = append(users, u)
+			}
+		}
+		item := map[string]any{"id": in.ID, "port": in.Port, "password": in.Password, "users": users}
+		if in.PaddingScheme != "" {
+			item["padding"] = in.PaddingScheme
+		}
+		out = append(out, item)
+	}
+	return out
+}
+
+// anytlsInboundPortTaken checks port against the exits already on the node.
+func anytlsInboundPortTaken(nodeID int64, port int, exceptID int64) bool {
+	var cnt int64
+	dbpkg.DB.Model(&model.AnyTLSSetting{}).Where("node_id = ? AND port = ?", nodeID, port).Count(&cnt)
+	if cnt > 0 {
+		return true
+	}
+	dbpkg.DB.Model(&model.ExitSetting{}).Where("node_id = ? AND port = ?", nodeID, port).Count(&cnt)
+	if cnt > 0 {
+		return true
+	}
+	dbpkg.DB.Model(&model.AnyTLSInbound{}).Where("node_id = ? AND port = ? AND id <> ?", nodeID, port, exceptID).Count(&cnt)
+	return cnt > 0
+}
+
+// anytlsInboundSNI decides the SNI params of a proxy on an inbound: the
+// inbound's own SNI, verified only when it is the exit's trusted cert domain.
+func anytlsInboundSNI(params map[string]interface{}, in model.AnyTLSInbound, cert *model.ExitCert) {
+	sni := strings.TrimSpace(in.SNI)
+	if cert != nil && (sni == "" || strings.EqualFold(sni, cert.Domain)) {
+		params["sni"] = cert.Domain
+		params["skip-cert-verify"] = false
+		return
+	}
+	delete(params, "skip-cert-verify")
+	if sni != "" {
+		params["sni"] = sni
+	}
+}
+
+// anytlsInboundItems returns one proxy per inbound the user may use on the
+// nodes assigned to them, connecting to the exit node directly.
+func anytlsInboundItems(user model.User, used map[string]int) []subProxy {
+	nodeIDs, nodeMap := subscriptionUserNodes(user.ID)
+	if len(nodeIDs) == 0 {
+		return nil
+	}
+	var inbounds []model.AnyTLSInbound
+	dbpkg.DB.Where("node_id IN ?", nodeIDs).Order("node_id asc, id asc").Find(&inbounds)
+	if len(inbounds) == 0 {
+		return nil
+	}
+	certMap := exitCertMap(nodeIDs)
+	var items []subProxy
+	for _, in := range inbounds {
+		n := nodeMap[in.NodeID]
+		host := subscriptionNodeHost(n)
+		if host == "" || !anytlsInboundAllows(in, user.ID) {
+			continue
+		}
+		params := map[string]interface{}{}
+		var cert *model.ExitCert
+		if ec, ok := certMap[in.NodeID]; ok {
+			cert = &ec
+		}
+		anytlsInboundSNI(params, in, cert)
+		pass := anytlsUserPassword(in.Password, user.ID)
+		params["password"] = pass
+		base := strings.TrimSpace(in.Name)
+		if base == "" {
+			base = fmt.Sprintf("%s-%d", n.Name, in.Port)
+		}
+		items = append(items, subProxy{
+			ID:       -in.ID,
+			Name:     uniqueName(base, -in.ID, used),
+			Group:    ifThen(strings.TrimSpace(n.Name) != "", n.Name, "AnyTLS"),
+			Type:     "anytls",
+			Server:   host,
+			Port:     in.Port,
+			Password: pass,
+			Params:   params,
+		})
+	}
+	return items
+}
+
+// anytlsInboundForTarget finds the inbound a forward targets by its port.
+func anytlsInboundForTarget(inbounds []model.AnyTLSInbound, nodeID int64, remoteAddr string) (model.AnyTLSInbound, bool) {
+	_, portStr, err := net.SplitHostPort(firstTargetHost(remoteAddr))
+	if err != nil {
+		return model.AnyTLSInbound{}, false
+	}
+	port, _ := strconv.Atoi(portStr)
+	for _, in := range inbounds {
+		if in.NodeID == nodeID && in.Port == port {
+			return in, true
+		}
+	}
+	return model.AnyTLSInbound{}, false
+}
+
+type anytlsInboundReq struct {
+	ID            int64   `json:"id"`
+	NodeID        int64   `json:"nodeId"`
+	Name          *string `json:"name"`
+	Port          *int    `json:"port"`
+	Password      *string `json:"password"`
+	SNI           *string `json:"sni"`
+	PaddingScheme *string `json:"paddingScheme"`
+	UserIDs       []int64 `json:"userIds"` // nil keeps, [] allows every assigned user
+}
+
+func (p anytlsInboundReq) applyTo(in *model.AnyTLSInbound) string {
+	if p.Name != nil {
+		in.Name = strings.TrimSpace(*p.Name)
+		if len(in.Name) > 64 {
+			return "名称过长"
+		}
+	}
+	if p.Port != nil {
+		in.Port = *p.Port
+	}
+	if in.Port <= 0 || in.Port > 65535 {
+		return "无效的端口"
+	}
+	if nodeExitPortTaken(in.NodeID, in.Port, in.ID, 0) {
+		return "端口已被该节点的其他出口占用"
+	}
+	if p.Password != nil {
+		in.Password = strings.TrimSpace(*p.Password)
+	}
+	if in.Password == "" {
+		in.Password = RandUUID()
+	}
+	if p.SNI != nil {
+		in.SNI = strings.TrimSpace(*p.SNI)
+	}
+	if p.PaddingScheme != nil {
+		scheme, ok := normalizeAnyTLSPadding(*p.PaddingScheme)
+		if !ok {
+			return "填充方案格式错误"
+		}
+		in.PaddingScheme = scheme
+	}
+	if p.UserIDs != nil {
+		ids := make([]int64, 0, len(p.UserIDs))
+		seen := map[int64]bool{}
+		for _, id := range p.UserIDs {
+			if id > 0 && !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		in.UserIDs = ""
+		if len(ids) > 0 {
+			b, _ := json.Marshal(ids)
+			in.UserIDs = string(b)
+		}
+	}
+	return ""
+}
+
+func anytlsInboundView(in model.AnyTLSInbound) map[string]any {
+	return map[string]any{
+		"id": in.ID, "nodeId": in.NodeID, "name": in.Name, "port": in.Port, "password": in.Password,
+		"sni": in.SNI, "paddingScheme": in.PaddingScheme, "userIds": anytlsInboundUserIDs(in.UserIDs),
+		"createdTime": in.CreatedTime, "updatedTime": in.UpdatedTime,
+	}
+}
+
+// AnyTLSInboundList AnyTLS 附加入站列表（管理员）
+// @Summary AnyTLS 附加入站列表
+// @Tags node
+// @Accept json
+// @Produce json
+// @Param data body object true "{nodeId?}"
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/node/anytls-inbound/list [post]
+func AnyTLSInboundList(c *gin.Context) {
+	var p struct {
+		NodeID int64 `json:"nodeId"`
+	}
+	_ = c.ShouldBindJSON(&p)
+	q := dbpkg.DB.Model(&model.AnyTLSInbound{})
+	if p.NodeID > 0 {
+		q = q.Where("node_id = ?", p.NodeID)
+	}
+	var rows []model.AnyTLSInbound
+	q.Order("node_id asc, port asc").Find(&rows)
+	out := make([]map[string]any, 0, len(rows))
+	for _, in := range rows {
+		out = append(out, anytlsInboundView(in))
+	}
+	c.JSON(http.StatusOK, response.Ok(out))
+}
+
+// AnyTLSInboundCreate 创建 AnyTLS 附加入站（管理员），需节点已配置 AnyTLS 出口
+// @Summary 创建 AnyTLS 附加入站
+// @Tags node
+// @Accept json
+// @Produce json
+// @Param data body object true "{nodeId, port, name?, password?, sni?, paddingScheme?, userIds?}"
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/node/anytls-inbound/create [post]
+func AnyTLSInboundCreate(c *gin.Context) {
+	var p anytlsInboundReq
+	if err := c.ShouldBindJSON(&p); err != nil || p.NodeID <= 0 || p.Port == nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	var cnt int64
+	dbpkg.DB.Model(&model.AnyTLSSetting{}).Where("node_id = ?", p.NodeID).Count(&cnt)
+	if cnt == 0 {
+		c.JSON(http.StatusOK, response.ErrMsg("请先为该节点配置 AnyTLS 出口"))
+		return
+	}
+	now := time.Now().UnixMilli()
+	in := model.AnyTLSInbound{NodeID: p.NodeID, CreatedTime: now, UpdatedTime: now}
+	if msg := p.applyTo(&in); msg != "" {
+		c.JSON(http.StatusOK, response.ErrMsg(msg))
+		return
+	}
+	if err := dbpkg.DB.Create(&in).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("保存失败"))
+		return
+	}
+	pushAnyTLSConfigToNode(in.NodeID)
+	c.JSON(http.StatusOK, response.Ok(anytlsInboundView(in)))
+}
+
+// AnyTLSInboundUpdate 更新 AnyTLS 附加入站（管理员）
+// @Summary 更新 AnyTLS 附加入站
+// @Tags node
+// @Accept json
+// @Produce json
+// @Param data body object true "{id, port?, name?, password?, sni?, paddingScheme?, userIds?}"
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/node/anytls-inbound/update [post]
+func AnyTLSInboundUpdate(c *gin.Context) {
+	var p anytlsInboundReq
+	if err := c.ShouldBindJSON(&p); err != nil || p.ID <= 0 {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	var in model.AnyTLSInbound
+	if err := dbpkg.DB.First(&in, p.ID).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("入站不存在"))
+		return
+	}
+	if msg := p.applyTo(&in); msg != "" {
+		c.JSON(http.StatusOK, response.ErrMsg(msg))
+		return
+	}
+	in.UpdatedTime = time.Now().UnixMilli()
+	if err := dbpkg.DB.Save(&in).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("保存失败"))
+		return
+	}
+	pushAnyTLSConfigToNode(in.NodeID)
+	c.JSON(http.StatusOK, response.Ok(anytlsInboundView(in)))
+}
+
+// AnyTLSInboundDelete 删除 AnyTLS 附加入站（管理员）
+// @Summary 删除 AnyTLS 附加入站
+// @Tags node
+// @Accept json
+// @Produce json
+// @Param data body object true "{id}"
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/node/anytls-inbound/delete [post]
+func AnyTLSInboundDelete(c *gin.Context) {
+	var p struct {
+		ID int64 `json:"id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	var in model.AnyTLSInbound
+	if err := dbpkg.DB.First(&in, p.ID).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("入站不存在"))
+		return
+	}
+	if err := dbpkg.DB.Delete(&in).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("删除失败"))
+		return
+	}
+	pushAnyTLSConfigToNode(in.NodeID)
+	c.JSON(http.StatusOK, response.OkMsg("删除成功"))
+}
@@ -0,0 +1,156 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"network-panel/golang-backend/internal/app/model"
+	"network-panel/golang-backend/internal/app/response"
+	dbpkg "network-panel/golang-backend/internal/db"
+)
+
+// anytlsLimitMode normalizes the over-limit action of a user node.
+// Empty means the default (reject new sessions).
+func anytlsLimitMode(s string) (string, bool) {
+	switch m := strings.ToLower(strings.TrimSpace(s)); m {
+	case "", "reject", "evict":
+		return m, true
+	}
+	return "", false
+}
+
+// one alert per node/user/kind within this window; the rows are still stored
+const anytlsViolAlertGapMs = 10 * 60 * 1000
+
+var (
+	anytlsViolAlertMu sync.Mutex
+	anytlsViolAlerted = map[string]int64{}
+)
+
+// POST /api/v1/agent/report-anytls-violations
+// {secret, events:[{userId, kind:sessions|ips, action:rejected|evicted, limit, count, lastIp, firstMs, lastMs}]}
+func AgentReportAnyTLSViolations(c *gin.Context) {
+	var p struct {
+		Secret string `json:"secret" binding:"required"`
+		Events []struct {
+			UserID  int64  `json:"userId"`
+			Kind    string `json:"kind"`
+			Action  string `json:"action"`
+			Limit   int    `json:"limit"`
+			Count   int64  `json:"count"`
+			LastIP  string `json:"lastIp"`
+			FirstMs int64  `json:"firstMs"`
+			LastMs  int64  `json:"lastMs"`
+		} `json:"events"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	var node model.Node
+	if err := dbpkg.DB.Where("secret = ?", p.Secret).First(&node).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("节点不存在"))
+		return
+	}
+	now := time.Now().UnixMilli()
+	rows := make([]model.AnyTLSViolation, 0, len(p.Events))
+	for _, e := range p.Events {
+		if e.UserID <= 0 || e.Count <= 0 || (e.Kind != "sessions" && e.Kind != "ips") || (e.Action != "rejected" && e.Action != "evicted") {
+			continue
+		}
+		if len(e.LastIP) > 64 {
+			e.LastIP = e.LastIP[:64]
+		}
+		t := e.LastMs
+		if t <= 0 || t > now {
+			t = now
+		}
+		rows = append(rows, model.AnyTLSViolation{NodeID: node.ID, UserID: e.UserID, Kind: e.Kind, Action: e.Action, Limit: e.Limit, Count: e.Count, LastIP: e.LastIP, FirstMs: e.FirstMs, TimeMs: t})
+	}
+	if len(rows) == 0 {
+		c.JSON(http.StatusOK, response.OkNoData())
+		return
+	}
+	if err := dbpkg.DB.Create(&rows).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("保存失败"))
+		return
+	}
+	alertAnyTLSViolations(node, rows, now)
+	c.JSON(http.StatusOK, response.OkNoData())
+}
+
+func alertAnyTLSViolations(node model.Node, rows []model.AnyTLSViolation, now int64) {
+	var due []model.AnyTLSViolation
+	anytlsViolAlertMu.Lock()
+	for _, r := range rows {
+		k := fmt.Sprintf("%d:%d:%s", node.ID, r.UserID, r.Kind)
+		if now-anytlsViolAlerted[k] < anytlsViolAlertGapMs {
+			continue
+		}
+		anytlsViolAlerted[k] = now
+		due = append(due, r)
+	}
+	anytlsViolAlertMu.Unlock()
+	if len(due) == 0 {
+		return
+	}
+	ids := make([]int64, 0, len(due))
+	for _, r := range due {
+		ids = append(ids, r.UserID)
+	}
+	var users []model.User
+	dbpkg.DB.Select("id, user").Where("id in ?", ids).Find(&users)
+	names := map[int64]string{}
+	for _, u := range users {
+		names[u.ID] = u.User
+	}
+	nid, name := node.ID, node.Name
+	for _, r := range due {
+		what := ifThen(r.Kind == "ips", "来源IP数", "并发会话数")
+		act := ifThen(r.Action == "evicted", "已断开最早的会话", "已拒绝新会话")
+		uname := names[r.UserID]
+		if uname == "" {
+			uname = fmt.Sprintf("#%d", r.UserID)
+		}
+		msg := fmt.Sprintf("用户 %s 超出 AnyTLS %s上限(%d)，%s %d 次，最近来源 %s", uname, what, r.Limit, act, r.Count, r.LastIP)
+		enqueueAlert(model.Alert{TimeMs: now, Type: "anytls_limit", NodeID: &nid, NodeName: &name, Message: msg})
+	}
+}
+
+// NodeUserAnyTLSViolations AnyTLS 会话/IP 超限记录
+// @Summary AnyTLS 会话/IP 超限记录
+// @Tags node
+// @Accept json
+// @Produce json
+// @Param data body object true "{nodeId?, userId?, range?(1h/12h/1d/7d/30d), limit?}"
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/node/user/anytls-violations [post]
+func NodeUserAnyTLSViolations(c *gin.Context) {
+	var p struct {
+		NodeID int64  `json:"nodeId"`
+		UserID int64  `json:"userId"`
+		Range  string `json:"range"`
+		Limit  int    `json:"limit"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	if p.Limit <= 0 || p.Limit > 1000 {
+		p.Limit = 200
+	}
+	q := dbpkg.DB.Model(&model.AnyTLSViolation{}).Where("time_ms >= ?", time.Now().UnixMilli()-rangeWindowMs(p.Range))
+	if p.NodeID > 0 {
+		q = q.Where("node_id = ?", p.NodeID)
+	}
+	if p.UserID > 0 {
+		q = q.Where("user_id = ?", p.UserID)
+	}
+	var rows []model.AnyTLSViolation
+	q.Order("time_ms desc").Limit(p.Limit).Find(&rows)
+	c.JSON(http.StatusOK, response.Ok(rows))
+}
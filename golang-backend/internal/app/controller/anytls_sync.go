@@ -57,11 +57,17 @@ func buildAnyTLSUsersForNode(nodeID int64, basePassword string) []map[string]any
 		if pass == "" {
 			continue
 		}
-		out = append(out, map[string]any{
+		u := map[string]any{
 			"userId":   r.UserID,
 			"password": pass,
 			"speedBps": speedLimitBytesByUserNode(r),
-		})
+		}
+		if r.MaxSessions > 0 || r.MaxIPs > 0 {
+			u["maxSessions"] = r.MaxSessions
+			u["maxIps"] = r.MaxIPs
+			u["limitMode"] = ifThen(r.LimitMode == "", "reject", r.LimitMode)
+		}
+		out = append(out, u)
 	}
 	return out
 }
@@ -98,6 +104,11 @@ func pushAnyTLSConfigToNode(nodeID int64) {
 		"password":      st.Password,
 		"allowFallback": allowFallback,
 		"users":         buildAnyTLSUsersForNode(nodeID, st.Password),
+		"decoy":         anytlsDecoyReq(st.DecoyMode, st.DecoyURL),
+		"outbound":      loadExitRules(st.RuleSetID),
+		"tls":           exitCertReq(nodeID),
+		"padding":       st.PaddingScheme,
+		"inbounds":      buildAnyTLSInboundsReq(nodeID),
 	}
 	if baseUserID > 0 {
 		req["baseUserId"] = baseUserID
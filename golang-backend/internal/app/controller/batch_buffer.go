@@ -42,12 +42,22 @@ var (
 	bufDiscMu sync.Mutex
 	bufDisc   []model.NodeDisconnectLog
 
+	// easytier peer/route rows buffer
+	bufEtPeerMu sync.Mutex
+	bufEtPeer   []model.EasyTierPeerStat
+
+	// node-to-node mesh latency buffer
+	bufMeshMu sync.Mutex
+	bufMesh   []model.NodeMeshResult
+
 	// buffer limits (to prevent unbounded memory growth)
 	maxSys   = getBufMax("BATCH_SYSINFO_MAX", 5000)
 	maxProbe = getBufMax("BATCH_PROBE_MAX", 5000)
 	maxOp    = getBufMax("BATCH_OP_MAX", 2000)
 	maxAlert = getBufMax("BATCH_ALERT_MAX", 1000)
 	maxDisc  = getBufMax("BATCH_DISC_MAX", 1000)
+	maxEtPeer = getBufMax("BATCH_ETPEER_MAX", 5000)
+	maxMesh   = getBufMax("BATCH_MESH_MAX", 10000)
 )
 
 func init() {
@@ -69,6 +79,9 @@ func batchFlusher() {
 }
 
 func flushOnce() {
+	start := time.Now()
+	rows := map[string]int{}
+	defer func() { recordFlush(start, rows) }()
 	// swap buffers
 	bufSysMu.Lock()
 	sys := bufSys
@@ -78,10 +91,19 @@ func flushOnce() {
 	probes := bufProbe
 	bufProbe = nil
 	bufProbeMu.Unlock()
+	bufEtPeerMu.Lock()
+	etPeers := bufEtPeer
+	bufEtPeer = nil
+	bufEtPeerMu.Unlock()
+	bufMeshMu.Lock()
+	mesh := bufMesh
+	bufMesh = nil
+	bufMeshMu.Unlock()
 	bufRuntimeMu.Lock()
 	runMap := bufRuntime
 	bufRuntime = map[int64]*model.NodeRuntime{}
 	bufRuntimeMu.Unlock()
+	rows["sysinfo"], rows["probe"], rows["easytier_peer"], rows["mesh"], rows["runtime"] = len(sys), len(probes), len(etPeers), len(mesh), len(runMap)
 
 	if len(sys) > 0 {
 		_ = dbpkg.DB.Create(&sys).Error
@@ -89,6 +111,12 @@ func flushOnce() {
 	if len(probes) > 0 {
 		_ = dbpkg.DB.Create(&probes).Error
 	}
+	if len(etPeers) > 0 {
+		_ = dbpkg.DB.CreateInBatches(&etPeers, 500).Error
+	}
+	if len(mesh) > 0 {
+		_ = dbpkg.DB.CreateInBatches(&mesh, 500).Error
+	}
 	if len(runMap) > 0 {
 		list := make([]model.NodeRuntime, 0, len(runMap))
 		for _, v := range runMap {
@@ -120,6 +148,7 @@ func flushOnce() {
 		ops := bufOp
 		bufOp = nil
 		bufOpMu.Unlock()
+		rows["oplog"] = len(ops)
 		if len(ops) > 0 {
 			_ = dbpkg.DB.Create(&ops).Error
 		}
@@ -129,6 +158,7 @@ func flushOnce() {
 		als := bufAlert
 		bufAlert = nil
 		bufAlertMu.Unlock()
+		rows["alert"] = len(als)
 		if len(als) > 0 {
 			_ = dbpkg.DB.Create(&als).Error
 		}
@@ -138,12 +168,62 @@ func flushOnce() {
 		ds := bufDisc
 		bufDisc = nil
 		bufDiscMu.Unlock()
+		rows["disconnect"] = len(ds)
 		if len(ds) > 0 {
 			_ = dbpkg.DB.Create(&ds).Error
 		}
 	}
 }
 
+// flush statistics exposed on /metrics
+var (
+	flushStatMu   sync.Mutex
+	flushCount    int64
+	flushSumSec   float64
+	flushLastSec  float64
+	flushLastMs   int64
+	flushRowTotal = map[string]int64{}
+)
+
+func recordFlush(start time.Time, rows map[string]int) {
+	d := time.Since(start).Seconds()
+	flushStatMu.Lock()
+	flushCount++
+	flushSumSec += d
+	flushLastSec = d
+	flushLastMs = start.UnixMilli()
+	for k, n := range rows {
+		flushRowTotal[k] += int64(n)
+	}
+	flushStatMu.Unlock()
+}
+
+type bufferDepth struct {
+	Name  string
+	Depth int
+	Max   int
+}
+
+// bufferDepths reports the number of rows currently waiting in each buffer.
+func bufferDepths() []bufferDepth {
+	depth := func(mu *sync.Mutex, n func() int) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return n()
+	}
+	return []bufferDepth{
+		{"sysinfo", depth(&bufSysMu, func() int { return len(bufSys) }), maxSys},
+		{"probe", depth(&bufProbeMu, func() int { return len(bufProbe) }), maxProbe},
+		{"easytier_peer", depth(&bufEtPeerMu, func() int { return len(bufEtPeer) }), maxEtPeer},
+		{"mesh", depth(&bufMeshMu, func() int { return len(bufMesh) }), maxMesh},
+		{"runtime", depth(&bufRuntimeMu, func() int { return len(bufRuntime) }), 0},
+		{"oplog", depth(&bufOpMu, func() int { return len(bufOp) }), maxOp},
+		{"alert", depth(&bufAlertMu, func() int { return len(bufAlert) }), maxAlert},
+		{"disconnect", depth(&bufDiscMu, func() int { return len(bufDisc) }), maxDisc},
+		{"flow", flowPendingDepth(), 0},
+	}
+}
+
 // Enqueue sysinfo sample
 func enqueueSysInfo(s model.NodeSysInfo) {
 	bufSysMu.Lock()
@@ -167,6 +247,46 @@ func enqueueProbes(rows []model.NodeProbeResult) {
 	bufProbeMu.Unlock()
 }
 
+// Enqueue easytier peer/route rows
+func enqueueEtPeers(rows []model.EasyTierPeerStat) {
+	if len(rows) == 0 {
+		return
+	}
+	bufEtPeerMu.Lock()
+	bufEtPeer = append(bufEtPeer, rows...)
+	if maxEtPeer > 0 && len(bufEtPeer) > maxEtPeer {
+		bufEtPeer = bufEtPeer[len(bufEtPeer)-maxEtPeer:]
+	}
+	bufEtPeerMu.Unlock()
+}
+
+// Enqueue node-to-node mesh samples
+func enqueueMesh(rows []model.NodeMeshResult) {
+	if len(rows) == 0 {
+		return
+	}
+	bufMeshMu.Lock()
+	bufMesh = append(bufMesh, rows...)
+	if maxMesh > 0 && len(bufMesh) > maxMesh {
+		bufMesh = bufMesh[len(bufMesh)-maxMesh:]
+	}
+	bufMeshMu.Unlock()
+}
+
+// readBufferedMesh returns unsaved mesh samples since from (srcNodeID/dstNodeID 0 = any)
+func readBufferedMesh(srcNodeID, dstNodeID int64, from int64) []model.NodeMeshResult {
+	bufMeshMu.Lock()
+	defer bufMeshMu.Unlock()
+	out := make([]model.NodeMeshResult, 0)
+	for _, r := range bufMesh {
+		if r.TimeMs < from || (srcNodeID > 0 && r.SrcNodeID != srcNodeID) || (dstNodeID > 0 && r.DstNodeID != dstNodeID) {
+			continue
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
 // Set latest runtime snapshot for node (overwrites previous)
 func setRuntime(rec model.NodeRuntime) {
 	if base, ok := getRuntimeCached(rec.NodeID); ok {
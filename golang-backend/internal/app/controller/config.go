@@ -11,6 +11,10 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// privateConfigKeys are credentials kept in vite_config that the public
+// config endpoints never return or overwrite; they have dedicated admin APIs.
+var privateConfigKeys = map[string]bool{"metrics_token": true}
+
 // ConfigList 配置列表
 // @Summary 获取所有配置
 // @Tags config
@@ -22,6 +26,9 @@ func ConfigList(c *gin.Context) {
 	dbpkg.DB.Find(&items)
 	m := map[string]string{}
 	for _, it := range items {
+		if privateConfigKeys[it.Name] {
+			continue
+		}
 		m[it.Name] = it.Value
 	}
 	c.JSON(http.StatusOK, response.Ok(m))
@@ -44,7 +51,7 @@ func ConfigGet(c *gin.Context) {
 		return
 	}
 	var it model.ViteConfig
-	if err := dbpkg.DB.Where("name = ?", p.Name).First(&it).Error; err != nil {
+	if privateConfigKeys[p.Name] || dbpkg.DB.Where("name = ?", p.Name).First(&it).Error != nil {
 		c.JSON(http.StatusOK, response.Ok(""))
 		return
 	}
@@ -66,6 +73,9 @@ func ConfigUpdate(c *gin.Context) {
 		return
 	}
 	for k, v := range m {
+		if privateConfigKeys[k] {
+			continue
+		}
 		var it model.ViteConfig
 		if err := dbpkg.DB.Where("name = ?", k).First(&it).Error; err != nil {
 			it.Name, it.Value, it.Time = k, v, timeNow()
@@ -87,7 +97,7 @@ func ConfigUpdate(c *gin.Context) {
 // @Router /api/v1/config/update-single [post]
 func ConfigUpdateSingle(c *gin.Context) {
 	var p struct{ Name, Value string }
-	if err := c.ShouldBindJSON(&p); err != nil || p.Name == "" {
+	if err := c.ShouldBindJSON(&p); err != nil || p.Name == "" || privateConfigKeys[p.Name] {
 		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
 		return
 	}
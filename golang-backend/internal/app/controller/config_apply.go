@@ -0,0 +1,1126 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/goccy/go-yaml"
+	"network-panel/golang-backend/internal/app/dto"
+	"network-panel/golang-backend/internal/app/model"
+	"network-panel/golang-backend/internal/app/response"
+	dbpkg "network-panel/golang-backend/internal/db"
+)
+
+// Declarative configuration apply.
+//
+// The document describes nodes, tunnels (with paths/link modes), forwards,
+// speed limits and user assignments by name. It is diffed against the DB into
+// a plan; every step runs the same functions as the UI handlers (updateNode,
+// createTunnel, setTunnelPath, createForward, ...), so agents receive exactly
+// what the UI would push. The apply is not atomic: each step commits and pushes
+// on its own, and if one fails the completed steps are undone in reverse
+// order, best effort. Pruned rows are re-inserted with their original IDs and
+// flow counters. Nodes and tunnels are never deleted (a node delete would
+// uninstall the agent, a tunnel delete could not be undone under the same ID);
+// `prune` only removes forwards, speed limits and assignments.
+
+type cfgDoc struct {
+	DryRun      bool            `json:"dryRun"`
+	Prune       bool            `json:"prune"`
+	Nodes       []cfgNode       `json:"nodes"`
+	Tunnels     []cfgTunnel     `json:"tunnels"`
+	SpeedLimits []cfgSpeedLimit `json:"speedLimits"`
+	Forwards    []cfgForward    `json:"forwards"`
+	Users       []cfgUser       `json:"users"`
+}
+
+type cfgNode struct {
+	Name     string  `json:"name"`
+	IP       *string `json:"ip"`
+	ServerIP *string `json:"serverIp"`
+	PortSta  *int    `json:"portSta"`
+	PortEnd  *int    `json:"portEnd"`
+}
+
+type cfgTunnel struct {
+	Name          string   `json:"name"`
+	InNode        string   `json:"inNode"`
+	OutNode       *string  `json:"outNode"`
+	OutExitID     *int64   `json:"outExitId"`
+	Type          *int     `json:"type"`
+	Flow          *int     `json:"flow"`
+	Protocol      *string  `json:"protocol"`
+	TrafficRatio  *float64 `json:"trafficRatio"`
+	TCPListenAddr *string  `json:"tcpListenAddr"`
+	UDPListenAddr *string  `json:"udpListenAddr"`
+	InterfaceName *string  `json:"interfaceName"`
+	Path          []string `json:"path"`
+	LinkModes     []string `json:"linkModes"`
+}
+
+type cfgSpeedLimit struct {
+	Name   string `json:"name"`
+	Speed  *int   `json:"speed"`
+	Tunnel string `json:"tunnel"`
+}
+
+type cfgForward struct {
+	Name          string  `json:"name"`
+	Tunnel        string  `json:"tunnel"`
+	User          string  `json:"user"`
+	Group         *string `json:"group"`
+	InPort        *int    `json:"inPort"`
+	RemoteAddr    *string `json:"remoteAddr"`
+	Strategy      *string `json:"strategy"`
+	InterfaceName *string `json:"interfaceName"`
+}
+
+type cfgUser struct {
+	User    string             `json:"user"`
+	Tunnels []cfgUserTunnelDoc `json:"tunnels"`
+	Nodes   []cfgUserNodeDoc   `json:"nodes"`
+}
+
+type cfgUserTunnelDoc struct {
+	Tunnel        string  `json:"tunnel"`
+	Flow          *int64  `json:"flow"`
+	Num           *int    `json:"num"`
+	ExpTime       *int64  `json:"expTime"`
+	FlowResetTime *int64  `json:"flowResetTime"`
+	SpeedLimit    *string `json:"speedLimit"`
+	Status        *int    `json:"status"`
+}
+
+type cfgUserNodeDoc struct {
+	Node          string  `json:"node"`
+	Flow          *int64  `json:"flow"`
+	Num           *int    `json:"num"`
+	PortRanges    *string `json:"portRanges"`
+	SpeedMbps     *int    `json:"speedMbps"`
+	ExpTime       *int64  `json:"expTime"`
+	FlowResetTime *int64  `json:"flowResetTime"`
+	Status        *int    `json:"status"`
+}
+
+type cfgChange struct {
+	From any `json:"from"`
+	To   any `json:"to"`
+}
+
+type cfgPlanItem struct {
+	Kind    string               `json:"kind"`   // node | tunnel | tunnelPath | speedLimit | forward | userTunnel | userNode
+	Action  string               `json:"action"` // create | update | delete
+	Key     string               `json:"key"`
+	ID      int64                `json:"id,omitempty"`
+	Changes map[string]cfgChange `json:"changes,omitempty"`
+	run     func(*cfgApplyCtx) (undo func() error, err error)
+}
+
+type cfgStepResult struct {
+	Kind    string `json:"kind"`
+	Action  string `json:"action"`
+	Key     string `json:"key"`
+	Success bool   `json:"success"`
+	Message string `json:"message,omitempty"`
+}
+
+// cfgState is a name-indexed snapshot of the current DB.
+type cfgState struct {
+	nodes       map[string]model.Node
+	tunnels     map[string]model.Tunnel
+	speeds      map[string]model.SpeedLimit
+	users       map[string]model.User
+	forwards    map[string]model.Forward // tunnelName/forwardName
+	userTunnels map[string]model.UserTunnel
+	userNodes   map[string]model.UserNode
+	nodeByID    map[int64]model.Node
+	tunnelByID  map[int64]model.Tunnel
+	speedByID   map[int64]model.SpeedLimit
+	userByID    map[int64]model.User
+	dupes       []string
+}
+
+type cfgApplyCtx struct {
+	st      *cfgState
+	nodes   map[string]int64
+	tunnels map[string]int64
+	speeds  map[string]int64
+	users   map[string]int64
+	// tunnels whose out node / path changed: their untouched forwards are re-pushed
+	repush map[int64]bool
+	// forwards already pushed by this apply
+	pushed map[int64]bool
+}
+
+var cfgApplyMu sync.Mutex
+
+func loadCfgState() *cfgState {
+	st := &cfgState{
+		nodes: map[string]model.Node{}, tunnels: map[string]model.Tunnel{}, speeds: map[string]model.SpeedLimit{},
+		users: map[string]model.User{}, forwards: map[string]model.Forward{}, userTunnels: map[string]model.UserTunnel{},
+		userNodes: map[string]model.UserNode{}, nodeByID: map[int64]model.Node{}, tunnelByID: map[int64]model.Tunnel{},
+		speedByID: map[int64]model.SpeedLimit{}, userByID: map[int64]model.User{},
+	}
+	var nodes []model.Node
+	dbpkg.DB.Order("id asc").Find(&nodes)
+	for _, n := range nodes {
+		if _, ok := st.nodes[n.Name]; ok {
+			st.dupes = append(st.dupes, "节点名称重复: "+n.Name)
+		}
+		st.nodes[n.Name] = n
+		st.nodeByID[n.ID] = n
+	}
+	var tunnels []model.Tunnel
+	dbpkg.DB.Order("id asc").Find(&tunnels)
+	for _, t := range tunnels {
+		st.tunnels[t.Name] = t
+		st.tunnelByID[t.ID] = t
+	}
+	var speeds []model.SpeedLimit
+	dbpkg.DB.Order("id asc").Find(&speeds)
+	for _, s := range speeds {
+		st.speeds[s.Name] = s
+		st.speedByID[s.ID] = s
+	}
+	var users []model.User
+	dbpkg.DB.Order("id asc").Find(&users)
+	for _, u := range users {
+		st.users[u.User] = u
+		st.userByID[u.ID] = u
+	}
+	var fwds []model.Forward
+	dbpkg.DB.Order("id asc").Find(&fwds)
+	for _, f := range fwds {
+		if t, ok := st.tunnelByID[f.TunnelID]; ok {
+			k := t.Name + "/" + f.Name
+			if _, dup := st.forwards[k]; dup {
+				st.dupes = append(st.dupes, "转发名称重复: "+k)
+			}
+			st.forwards[k] = f
+		}
+	}
+	var uts []model.UserTunnel
+	dbpkg.DB.Find(&uts)
+	for _, ut := range uts {
+		u, ok1 := st.userByID[ut.UserID]
+		t, ok2 := st.tunnelByID[ut.TunnelID]
+		if ok1 && ok2 {
+			st.userTunnels[u.User+"/"+t.Name] = ut
+		}
+	}
+	var uns []model.UserNode
+	dbpkg.DB.Find(&uns)
+	for _, un := range uns {
+		u, ok1 := st.userByID[un.UserID]
+		n, ok2 := st.nodeByID[un.NodeID]
+		if ok1 && ok2 {
+			st.userNodes[u.User+"/"+n.Name] = un
+		}
+	}
+	return st
+}
+
+// cfgDeref returns the pointed-to value (nil for nil pointers) for diffing/display.
+func cfgDeref(v any) any {
+	rv := reflect.ValueOf(v)
+	if !rv.IsValid() {
+		return nil
+	}
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil
+		}
+		return rv.Elem().Interface()
+	}
+	return v
+}
+
+func cfgDiff(ch map[string]cfgChange, field string, from, to any) {
+	f, t := cfgDeref(from), cfgDeref(to)
+	if fmt.Sprint(f) != fmt.Sprint(t) {
+		ch[field] = cfgChange{From: f, To: t}
+	}
+}
+
+func pick[T any](want *T, cur T) T {
+	if want != nil {
+		return *want
+	}
+	return cur
+}
+
+func cfgPtr[T any](v T) *T { return &v }
+
+func (x *cfgApplyCtx) nodeID(name string) int64 {
+	if id, ok := x.nodes[name]; ok {
+		return id
+	}
+	var n model.Node
+	if dbpkg.DB.Where("name = ?", name).Order("id desc").First(&n).Error == nil {
+		x.nodes[name] = n.ID
+	}
+	return n.ID
+}
+
+func (x *cfgApplyCtx) tunnelID(name string) int64 {
+	if id, ok := x.tunnels[name]; ok {
+		return id
+	}
+	var t model.Tunnel
+	if dbpkg.DB.Where("name = ?", name).Order("id desc").First(&t).Error == nil {
+		x.tunnels[name] = t.ID
+	}
+	return t.ID
+}
+
+func (x *cfgApplyCtx) speedID(name string) int64 {
+	if id, ok := x.speeds[name]; ok {
+		return id
+	}
+	var s model.SpeedLimit
+	if dbpkg.DB.Where("name = ?", name).Order("id desc").First(&s).Error == nil {
+		x.speeds[name] = s.ID
+	}
+	return s.ID
+}
+
+func tunnelPathIDs(tid int64) []int64 {
+	var ids []int64
+	_ = json.Unmarshal([]byte(getCfg(tunnelPathKey(tid))), &ids)
+	return ids
+}
+
+func tunnelLinkModes(tid int64) []string {
+	var modes []string
+	_ = json.Unmarshal([]byte(getCfg(tunnelLinkModeKey(tid))), &modes)
+	return modes
+}
+
+// buildCfgPlan validates the document and diffs it against the current state.
+func buildCfgPlan(doc cfgDoc, st *cfgState) ([]cfgPlanItem, []string) {
+	var errs []string
+	errs = append(errs, st.dupes...)
+	var creates, deletes []cfgPlanItem
+
+	docNodes := map[string]bool{}
+	for _, n := range doc.Nodes {
+		if n.Name == "" || docNodes[n.Name] {
+			errs = append(errs, "节点名称为空或重复: "+n.Name)
+			continue
+		}
+		docNodes[n.Name] = true
+	}
+	nodeKnown := func(name string) bool { _, ok := st.nodes[name]; return ok || docNodes[name] }
+	docTunnels := map[string]cfgTunnel{}
+	for _, t := range doc.Tunnels {
+		if t.Name == "" {
+			errs = append(errs, "隧道名称为空")
+			continue
+		}
+		if _, dup := docTunnels[t.Name]; dup {
+			errs = append(errs, "隧道名称重复: "+t.Name)
+			continue
+		}
+		docTunnels[t.Name] = t
+	}
+	tunnelKnown := func(name string) bool { _, ok := st.tunnels[name]; _, ok2 := docTunnels[name]; return ok || ok2 }
+	docSpeeds := map[string]bool{}
+	for _, s := range doc.SpeedLimits {
+		docSpeeds[s.Name] = true
+	}
+	speedKnown := func(name string) bool { _, ok := st.speeds[name]; return ok || docSpeeds[name] }
+
+	// ---- nodes (create/update only) ----
+	for _, dn := range doc.Nodes {
+		dn := dn
+		cur, exists := st.nodes[dn.Name]
+		if !exists {
+			if dn.IP == nil || *dn.IP == "" || dn.PortSta == nil || dn.PortEnd == nil {
+				errs = append(errs, "新建节点需要 ip/portSta/portEnd: "+dn.Name)
+				continue
+			}
+			ch := map[string]cfgChange{}
+			cfgDiff(ch, "ip", nil, dn.IP)
+			cfgDiff(ch, "serverIp", nil, dn.ServerIP)
+			cfgDiff(ch, "portSta", nil, dn.PortSta)
+			cfgDiff(ch, "portEnd", nil, dn.PortEnd)
+			creates = append(creates, cfgPlanItem{Kind: "node", Action: "create", Key: dn.Name, Changes: ch, run: func(x *cfgApplyCtx) (func() error, error) {
+				n, err := createNode(dto.NodeDto{Name: dn.Name, IP: *dn.IP, ServerIP: pick(dn.ServerIP, *dn.IP), PortSta: *dn.PortSta, PortEnd: *dn.PortEnd}, cfgPtr(adminUserID()))
+				if err != nil {
+					return nil, err
+				}
+				x.nodes[dn.Name] = n.ID
+				return func() error { return deleteNode(n.ID) }, nil
+			}})
+			continue
+		}
+		ch := map[string]cfgChange{}
+		if dn.IP != nil {
+			cfgDiff(ch, "ip", cur.IP, dn.IP)
+		}
+		if dn.ServerIP != nil {
+			cfgDiff(ch, "serverIp", cur.ServerIP, dn.ServerIP)
+		}
+		if dn.PortSta != nil {
+			cfgDiff(ch, "portSta", cur.PortSta, dn.PortSta)
+		}
+		if dn.PortEnd != nil {
+			cfgDiff(ch, "portEnd", cur.PortEnd, dn.PortEnd)
+		}
+		if len(ch) == 0 {
+			continue
+		}
+		creates = append(creates, cfgPlanItem{Kind: "node", Action: "update", Key: dn.Name, ID: cur.ID, Changes: ch, run: func(x *cfgApplyCtx) (func() error, error) {
+			req := dto.NodeUpdateDto{ID: cur.ID, Name: cur.Name, IP: pick(dn.IP, cur.IP), ServerIP: pick(dn.ServerIP, cur.ServerIP), PortSta: pick(dn.PortSta, cur.PortSta), PortEnd: pick(dn.PortEnd, cur.PortEnd)}
+			if err := updateNode(req, 0, 0); err != nil {
+				return nil, err
+			}
+			return func() error {
+				return updateNode(dto.NodeUpdateDto{ID: cur.ID, Name: cur.Name, IP: cur.IP, ServerIP: cur.ServerIP, PortSta: cur.PortSta, PortEnd: cur.PortEnd}, 0, 0)
+			}, nil
+		}})
+	}
+
+	// ---- tunnels ----
+	tunnelReq := func(x *cfgApplyCtx, id int64, name string, cur model.Tunnel, dt cfgTunnel) dto.TunnelUpdateDto {
+		req := dto.TunnelUpdateDto{
+			ID:            id,
+			Name:          name,
+			Flow:          int64(pick(dt.Flow, cur.Flow)),
+			Protocol:      ifThen(dt.Protocol != nil, dt.Protocol, cur.Protocol),
+			TrafficRatio:  ifThen(dt.TrafficRatio != nil, dt.TrafficRatio, cur.TrafficRatio),
+			TCPListenAddr: ifThen(dt.TCPListenAddr != nil, dt.TCPListenAddr, cur.TCPListenAddr),
+			UDPListenAddr: ifThen(dt.UDPListenAddr != nil, dt.UDPListenAddr, cur.UDPListenAddr),
+			InterfaceName: ifThen(dt.InterfaceName != nil, dt.InterfaceName, cur.InterfaceName),
+			OutExitID:     dt.OutExitID,
+		}
+		if dt.OutNode != nil {
+			if *dt.OutNode == "" {
+				req.OutNodeID = cfgPtr(int64(0))
+			} else {
+				req.OutNodeID = cfgPtr(x.nodeID(*dt.OutNode))
+			}
+		}
+		return req
+	}
+	restoreTunnel := func(cur model.Tunnel) dto.TunnelUpdateDto {
+		req := dto.TunnelUpdateDto{ID: cur.ID, Name: cur.Name, Flow: int64(cur.Flow), Protocol: cur.Protocol, TrafficRatio: cur.TrafficRatio,
+			TCPListenAddr: cur.TCPListenAddr, UDPListenAddr: cur.UDPListenAddr, InterfaceName: cur.InterfaceName}
+		if cur.OutExitID != nil {
+			req.OutExitID = cur.OutExitID
+		} else {
+			req.OutNodeID = cfgPtr(int64(0))
+			if cur.OutNodeID != nil {
+				req.OutNodeID = cur.OutNodeID
+			}
+		}
+		return req
+	}
+	pathItem := func(dt cfgTunnel, curPath []int64, curModes []string, tid int64) *cfgPlanItem {
+		if dt.Path == nil && dt.LinkModes == nil {
+			return nil
+		}
+		for _, p := range dt.Path {
+			if !nodeKnown(p) {
+				errs = append(errs, fmt.Sprintf("隧道 %s 路径节点不存在: %s", dt.Name, p))
+				return nil
+			}
+		}
+		names := make([]string, 0, len(curPath))
+		for _, id := range curPath {
+			names = append(names, st.nodeByID[id].Name)
+		}
+		ch := map[string]cfgChange{}
+		if dt.Path != nil {
+			cfgDiff(ch, "path", names, dt.Path)
+		}
+		if dt.LinkModes != nil {
+			cfgDiff(ch, "linkModes", curModes, dt.LinkModes)
+		}
+		if len(ch) == 0 {
+			return nil
+		}
+		return &cfgPlanItem{Kind: "tunnelPath", Action: ifThen(tid > 0, "update", "create"), Key: dt.Name, ID: tid, Changes: ch, run: func(x *cfgApplyCtx) (func() error, error) {
+			id := x.tunnelID(dt.Name)
+			ids := curPath
+			if dt.Path != nil {
+				ids = make([]int64, 0, len(dt.Path))
+				for _, p := range dt.Path {
+					ids = append(ids, x.nodeID(p))
+				}
+			}
+			modes := dt.LinkModes
+			if modes == nil {
+				modes = curModes
+			}
+			setTunnelPath(id, ids, modes)
+			x.repush[id] = true
+			return func() error {
+				setTunnelPath(id, curPath, curModes)
+				return nil
+			}, nil
+		}}
+	}
+	for _, dt := range doc.Tunnels {
+		dt := dt
+		if dt.Name == "" {
+			continue
+		}
+		if dt.OutNode != nil && *dt.OutNode != "" && !nodeKnown(*dt.OutNode) {
+			errs = append(errs, fmt.Sprintf("隧道 %s 出口节点不存在: %s", dt.Name, *dt.OutNode))
+			continue
+		}
+		cur, exists := st.tunnels[dt.Name]
+		if !exists {
+			if dt.InNode == "" || !nodeKnown(dt.InNode) {
+				errs = append(errs, fmt.Sprintf("隧道 %s 入口节点不存在: %s", dt.Name, dt.InNode))
+				continue
+			}
+			if dt.Type == nil {
+				errs = append(errs, "新建隧道需要 type: "+dt.Name)
+				continue
+			}
+			ch := map[string]cfgChange{}
+			cfgDiff(ch, "inNode", nil, dt.InNode)
+			cfgDiff(ch, "type", nil, dt.Type)
+			cfgDiff(ch, "outNode", nil, dt.OutNode)
+			cfgDiff(ch, "outExitId", nil, dt.OutExitID)
+			cfgDiff(ch, "protocol", nil, dt.Protocol)
+			creates = append(creates, cfgPlanItem{Kind: "tunnel", Action: "create", Key: dt.Name, Changes: ch, run: func(x *cfgApplyCtx) (func() error, error) {
+				u := tunnelReq(x, 0, dt.Name, model.Tunnel{Flow: 1}, dt)
+				req := dto.TunnelDto{Name: dt.Name, InNodeID: x.nodeID(dt.InNode), OutExitID: u.OutExitID, Type: *dt.Type, Flow: int(u.Flow), Protocol: u.Protocol,
+					TrafficRatio: u.TrafficRatio, TCPListenAddr: u.TCPListenAddr, UDPListenAddr: u.UDPListenAddr, InterfaceName: u.InterfaceName}
+				if u.OutNodeID != nil && *u.OutNodeID != 0 {
+					req.OutNodeID = u.OutNodeID
+				}
+				t, err := createTunnel(req, adminUserID(), 0)
+				if err != nil {
+					return nil, err
+				}
+				x.tunnels[dt.Name] = t.ID
+				return func() error { return deleteTunnel(t.ID, 0, 0) }, nil
+			}})
+			if pi := pathItem(dt, nil, nil, 0); pi != nil {
+				creates = append(creates, *pi)
+			}
+			continue
+		}
+		if dt.InNode != "" && st.nodes[dt.InNode].ID != cur.InNodeID {
+			errs = append(errs, "隧道入口节点不可修改，请更换隧道名称重建: "+dt.Name)
+			continue
+		}
+		if dt.Type != nil && *dt.Type != cur.Type {
+			errs = append(errs, "隧道类型不可修改: "+dt.Name)
+			continue
+		}
+		ch := map[string]cfgChange{}
+		if dt.Flow != nil {
+			cfgDiff(ch, "flow", cur.Flow, dt.Flow)
+		}
+		if dt.Protocol != nil {
+			cfgDiff(ch, "protocol", cur.Protocol, dt.Protocol)
+		}
+		if dt.TrafficRatio != nil {
+			cfgDiff(ch, "trafficRatio", cur.TrafficRatio, dt.TrafficRatio)
+		}
+		if dt.TCPListenAddr != nil {
+			cfgDiff(ch, "tcpListenAddr", cur.TCPListenAddr, dt.TCPListenAddr)
+		}
+		if dt.UDPListenAddr != nil {
+			cfgDiff(ch, "udpListenAddr", cur.UDPListenAddr, dt.UDPListenAddr)
+		}
+		if dt.InterfaceName != nil {
+			cfgDiff(ch, "interfaceName", cur.InterfaceName, dt.InterfaceName)
+		}
+		if dt.OutNode != nil {
+			curOut := ""
+			if cur.OutNodeID != nil {
+				curOut = st.nodeByID[*cur.OutNodeID].Name
+			}
+			cfgDiff(ch, "outNode", curOut, *dt.OutNode)
+		}
+		if dt.OutExitID != nil {
+			cfgDiff(ch, "outExitId", cur.OutExitID, dt.OutExitID)
+		}
+		if len(ch) > 0 {
+			_, outChanged := ch["outNode"]
+			_, extChanged := ch["outExitId"]
+			creates = append(creates, cfgPlanItem{Kind: "tunnel", Action: "update", Key: dt.Name, ID: cur.ID, Changes: ch, run: func(x *cfgApplyCtx) (func() error, error) {
+				if err := updateTunnel(tunnelReq(x, cur.ID, cur.Name, cur, dt), 0, 0); err != nil {
+					return nil, err
+				}
+				if outChanged || extChanged {
+					x.repush[cur.ID] = true
+				}
+				return func() error { return updateTunnel(restoreTunnel(cur), 0, 0) }, nil
+			}})
+		}
+		if pi := pathItem(dt, tunnelPathIDs(cur.ID), tunnelLinkModes(cur.ID), cur.ID); pi != nil {
+			creates = append(creates, *pi)
+		}
+	}
+
+	// ---- speed limits ----
+	for _, ds := range doc.SpeedLimits {
+		ds := ds
+		if ds.Name == "" || ds.Tunnel == "" || !tunnelKnown(ds.Tunnel) {
+			errs = append(errs, "限速规则名称为空或隧道不存在: "+ds.Name)
+			continue
+		}
+		cur, exists := st.speeds[ds.Name]
+		if !exists {
+			if ds.Speed == nil {
+				errs = append(errs, "新建限速规则需要 speed: "+ds.Name)
+				continue
+			}
+			ch := map[string]cfgChange{}
+			cfgDiff(ch, "speed", nil, ds.Speed)
+			cfgDiff(ch, "tunnel", nil, ds.Tunnel)
+			creates = append(creates, cfgPlanItem{Kind: "speedLimit", Action: "create", Key: ds.Name, Changes: ch, run: func(x *cfgApplyCtx) (func() error, error) {
+				sl, err := createSpeedLimit(dto.SpeedLimitDto{Name: ds.Name, Speed: *ds.Speed, TunnelID: x.tunnelID(ds.Tunnel), TunnelName: ds.Tunnel})
+				if err != nil {
+					return nil, err
+				}
+				x.speeds[ds.Name] = sl.ID
+				return func() error { return deleteSpeedLimit(sl.ID) }, nil
+			}})
+			continue
+		}
+		ch := map[string]cfgChange{}
+		if ds.Speed != nil {
+			cfgDiff(ch, "speed", cur.Speed, ds.Speed)
+		}
+		cfgDiff(ch, "tunnel", cur.TunnelName, ds.Tunnel)
+		if len(ch) == 0 {
+			continue
+		}
+		creates = append(creates, cfgPlanItem{Kind: "speedLimit", Action: "update", Key: ds.Name, ID: cur.ID, Changes: ch, run: func(x *cfgApplyCtx) (func() error, error) {
+			req := dto.SpeedLimitUpdateDto{ID: cur.ID, Name: cur.Name, Speed: pick(ds.Speed, cur.Speed), TunnelID: x.tunnelID(ds.Tunnel), TunnelName: ds.Tunnel}
+			if err := updateSpeedLimit(req); err != nil {
+				return nil, err
+			}
+			return func() error {
+				return updateSpeedLimit(dto.SpeedLimitUpdateDto{ID: cur.ID, Name: cur.Name, Speed: cur.Speed, TunnelID: cur.TunnelID, TunnelName: cur.TunnelName})
+			}, nil
+		}})
+	}
+
+	// ---- user assignments ----
+	deletedForwards := map[int64]bool{}
+	keptAssign := map[string]bool{}
+	for _, du := range doc.Users {
+		du := du
+		u, ok := st.users[du.User]
+		if !ok {
+			errs = append(errs, "用户不存在（配置不创建用户）: "+du.User)
+			continue
+		}
+		for _, a := range du.Tunnels {
+			a := a
+			if !tunnelKnown(a.Tunnel) {
+				errs = append(errs, fmt.Sprintf("用户 %s 分配的隧道不存在: %s", du.User, a.Tunnel))
+				continue
+			}
+			if a.SpeedLimit != nil && *a.SpeedLimit != "" && !speedKnown(*a.SpeedLimit) {
+				errs = append(errs, fmt.Sprintf("用户 %s 引用的限速规则不存在: %s", du.User, *a.SpeedLimit))
+				continue
+			}
+			key := du.User + "/" + a.Tunnel
+			keptAssign["t:"+key] = true
+			speedOf := func(x *cfgApplyCtx, cur *int64) *int64 {
+				if a.SpeedLimit == nil {
+					return cur
+				}
+				if *a.SpeedLimit == "" {
+					return nil
+				}
+				return cfgPtr(x.speedID(*a.SpeedLimit))
+			}
+			cur, exists := st.userTunnels[key]
+			if !exists {
+				ch := map[string]cfgChange{}
+				cfgDiff(ch, "flow", nil, a.Flow)
+				cfgDiff(ch, "num", nil, a.Num)
+				cfgDiff(ch, "speedLimit", nil, a.SpeedLimit)
+				creates = append(creates, cfgPlanItem{Kind: "userTunnel", Action: "create", Key: key, Changes: ch, run: func(x *cfgApplyCtx) (func() error, error) {
+					req := dto.UserTunnelDto{UserID: u.ID, TunnelID: x.tunnelID(a.Tunnel), Flow: pick(a.Flow, 0), Num: pick(a.Num, 0),
+						ExpTime: a.ExpTime, FlowResetTime: a.FlowResetTime, SpeedID: speedOf(x, nil), Status: a.Status}
+					ut, err := assignUserTunnel(req)
+					if err != nil {
+						return nil, err
+					}
+					return func() error { return removeUserTunnel(ut.ID) }, nil
+				}})
+				continue
+			}
+			ch := map[string]cfgChange{}
+			if a.Flow != nil {
+				cfgDiff(ch, "flow", cur.Flow, a.Flow)
+			}
+			if a.Num != nil {
+				cfgDiff(ch, "num", cur.Num, a.Num)
+			}
+			if a.ExpTime != nil {
+				cfgDiff(ch, "expTime", cur.ExpTime, a.ExpTime)
+			}
+			if a.FlowResetTime != nil {
+				cfgDiff(ch, "flowResetTime", cur.FlowResetTime, a.FlowResetTime)
+			}
+			if a.Status != nil {
+				cfgDiff(ch, "status", cur.Status, a.Status)
+			}
+			if a.SpeedLimit != nil {
+				curName := ""
+				if cur.SpeedID != nil {
+					curName = st.speedByID[*cur.SpeedID].Name
+				}
+				cfgDiff(ch, "speedLimit", curName, *a.SpeedLimit)
+			}
+			if len(ch) == 0 {
+				continue
+			}
+			creates = append(creates, cfgPlanItem{Kind: "userTunnel", Action: "update", Key: key, ID: cur.ID, Changes: ch, run: func(x *cfgApplyCtx) (func() error, error) {
+				req := dto.UserTunnelUpdateDto{ID: cur.ID, Flow: pick(a.Flow, cur.Flow), Num: pick(a.Num, cur.Num), ExpTime: a.ExpTime,
+					FlowResetTime: a.FlowResetTime, Status: a.Status, SpeedID: speedOf(x, cur.SpeedID)}
+				if err := updateUserTunnel(req); err != nil {
+					return nil, err
+				}
+				return func() error {
+					return updateUserTunnel(dto.UserTunnelUpdateDto{ID: cur.ID, Flow: cur.Flow, Num: cur.Num, ExpTime: cur.ExpTime,
+						FlowResetTime: cur.FlowResetTime, Status: cfgPtr(cur.Status), SpeedID: cur.SpeedID})
+				}, nil
+			}})
+		}
+		for _, a := range du.Nodes {
+			a := a
+			if !nodeKnown(a.Node) {
+				errs = append(errs, fmt.Sprintf("用户 %s 分配的节点不存在: %s", du.User, a.Node))
+				continue
+			}
+			key := du.User + "/" + a.Node
+			keptAssign["n:"+key] = true
+			cur, exists := st.userNodes[key]
+			if !exists {
+				ch := map[string]cfgChange{}
+				cfgDiff(ch, "flow", nil, a.Flow)
+				cfgDiff(ch, "num", nil, a.Num)
+				cfgDiff(ch, "portRanges", nil, a.PortRanges)
+				cfgDiff(ch, "speedMbps", nil, a.SpeedMbps)
+				creates = append(creates, cfgPlanItem{Kind: "userNode", Action: "create", Key: key, Changes: ch, run: func(x *cfgApplyCtx) (func() error, error) {
+					nid := x.nodeID(a.Node)
+					req := dto.UserNodeDto{UserID: u.ID, NodeID: nid, Flow: pick(a.Flow, 0), Num: pick(a.Num, 0), PortRanges: pick(a.PortRanges, ""),
+						SpeedMbps: a.SpeedMbps, ExpTime: a.ExpTime, FlowResetTime: a.FlowResetTime, Status: a.Status}
+					if msg := assignUserNode(req); msg != "" {
+						return nil, errors.New(msg)
+					}
+					return func() error {
+						var un model.UserNode
+						if dbpkg.DB.Where("user_id = ? AND node_id = ?", u.ID, nid).First(&un).Error == nil {
+							return removeUserNode(un.ID)
+						}
+						return nil
+					}, nil
+				}})
+				continue
+			}
+			ch := map[string]cfgChange{}
+			if a.Flow != nil {
+				cfgDiff(ch, "flow", cur.Flow, a.Flow)
+			}
+			if a.Num != nil {
+				cfgDiff(ch, "num", cur.Num, a.Num)
+			}
+			if a.PortRanges != nil {
+				cfgDiff(ch, "portRanges", cur.PortRanges, a.PortRanges)
+			}
+			if a.SpeedMbps != nil {
+				cfgDiff(ch, "speedMbps", cur.SpeedMbps, a.SpeedMbps)
+			}
+			if a.ExpTime != nil {
+				cfgDiff(ch, "expTime", cur.ExpTime, a.ExpTime)
+			}
+			if a.FlowResetTime != nil {
+				cfgDiff(ch, "flowResetTime", cur.FlowResetTime, a.FlowResetTime)
+			}
+			if a.Status != nil {
+				cfgDiff(ch, "status", cur.Status, a.Status)
+			}
+			if len(ch) == 0 {
+				continue
+			}
+			creates = append(creates, cfgPlanItem{Kind: "userNode", Action: "update", Key: key, ID: cur.ID, Changes: ch, run: func(x *cfgApplyCtx) (func() error, error) {
+				req := dto.UserNodeUpdateDto{ID: cur.ID, Flow: pick(a.Flow, cur.Flow), Num: pick(a.Num, cur.Num), PortRanges: a.PortRanges,
+					SpeedMbps: a.SpeedMbps, ExpTime: a.ExpTime, FlowResetTime: a.FlowResetTime, Status: a.Status}
+				if err := updateUserNode(req); err != nil {
+					return nil, err
+				}
+				return func() error {
+					return updateUserNode(dto.UserNodeUpdateDto{ID: cur.ID, Flow: cur.Flow, Num: cur.Num, PortRanges: cfgPtr(cur.PortRanges), SpeedMbps: cfgPtr(cur.SpeedMbps),
+						ExpTime: cur.ExpTime, FlowResetTime: cur.FlowResetTime, Status: cfgPtr(cur.Status)})
+				}, nil
+			}})
+		}
+	}
+
+	// ---- forwards ----
+	docForwards := map[string]bool{}
+	for _, df := range doc.Forwards {
+		df := df
+		key := df.Tunnel + "/" + df.Name
+		if df.Name == "" || docForwards[key] {
+			errs = append(errs, "转发名称为空或重复: "+key)
+			continue
+		}
+		docForwards[key] = true
+		if !tunnelKnown(df.Tunnel) {
+			errs = append(errs, fmt.Sprintf("转发 %s 的隧道不存在", key))
+			continue
+		}
+		cur, exists := st.forwards[key]
+		if df.User != "" {
+			u, ok := st.users[df.User]
+			if !ok {
+				errs = append(errs, fmt.Sprintf("转发 %s 的用户不存在: %s", key, df.User))
+				continue
+			}
+			if exists && u.ID != cur.UserID {
+				errs = append(errs, "转发所属用户不可修改: "+key)
+				continue
+			}
+		}
+		if !exists {
+			if df.RemoteAddr == nil || *df.RemoteAddr == "" {
+				errs = append(errs, "新建转发需要 remoteAddr: "+key)
+				continue
+			}
+			ch := map[string]cfgChange{}
+			cfgDiff(ch, "user", nil, df.User)
+			cfgDiff(ch, "inPort", nil, df.InPort)
+			cfgDiff(ch, "remoteAddr", nil, df.RemoteAddr)
+			cfgDiff(ch, "strategy", nil, df.Strategy)
+			creates = append(creates, cfgPlanItem{Kind: "forward", Action: "create", Key: key, Changes: ch, run: func(x *cfgApplyCtx) (func() error, error) {
+				// create as the owner with their real role so quota/permission checks apply
+				uid, role := adminUserID(), 0
+				if df.User != "" {
+					uid, role = st.users[df.User].ID, st.users[df.User].RoleID
+				}
+				req := dto.ForwardDto{Name: df.Name, TunnelID: x.tunnelID(df.Tunnel), RemoteAddr: *df.RemoteAddr, Group: pick(df.Group, ""),
+					InPort: df.InPort, Strategy: df.Strategy, InterfaceName: df.InterfaceName}
+				f, _, err := createForward(req, uid, role)
+				if err != nil {
+					return nil, err
+				}
+				x.pushed[f.ID] = true
+				return func() error { return deleteForwardByID(f.ID) }, nil
+			}})
+			continue
+		}
+		ch := map[string]cfgChange{}
+		if df.InPort != nil {
+			cfgDiff(ch, "inPort", cur.InPort, df.InPort)
+		}
+		if df.RemoteAddr != nil {
+			cfgDiff(ch, "remoteAddr", cur.RemoteAddr, normalizeRemoteAddrList(*df.RemoteAddr))
+		}
+		if df.Strategy != nil {
+			cfgDiff(ch, "strategy", cur.Strategy, df.Strategy)
+		}
+		if df.Group != nil {
+			cfgDiff(ch, "group", cur.Group, df.Group)
+		}
+		if df.InterfaceName != nil {
+			cfgDiff(ch, "interfaceName", cur.InterfaceName, df.InterfaceName)
+		}
+		if len(ch) == 0 {
+			continue
+		}
+		creates = append(creates, cfgPlanItem{Kind: "forward", Action: "update", Key: key, ID: cur.ID, Changes: ch, run: func(x *cfgApplyCtx) (func() error, error) {
+			next := cur
+			next.InPort = pick(df.InPort, cur.InPort)
+			next.RemoteAddr = pick(df.RemoteAddr, cur.RemoteAddr)
+			next.Group = pick(df.Group, cur.Group)
+			if df.Strategy != nil {
+				next.Strategy = df.Strategy
+			}
+			if df.InterfaceName != nil {
+				next.InterfaceName = df.InterfaceName
+			}
+			if err := reapplyForward(next); err != nil {
+				return nil, err
+			}
+			x.pushed[cur.ID] = true
+			return func() error { return reapplyForward(cur) }, nil
+		}})
+	}
+
+	// ---- prune (deletes run after all creates/updates, dependents first) ----
+	if doc.Prune {
+		if doc.Forwards != nil {
+			keys := sortedKeys(st.forwards)
+			for _, k := range keys {
+				if docForwards[k] {
+					continue
+				}
+				f := st.forwards[k]
+				deletedForwards[f.ID] = true
+				deletes = append(deletes, cfgPlanItem{Kind: "forward", Action: "delete", Key: k, ID: f.ID, run: func(x *cfgApplyCtx) (func() error, error) {
+					var row model.Forward
+					if err := dbpkg.DB.First(&row, f.ID).Error; err != nil {
+						return nil, err
+					}
+					var mids []model.ForwardMidPort
+					dbpkg.DB.Where("forward_id = ?", f.ID).Find(&mids)
+					if err := deleteForwardByID(f.ID); err != nil {
+						return nil, err
+					}
+					return func() error { return restoreForward(row, mids) }, nil
+				}})
+			}
+		}
+		for _, du := range doc.Users {
+			u, ok := st.users[du.User]
+			if !ok {
+				continue
+			}
+			if du.Tunnels != nil {
+				for _, k := range sortedKeys(st.userTunnels) {
+					ut := st.userTunnels[k]
+					if ut.UserID != u.ID || keptAssign["t:"+k] {
+						continue
+					}
+					var fids []int64
+					dbpkg.DB.Model(&model.Forward{}).Where("user_id = ? AND tunnel_id = ?", ut.UserID, ut.TunnelID).Pluck("id", &fids)
+					for _, fid := range fids {
+						if !deletedForwards[fid] {
+							errs = append(errs, "用户在该隧道仍有转发，请一并移除: "+k)
+							break
+						}
+					}
+					deletes = append(deletes, cfgPlanItem{Kind: "userTunnel", Action: "delete", Key: k, ID: ut.ID, run: func(x *cfgApplyCtx) (func() error, error) {
+						var row model.UserTunnel
+						if err := dbpkg.DB.First(&row, ut.ID).Error; err != nil {
+							return nil, err
+						}
+						if err := removeUserTunnel(ut.ID); err != nil {
+							return nil, err
+						}
+						return func() error { return dbpkg.DB.Create(&row).Error }, nil
+					}})
+				}
+			}
+			if du.Nodes != nil {
+				for _, k := range sortedKeys(st.userNodes) {
+					un := st.userNodes[k]
+					if un.UserID != u.ID || keptAssign["n:"+k] {
+						continue
+					}
+					deletes = append(deletes, cfgPlanItem{Kind: "userNode", Action: "delete", Key: k, ID: un.ID, run: func(x *cfgApplyCtx) (func() error, error) {
+						var row model.UserNode
+						if err := dbpkg.DB.First(&row, un.ID).Error; err != nil {
+							return nil, err
+						}
+						if err := removeUserNode(un.ID); err != nil {
+							return nil, err
+						}
+						return func() error {
+							if err := dbpkg.DB.Create(&row).Error; err != nil {
+								return err
+							}
+							go pushExitUsersToNode(row.NodeID)
+							return nil
+						}, nil
+					}})
+				}
+			}
+		}
+		if doc.SpeedLimits != nil {
+			for _, k := range sortedKeys(st.speeds) {
+				if docSpeeds[k] {
+					continue
+				}
+				s := st.speeds[k]
+				deletes = append(deletes, cfgPlanItem{Kind: "speedLimit", Action: "delete", Key: k, ID: s.ID, run: func(x *cfgApplyCtx) (func() error, error) {
+					var row model.SpeedLimit
+					if err := dbpkg.DB.First(&row, s.ID).Error; err != nil {
+						return nil, err
+					}
+					if err := deleteSpeedLimit(s.ID); err != nil {
+						return nil, err
+					}
+					return func() error {
+						if err := dbpkg.DB.Create(&row).Error; err != nil {
+							return err
+						}
+						applyLimiterForTunnel(row.TunnelID)
+						return nil
+					}, nil
+				}})
+			}
+		}
+		if doc.Tunnels != nil {
+			// a deleted tunnel cannot be restored under its old ID, so its
+			// removal would not survive a rollback
+			for _, k := range sortedKeys(st.tunnels) {
+				if _, ok := docTunnels[k]; !ok {
+					errs = append(errs, "配置下发不删除隧道，请在隧道列表中手动删除: "+k)
+				}
+			}
+		}
+	}
+	return append(creates, deletes...), errs
+}
+
+// restoreForward re-inserts a pruned forward with its original ID, flow
+// counters and mid-hop ports, then pushes its services again.
+func restoreForward(f model.Forward, mids []model.ForwardMidPort) error {
+	if err := dbpkg.DB.Create(&f).Error; err != nil {
+		return err
+	}
+	if len(mids) > 0 {
+		if err := dbpkg.DB.Create(&mids).Error; err != nil {
+			return err
+		}
+	}
+	return reapplyForward(f)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// parseCfgDoc accepts JSON or YAML.
+func parseCfgDoc(raw []byte) (cfgDoc, error) {
+	var doc cfgDoc
+	trimmed := strings.TrimSpace(string(raw))
+	if trimmed == "" {
+		return doc, errors.New("配置为空")
+	}
+	if !strings.HasPrefix(trimmed, "{") {
+		j, err := yaml.YAMLToJSON(raw)
+		if err != nil {
+			return doc, fmt.Errorf("YAML 解析失败: %v", err)
+		}
+		raw = j
+	}
+	dec := json.NewDecoder(strings.NewReader(string(raw)))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&doc); err != nil {
+		return doc, fmt.Errorf("配置解析失败: %v", err)
+	}
+	return doc, nil
+}
+
+// ConfigApply 声明式配置下发（支持 dryRun 预览差异）
+// @Summary 声明式配置下发
+// @Tags config
+// @Accept json
+// @Produce json
+// @Param dryRun query bool false "仅计算差异"
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/config/apply [post]
+func ConfigApply(c *gin.Context) {
+	raw, err := io.ReadAll(io.LimitReader(c.Request.Body, 4<<20))
+	if err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	doc, err := parseCfgDoc(raw)
+	if err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg(err.Error()))
+		return
+	}
+	if v := c.Query("dryRun"); v == "1" || v == "true" {
+		doc.DryRun = true
+	}
+	cfgApplyMu.Lock()
+	defer cfgApplyMu.Unlock()
+
+	st := loadCfgState()
+	plan, errs := buildCfgPlan(doc, st)
+	summary := map[string]int{"create": 0, "update": 0, "delete": 0}
+	for _, it := range plan {
+		summary[it.Action]++
+	}
+	if len(errs) > 0 {
+		r := response.ErrMsg("配置校验失败: " + errs[0])
+		r.Data = map[string]any{"errors": errs, "plan": plan, "summary": summary}
+		c.JSON(http.StatusOK, r)
+		return
+	}
+	if doc.DryRun || len(plan) == 0 {
+		c.JSON(http.StatusOK, response.Ok(map[string]any{"dryRun": doc.DryRun, "applied": false, "plan": plan, "summary": summary}))
+		return
+	}
+
+	x := &cfgApplyCtx{st: st, nodes: map[string]int64{}, tunnels: map[string]int64{}, speeds: map[string]int64{}, users: map[string]int64{}, repush: map[int64]bool{}, pushed: map[int64]bool{}}
+	results := make([]cfgStepResult, 0, len(plan))
+	type cfgUndo struct {
+		it cfgPlanItem
+		fn func() error
+	}
+	var undos []cfgUndo
+	failed := false
+	for _, it := range plan {
+		undo, err := it.run(x)
+		res := cfgStepResult{Kind: it.Kind, Action: it.Action, Key: it.Key, Success: err == nil}
+		if err != nil {
+			res.Message = err.Error()
+			results = append(results, res)
+			failed = true
+			break
+		}
+		results = append(results, res)
+		if undo != nil {
+			undos = append(undos, cfgUndo{it: it, fn: undo})
+		}
+	}
+	if failed {
+		cause := results[len(results)-1]
+		undoFailed := 0
+		for i := len(undos) - 1; i >= 0; i-- {
+			u := undos[i]
+			if err := u.fn(); err != nil {
+				undoFailed++
+				results = append(results, cfgStepResult{Kind: u.it.Kind, Action: "rollback", Key: u.it.Key, Message: err.Error()})
+			}
+		}
+		jlog(map[string]any{"event": "config_apply_rollback", "steps": len(undos), "undoFailed": undoFailed, "failed": cause})
+		msg := "配置下发失败，已回滚: " + cause.Message
+		if undoFailed > 0 {
+			msg = fmt.Sprintf("配置下发失败，回滚时 %d 步失败，请检查 results: %s", undoFailed, cause.Message)
+		}
+		r := response.ErrMsg(msg)
+		r.Data = map[string]any{"plan": plan, "summary": summary, "results": results, "rolledBack": undoFailed == 0}
+		c.JSON(http.StatusOK, r)
+		return
+	}
+	// tunnels whose exit/path changed: re-push their untouched forwards
+	repushed := []int64{}
+	for tid := range x.repush {
+		var fwds []model.Forward
+		dbpkg.DB.Where("tunnel_id = ?", tid).Find(&fwds)
+		for _, f := range fwds {
+			if x.pushed[f.ID] || (f.Status != nil && *f.Status != 1) {
+				continue
+			}
+			if reapplyForward(f) == nil {
+				repushed = append(repushed, f.ID)
+			}
+		}
+	}
+	jlog(map[string]any{"event": "config_apply", "summary": summary, "repushed": repushed})
+	c.JSON(http.StatusOK, response.Ok(map[string]any{"dryRun": false, "applied": true, "plan": plan, "summary": summary, "results": results, "repushedForwards": repushed}))
+}
@@ -0,0 +1,51 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func callHandler(h gin.HandlerFunc, body string) map[string]any {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("POST", "/", strings.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	h(c)
+	var out map[string]any
+	_ = json.Unmarshal(w.Body.Bytes(), &out)
+	return out
+}
+
+func TestConfigListHidesMetricsToken(t *testing.T) {
+	useTestDB(t)
+	setCfg("metrics_token", "tok-secret")
+	setCfg("app_name", "panel")
+
+	list := callHandler(ConfigList, "")
+	data, _ := list["data"].(map[string]any)
+	if data["app_name"] != "panel" {
+		t.Fatalf("config list missing public key: %v", list)
+	}
+	if _, ok := data["metrics_token"]; ok || strings.Contains(mustJSON(list), "tok-secret") {
+		t.Fatal("config list exposes metrics_token")
+	}
+	if got := callHandler(ConfigGet, `{"name":"metrics_token"}`); got["data"] != "" {
+		t.Fatalf("config get exposes metrics_token: %v", got)
+	}
+
+	callHandler(ConfigUpdate, `{"metrics_token":"weak"}`)
+	callHandler(ConfigUpdateSingle, `{"name":"metrics_token","value":"weak"}`)
+	if metricsToken() != "tok-secret" {
+		t.Fatal("metrics_token overwritten through the config API")
+	}
+}
+
+func mustJSON(v any) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
@@ -46,6 +46,8 @@ type etNode struct {
 	PeerNodeID *int64  `json:"peerNodeId,omitempty"`
 	IPv4       string  `json:"ipv4"`
 	PeerIP     *string `json:"peerIp,omitempty"`
+	// ExtraPeers are additional redundant links (rendered as extra [[peer]] blocks)
+	ExtraPeers []int64 `json:"extraPeers,omitempty"`
 }
 
 // guard to avoid duplicate EasyTier install scripts being sent concurrently
@@ -297,8 +299,8 @@ func EasyTierReapply(c *gin.Context) {
 			m.Port = master.Port
 			changed = true
 		}
-		if m.IPv4 == "" {
-			m.IPv4 = fmt.Sprintf("%d", master.NodeID)
+		if ov := overlayIPFor(master.NodeID); ov != "" && m.IPv4 != ov {
+			m.IPv4 = ov
 			changed = true
 		}
 		if changed {
@@ -306,7 +308,7 @@ func EasyTierReapply(c *gin.Context) {
 			configured[m.NodeID] = m
 		}
 	} else {
-		nodes = append(nodes, etNode{NodeID: master.NodeID, IP: master.IP, Port: master.Port, IPv4: fmt.Sprintf("%d", master.NodeID)})
+		nodes = append(nodes, etNode{NodeID: master.NodeID, IP: master.IP, Port: master.Port, IPv4: overlayIPFor(master.NodeID)})
 		idx[master.NodeID] = len(nodes) - 1
 		configured[master.NodeID] = nodes[idx[master.NodeID]]
 		changed = true
@@ -364,7 +366,7 @@ func EasyTierReapply(c *gin.Context) {
 				continue
 			}
 			port := pickNodePort(node)
-			ipv4 := fmt.Sprintf("%d", node.ID)
+			ipv4 := overlayIPFor(node.ID)
 			var peerNodeID *int64
 			var peerIP *string
 			if node.ID != master.NodeID {
@@ -404,8 +406,13 @@ func EasyTierReapply(c *gin.Context) {
 		reqID := RandUUID()
 		now := time.Now().UnixMilli()
 		updateEasyTierRuntime(id, "", "reapply", "", reqID, now)
-		conf := renderEasyTierConf(id)
-		ok, msg := writeEasyTierConfig(id, conf, reqID)
+		conf, err := renderEasyTierConf(id)
+		ok, msg := false, ""
+		if err != nil {
+			msg = err.Error()
+		} else {
+			ok, msg = writeEasyTierConfig(id, conf, reqID)
+		}
 		if ok {
 			item["requestId"] = reqID
 			success++
@@ -528,15 +535,15 @@ func EasyTierListNodes(c *gin.Context) {
 	if v := getCfg(etNodesKey); v != "" {
 		_ = json.Unmarshal([]byte(v), &nodes)
 	}
-	// normalize IPv4 segment to node ID to avoid duplicates
+	// normalize IPv4 to the IPAM allocation to avoid duplicates
 	changed := false
 	for i := range nodes {
 		if nodes[i].NodeID == 0 {
 			continue
 		}
-		ipv4Seg := fmt.Sprintf("%d", nodes[i].NodeID)
-		if nodes[i].IPv4 != ipv4Seg {
-			nodes[i].IPv4 = ipv4Seg
+		ov := overlayIPFor(nodes[i].NodeID)
+		if ov != "" && nodes[i].IPv4 != ov {
+			nodes[i].IPv4 = ov
 			changed = true
 		}
 	}
@@ -579,14 +586,13 @@ func EasyTierListNodes(c *gin.Context) {
 			it["ip"] = j.IP
 			it["port"] = j.Port
 			it["peerNodeId"] = j.PeerNodeID
+			it["extraPeers"] = j.ExtraPeers
 			it["ipv4"] = j.IPv4
 			if j.PeerIP != nil {
 				it["peerIp"] = *j.PeerIP
 			}
-			expectedIP := ""
-			ipv4Seg := ipv4Tail(j.IPv4, n.ID)
-			if ipv4Seg != "" {
-				expectedIP = "10.126.126." + ipv4Seg
+			expectedIP := j.IPv4
+			if expectedIP != "" {
 				it["expectedIp"] = expectedIP
 			}
 			joinedOk := false
@@ -638,8 +644,12 @@ func EasyTierJoin(c *gin.Context) {
 	if v := getCfg(etNodesKey); v != "" {
 		_ = json.Unmarshal([]byte(v), &nodes)
 	}
-	// use node id as the last segment (template carries prefix)
-	ipv4 := fmt.Sprintf("%d", p.NodeID)
+	// stable overlay address from IPAM
+	ipv4 := overlayIPFor(p.NodeID)
+	if ipv4 == "" {
+		c.JSON(http.StatusOK, response.ErrMsg("组网地址池已耗尽"))
+		return
+	}
 	// normalize/validate port
 	var n model.Node
 	_ = dbpkg.DB.First(&n, p.NodeID).Error
@@ -699,7 +709,11 @@ func EasyTierJoin(c *gin.Context) {
 		reqID = lastEasyTierRequestID(p.NodeID)
 	}
 	// render and send default.conf (write to both common paths)
-	conf := renderEasyTierConf(p.NodeID)
+	conf, err := renderEasyTierConf(p.NodeID)
+	if err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg(err.Error()))
+		return
+	}
 	if ok, msg := writeEasyTierConfig(p.NodeID, conf, reqID); !ok {
 		if msg == "" {
 			msg = "写配置失败"
@@ -720,7 +734,7 @@ func ensureMasterJoined(nodeID int64, ip string, port int, host string) {
 	if nodeID == 0 {
 		return
 	}
-	ipv4 := fmt.Sprintf("%d", nodeID)
+	ipv4 := overlayIPFor(nodeID)
 	var nodes []etNode
 	if v := getCfg(etNodesKey); v != "" {
 		_ = json.Unmarshal([]byte(v), &nodes)
@@ -754,7 +768,11 @@ func ensureMasterJoined(nodeID int64, ip string, port int, host string) {
 		waitEtInstallFinish(nodeID, time.Duration(installTO)*time.Second)
 		reqID = lastEasyTierRequestID(nodeID)
 	}
-	conf := renderEasyTierConf(nodeID)
+	conf, err := renderEasyTierConf(nodeID)
+	if err != nil {
+		jlog(map[string]any{"event": "easytier_render_failed", "nodeId": nodeID, "error": err.Error()})
+		return
+	}
 	_, _ = writeEasyTierConfig(nodeID, conf, reqID)
 	_ = requestWithRetry(nodeID, "RestartService", map[string]any{"requestId": RandUUID(), "name": "easytier@default"}, 15*time.Second, 1)
 	_ = requestWithRetry(nodeID, "RestartService", map[string]any{"requestId": RandUUID(), "name": "easytier"}, 20*time.Second, 1)
@@ -840,7 +858,7 @@ func ensureEasyTierAutoJoin() {
 			IP:         selfIP,
 			Port:       port,
 			PeerNodeID: &nid,
-			IPv4:       fmt.Sprintf("%d", n.ID),
+			IPv4:       overlayIPFor(n.ID),
 			PeerIP:     peerIP,
 		})
 		added = append(added, n.ID)
@@ -918,7 +936,7 @@ func ensureEasyTierAutoJoinFor(nodeID int64) {
 		IP:         selfIP,
 		Port:       port,
 		PeerNodeID: &nid,
-		IPv4:       fmt.Sprintf("%d", node.ID),
+		IPv4:       overlayIPFor(node.ID),
 		PeerIP:     peerIP,
 	})
 	b, _ := json.Marshal(nodes)
@@ -938,13 +956,32 @@ func deployEasyTierNodes(ids []int64) {
 			waitEtInstallFinish(nodeID, time.Duration(installTO)*time.Second)
 			reqID = lastEasyTierRequestID(nodeID)
 		}
-		conf := renderEasyTierConf(nodeID)
+		conf, err := renderEasyTierConf(nodeID)
+		if err != nil {
+			jlog(map[string]any{"event": "easytier_render_failed", "nodeId": nodeID, "error": err.Error()})
+			continue
+		}
 		_, _ = writeEasyTierConfig(nodeID, conf, reqID)
 		_ = requestWithRetry(nodeID, "RestartService", map[string]any{"requestId": RandUUID(), "name": "easytier@default"}, 15*time.Second, 1)
 		_ = requestWithRetry(nodeID, "RestartService", map[string]any{"requestId": RandUUID(), "name": "easytier"}, 20*time.Second, 1)
 	}
 }
 
+// applyEasyTierConfig renders and writes the node's config, then restarts the service.
+func applyEasyTierConfig(nodeID int64, reqID string) (bool, string) {
+	conf, err := renderEasyTierConf(nodeID)
+	if err != nil {
+		return false, err.Error()
+	}
+	ok, msg := writeEasyTierConfig(nodeID, conf, reqID)
+	if !ok {
+		return false, msg
+	}
+	_ = requestWithRetry(nodeID, "RestartService", map[string]any{"requestId": RandUUID(), "name": "easytier@default"}, 15*time.Second, 1)
+	_ = requestWithRetry(nodeID, "RestartService", map[string]any{"requestId": RandUUID(), "name": "easytier"}, 20*time.Second, 1)
+	return true, ""
+}
+
 func pickBestMaster() (etMaster, bool) {
 	var list []model.Node
 	if err := dbpkg.DB.Find(&list).Error; err != nil || len(list) == 0 {
@@ -1471,9 +1508,9 @@ func EasyTierRemove(c *gin.Context) {
 	c.JSON(http.StatusOK, response.OkMsg("已移除"))
 }
 
-func renderEasyTierConf(nodeID int64) string {
+func renderEasyTierConf(nodeID int64) (string, error) {
 	// simple template: load from easytier/default.conf and replace placeholders
-	// placeholders: {hostname}, {ipv4}, {port}, {ip}, {peer_port}, {secret}
+	// placeholders: {hostname}, {overlay_ip}, {ipv4}, {port}, {ip}, {peer_port}, {secret}
 	secret := getCfg(etSecretKey)
 	if secret == "" {
 		secret = RandUUID32()
@@ -1512,9 +1549,13 @@ func renderEasyTierConf(nodeID int64) string {
 		self.Port = pickNodePort(n)
 		changed = true
 	}
-	ipv4Seg := fmt.Sprintf("%d", nodeID)
-	if self.IPv4 != ipv4Seg {
-		self.IPv4 = ipv4Seg
+	rec, err := allocOverlayIP(nodeID)
+	if err != nil {
+		return "", err
+	}
+	overlayIP := rec.IP
+	if self.IPv4 != overlayIP {
+		self.IPv4 = overlayIP
 		changed = true
 	}
 	if self.PeerNodeID == nil && self.PeerIP == nil && master.NodeID != 0 && master.NodeID != nodeID {
@@ -1584,7 +1625,7 @@ func renderEasyTierConf(nodeID int64) string {
 		tpl = `hostname = "{hostname}"
 instance_name = "network-panel"
 dhcp = false
-ipv4 = "10.126.126.{ipv4}"
+ipv4 = "{overlay_ip}"
 listeners = [
     "tcp://{listen}:{port}",
 ]
@@ -1618,7 +1659,10 @@ enable-quic-proxy=true
 	}
 	out := tpl
 	out = strings.ReplaceAll(out, "{hostname}", hostName)
-	out = strings.ReplaceAll(out, "{ipv4}", ipv4Tail(self.IPv4, nodeID))
+	// legacy templates hardcode the /24 prefix and only substitute the last octet
+	out = strings.ReplaceAll(out, "10.126.126.{ipv4}", "{overlay_ip}")
+	out = strings.ReplaceAll(out, "{overlay_ip}", overlayConfAddr(self.IPv4))
+	out = strings.ReplaceAll(out, "{ipv4}", overlayLastOctet(self.IPv4))
 	out = strings.ReplaceAll(out, "{listen}", listenHost)
 	out = strings.ReplaceAll(out, "{port}", fmt.Sprintf("%d", self.Port))
 	out = strings.ReplaceAll(out, "{ip}", peerIP)
@@ -1628,7 +1672,30 @@ enable-quic-proxy=true
 	if !strings.HasSuffix(out, "\n") {
 		out += "\n"
 	}
-	return out
+	out += renderExtraPeers(self, nodes)
+	return out, nil
+}
+
+// renderExtraPeers renders additional [[peer]] blocks for redundant links.
+func renderExtraPeers(self etNode, nodes []etNode) string {
+	var b strings.Builder
+	for _, pid := range self.ExtraPeers {
+		if pid == self.NodeID || (self.PeerNodeID != nil && *self.PeerNodeID == pid) {
+			continue
+		}
+		for _, x := range nodes {
+			if x.NodeID != pid || x.IP == "" || x.Port == 0 {
+				continue
+			}
+			host := x.IP
+			if strings.Contains(host, ":") && !strings.HasPrefix(host, "[") {
+				host = "[" + host + "]"
+			}
+			fmt.Fprintf(&b, "\n[[peer]]\nuri = \"tcp://%s:%d\"\n", host, x.Port)
+			break
+		}
+	}
+	return b.String()
 }
 
 func readFileDefault(p string) string { b, _ := os.ReadFile(p); return string(b) }
@@ -2335,29 +2402,10 @@ func EasyTierLogStream(c *gin.Context) {
 }
 func RandUUID32() string              { v := RandUUID(); sum := md5.Sum([]byte(v)); return fmt.Sprintf("%x", sum) }
 
-// ipv4Tail returns the last numeric segment for template placeholder.
-func ipv4Tail(v string, nodeID int64) string {
-	v = strings.TrimSpace(v)
-	target := fmt.Sprintf("%d", nodeID)
-	if v == "" {
-		return target
-	}
-	// digits only
-	if allDigits(v) {
-		if v == target {
-			return v
-		}
-		return target
-	}
-	// dotted ipv4
-	if strings.Contains(v, ".") {
-		parts := strings.Split(v, ".")
-		last := strings.TrimSpace(parts[len(parts)-1])
-		if allDigits(last) && last == target {
-			return last
-		}
-	}
-	return target
+// overlayLastOctet returns the last segment of an overlay address for the {ipv4} placeholder.
+func overlayLastOctet(ip string) string {
+	parts := strings.Split(strings.TrimSpace(ip), ".")
+	return parts[len(parts)-1]
 }
 
 func parseInterfaceList(raw *string) []string {
@@ -2410,21 +2458,6 @@ func hasIP(list []string, ip string) bool {
 	return false
 }
 
-func allDigits(s string) bool {
-	if s == "" {
-		return false
-	}
-	for _, r := range s {
-		if r < '0' || r > '9' {
-			return false
-		}
-	}
-	if n, err := strconv.Atoi(s); err == nil {
-		return n >= 0 && n <= 255
-	}
-	return false
-}
-
 func randDevSuffix(n int) string {
 	if n <= 0 {
 		n = 5
@@ -2529,17 +2562,24 @@ func EasyTierChangePeer(c *gin.Context) {
 	b, _ := json.Marshal(nodes)
 	setCfg(etNodesKey, string(b))
 	// rewrite config on target node and restart
-	conf := renderEasyTierConf(p.NodeID)
+	conf, err := renderEasyTierConf(p.NodeID)
+	if err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg(err.Error()))
+		return
+	}
 	_, _ = writeEasyTierConfig(p.NodeID, conf, "")
 	RequestOp(p.NodeID, "RestartService", map[string]any{"requestId": RandUUID(), "name": "easytier@default"}, 15*time.Second)
 	RequestOp(p.NodeID, "RestartService", map[string]any{"requestId": RandUUID(), "name": "easytier"}, 15*time.Second)
 	c.JSON(http.StatusOK, response.OkMsg("已变更"))
 }
 
-// POST /api/v1/easytier/auto-assign {mode:"chain"}
+// POST /api/v1/easytier/auto-assign {mode:"chain"|"star"|"ring"|"latency", degree?, dryRun?, refresh?}
 func EasyTierAutoAssign(c *gin.Context) {
 	var p struct {
-		Mode string `json:"mode"`
+		Mode    string `json:"mode"`
+		Degree  int    `json:"degree"`
+		DryRun  bool   `json:"dryRun"`
+		Refresh bool   `json:"refresh"`
 	}
 	_ = c.ShouldBindJSON(&p)
 	var nodes []etNode
@@ -2550,6 +2590,13 @@ func EasyTierAutoAssign(c *gin.Context) {
 		c.JSON(http.StatusOK, response.OkMsg("无需分配"))
 		return
 	}
+	if p.Mode == "latency" {
+		easyTierLatencyAssign(c, nodes, p.Degree, p.DryRun, p.Refresh)
+		return
+	}
+	for i := range nodes {
+		nodes[i].ExtraPeers = nil
+	}
 	// support chain (default), star (all -> master), ring (i->i+1, last->first)
 	switch p.Mode {
 	case "star":
@@ -2580,7 +2627,11 @@ func EasyTierAutoAssign(c *gin.Context) {
 	setCfg(etNodesKey, string(b))
 	// rewrite all configs and restart
 	for _, n := range nodes {
-		conf := renderEasyTierConf(n.NodeID)
+		conf, err := renderEasyTierConf(n.NodeID)
+		if err != nil {
+			jlog(map[string]any{"event": "easytier_render_failed", "nodeId": n.NodeID, "error": err.Error()})
+			continue
+		}
 		_, _ = writeEasyTierConfig(n.NodeID, conf, "")
 		_ = requestWithRetry(n.NodeID, "RestartService", map[string]any{"requestId": RandUUID(), "name": "easytier@default"}, 15*time.Second, 1)
 		_ = requestWithRetry(n.NodeID, "RestartService", map[string]any{"requestId": RandUUID(), "name": "easytier"}, 20*time.Second, 1)
@@ -0,0 +1,682 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"network-panel/golang-backend/internal/app/model"
+	"network-panel/golang-backend/internal/app/response"
+	dbpkg "network-panel/golang-backend/internal/db"
+)
+
+// Overlay ACLs: rules are enforced on the destination member by an
+// agent-managed nftables table (inet np_overlay) that only matches traffic
+// addressed to the member's own overlay IP. Established/related traffic is
+// always accepted so replies to allowed connections are not affected.
+
+const (
+	etNetworkName    = "network-panel" // network_name in the EasyTier config
+	etACLEnabledKey  = "easytier_acl_enabled"
+	etACLDefaultKey  = "easytier_acl_default" // allow | deny
+	etACLNftTable    = "np_overlay"
+	etACLAnyEndpoint = "any"
+)
+
+type aclPortRange struct{ From, To int }
+
+func parseACLPorts(s string) ([]aclPortRange, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	var out []aclPortRange
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		lo, hi := part, part
+		if i := strings.Index(part, "-"); i > 0 {
+			lo, hi = strings.TrimSpace(part[:i]), strings.TrimSpace(part[i+1:])
+		}
+		a, err1 := strconv.Atoi(lo)
+		b, err2 := strconv.Atoi(hi)
+		if err1 != nil || err2 != nil || a < 1 || b > 65535 || a > b {
+			return nil, fmt.Errorf("端口格式错误: %s", part)
+		}
+		out = append(out, aclPortRange{a, b})
+	}
+	return out, nil
+}
+
+func aclPortsContain(rs []aclPortRange, port int) bool {
+	for _, r := range rs {
+		if port >= r.From && port <= r.To {
+			return true
+		}
+	}
+	return false
+}
+
+func aclEnabled() bool { return getCfg(etACLEnabledKey) == "1" }
+
+func aclDefaultPolicy() string {
+	if getCfg(etACLDefaultKey) == "deny" {
+		return "deny"
+	}
+	return "allow"
+}
+
+// aclContext holds the lookups needed to resolve rule endpoints.
+type aclContext struct {
+	groups  map[int64][]int64
+	overlay map[int64]string // node id -> overlay IP
+	byIP    map[string]int64
+}
+
+func loadACLContext() aclContext {
+	ctx := aclContext{groups: map[int64][]int64{}, overlay: map[int64]string{}, byIP: map[string]int64{}}
+	var gs []model.OverlayACLGroup
+	dbpkg.DB.Find(&gs)
+	for _, g := range gs {
+		var ids []int64
+		_ = json.Unmarshal([]byte(g.NodeIDs), &ids)
+		ctx.groups[g.ID] = ids
+	}
+	var addrs []model.OverlayAddress
+	dbpkg.DB.Find(&addrs)
+	for _, a := range addrs {
+		ctx.overlay[a.NodeID] = a.IP
+		ctx.byIP[a.IP] = a.NodeID
+	}
+	return ctx
+}
+
+func (x aclContext) endpointNodes(typ, val string) []int64 {
+	switch typ {
+	case "node":
+		if id, err := strconv.ParseInt(strings.TrimSpace(val), 10, 64); err == nil {
+			return []int64{id}
+		}
+	case "group":
+		if id, err := strconv.ParseInt(strings.TrimSpace(val), 10, 64); err == nil {
+			return x.groups[id]
+		}
+	}
+	return nil
+}
+
+// endpointAddrs renders an endpoint as nft address elements; all=true means no address match.
+func (x aclContext) endpointAddrs(typ, val string) (addrs []string, all bool) {
+	switch typ {
+	case "", etACLAnyEndpoint:
+		return nil, true
+	case "cidr":
+		return []string{strings.TrimSpace(val)}, false
+	}
+	for _, id := range x.endpointNodes(typ, val) {
+		if ip := x.overlay[id]; ip != "" {
+			addrs = append(addrs, ip)
+		}
+	}
+	return addrs, false
+}
+
+func (x aclContext) endpointMatch(typ, val string, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	switch typ {
+	case "", etACLAnyEndpoint:
+		return true
+	case "cidr":
+		_, n, err := net.ParseCIDR(strings.TrimSpace(val))
+		if err != nil {
+			if one := net.ParseIP(strings.TrimSpace(val)); one != nil {
+				return one.Equal(ip)
+			}
+			return false
+		}
+		return n.Contains(ip)
+	}
+	for _, id := range x.endpointNodes(typ, val) {
+		if o := net.ParseIP(x.overlay[id]); o != nil && o.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func validateACLEndpoint(typ, val string) string {
+	switch typ {
+	case "", etACLAnyEndpoint:
+		return ""
+	case "node", "group":
+		if _, err := strconv.ParseInt(strings.TrimSpace(val), 10, 64); err != nil {
+			return "成员/分组ID无效"
+		}
+		return ""
+	case "cidr":
+		v := strings.TrimSpace(val)
+		ip, _, err := net.ParseCIDR(v)
+		if err != nil {
+			ip = net.ParseIP(v)
+		}
+		if ip == nil {
+			return "网段格式错误"
+		}
+		// member overlay addresses are IPv4 only, an IPv6 endpoint can never match
+		if ip.To4() == nil {
+			return "网段需为 IPv4（组网地址仅支持 IPv4）"
+		}
+		return ""
+	}
+	return "端点类型无效"
+}
+
+func normalizeACLRule(r *model.OverlayACLRule) string {
+	if r.Network == "" {
+		r.Network = etNetworkName
+	}
+	if r.Network != etNetworkName {
+		return "仅支持网络 " + etNetworkName
+	}
+	r.Action = strings.ToLower(strings.TrimSpace(r.Action))
+	if r.Action != "allow" && r.Action != "deny" {
+		return "动作必须为 allow 或 deny"
+	}
+	if r.SrcType == "" {
+		r.SrcType = etACLAnyEndpoint
+	}
+	if r.DstType == "" {
+		r.DstType = etACLAnyEndpoint
+	}
+	if msg := validateACLEndpoint(r.SrcType, r.SrcValue); msg != "" {
+		return "源" + msg
+	}
+	if msg := validateACLEndpoint(r.DstType, r.DstValue); msg != "" {
+		return "目标" + msg
+	}
+	r.Protocol = strings.ToLower(strings.TrimSpace(r.Protocol))
+	switch r.Protocol {
+	case "":
+		r.Protocol = "any"
+	case "any", "tcp", "udp", "icmp":
+	default:
+		return "协议必须为 any/tcp/udp/icmp"
+	}
+	if _, err := parseACLPorts(r.Ports); err != nil {
+		return err.Error()
+	}
+	if r.Protocol == "icmp" {
+		r.Ports = ""
+	}
+	return ""
+}
+
+func loadACLRules(network string) []model.OverlayACLRule {
+	var rules []model.OverlayACLRule
+	dbpkg.DB.Where("network = ? AND enabled = ?", network, true).Order("priority asc, id asc").Find(&rules)
+	return rules
+}
+
+type aclTraceItem struct {
+	RuleID int64  `json:"ruleId"`
+	Name   string `json:"name"`
+	Match  bool   `json:"match"`
+	Reason string `json:"reason"`
+}
+
+// evaluateACL walks the rules like the rendered nft chain does (first match wins).
+func evaluateACL(x aclContext, rules []model.OverlayACLRule, src, dst net.IP, proto string, port int) (string, *model.OverlayACLRule, []aclTraceItem) {
+	trace := make([]aclTraceItem, 0, len(rules))
+	for i := range rules {
+		r := rules[i]
+		t := aclTraceItem{RuleID: r.ID, Name: r.Name}
+		ports, _ := parseACLPorts(r.Ports)
+		switch {
+		case !x.endpointMatch(r.DstType, r.DstValue, dst):
+			t.Reason = "目标不匹配"
+		case !x.endpointMatch(r.SrcType, r.SrcValue, src):
+			t.Reason = "源不匹配"
+		case r.Protocol != "any" && r.Protocol != proto:
+			t.Reason = "协议不匹配"
+		case len(ports) > 0 && proto != "tcp" && proto != "udp":
+			t.Reason = "规则限定端口，仅匹配 tcp/udp"
+		case len(ports) > 0 && !aclPortsContain(ports, port):
+			t.Reason = "端口不匹配"
+		default:
+			t.Match = true
+			t.Reason = "命中"
+		}
+		trace = append(trace, t)
+		if t.Match {
+			return r.Action, &r, trace
+		}
+	}
+	return aclDefaultPolicy(), nil, trace
+}
+
+// ipv4Elems keeps the IPv4 addresses/CIDRs; rules saved before IPv6 endpoints
+// were rejected would otherwise make "ip saddr" (and the whole ruleset) invalid.
+func ipv4Elems(items []string) []string {
+	out := items[:0:0]
+	for _, it := range items {
+		ip, _, err := net.ParseCIDR(it)
+		if err != nil {
+			ip = net.ParseIP(it)
+		}
+		if ip != nil && ip.To4() != nil {
+			out = append(out, it)
+		}
+	}
+	return out
+}
+
+func nftSet(items []string) string {
+	if len(items) == 1 {
+		return items[0]
+	}
+	return "{ " + strings.Join(items, ", ") + " }"
+}
+
+// renderACLNft renders the nft ruleset enforced on one member (as destination).
+func renderACLNft(x aclContext, rules []model.OverlayACLRule, nodeID int64) (string, error) {
+	self := x.overlay[nodeID]
+	if self == "" {
+		return "", fmt.Errorf("节点未分配组网地址")
+	}
+	selfIP := net.ParseIP(self)
+	lines := []string{
+		"table inet " + etACLNftTable,
+		"delete table inet " + etACLNftTable,
+		"table inet " + etACLNftTable + " {",
+		"\tchain input {",
+		"\t\ttype filter hook input priority -5; policy accept;",
+		"\t\tip daddr " + self + " ct state established,related accept",
+	}
+	for _, r := range rules {
+		if !x.endpointMatch(r.DstType, r.DstValue, selfIP) {
+			continue
+		}
+		expr := []string{"ip daddr " + self}
+		addrs, all := x.endpointAddrs(r.SrcType, r.SrcValue)
+		if !all {
+			addrs = ipv4Elems(addrs)
+			if len(addrs) == 0 {
+				continue // empty group / unknown member / non-IPv4 source matches nothing
+			}
+			expr = append(expr, "ip saddr "+nftSet(addrs))
+		}
+		ports, _ := parseACLPorts(r.Ports)
+		ps := make([]string, 0, len(ports))
+		for _, p := range ports {
+			if p.From == p.To {
+				ps = append(ps, strconv.Itoa(p.From))
+			} else {
+				ps = append(ps, fmt.Sprintf("%d-%d", p.From, p.To))
+			}
+		}
+		switch r.Protocol {
+		case "tcp", "udp":
+			if len(ps) > 0 {
+				expr = append(expr, r.Protocol+" dport "+nftSet(ps))
+			} else {
+				expr = append(expr, "meta l4proto "+r.Protocol)
+			}
+		case "icmp":
+			expr = append(expr, "ip protocol icmp")
+		default:
+			if len(ps) > 0 {
+				expr = append(expr, "meta l4proto { tcp, udp } th dport "+nftSet(ps))
+			}
+		}
+		verdict := "accept"
+		if r.Action == "deny" {
+			verdict = "drop"
+		}
+		expr = append(expr, verdict, fmt.Sprintf("comment \"rule-%d\"", r.ID))
+		lines = append(lines, "\t\t"+strings.Join(expr, " "))
+	}
+	if aclDefaultPolicy() == "deny" {
+		lines = append(lines, "\t\tip daddr "+self+" drop")
+	}
+	lines = append(lines, "\t}", "}")
+	return strings.Join(lines, "\n") + "\n", nil
+}
+
+func buildACLScript(ruleset string) string {
+	delimiter := "NP_ACL_" + RandUUID32()
+	lines := []string{
+		"#!/usr/bin/env sh",
+		"set -e",
+		"SUDO=\"\"",
+		"if [ \"$(id -u)\" -ne 0 ] && command -v sudo >/dev/null 2>&1; then",
+		"  SUDO=\"sudo\"",
+		"fi",
+		"if ! command -v nft >/dev/null 2>&1; then",
+		"  echo \"nft not found\" >&2",
+		"  exit 1",
+		"fi",
+	}
+	if ruleset == "" {
+		lines = append(lines, "$SUDO nft delete table inet "+etACLNftTable+" 2>/dev/null || true")
+		return strings.Join(lines, "\n") + "\n"
+	}
+	lines = append(lines,
+		"tmp=$(mktemp /tmp/np_acl.XXXX)",
+		"cat >\"$tmp\" <<'"+delimiter+"'",
+		strings.TrimRight(ruleset, "\n"),
+		delimiter,
+		"$SUDO nft -f \"$tmp\"",
+		"rm -f \"$tmp\"",
+	)
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// applyOverlayACL pushes the member's ruleset (or removes it when ACLs are disabled).
+func applyOverlayACL(x aclContext, rules []model.OverlayACLRule, nodeID int64) (bool, string) {
+	ruleset := ""
+	if aclEnabled() {
+		var err error
+		if ruleset, err = renderACLNft(x, rules, nodeID); err != nil {
+			return false, err.Error()
+		}
+	}
+	ok, msg := requestWithRetrySuccess(nodeID, "RunScript", map[string]any{
+		"requestId":  RandUUID(),
+		"content":    buildACLScript(ruleset),
+		"timeoutSec": 20,
+	}, 30*time.Second, 1)
+	jlog(map[string]any{"event": "overlay_acl_apply", "nodeId": nodeID, "ok": ok, "msg": msg})
+	return ok, msg
+}
+
+func applyOverlayACLAll() []map[string]any {
+	x := loadACLContext()
+	rules := loadACLRules(etNetworkName)
+	out := []map[string]any{}
+	for _, n := range loadEtNodes() {
+		ok, msg := applyOverlayACL(x, rules, n.NodeID)
+		out = append(out, map[string]any{"nodeId": n.NodeID, "success": ok, "message": msg})
+	}
+	return out
+}
+
+// reapplyOverlayACLFor restores a member's ruleset after the agent reconnects (nft state is not persistent).
+func reapplyOverlayACLFor(nodeID int64) {
+	if !aclEnabled() || !etNodeConfigured(nodeID) {
+		return
+	}
+	time.Sleep(3 * time.Second)
+	applyOverlayACL(loadACLContext(), loadACLRules(etNetworkName), nodeID)
+}
+
+// ---- Admin API ----
+
+// EasyTierACLList 组网 ACL 规则、分组与设置
+// POST /api/v1/easytier/acl/list
+func EasyTierACLList(c *gin.Context) {
+	var rules []model.OverlayACLRule
+	dbpkg.DB.Order("priority asc, id asc").Find(&rules)
+	var groups []model.OverlayACLGroup
+	dbpkg.DB.Order("id asc").Find(&groups)
+	c.JSON(http.StatusOK, response.Ok(map[string]any{
+		"network":       etNetworkName,
+		"enabled":       aclEnabled(),
+		"defaultPolicy": aclDefaultPolicy(),
+		"rules":         rules,
+		"groups":        groups,
+	}))
+}
+
+// EasyTierACLSettings 启用/停用 ACL 与默认策略
+// POST /api/v1/easytier/acl/settings {enabled, defaultPolicy}
+func EasyTierACLSettings(c *gin.Context) {
+	var p struct {
+		Enabled       *bool  `json:"enabled"`
+		DefaultPolicy string `json:"defaultPolicy"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	if p.DefaultPolicy != "" {
+		if p.DefaultPolicy != "allow" && p.DefaultPolicy != "deny" {
+			c.JSON(http.StatusOK, response.ErrMsg("默认策略必须为 allow 或 deny"))
+			return
+		}
+		setCfg(etACLDefaultKey, p.DefaultPolicy)
+	}
+	if p.Enabled != nil {
+		setCfg(etACLEnabledKey, ifThen(*p.Enabled, "1", "0"))
+	}
+	go applyOverlayACLAll()
+	c.JSON(http.StatusOK, response.OkMsg("已保存，正在下发"))
+}
+
+// aclRuleBody accepts a rule while telling an omitted "enabled" apart from false.
+type aclRuleBody struct {
+	model.OverlayACLRule
+	Enabled *bool `json:"enabled"`
+}
+
+// EasyTierACLCreate 新增 ACL 规则（enabled 缺省为 true）
+// POST /api/v1/easytier/acl/create
+func EasyTierACLCreate(c *gin.Context) {
+	var p aclRuleBody
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	r := p.OverlayACLRule
+	r.Enabled = p.Enabled == nil || *p.Enabled
+	if msg := normalizeACLRule(&r); msg != "" {
+		c.JSON(http.StatusOK, response.ErrMsg(msg))
+		return
+	}
+	now := time.Now().UnixMilli()
+	r.ID = 0
+	r.CreatedTime, r.UpdatedTime = now, now
+	if err := dbpkg.DB.Create(&r).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("保存失败"))
+		return
+	}
+	go applyOverlayACLAll()
+	c.JSON(http.StatusOK, response.Ok(r))
+}
+
+// EasyTierACLUpdate 修改 ACL 规则（enabled 缺省保持不变）
+// POST /api/v1/easytier/acl/update
+func EasyTierACLUpdate(c *gin.Context) {
+	var p aclRuleBody
+	if err := c.ShouldBindJSON(&p); err != nil || p.ID == 0 {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	r := p.OverlayACLRule
+	var old model.OverlayACLRule
+	if err := dbpkg.DB.First(&old, r.ID).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("不存在"))
+		return
+	}
+	r.Enabled = old.Enabled
+	if p.Enabled != nil {
+		r.Enabled = *p.Enabled
+	}
+	if msg := normalizeACLRule(&r); msg != "" {
+		c.JSON(http.StatusOK, response.ErrMsg(msg))
+		return
+	}
+	r.CreatedTime = old.CreatedTime
+	r.UpdatedTime = time.Now().UnixMilli()
+	if err := dbpkg.DB.Save(&r).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("保存失败"))
+		return
+	}
+	go applyOverlayACLAll()
+	c.JSON(http.StatusOK, response.Ok(r))
+}
+
+// EasyTierACLDelete 删除 ACL 规则
+// POST /api/v1/easytier/acl/delete {id}
+func EasyTierACLDelete(c *gin.Context) {
+	var p struct {
+		ID int64 `json:"id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	_ = dbpkg.DB.Delete(&model.OverlayACLRule{}, p.ID).Error
+	go applyOverlayACLAll()
+	c.JSON(http.StatusOK, response.OkNoData())
+}
+
+// EasyTierACLGroupSave 新增/修改成员分组
+// POST /api/v1/easytier/acl/group/save {id?, name, nodeIds}
+func EasyTierACLGroupSave(c *gin.Context) {
+	var p struct {
+		ID      int64   `json:"id"`
+		Name    string  `json:"name" binding:"required"`
+		NodeIDs []int64 `json:"nodeIds"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	sort.Slice(p.NodeIDs, func(i, j int) bool { return p.NodeIDs[i] < p.NodeIDs[j] })
+	b, _ := json.Marshal(p.NodeIDs)
+	now := time.Now().UnixMilli()
+	g := model.OverlayACLGroup{Name: p.Name, NodeIDs: string(b), CreatedTime: now, UpdatedTime: now}
+	if p.ID > 0 {
+		if err := dbpkg.DB.First(&g, p.ID).Error; err != nil {
+			c.JSON(http.StatusOK, response.ErrMsg("不存在"))
+			return
+		}
+		g.Name, g.NodeIDs, g.UpdatedTime = p.Name, string(b), now
+	}
+	if err := dbpkg.DB.Save(&g).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("保存失败"))
+		return
+	}
+	go applyOverlayACLAll()
+	c.JSON(http.StatusOK, response.Ok(g))
+}
+
+// EasyTierACLGroupDelete 删除成员分组（被规则引用时拒绝）
+// POST /api/v1/easytier/acl/group/delete {id}
+func EasyTierACLGroupDelete(c *gin.Context) {
+	var p struct {
+		ID int64 `json:"id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	gid := strconv.FormatInt(p.ID, 10)
+	var cnt int64
+	dbpkg.DB.Model(&model.OverlayACLRule{}).Where("(src_type = 'group' AND src_value = ?) OR (dst_type = 'group' AND dst_value = ?)", gid, gid).Count(&cnt)
+	if cnt > 0 {
+		c.JSON(http.StatusOK, response.ErrMsg("分组仍被规则引用"))
+		return
+	}
+	_ = dbpkg.DB.Delete(&model.OverlayACLGroup{}, p.ID).Error
+	c.JSON(http.StatusOK, response.OkNoData())
+}
+
+// EasyTierACLEvaluate 规则试算（不下发）
+// POST /api/v1/easytier/acl/evaluate {srcNodeId|srcIp, dstNodeId|dstIp, protocol, port}
+func EasyTierACLEvaluate(c *gin.Context) {
+	var p struct {
+		SrcNodeID int64  `json:"srcNodeId"`
+		SrcIP     string `json:"srcIp"`
+		DstNodeID int64  `json:"dstNodeId"`
+		DstIP     string `json:"dstIp"`
+		Protocol  string `json:"protocol"`
+		Port      int    `json:"port"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	x := loadACLContext()
+	if p.SrcNodeID > 0 {
+		p.SrcIP = x.overlay[p.SrcNodeID]
+	}
+	if p.DstNodeID > 0 {
+		p.DstIP = x.overlay[p.DstNodeID]
+	}
+	src, dst := net.ParseIP(strings.TrimSpace(p.SrcIP)), net.ParseIP(strings.TrimSpace(p.DstIP))
+	if src == nil || dst == nil {
+		c.JSON(http.StatusOK, response.ErrMsg("源或目标地址无效"))
+		return
+	}
+	proto := strings.ToLower(strings.TrimSpace(p.Protocol))
+	if proto == "" {
+		proto = "tcp"
+	}
+	action, rule, trace := evaluateACL(x, loadACLRules(etNetworkName), src, dst, proto, p.Port)
+	enforced := aclEnabled() && x.byIP[dst.String()] > 0
+	if !aclEnabled() {
+		action = "allow"
+	}
+	c.JSON(http.StatusOK, response.Ok(map[string]any{
+		"action":        action,
+		"rule":          rule,
+		"defaultPolicy": aclDefaultPolicy(),
+		"enabled":       aclEnabled(),
+		"enforced":      enforced,
+		"srcIp":         src.String(),
+		"dstIp":         dst.String(),
+		"srcNodeId":     x.byIP[src.String()],
+		"dstNodeId":     x.byIP[dst.String()],
+		"trace":         trace,
+	}))
+}
+
+// EasyTierACLPreview 预览某成员将下发的 nftables 规则
+// POST /api/v1/easytier/acl/preview {nodeId}
+func EasyTierACLPreview(c *gin.Context) {
+	var p struct {
+		NodeID int64 `json:"nodeId" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	ruleset, err := renderACLNft(loadACLContext(), loadACLRules(etNetworkName), p.NodeID)
+	if err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg(err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, response.Ok(map[string]any{"enabled": aclEnabled(), "ruleset": ruleset}))
+}
+
+// EasyTierACLApply 立即向成员下发 ACL
+// POST /api/v1/easytier/acl/apply {nodeIds?}
+func EasyTierACLApply(c *gin.Context) {
+	var p struct {
+		NodeIDs []int64 `json:"nodeIds"`
+	}
+	_ = c.ShouldBindJSON(&p)
+	if len(p.NodeIDs) == 0 {
+		c.JSON(http.StatusOK, response.Ok(applyOverlayACLAll()))
+		return
+	}
+	x := loadACLContext()
+	rules := loadACLRules(etNetworkName)
+	out := []map[string]any{}
+	for _, id := range p.NodeIDs {
+		ok, msg := applyOverlayACL(x, rules, id)
+		out = append(out, map[string]any{"nodeId": id, "success": ok, "message": msg})
+	}
+	c.JSON(http.StatusOK, response.Ok(out))
+}
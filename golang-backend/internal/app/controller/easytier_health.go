@@ -0,0 +1,416 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"network-panel/golang-backend/internal/app/model"
+	"network-panel/golang-backend/internal/app/response"
+	dbpkg "network-panel/golang-backend/internal/db"
+)
+
+// EasyTier peer health: agents report `easytier-cli peer`/`route` every minute.
+// The latest snapshot per node is kept in memory, rows are batch-written to
+// easytier_peer_stat, and an alert is raised when a member stops seeing another
+// online member for several consecutive reports.
+
+type etPeerSnapshot struct {
+	TimeMs int64                    `json:"timeMs"`
+	Rows   []model.EasyTierPeerStat `json:"rows"`
+}
+
+var (
+	etPeerMu     sync.Mutex
+	etPeerLatest = map[int64]etPeerSnapshot{}
+	// consecutive misses per node -> member; alerted marks an open "lost" alert
+	etPeerMisses  = map[int64]map[int64]int{}
+	etPeerAlerted = map[int64]map[int64]bool{}
+)
+
+// overlayNodeIndex maps overlay IPv4 -> node id.
+func overlayNodeIndex() map[string]int64 {
+	var rows []model.OverlayAddress
+	dbpkg.DB.Find(&rows)
+	out := make(map[string]int64, len(rows))
+	for _, r := range rows {
+		out[r.IP] = r.NodeID
+	}
+	return out
+}
+
+func isNodeConnected(nodeID int64) bool {
+	nodeConnMu.RLock()
+	defer nodeConnMu.RUnlock()
+	return len(nodeConns[nodeID]) > 0
+}
+
+// AgentReportEasyTierPeers 上报 EasyTier 对端/路由表
+// POST /api/v1/agent/report-easytier-peers {secret, timeMs, peers:[...], routes:[...]}
+func AgentReportEasyTierPeers(c *gin.Context) {
+	type peerItem struct {
+		IPv4        string  `json:"ipv4"`
+		Hostname    string  `json:"hostname"`
+		Cost        string  `json:"cost"`
+		LatMs       float64 `json:"latMs"`
+		LossPct     float64 `json:"lossPct"`
+		TunnelProto string  `json:"tunnelProto"`
+		NatType     string  `json:"natType"`
+	}
+	type routeItem struct {
+		IPv4         string  `json:"ipv4"`
+		Hostname     string  `json:"hostname"`
+		NextHopIPv4  string  `json:"nextHopIpv4"`
+		NextHopLatMs float64 `json:"nextHopLatMs"`
+		Cost         int     `json:"cost"`
+	}
+	var p struct {
+		Secret string      `json:"secret" binding:"required"`
+		TimeMs int64       `json:"timeMs"`
+		Peers  []peerItem  `json:"peers"`
+		Routes []routeItem `json:"routes"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	var node model.Node
+	if err := dbpkg.DB.Where("secret = ?", p.Secret).First(&node).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("节点不存在"))
+		return
+	}
+	now := time.Now().UnixMilli()
+	if p.TimeMs <= 0 || p.TimeMs > now {
+		p.TimeMs = now
+	}
+	idx := overlayNodeIndex()
+	byIP := map[string]*model.EasyTierPeerStat{}
+	rows := make([]model.EasyTierPeerStat, 0, len(p.Peers)+len(p.Routes))
+	order := []string{}
+	for _, r := range p.Routes {
+		if r.IPv4 == "" {
+			continue
+		}
+		row := &model.EasyTierPeerStat{NodeID: node.ID, PeerNodeID: idx[r.IPv4], PeerIP: r.IPv4, Hostname: r.Hostname, RouteCost: r.Cost, NextHopIP: r.NextHopIPv4, LatencyMs: r.NextHopLatMs, TimeMs: p.TimeMs}
+		byIP[r.IPv4] = row
+		order = append(order, r.IPv4)
+	}
+	for _, x := range p.Peers {
+		if x.IPv4 == "" {
+			continue
+		}
+		row, ok := byIP[x.IPv4]
+		if !ok {
+			row = &model.EasyTierPeerStat{NodeID: node.ID, PeerNodeID: idx[x.IPv4], PeerIP: x.IPv4, Hostname: x.Hostname, RouteCost: 1, TimeMs: p.TimeMs}
+			byIP[x.IPv4] = row
+			order = append(order, x.IPv4)
+		}
+		row.Direct = true
+		row.Cost = x.Cost
+		row.LatencyMs = x.LatMs
+		row.LossPct = x.LossPct
+		row.TunnelProto = x.TunnelProto
+		row.NatType = x.NatType
+	}
+	for _, ip := range order {
+		rows = append(rows, *byIP[ip])
+	}
+	enqueueEtPeers(rows)
+	etPeerMu.Lock()
+	etPeerLatest[node.ID] = etPeerSnapshot{TimeMs: p.TimeMs, Rows: rows}
+	etPeerMu.Unlock()
+	evaluateEasyTierPeerHealth(node, rows)
+	c.JSON(http.StatusOK, response.OkNoData())
+}
+
+// evaluateEasyTierPeerHealth raises/resolves peer-lost alerts for one reporting member.
+func evaluateEasyTierPeerHealth(node model.Node, rows []model.EasyTierPeerStat) {
+	members := map[int64]bool{}
+	for _, n := range loadEtNodes() {
+		if n.NodeID != node.ID {
+			members[n.NodeID] = true
+		}
+	}
+	if len(members) == 0 {
+		return
+	}
+	seen := map[int64]bool{}
+	for _, r := range rows {
+		if r.PeerNodeID > 0 {
+			seen[r.PeerNodeID] = true
+		}
+	}
+	threshold := getCfgInt("easytier_peer_alert_misses", 3)
+	var lost, recovered []int64
+	etPeerMu.Lock()
+	misses := etPeerMisses[node.ID]
+	if misses == nil {
+		misses = map[int64]int{}
+		etPeerMisses[node.ID] = misses
+	}
+	alerted := etPeerAlerted[node.ID]
+	if alerted == nil {
+		alerted = map[int64]bool{}
+		etPeerAlerted[node.ID] = alerted
+	}
+	for id := range members {
+		if seen[id] {
+			delete(misses, id)
+			if alerted[id] {
+				delete(alerted, id)
+				recovered = append(recovered, id)
+			}
+			continue
+		}
+		// a member that is itself offline is covered by the offline alert
+		if !isNodeConnected(id) {
+			delete(misses, id)
+			continue
+		}
+		misses[id]++
+		if misses[id] >= threshold && !alerted[id] {
+			alerted[id] = true
+			lost = append(lost, id)
+		}
+	}
+	for id := range alerted {
+		if !members[id] {
+			delete(alerted, id)
+		}
+	}
+	etPeerMu.Unlock()
+	if len(lost) == 0 && len(recovered) == 0 {
+		return
+	}
+	names := nodeNames(append(append([]int64{}, lost...), recovered...))
+	now := time.Now().UnixMilli()
+	name := node.Name
+	nid := node.ID
+	if len(lost) > 0 {
+		msg := "组网对端丢失: " + joinNodeNames(lost, names)
+		if len(seen) == 0 {
+			msg = "组网节点已孤立，丢失全部对端: " + joinNodeNames(lost, names)
+		}
+		enqueueAlert(model.Alert{TimeMs: now, Type: "easytier_peer_lost", NodeID: &nid, NodeName: &name, Message: msg})
+		go notifyCallback("easytier_peer_lost", node, map[string]any{"lostNodeIds": lost})
+		jlog(map[string]any{"event": "easytier_peer_lost", "nodeId": node.ID, "lost": lost})
+	}
+	if len(recovered) > 0 {
+		enqueueAlert(model.Alert{TimeMs: now, Type: "easytier_peer_recovered", NodeID: &nid, NodeName: &name, Message: "组网对端恢复: " + joinNodeNames(recovered, names)})
+		go notifyCallback("easytier_peer_recovered", node, map[string]any{"recoveredNodeIds": recovered})
+	}
+}
+
+func loadEtNodes() []etNode {
+	var nodes []etNode
+	if v := getCfg(etNodesKey); v != "" {
+		_ = json.Unmarshal([]byte(v), &nodes)
+	}
+	return nodes
+}
+
+func nodeNames(ids []int64) map[int64]string {
+	out := map[int64]string{}
+	if len(ids) == 0 {
+		return out
+	}
+	var list []model.Node
+	dbpkg.DB.Select("id, name").Where("id IN ?", ids).Find(&list)
+	for _, n := range list {
+		out[n.ID] = n.Name
+	}
+	return out
+}
+
+func joinNodeNames(ids []int64, names map[int64]string) string {
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	parts := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if n := names[id]; n != "" {
+			parts = append(parts, n)
+		} else {
+			parts = append(parts, fmt.Sprintf("#%d", id))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// latestEasyTierPeers returns the latest snapshot from memory, falling back to the database.
+func latestEasyTierPeers(nodeID int64) (etPeerSnapshot, bool) {
+	etPeerMu.Lock()
+	s, ok := etPeerLatest[nodeID]
+	etPeerMu.Unlock()
+	if ok {
+		return s, true
+	}
+	var last model.EasyTierPeerStat
+	if err := dbpkg.DB.Where("node_id = ?", nodeID).Order("time_ms desc").First(&last).Error; err != nil {
+		return etPeerSnapshot{}, false
+	}
+	var rows []model.EasyTierPeerStat
+	dbpkg.DB.Where("node_id = ? AND time_ms = ?", nodeID, last.TimeMs).Find(&rows)
+	return etPeerSnapshot{TimeMs: last.TimeMs, Rows: rows}, true
+}
+
+// EasyTierPeers 节点当前组网对端/路由
+// @Summary 组网对端状态
+// @Tags easytier
+// @Accept json
+// @Produce json
+// @Success 200 {object} SwaggerResp
+// @Router /api/v1/easytier/peers [post]
+func EasyTierPeers(c *gin.Context) {
+	var p struct {
+		NodeID int64 `json:"nodeId" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	s, ok := latestEasyTierPeers(p.NodeID)
+	if !ok {
+		c.JSON(http.StatusOK, response.Ok(map[string]any{"timeMs": 0, "rows": []any{}}))
+		return
+	}
+	c.JSON(http.StatusOK, response.Ok(s))
+}
+
+// EasyTierPeerHistory 组网对端延迟/丢包历史
+// @Summary 组网对端历史
+// @Tags easytier
+// @Accept json
+// @Produce json
+// @Success 200 {object} SwaggerResp
+// @Router /api/v1/easytier/peers/history [post]
+func EasyTierPeerHistory(c *gin.Context) {
+	var p struct {
+		NodeID     int64  `json:"nodeId" binding:"required"`
+		PeerNodeID int64  `json:"peerNodeId"`
+		PeerIP     string `json:"peerIp"`
+		Range      string `json:"range"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	from := time.Now().UnixMilli() - rangeWindowMs(p.Range)
+	var rows []model.EasyTierPeerStat
+	// older parts of long ranges come from the rollups (member peers only), one row per bucket
+	if res, wm, ok := rollupHistorySplit(from); ok && (p.PeerNodeID > 0 || p.PeerIP == "") {
+		for _, g := range loadGaugeRollups(res, from, wm, p.NodeID, p.PeerNodeID, []string{"etpeer_rtt", "etpeer_loss"}) {
+			n := len(rows)
+			if n == 0 || rows[n-1].TimeMs != g.BucketMs || rows[n-1].PeerNodeID != g.RefID {
+				rows = append(rows, model.EasyTierPeerStat{NodeID: g.NodeID, PeerNodeID: g.RefID, TimeMs: g.BucketMs})
+				n++
+			}
+			if g.Series == "etpeer_rtt" {
+				rows[n-1].LatencyMs = g.Avg
+			} else {
+				rows[n-1].LossPct = g.Avg
+			}
+		}
+		from = wm
+	}
+	q := dbpkg.DB.Where("node_id = ? AND time_ms >= ?", p.NodeID, from)
+	if p.PeerNodeID > 0 {
+		q = q.Where("peer_node_id = ?", p.PeerNodeID)
+	} else if p.PeerIP != "" {
+		q = q.Where("peer_ip = ?", p.PeerIP)
+	}
+	var raw []model.EasyTierPeerStat
+	q.Order("time_ms asc").Find(&raw)
+	rows = append(rows, raw...)
+	bufEtPeerMu.Lock()
+	for _, r := range bufEtPeer {
+		if r.NodeID != p.NodeID || r.TimeMs < from {
+			continue
+		}
+		if (p.PeerNodeID > 0 && r.PeerNodeID != p.PeerNodeID) || (p.PeerNodeID == 0 && p.PeerIP != "" && r.PeerIP != p.PeerIP) {
+			continue
+		}
+		rows = append(rows, r)
+	}
+	bufEtPeerMu.Unlock()
+	c.JSON(http.StatusOK, response.Ok(rows))
+}
+
+// EasyTierHealth 组网健康概览
+// @Summary 组网健康概览
+// @Tags easytier
+// @Produce json
+// @Success 200 {object} SwaggerResp
+// @Router /api/v1/easytier/health [post]
+func EasyTierHealth(c *gin.Context) {
+	members := loadEtNodes()
+	ids := make([]int64, 0, len(members))
+	for _, m := range members {
+		ids = append(ids, m.NodeID)
+	}
+	names := nodeNames(ids)
+	out := make([]map[string]any, 0, len(members))
+	for _, m := range members {
+		it := map[string]any{"nodeId": m.NodeID, "name": names[m.NodeID], "online": isNodeConnected(m.NodeID)}
+		s, ok := latestEasyTierPeers(m.NodeID)
+		direct, reachable := 0, map[int64]bool{}
+		var latSum, lossSum float64
+		for _, r := range s.Rows {
+			if r.Direct {
+				direct++
+				latSum += r.LatencyMs
+				lossSum += r.LossPct
+			}
+			if r.PeerNodeID > 0 {
+				reachable[r.PeerNodeID] = true
+			}
+		}
+		missing := []int64{}
+		for _, o := range members {
+			if o.NodeID != m.NodeID && !reachable[o.NodeID] {
+				missing = append(missing, o.NodeID)
+			}
+		}
+		it["reported"] = ok
+		it["updatedAt"] = s.TimeMs
+		it["directPeers"] = direct
+		it["reachableMembers"] = len(reachable)
+		it["missing"] = missing
+		if direct > 0 {
+			it["avgLatencyMs"] = latSum / float64(direct)
+			it["avgLossPct"] = lossSum / float64(direct)
+		}
+		etPeerMu.Lock()
+		alerted := make([]int64, 0)
+		for id := range etPeerAlerted[m.NodeID] {
+			alerted = append(alerted, id)
+		}
+		etPeerMu.Unlock()
+		it["lost"] = alerted
+		out = append(out, it)
+	}
+	c.JSON(http.StatusOK, response.Ok(out))
+}
+
+// rangeWindowMs converts a range keyword (1h/12h/1d/7d/30d/90d/365d) into milliseconds.
+func rangeWindowMs(r string) int64 {
+	switch r {
+	case "12h":
+		return 12 * 3600 * 1000
+	case "1d":
+		return 24 * 3600 * 1000
+	case "7d":
+		return 7 * 24 * 3600 * 1000
+	case "30d":
+		return 30 * 24 * 3600 * 1000
+	case "90d":
+		return 90 * 24 * 3600 * 1000
+	case "365d":
+		return 365 * 24 * 3600 * 1000
+	default:
+		return 3600 * 1000
+	}
+}
@@ -0,0 +1,626 @@
+package controller
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"network-panel/golang-backend/internal/app/model"
+	"network-panel/golang-backend/internal/app/response"
+	dbpkg "network-panel/golang-backend/internal/db"
+)
+
+// Overlay IPAM: every node that joins EasyTier gets a stable overlay IPv4 from
+// the configured CIDR. Allocations are persisted in overlay_address so that
+// re-joining, re-ordering or re-rendering configs never moves an address.
+
+const (
+	etCIDRKey     = "easytier_cidr"
+	etDefaultCIDR = "10.126.126.0/24"
+)
+
+var (
+	ipamMu sync.Mutex
+
+	overlayNetMu     sync.Mutex
+	overlayNetCache  *net.IPNet
+	overlayNetLoaded time.Time
+)
+
+// overlayCIDR returns the configured overlay network (cached for a short time).
+func overlayCIDR() *net.IPNet {
+	overlayNetMu.Lock()
+	defer overlayNetMu.Unlock()
+	if overlayNetCache != nil && time.Since(overlayNetLoaded) < 30*time.Second {
+		return overlayNetCache
+	}
+	n, err := parseOverlayCIDR(getCfg(etCIDRKey))
+	if err != nil {
+		n, _ = parseOverlayCIDR(etDefaultCIDR)
+	}
+	overlayNetCache = n
+	overlayNetLoaded = time.Now()
+	return n
+}
+
+func resetOverlayCIDRCache() {
+	overlayNetMu.Lock()
+	overlayNetCache = nil
+	overlayNetMu.Unlock()
+}
+
+func parseOverlayCIDR(v string) (*net.IPNet, error) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		v = etDefaultCIDR
+	}
+	_, n, err := net.ParseCIDR(v)
+	if err != nil {
+		return nil, err
+	}
+	if n.IP.To4() == nil {
+		return nil, errors.New("仅支持 IPv4 网段")
+	}
+	ones, bits := n.Mask.Size()
+	if bits != 32 || ones < 8 || ones > 30 {
+		return nil, errors.New("网段前缀需在 /8 到 /30 之间")
+	}
+	return n, nil
+}
+
+// isOverlayIP reports whether ip belongs to the EasyTier overlay network.
+func isOverlayIP(ip string) bool {
+	p := net.ParseIP(strings.TrimSpace(ip))
+	if p == nil || p.To4() == nil {
+		return false
+	}
+	return overlayCIDR().Contains(p)
+}
+
+func ip4ToU32(ip net.IP) uint32 { return binary.BigEndian.Uint32(ip.To4()) }
+
+func u32ToIP4(v uint32) net.IP {
+	b := make(net.IP, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
+
+// overlayHostRange returns first/last usable host addresses of n.
+func overlayHostRange(n *net.IPNet) (uint32, uint32) {
+	base := ip4ToU32(n.IP)
+	ones, _ := n.Mask.Size()
+	size := uint32(1) << uint(32-ones)
+	return base + 1, base + size - 2
+}
+
+// validOverlayHost checks ip is a usable host address inside n.
+func validOverlayHost(n *net.IPNet, ip string) bool {
+	p := net.ParseIP(strings.TrimSpace(ip))
+	if p == nil || p.To4() == nil || !n.Contains(p) {
+		return false
+	}
+	first, last := overlayHostRange(n)
+	v := ip4ToU32(p)
+	return v >= first && v <= last
+}
+
+// overlayPrefixLen returns the prefix length of the overlay network.
+func overlayPrefixLen() int {
+	ones, _ := overlayCIDR().Mask.Size()
+	return ones
+}
+
+// overlayConfAddr formats an allocated address for the EasyTier ipv4 option.
+// The default /24 keeps the plain form understood by every EasyTier version.
+func overlayConfAddr(ip string) string {
+	if p := overlayPrefixLen(); p != 24 {
+		return fmt.Sprintf("%s/%d", ip, p)
+	}
+	return ip
+}
+
+// overlayIPFor returns the node's overlay address, allocating one when missing.
+func overlayIPFor(nodeID int64) string {
+	rec, err := allocOverlayIP(nodeID)
+	if err != nil {
+		return ""
+	}
+	return rec.IP
+}
+
+// lookupOverlayIP returns the persisted allocation without allocating.
+func lookupOverlayIP(nodeID int64) string {
+	var rec model.OverlayAddress
+	if err := dbpkg.DB.Where("node_id = ?", nodeID).First(&rec).Error; err != nil {
+		return ""
+	}
+	return rec.IP
+}
+
+// allocOverlayIP returns the stable allocation for nodeID, creating it on first use.
+// Candidate order keeps already deployed addresses: an overlay IP the node
+// currently reports, then the legacy "<prefix>.<nodeId>" layout, then the
+// lowest free host in the pool.
+func allocOverlayIP(nodeID int64) (model.OverlayAddress, error) {
+	if nodeID <= 0 {
+		return model.OverlayAddress{}, errors.New("节点无效")
+	}
+	ipamMu.Lock()
+	defer ipamMu.Unlock()
+	cidr := overlayCIDR()
+	var rec model.OverlayAddress
+	if err := dbpkg.DB.Where("node_id = ?", nodeID).First(&rec).Error; err == nil && rec.ID > 0 {
+		if validOverlayHost(cidr, rec.IP) {
+			return rec, nil
+		}
+	}
+	used := overlayUsedSet(nodeID)
+	ip := ""
+	for _, cand := range overlayCandidates(nodeID, cidr) {
+		if validOverlayHost(cidr, cand) && !used[cand] {
+			ip = cand
+			break
+		}
+	}
+	if ip == "" {
+		first, last := overlayHostRange(cidr)
+		for v := first; v <= last; v++ {
+			s := u32ToIP4(v).String()
+			if !used[s] {
+				ip = s
+				break
+			}
+		}
+	}
+	if ip == "" {
+		return model.OverlayAddress{}, errors.New("组网地址池已耗尽")
+	}
+	now := time.Now().UnixMilli()
+	if rec.ID > 0 {
+		rec.IP = ip
+		rec.Reserved = false
+		rec.UpdatedTime = now
+		if err := dbpkg.DB.Save(&rec).Error; err != nil {
+			return model.OverlayAddress{}, err
+		}
+		return rec, nil
+	}
+	rec = model.OverlayAddress{NodeID: nodeID, IP: ip, CreatedTime: now, UpdatedTime: now}
+	if err := dbpkg.DB.Create(&rec).Error; err != nil {
+		return model.OverlayAddress{}, err
+	}
+	return rec, nil
+}
+
+func overlayUsedSet(exceptNodeID int64) map[string]bool {
+	var rows []model.OverlayAddress
+	dbpkg.DB.Find(&rows)
+	used := map[string]bool{}
+	for _, r := range rows {
+		if r.NodeID != exceptNodeID {
+			used[r.IP] = true
+		}
+	}
+	return used
+}
+
+func overlayCandidates(nodeID int64, cidr *net.IPNet) []string {
+	out := make([]string, 0, 2)
+	if rt, ok := getRuntimeCached(nodeID); ok {
+		for _, ip := range parseInterfaceList(rt.Interfaces) {
+			if validOverlayHost(cidr, ip) {
+				out = append(out, ip)
+			}
+		}
+	}
+	first, last := overlayHostRange(cidr)
+	if v := first - 1 + uint32(nodeID); nodeID > 0 && v >= first && v <= last {
+		out = append(out, u32ToIP4(v).String())
+	}
+	return out
+}
+
+// setOverlayIP pins nodeID to ip (manual reservation) and returns the previous address.
+func setOverlayIP(nodeID int64, ip string, reserved bool, note string) (string, error) {
+	ip = strings.TrimSpace(ip)
+	ipamMu.Lock()
+	defer ipamMu.Unlock()
+	cidr := overlayCIDR()
+	if !validOverlayHost(cidr, ip) {
+		return "", fmt.Errorf("地址 %s 不在组网网段 %s 的可用范围内", ip, cidr.String())
+	}
+	var other model.OverlayAddress
+	if err := dbpkg.DB.Where("ip = ? AND node_id <> ?", ip, nodeID).First(&other).Error; err == nil && other.ID > 0 {
+		return "", fmt.Errorf("地址 %s 已分配给节点 %d", ip, other.NodeID)
+	}
+	now := time.Now().UnixMilli()
+	var rec model.OverlayAddress
+	if err := dbpkg.DB.Where("node_id = ?", nodeID).First(&rec).Error; err == nil && rec.ID > 0 {
+		old := rec.IP
+		rec.IP = ip
+		rec.Reserved = reserved
+		rec.Note = note
+		rec.UpdatedTime = now
+		return old, dbpkg.DB.Save(&rec).Error
+	}
+	rec = model.OverlayAddress{NodeID: nodeID, IP: ip, Reserved: reserved, Note: note, CreatedTime: now, UpdatedTime: now}
+	return "", dbpkg.DB.Create(&rec).Error
+}
+
+func releaseOverlayIP(nodeID int64) {
+	ipamMu.Lock()
+	_ = dbpkg.DB.Where("node_id = ?", nodeID).Delete(&model.OverlayAddress{}).Error
+	ipamMu.Unlock()
+}
+
+// overlayConflict describes a mismatch between allocations and what nodes report.
+type overlayConflict struct {
+	NodeID      int64  `json:"nodeId"`
+	IP          string `json:"ip"`
+	Kind        string `json:"kind"` // out_of_range | foreign | stale
+	OtherNodeID int64  `json:"otherNodeId,omitempty"`
+	Expected    string `json:"expected,omitempty"`
+}
+
+// detectOverlayConflicts compares persisted allocations with overlay IPs seen on node interfaces.
+func detectOverlayConflicts() []overlayConflict {
+	cidr := overlayCIDR()
+	var rows []model.OverlayAddress
+	dbpkg.DB.Order("node_id asc").Find(&rows)
+	owner := map[string]int64{}
+	byNode := map[int64]string{}
+	out := make([]overlayConflict, 0)
+	for _, r := range rows {
+		owner[r.IP] = r.NodeID
+		byNode[r.NodeID] = r.IP
+		if !validOverlayHost(cidr, r.IP) {
+			out = append(out, overlayConflict{NodeID: r.NodeID, IP: r.IP, Kind: "out_of_range"})
+		}
+	}
+	var runs []model.NodeRuntime
+	dbpkg.DB.Find(&runs)
+	for _, rt := range runs {
+		if cached, ok := getRuntimeCached(rt.NodeID); ok {
+			rt = cached
+		}
+		for _, ip := range parseInterfaceList(rt.Interfaces) {
+			if !isOverlayIP(ip) {
+				continue
+			}
+			if o, ok := owner[ip]; ok && o != rt.NodeID {
+				out = append(out, overlayConflict{NodeID: rt.NodeID, IP: ip, Kind: "foreign", OtherNodeID: o, Expected: byNode[rt.NodeID]})
+				continue
+			}
+			if exp, ok := byNode[rt.NodeID]; ok && exp != ip {
+				out = append(out, overlayConflict{NodeID: rt.NodeID, IP: ip, Kind: "stale", Expected: exp})
+			}
+		}
+	}
+	return out
+}
+
+// overlayChangeResult summarises dependents touched by an address change.
+type overlayChangeResult struct {
+	NodeID   int64   `json:"nodeId"`
+	OldIP    string  `json:"oldIp"`
+	NewIP    string  `json:"newIp"`
+	Tunnels  []int64 `json:"tunnels"`
+	Forwards []int64 `json:"forwards"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// propagateOverlayChange rewrites tunnel bind/iface maps and forward targets that
+// referenced oldIP, then re-pushes EasyTier config and affected forwards in background.
+func propagateOverlayChange(nodeID int64, oldIP, newIP string) overlayChangeResult {
+	res := overlayChangeResult{NodeID: nodeID, OldIP: oldIP, NewIP: newIP, Tunnels: []int64{}, Forwards: []int64{}}
+	if oldIP == "" || oldIP == newIP {
+		return res
+	}
+	tunnelSet := map[int64]bool{}
+	var cfgs []model.ViteConfig
+	dbpkg.DB.Where("name LIKE ? OR name LIKE ?", "tunnel_iface_%", "tunnel_bindip_%").Find(&cfgs)
+	now := time.Now().UnixMilli()
+	for _, cfg := range cfgs {
+		m := map[int64]string{}
+		if json.Unmarshal([]byte(cfg.Value), &m) != nil {
+			continue
+		}
+		changed := false
+		for nid, ip := range m {
+			if ip == oldIP {
+				m[nid] = newIP
+				changed = true
+			}
+		}
+		if !changed {
+			continue
+		}
+		b, _ := json.Marshal(m)
+		cfg.Value = string(b)
+		cfg.Time = now
+		_ = dbpkg.DB.Save(&cfg).Error
+		idStr := strings.TrimPrefix(strings.TrimPrefix(cfg.Name, "tunnel_iface_"), "tunnel_bindip_")
+		if tid, err := strconv.ParseInt(idStr, 10, 64); err == nil {
+			tunnelSet[tid] = true
+		}
+	}
+	var tunnels []model.Tunnel
+	dbpkg.DB.Where("in_ip = ? OR out_ip = ?", oldIP, oldIP).Find(&tunnels)
+	for _, t := range tunnels {
+		updates := map[string]any{"updated_time": now}
+		if t.InIP == oldIP {
+			updates["in_ip"] = newIP
+		}
+		if t.OutIP != nil && *t.OutIP == oldIP {
+			updates["out_ip"] = newIP
+		}
+		_ = dbpkg.DB.Model(&model.Tunnel{}).Where("id = ?", t.ID).Updates(updates).Error
+		tunnelSet[t.ID] = true
+	}
+	for tid := range tunnelSet {
+		res.Tunnels = append(res.Tunnels, tid)
+	}
+	sort.Slice(res.Tunnels, func(i, j int) bool { return res.Tunnels[i] < res.Tunnels[j] })
+
+	fwdSet := map[int64]model.Forward{}
+	if len(res.Tunnels) > 0 {
+		var list []model.Forward
+		dbpkg.DB.Where("tunnel_id IN ?", res.Tunnels).Find(&list)
+		for _, f := range list {
+			fwdSet[f.ID] = f
+		}
+	}
+	var targeted []model.Forward
+	dbpkg.DB.Where("remote_addr LIKE ?", "%"+oldIP+"%").Find(&targeted)
+	for _, f := range targeted {
+		addrs := parseRemoteAddrs(f.RemoteAddr)
+		changed := false
+		for i, a := range addrs {
+			host, port := splitHostPortSafe(a)
+			if host == oldIP && port > 0 {
+				addrs[i] = net.JoinHostPort(newIP, strconv.Itoa(port))
+				changed = true
+			}
+		}
+		if !changed {
+			continue
+		}
+		f.RemoteAddr = strings.Join(addrs, ",")
+		_ = dbpkg.DB.Model(&model.Forward{}).Where("id = ?", f.ID).Updates(map[string]any{"remote_addr": f.RemoteAddr, "updated_time": now}).Error
+		fwdSet[f.ID] = f
+	}
+	fwds := make([]model.Forward, 0, len(fwdSet))
+	for id, f := range fwdSet {
+		res.Forwards = append(res.Forwards, id)
+		fwds = append(fwds, f)
+	}
+	sort.Slice(res.Forwards, func(i, j int) bool { return res.Forwards[i] < res.Forwards[j] })
+
+	go func() {
+		if etNodeConfigured(nodeID) {
+			applyEasyTierConfig(nodeID, "")
+		}
+		for _, f := range fwds {
+			if f.Status != nil && *f.Status == 0 {
+				continue
+			}
+			if err := reapplyForward(f); err != nil {
+				jlog(map[string]any{"event": "ipam_forward_reapply_failed", "forwardId": f.ID, "msg": err.Error()})
+			}
+		}
+	}()
+	return res
+}
+
+func etNodeConfigured(nodeID int64) bool {
+	var nodes []etNode
+	if v := getCfg(etNodesKey); v != "" {
+		_ = json.Unmarshal([]byte(v), &nodes)
+	}
+	for _, n := range nodes {
+		if n.NodeID == nodeID {
+			return true
+		}
+	}
+	return false
+}
+
+// EasyTierIPAMList 组网地址分配列表
+// @Summary 组网地址分配列表
+// @Tags easytier
+// @Accept json
+// @Produce json
+// @Success 200 {object} SwaggerResp
+// @Router /api/v1/easytier/ipam/list [post]
+func EasyTierIPAMList(c *gin.Context) {
+	cidr := overlayCIDR()
+	var rows []model.OverlayAddress
+	dbpkg.DB.Order("node_id asc").Find(&rows)
+	var nodes []model.Node
+	dbpkg.DB.Find(&nodes)
+	names := map[int64]string{}
+	for _, n := range nodes {
+		names[n.ID] = n.Name
+	}
+	list := make([]map[string]any, 0, len(rows))
+	for _, r := range rows {
+		list = append(list, map[string]any{
+			"nodeId":      r.NodeID,
+			"nodeName":    names[r.NodeID],
+			"ip":          r.IP,
+			"reserved":    r.Reserved,
+			"note":        r.Note,
+			"updatedTime": r.UpdatedTime,
+		})
+	}
+	first, last := overlayHostRange(cidr)
+	c.JSON(http.StatusOK, response.Ok(map[string]any{
+		"cidr":        cidr.String(),
+		"capacity":    int64(last) - int64(first) + 1,
+		"allocations": list,
+		"conflicts":   detectOverlayConflicts(),
+	}))
+}
+
+// EasyTierIPAMSetCIDR 修改组网网段
+// @Summary 修改组网网段（超出新网段的地址将重新分配并更新依赖，所有成员重新下发配置）
+// @Tags easytier
+// @Accept json
+// @Produce json
+// @Success 200 {object} SwaggerResp
+// @Router /api/v1/easytier/ipam/cidr [post]
+func EasyTierIPAMSetCIDR(c *gin.Context) {
+	var p struct {
+		CIDR string `json:"cidr" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	n, err := parseOverlayCIDR(p.CIDR)
+	if err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("网段无效: "+err.Error()))
+		return
+	}
+	first, last := overlayHostRange(n)
+	var count int64
+	dbpkg.DB.Model(&model.OverlayAddress{}).Count(&count)
+	if int64(last)-int64(first)+1 < count {
+		c.JSON(http.StatusOK, response.ErrMsg("新网段容量不足以容纳已分配节点"))
+		return
+	}
+	old := map[int64]string{}
+	var rows []model.OverlayAddress
+	dbpkg.DB.Find(&rows)
+	for _, r := range rows {
+		old[r.NodeID] = r.IP
+	}
+	setCfg(etCIDRKey, n.String())
+	resetOverlayCIDRCache()
+	changes := make([]overlayChangeResult, 0)
+	// members keeping their address still carry the old prefix length in their config
+	kept := make([]int64, 0, len(rows))
+	for _, r := range rows {
+		if validOverlayHost(n, r.IP) {
+			kept = append(kept, r.NodeID)
+			continue
+		}
+		rec, err := allocOverlayIP(r.NodeID)
+		if err != nil {
+			changes = append(changes, overlayChangeResult{NodeID: r.NodeID, OldIP: r.IP, Tunnels: []int64{}, Forwards: []int64{}, Error: err.Error()})
+			continue
+		}
+		changes = append(changes, propagateOverlayChange(r.NodeID, old[r.NodeID], rec.IP))
+	}
+	go func() {
+		for _, nid := range kept {
+			if !etNodeConfigured(nid) {
+				continue
+			}
+			if ok, msg := applyEasyTierConfig(nid, ""); !ok {
+				jlog(map[string]any{"event": "ipam_config_push_failed", "nodeId": nid, "msg": msg})
+			}
+		}
+	}()
+	c.JSON(http.StatusOK, response.Ok(map[string]any{"cidr": n.String(), "changes": changes}))
+}
+
+// EasyTierIPAMReserve 手动指定节点组网地址
+// @Summary 手动保留/修改节点组网地址，并同步更新依赖的隧道与转发
+// @Tags easytier
+// @Accept json
+// @Produce json
+// @Success 200 {object} SwaggerResp
+// @Router /api/v1/easytier/ipam/reserve [post]
+func EasyTierIPAMReserve(c *gin.Context) {
+	var p struct {
+		NodeID int64  `json:"nodeId" binding:"required"`
+		IP     string `json:"ip" binding:"required"`
+		Note   string `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	var n model.Node
+	if err := dbpkg.DB.First(&n, p.NodeID).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("节点不存在"))
+		return
+	}
+	old, err := setOverlayIP(p.NodeID, p.IP, true, strings.TrimSpace(p.Note))
+	if err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg(err.Error()))
+		return
+	}
+	res := propagateOverlayChange(p.NodeID, old, strings.TrimSpace(p.IP))
+	if old == "" && etNodeConfigured(p.NodeID) {
+		go applyEasyTierConfig(p.NodeID, "")
+	}
+	c.JSON(http.StatusOK, response.Ok(res))
+}
+
+// EasyTierIPAMUnreserve 取消手动保留（地址保持不变，仅取消固定标记）
+// @Summary 取消节点组网地址保留
+// @Tags easytier
+// @Accept json
+// @Produce json
+// @Success 200 {object} SwaggerResp
+// @Router /api/v1/easytier/ipam/unreserve [post]
+func EasyTierIPAMUnreserve(c *gin.Context) {
+	var p struct {
+		NodeID int64 `json:"nodeId" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	_ = dbpkg.DB.Model(&model.OverlayAddress{}).Where("node_id = ?", p.NodeID).Updates(map[string]any{"reserved": false, "updated_time": time.Now().UnixMilli()}).Error
+	c.JSON(http.StatusOK, response.OkMsg("已取消保留"))
+}
+
+// EasyTierIPAMConflicts 检测组网地址冲突
+// @Summary 检测组网地址冲突；fix=true 时对使用了错误地址的节点重新下发配置
+// @Tags easytier
+// @Accept json
+// @Produce json
+// @Success 200 {object} SwaggerResp
+// @Router /api/v1/easytier/ipam/conflicts [post]
+func EasyTierIPAMConflicts(c *gin.Context) {
+	var p struct {
+		Fix bool `json:"fix"`
+	}
+	_ = c.ShouldBindJSON(&p)
+	list := detectOverlayConflicts()
+	fixed := make([]int64, 0)
+	if p.Fix {
+		seen := map[int64]bool{}
+		for _, cf := range list {
+			if seen[cf.NodeID] {
+				continue
+			}
+			seen[cf.NodeID] = true
+			if cf.Kind == "out_of_range" {
+				old := cf.IP
+				if rec, err := allocOverlayIP(cf.NodeID); err == nil {
+					propagateOverlayChange(cf.NodeID, old, rec.IP)
+					fixed = append(fixed, cf.NodeID)
+				}
+				continue
+			}
+			if etNodeConfigured(cf.NodeID) {
+				go applyEasyTierConfig(cf.NodeID, "")
+				fixed = append(fixed, cf.NodeID)
+			}
+		}
+	}
+	c.JSON(http.StatusOK, response.Ok(map[string]any{"conflicts": list, "fixed": fixed}))
+}
@@ -0,0 +1,387 @@
+package controller
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"network-panel/golang-backend/internal/app/model"
+	"network-panel/golang-backend/internal/app/response"
+	dbpkg "network-panel/golang-backend/internal/db"
+)
+
+// latency-optimized EasyTier topology planner
+//
+// Every configured member pings every other member's public address; the
+// resulting RTT/loss matrix is turned into a cost graph. The master is the
+// node with the lowest total cost to everyone else, the tree is a minimum
+// spanning tree rooted at the master (each node dials its parent) and with
+// degree k each node additionally dials its k-1 cheapest non-adjacent peers.
+
+const (
+	etLatencyMatrixKey = "easytier_latency_matrix"
+	etLatencyMatrixTTL = 10 * time.Minute
+	// every 1% packet loss costs as much as 10ms RTT
+	etLossPenaltyMs = 10.0
+	etMaxDegree     = 4
+)
+
+type etLatencySample struct {
+	From int64   `json:"from"`
+	To   int64   `json:"to"`
+	Avg  float64 `json:"avg"`
+	Loss float64 `json:"loss"`
+	OK   bool    `json:"ok"`
+	Msg  string  `json:"msg,omitempty"`
+}
+
+type etLatencyMatrix struct {
+	MeasuredAt int64             `json:"measuredAt"`
+	Samples    []etLatencySample `json:"samples"`
+}
+
+type etPlanEdge struct {
+	From int64   `json:"from"`
+	To   int64   `json:"to"`
+	RTT  float64 `json:"rttMs"`
+	Loss float64 `json:"loss"`
+	Cost float64 `json:"cost"`
+	Kind string  `json:"kind"` // tree | redundant | fallback
+}
+
+type etPlanNode struct {
+	NodeID     int64   `json:"nodeId"`
+	Name       string  `json:"name"`
+	PeerNodeID *int64  `json:"peerNodeId,omitempty"`
+	ExtraPeers []int64 `json:"extraPeers,omitempty"`
+	Changed    bool    `json:"changed"`
+}
+
+type etTopologyPlan struct {
+	Mode       string       `json:"mode"`
+	Degree     int          `json:"degree"`
+	MasterID   int64        `json:"masterId"`
+	OldMaster  int64        `json:"oldMasterId"`
+	Nodes      []etPlanNode `json:"nodes"`
+	Edges      []etPlanEdge `json:"edges"`
+	Changed    []int64      `json:"changed"`
+	Unmeasured []int64      `json:"unmeasured"`
+	MeasuredAt int64        `json:"measuredAt"`
+	TotalCost  float64      `json:"totalCost"`
+}
+
+func etPairKey(a, b int64) [2]int64 {
+	if a > b {
+		a, b = b, a
+	}
+	return [2]int64{a, b}
+}
+
+// measureEasyTierLatency pings every unordered member pair once (from the lower id side).
+// Pairs not started before easytier_latency_timeout_sec are recorded as failed and
+// the partial matrix is not cached.
+func measureEasyTierLatency(nodes []etNode) etLatencyMatrix {
+	type job struct{ from, to etNode }
+	jobs := make([]job, 0, len(nodes)*(len(nodes)-1)/2)
+	for i := range nodes {
+		for j := i + 1; j < len(nodes); j++ {
+			if nodes[j].IP == "" {
+				continue
+			}
+			jobs = append(jobs, job{from: nodes[i], to: nodes[j]})
+		}
+	}
+	count := getCfgInt("easytier_latency_ping_count", 5)
+	par := getCfgInt("easytier_latency_parallel", 8)
+	deadline := time.Now().Add(time.Duration(getCfgInt("easytier_latency_timeout_sec", 60)) * time.Second)
+	out := make([]etLatencySample, len(jobs))
+	sem := make(chan struct{}, par)
+	var wg sync.WaitGroup
+	partial := false
+	for i, jb := range jobs {
+		sem <- struct{}{}
+		if time.Now().After(deadline) {
+			<-sem
+			out[i] = etLatencySample{From: jb.from.NodeID, To: jb.to.NodeID, Loss: 100, Msg: "测量超时"}
+			partial = true
+			continue
+		}
+		wg.Add(1)
+		go func(i int, jb job) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			avg, loss, ok, msg := diagnosePingFromNode(jb.from.NodeID, jb.to.IP, count, 1500)
+			out[i] = etLatencySample{From: jb.from.NodeID, To: jb.to.NodeID, Avg: avg, Loss: loss, OK: ok && loss < 100, Msg: msg}
+		}(i, jb)
+	}
+	wg.Wait()
+	m := etLatencyMatrix{MeasuredAt: time.Now().UnixMilli(), Samples: out}
+	if partial {
+		jlog(map[string]any{"event": "easytier_latency_timeout", "pairs": len(jobs)})
+		return m
+	}
+	if b, err := json.Marshal(m); err == nil {
+		setCfg(etLatencyMatrixKey, string(b))
+	}
+	return m
+}
+
+// loadEasyTierLatency returns the cached matrix if it is fresh and covers all members.
+func loadEasyTierLatency(nodes []etNode) (etLatencyMatrix, bool) {
+	var m etLatencyMatrix
+	v := getCfg(etLatencyMatrixKey)
+	if v == "" || json.Unmarshal([]byte(v), &m) != nil {
+		return m, false
+	}
+	if time.Since(time.UnixMilli(m.MeasuredAt)) > etLatencyMatrixTTL {
+		return m, false
+	}
+	seen := map[[2]int64]bool{}
+	for _, s := range m.Samples {
+		seen[etPairKey(s.From, s.To)] = true
+	}
+	for i := range nodes {
+		for j := i + 1; j < len(nodes); j++ {
+			if nodes[j].IP != "" && !seen[etPairKey(nodes[i].NodeID, nodes[j].NodeID)] {
+				return m, false
+			}
+		}
+	}
+	return m, true
+}
+
+// planEasyTierTopology computes master, spanning tree and redundant links from the matrix.
+func planEasyTierTopology(nodes []etNode, m etLatencyMatrix, degree int, oldMaster int64) (etTopologyPlan, []etNode) {
+	cost := map[[2]int64]etLatencySample{}
+	for _, s := range m.Samples {
+		if s.OK {
+			cost[etPairKey(s.From, s.To)] = s
+		}
+	}
+	if len(cost) == 0 {
+		// nothing measured: leave MasterID unset so callers do not apply a blind plan
+		return etTopologyPlan{Mode: "latency", Degree: degree, OldMaster: oldMaster, MeasuredAt: m.MeasuredAt}, nodes
+	}
+	edgeCost := func(a, b int64) (float64, bool) {
+		s, ok := cost[etPairKey(a, b)]
+		if !ok {
+			return math.Inf(1), false
+		}
+		return s.Avg + s.Loss*etLossPenaltyMs, true
+	}
+	ids := make([]int64, 0, len(nodes))
+	for _, n := range nodes {
+		ids = append(ids, n.NodeID)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	// master: most reachable node, then lowest total cost; keep the current master on ties
+	master := int64(0)
+	bestReach, bestSum := -1, math.Inf(1)
+	for _, a := range ids {
+		reach, sum := 0, 0.0
+		for _, b := range ids {
+			if a == b {
+				continue
+			}
+			if c, ok := edgeCost(a, b); ok {
+				reach++
+				sum += c
+			}
+		}
+		better := reach > bestReach || (reach == bestReach && sum < bestSum-0.5)
+		tie := reach == bestReach && math.Abs(sum-bestSum) <= 0.5 && a == oldMaster
+		if better || tie {
+			master, bestReach, bestSum = a, reach, sum
+		}
+	}
+
+	plan := etTopologyPlan{Mode: "latency", Degree: degree, MasterID: master, OldMaster: oldMaster, MeasuredAt: m.MeasuredAt}
+	parent := map[int64]int64{}
+	adj := map[[2]int64]bool{}
+	addEdge := func(from, to int64, kind string) {
+		s := cost[etPairKey(from, to)]
+		c, _ := edgeCost(from, to)
+		if math.IsInf(c, 1) {
+			c = 0
+		}
+		plan.Edges = append(plan.Edges, etPlanEdge{From: from, To: to, RTT: s.Avg, Loss: s.Loss, Cost: c, Kind: kind})
+		plan.TotalCost += c
+		adj[etPairKey(from, to)] = true
+	}
+
+	// Prim's MST rooted at the master
+	inTree := map[int64]bool{master: true}
+	for len(inTree) < len(ids) {
+		bestFrom, bestTo, best := int64(0), int64(0), math.Inf(1)
+		for _, a := range ids {
+			if !inTree[a] {
+				continue
+			}
+			for _, b := range ids {
+				if inTree[b] {
+					continue
+				}
+				if c, ok := edgeCost(a, b); ok && c < best {
+					bestFrom, bestTo, best = a, b, c
+				}
+			}
+		}
+		if bestTo == 0 {
+			break
+		}
+		inTree[bestTo] = true
+		parent[bestTo] = bestFrom
+		addEdge(bestTo, bestFrom, "tree")
+	}
+	// nodes without any measured link fall back to dialing the master
+	for _, id := range ids {
+		if !inTree[id] {
+			plan.Unmeasured = append(plan.Unmeasured, id)
+			parent[id] = master
+			addEdge(id, master, "fallback")
+		}
+	}
+
+	// redundancy: each node dials its cheapest non-adjacent peers until it has `degree` links
+	extra := map[int64][]int64{}
+	linkCount := map[int64]int{}
+	for _, e := range plan.Edges {
+		linkCount[e.From]++
+		linkCount[e.To]++
+	}
+	for _, a := range ids {
+		if degree <= 1 {
+			break
+		}
+		type cand struct {
+			id int64
+			c  float64
+		}
+		cands := []cand{}
+		for _, b := range ids {
+			if a == b || adj[etPairKey(a, b)] {
+				continue
+			}
+			if c, ok := edgeCost(a, b); ok {
+				cands = append(cands, cand{b, c})
+			}
+		}
+		sort.Slice(cands, func(i, j int) bool { return cands[i].c < cands[j].c })
+		for _, cd := range cands {
+			if linkCount[a] >= degree {
+				break
+			}
+			extra[a] = append(extra[a], cd.id)
+			linkCount[a]++
+			linkCount[cd.id]++
+			addEdge(a, cd.id, "redundant")
+		}
+	}
+
+	var names = map[int64]string{}
+	var list []model.Node
+	dbpkg.DB.Select("id, name").Where("id IN ?", ids).Find(&list)
+	for _, n := range list {
+		names[n.ID] = n.Name
+	}
+	next := make([]etNode, len(nodes))
+	copy(next, nodes)
+	for i := range next {
+		old := nodes[i]
+		nn := &next[i]
+		nn.PeerIP = nil
+		nn.PeerNodeID = nil
+		if p, ok := parent[nn.NodeID]; ok && nn.NodeID != master {
+			pid := p
+			nn.PeerNodeID = &pid
+		}
+		nn.ExtraPeers = extra[nn.NodeID]
+		changed := !sameEtPeers(old, *nn)
+		if changed {
+			plan.Changed = append(plan.Changed, nn.NodeID)
+		}
+		plan.Nodes = append(plan.Nodes, etPlanNode{NodeID: nn.NodeID, Name: names[nn.NodeID], PeerNodeID: nn.PeerNodeID, ExtraPeers: nn.ExtraPeers, Changed: changed})
+	}
+	return plan, next
+}
+
+// sameEtPeers reports whether two member entries render the same peer set.
+func sameEtPeers(a, b etNode) bool {
+	if (a.PeerNodeID == nil) != (b.PeerNodeID == nil) || (a.PeerNodeID != nil && *a.PeerNodeID != *b.PeerNodeID) {
+		return false
+	}
+	pa, pb := "", ""
+	if a.PeerIP != nil {
+		pa = *a.PeerIP
+	}
+	if b.PeerIP != nil {
+		pb = *b.PeerIP
+	}
+	if pa != pb || len(a.ExtraPeers) != len(b.ExtraPeers) {
+		return false
+	}
+	x := append([]int64(nil), a.ExtraPeers...)
+	y := append([]int64(nil), b.ExtraPeers...)
+	sort.Slice(x, func(i, j int) bool { return x[i] < x[j] })
+	sort.Slice(y, func(i, j int) bool { return y[i] < y[j] })
+	for i := range x {
+		if x[i] != y[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// easyTierLatencyAssign handles auto-assign mode "latency": preview (dryRun) or incremental apply.
+func easyTierLatencyAssign(c *gin.Context, nodes []etNode, degree int, dryRun bool, refresh bool) {
+	if degree <= 0 {
+		degree = 1
+	}
+	if degree > etMaxDegree {
+		degree = etMaxDegree
+	}
+	var master etMaster
+	_ = json.Unmarshal([]byte(getCfg(etMasterKey)), &master)
+	m, fresh := loadEasyTierLatency(nodes)
+	if refresh || !fresh {
+		m = measureEasyTierLatency(nodes)
+	}
+	plan, next := planEasyTierTopology(nodes, m, degree, master.NodeID)
+	if plan.MasterID == 0 {
+		c.JSON(http.StatusOK, response.ErrMsg("无可用延迟数据"))
+		return
+	}
+	if dryRun {
+		c.JSON(http.StatusOK, response.Ok(map[string]any{"plan": plan, "matrix": m.Samples}))
+		return
+	}
+	if b, err := json.Marshal(next); err == nil {
+		setCfg(etNodesKey, string(b))
+	}
+	if plan.MasterID != master.NodeID {
+		for _, n := range next {
+			if n.NodeID == plan.MasterID {
+				if b, err := json.Marshal(etMaster{NodeID: n.NodeID, IP: n.IP, Port: n.Port}); err == nil {
+					setCfg(etMasterKey, string(b))
+				}
+				break
+			}
+		}
+	}
+	// only members whose peer set changed are rewritten and restarted; restarts
+	// take tens of seconds per node so they run after the response
+	go func(plan etTopologyPlan) {
+		results := make([]map[string]any, 0, len(plan.Changed))
+		for _, id := range plan.Changed {
+			ok, msg := applyEasyTierConfig(id, "")
+			results = append(results, map[string]any{"nodeId": id, "success": ok, "message": msg})
+		}
+		jlog(map[string]any{"event": "easytier_topology_apply", "master": plan.MasterID, "degree": degree, "changed": plan.Changed, "results": results})
+	}(plan)
+	c.JSON(http.StatusOK, response.Ok(map[string]any{"plan": plan, "applying": plan.Changed}))
+}
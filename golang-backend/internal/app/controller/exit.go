@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
@@ -32,6 +33,10 @@ func NodeSetExit(c *gin.Context) {
 		Method        string  `json:"method"`
 		ExitIP        *string `json:"exitIp"`
 		AllowFallback *bool   `json:"allowFallback"`
+		DecoyMode     *string `json:"decoyMode"`
+		DecoyURL      *string `json:"decoyUrl"`
+		RuleSetID     *int64  `json:"ruleSetId"`
+		PaddingScheme *string `json:"paddingScheme"`
 		// optional extras
 		Observer string                 `json:"observer"`
 		Limiter  string                 `json:"limiter"`
@@ -72,6 +77,17 @@ func NodeSetExit(c *gin.Context) {
 	if p.Method == "" && exitType == "ss" {
 		p.Method = "AEAD_CHACHA20_POLY1305"
 	}
+	if p.RuleSetID != nil {
+		// rule sets are admin-managed and may carry upstream proxy credentials
+		if roleInf, ok := c.Get("role_id"); ok && roleInf != 0 {
+			c.JSON(http.StatusOK, response.ErrMsg("仅管理员可设置出站规则集"))
+			return
+		}
+	}
+	if p.RuleSetID != nil && !validExitRuleSetID(*p.RuleSetID) {
+		c.JSON(http.StatusOK, response.ErrMsg("出站规则集不存在"))
+		return
+	}
 
 	if exitType == "anytls" {
 		allowFallback := getAnyTLSExitFallback(p.NodeID)
@@ -82,6 +98,39 @@ func NodeSetExit(c *gin.Context) {
 		if p.ExitIP != nil {
 			exitIP = strings.TrimSpace(*p.ExitIP)
 		}
+		var existing model.AnyTLSSetting
+		_ = dbpkg.DB.Where("node_id = ?", p.NodeID).First(&existing).Error
+		decoyMode, decoyURL := existing.DecoyMode, existing.DecoyURL
+		if p.DecoyMode != nil {
+			decoyMode = strings.ToLower(strings.TrimSpace(*p.DecoyMode))
+		}
+		if p.DecoyURL != nil {
+			decoyURL = strings.TrimSpace(*p.DecoyURL)
+		}
+		if errMsg := validateAnyTLSDecoy(decoyMode, decoyURL); errMsg != "" {
+			c.JSON(http.StatusOK, response.ErrMsg(errMsg))
+			return
+		}
+		ruleSetID := existing.RuleSetID
+		if p.RuleSetID != nil {
+			ruleSetID = exitRuleSetRef(*p.RuleSetID)
+		}
+		paddingScheme := existing.PaddingScheme
+		if p.PaddingScheme != nil {
+			v, ok := normalizeAnyTLSPadding(*p.PaddingScheme)
+			if !ok {
+				c.JSON(http.StatusOK, response.ErrMsg("填充方案格式错误"))
+				return
+			}
+			paddingScheme = v
+		}
+		var inboundCnt, nativeCnt int64
+		dbpkg.DB.Model(&model.AnyTLSInbound{}).Where("node_id = ? AND port = ?", p.NodeID, p.Port).Count(&inboundCnt)
+		dbpkg.DB.Model(&model.NativeExit{}).Where("node_id = ? AND port = ?", p.NodeID, p.Port).Count(&nativeCnt)
+		if inboundCnt > 0 || nativeCnt > 0 {
+			c.JSON(http.StatusOK, response.ErrMsg("端口已被该节点的其他出口占用"))
+			return
+		}
 		var baseUserID int64
 		if v, ok := c.Get("user_id"); ok {
 			if id, ok2 := v.(int64); ok2 {
@@ -99,6 +148,11 @@ func NodeSetExit(c *gin.Context) {
 			"port":      p.Port,
 			"password":  p.Password,
 			"users":     buildAnyTLSUsersForNode(p.NodeID, p.Password),
+			"decoy":     anytlsDecoyReq(decoyMode, decoyURL),
+			"outbound":  loadExitRules(ruleSetID),
+			"tls":       exitCertReq(p.NodeID),
+			"padding":   paddingScheme,
+			"inbounds":  buildAnyTLSInboundsReq(p.NodeID),
 		}
 		if baseUserID > 0 {
 			req["baseUserId"] = baseUserID
@@ -123,11 +177,13 @@ func NodeSetExit(c *gin.Context) {
 				return
 			}
 			now := time.Now().UnixMilli()
-			var existing model.AnyTLSSetting
-			tx := dbpkg.DB.Where("node_id = ?", p.NodeID).First(&existing)
-			if tx.Error == nil && existing.ID > 0 {
+			if existing.ID > 0 {
 				existing.Port = p.Port
 				existing.Password = p.Password
+				existing.DecoyMode = decoyMode
+				existing.DecoyURL = decoyURL
+				existing.RuleSetID = ruleSetID
+				existing.PaddingScheme = paddingScheme
 				if baseUserID > 0 {
 					existing.BaseUserID = &baseUserID
 				}
@@ -146,12 +202,19 @@ func NodeSetExit(c *gin.Context) {
 						}
 						return nil
 					}(),
+					DecoyMode:     decoyMode,
+					DecoyURL:      decoyURL,
+					RuleSetID:     ruleSetID,
+					PaddingScheme: paddingScheme,
 				}
 				_ = dbpkg.DB.Create(&rec).Error
 			}
 			if p.ExitIP != nil {
 				_ = setAnyTLSExitIP(p.NodeID, exitIP)
 			}
+			if p.ExitIP != nil || p.RuleSetID != nil {
+				go pushNativeExitsToNode(p.NodeID)
+			}
 			if p.AllowFallback != nil {
 				_ = setAnyTLSExitFallback(p.NodeID, allowFallback)
 			}
@@ -163,7 +226,6 @@ func NodeSetExit(c *gin.Context) {
 	}
 
 	// Build service config (SS)
-	name := fmt.Sprintf("exit_ss_%d", p.Port)
 	var baseUserID int64
 	if v, ok := c.Get("user_id"); ok {
 		if id, ok2 := v.(int64); ok2 {
@@ -176,24 +238,13 @@ func NodeSetExit(c *gin.Context) {
 			baseUserID = u.ID
 		}
 	}
-	exitObserverName := ""
-	var exitObserverSpec map[string]any
-	if baseUserID > 0 {
-		exitObserverName, exitObserverSpec = buildExitObserverPluginSpec(p.NodeID, baseUserID, p.Port)
-	}
-	obsName := exitObserverName
-	if obsName == "" {
-		obsName = strings.TrimSpace(p.Observer)
-	}
-	svc := buildSSService(name, p.Port, p.Password, p.Method, map[string]any{
-		"observer": obsName,
-		"limiter":  p.Limiter,
-		"rlimiter": p.RLimiter,
-		"metadata": p.Metadata,
-	})
-	if exitObserverSpec != nil {
-		svc["_observers"] = []any{exitObserverSpec}
+	var existingSS model.ExitSetting
+	_ = dbpkg.DB.Where("node_id = ?", p.NodeID).First(&existingSS).Error
+	ruleSetID := existingSS.RuleSetID
+	if p.RuleSetID != nil {
+		ruleSetID = exitRuleSetRef(*p.RuleSetID)
 	}
+	svc := buildSSExitService(p.NodeID, p.Port, p.Password, p.Method, baseUserID, p.Observer, p.Limiter, p.RLimiter, p.Metadata, loadExitRules(ruleSetID))
 	// 同步等待 agent 回执，便于捕获 GOST 配置错误
 	req := map[string]interface{}{
 		"requestId": RandUUID(),
@@ -237,6 +288,7 @@ func NodeSetExit(c *gin.Context) {
 				existing.BaseUserID = &baseUserID
 			}
 			existing.Metadata = metaStr
+			existing.RuleSetID = ruleSetID
 			existing.UpdatedTime = now
 			_ = dbpkg.DB.Save(&existing).Error
 		} else {
@@ -256,7 +308,8 @@ func NodeSetExit(c *gin.Context) {
 					}
 					return nil
 				}(),
-				Metadata:   metaStr,
+				Metadata:  metaStr,
+				RuleSetID: ruleSetID,
 			}
 			_ = dbpkg.DB.Create(&rec).Error
 		}
@@ -307,6 +360,10 @@ func NodeGetExit(c *gin.Context) {
 			"type":          "anytls",
 			"exitIp":        exitIP,
 			"allowFallback": allowFallback,
+			"decoyMode":     anytlsDecoyMode(item.DecoyMode),
+			"decoyUrl":      item.DecoyURL,
+			"ruleSetId":     valOr0(item.RuleSetID),
+			"paddingScheme": item.PaddingScheme,
 		}
 		c.JSON(http.StatusOK, response.Ok(out))
 		return
@@ -322,18 +379,71 @@ func NodeGetExit(c *gin.Context) {
 		_ = json.Unmarshal([]byte(*item.Metadata), &meta)
 	}
 	out := gin.H{
-		"nodeId":   item.NodeID,
-		"port":     item.Port,
-		"password": item.Password,
-		"method":   item.Method,
-		"observer": deref(item.Observer),
-		"limiter":  deref(item.Limiter),
-		"rlimiter": deref(item.RLimiter),
-		"metadata": meta,
+		"nodeId":    item.NodeID,
+		"port":      item.Port,
+		"password":  item.Password,
+		"method":    item.Method,
+		"observer":  deref(item.Observer),
+		"limiter":   deref(item.Limiter),
+		"rlimiter":  deref(item.RLimiter),
+		"metadata":  meta,
+		"ruleSetId": valOr0(item.RuleSetID),
 	}
 	c.JSON(http.StatusOK, response.Ok(out))
 }
 
+// buildSSExitService assembles the gost service of a shadowsocks exit.
+func buildSSExitService(nodeID int64, port int, password, method string, baseUserID int64, observer, limiter, rlimiter string, metadata map[string]interface{}, rules *exitRules) map[string]any {
+	name := fmt.Sprintf("exit_ss_%d", port)
+	exitObserverName := ""
+	var exitObserverSpec map[string]any
+	if baseUserID > 0 {
+		exitObserverName, exitObserverSpec = buildExitObserverPluginSpec(nodeID, baseUserID, port)
+	}
+	obsName := exitObserverName
+	if obsName == "" {
+		obsName = strings.TrimSpace(observer)
+	}
+	svc := buildSSService(name, port, password, method, map[string]any{
+		"observer": obsName,
+		"limiter":  limiter,
+		"rlimiter": rlimiter,
+		"metadata": metadata,
+	})
+	if exitObserverSpec != nil {
+		svc["_observers"] = []any{exitObserverSpec}
+	}
+	applyExitRulesToSS(svc, name, rules)
+	return svc
+}
+
+// pushSSExitToNode re-applies the saved SS exit of a node (e.g. after its
+// outbound rule set changed).
+func pushSSExitToNode(nodeID int64) {
+	var es model.ExitSetting
+	if err := dbpkg.DB.Where("node_id = ?", nodeID).First(&es).Error; err != nil || es.ID == 0 {
+		return
+	}
+	var meta map[string]interface{}
+	if es.Metadata != nil && *es.Metadata != "" {
+		_ = json.Unmarshal([]byte(*es.Metadata), &meta)
+	}
+	svc := buildSSExitService(nodeID, es.Port, es.Password, es.Method, valOr0(es.BaseUserID), deref(es.Observer), deref(es.Limiter), deref(es.RLimiter), meta, loadExitRules(es.RuleSetID))
+	req := map[string]interface{}{
+		"requestId": RandUUID(),
+		"services":  expandRUDP([]map[string]any{svc}),
+	}
+	_, _ = RequestOp(nodeID, "AddService", req, 10*time.Second)
+}
+
+// exitRuleSetRef maps a set-exit ruleSetId to the stored reference; 0 clears it.
+func exitRuleSetRef(id int64) *int64 {
+	if id <= 0 {
+		return nil
+	}
+	return &id
+}
+
 func strPtrOrNil(s string) *string {
 	if s == "" {
 		return nil
@@ -394,6 +504,36 @@ func setAnyTLSExitFallback(nodeID int64, allow bool) error {
 	return dbpkg.DB.Create(&model.ViteConfig{Name: key, Value: val, Time: now}).Error
 }
 
+// anytlsDecoyMode normalizes the stored decoy mode; empty means the built-in page.
+func anytlsDecoyMode(mode string) string {
+	if mode == "" {
+		return "static"
+	}
+	return mode
+}
+
+func validateAnyTLSDecoy(mode, rawURL string) string {
+	switch mode {
+	case "", "static", "close":
+		return ""
+	case "proxy":
+		u, err := url.Parse(rawURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return "回落地址需为 http(s):// 开头的有效地址"
+		}
+		return ""
+	}
+	return "无效的回落模式"
+}
+
+func anytlsDecoyReq(mode, rawURL string) map[string]any {
+	out := map[string]any{"mode": anytlsDecoyMode(mode)}
+	if mode == "proxy" {
+		out["url"] = rawURL
+	}
+	return out
+}
+
 func getAnyTLSExitFallback(nodeID int64) bool {
 	key := anytlsExitFallbackKey(nodeID)
 	var cfg model.ViteConfig
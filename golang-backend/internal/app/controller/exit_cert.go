@@ -0,0 +1,275 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"network-panel/golang-backend/internal/app/model"
+	"network-panel/golang-backend/internal/app/response"
+	dbpkg "network-panel/golang-backend/internal/db"
+)
+
+// ---- ACME certificates for exit domains ----
+//
+// An admin assigns a domain to an exit node; the agent obtains and renews a
+// certificate for it (HTTP-01, TLS-ALPN-01 or DNS-01 through a provider
+// plugin) and serves it on the AnyTLS listener, reporting the certificate
+// state back. Subscriptions use the domain as SNI with verification only
+// while the reported certificate is valid, and fall back to skip-verify
+// otherwise.
+
+var exitCertDomainRe = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z]{2,63}$`)
+
+// DNS providers compiled into flux-agent (see cmd/flux-agent/anytls_acme.go)
+var exitCertDNSProviders = map[string]bool{"cloudflare": true, "alidns": true}
+
+// exitCertReq is the "tls" object of SetAnyTLS; nil when the node has no domain.
+func exitCertReq(nodeID int64) map[string]any {
+	var ec model.ExitCert
+	if err := dbpkg.DB.Where("node_id = ?", nodeID).First(&ec).Error; err != nil || ec.Domain == "" {
+		return nil
+	}
+	req := map[string]any{"domain": ec.Domain}
+	for k, v := range map[string]string{"email": ec.Email, "ca": ec.CA, "caRoot": ec.CARoot, "challenge": ec.Challenge, "dnsProvider": ec.DNSProvider} {
+		if v != "" {
+			req[k] = v
+		}
+	}
+	if creds := exitCertCredentials(ec); len(creds) > 0 {
+		req["dnsCredentials"] = creds
+	}
+	if ec.AltHTTPPort > 0 {
+		req["altHttpPort"] = ec.AltHTTPPort
+	}
+	if ec.AltTLSALPNPort > 0 {
+		req["altTlsAlpnPort"] = ec.AltTLSALPNPort
+	}
+	return req
+}
+
+func exitCertCredentials(ec model.ExitCert) map[string]string {
+	creds := map[string]string{}
+	if ec.DNSCredentials != "" {
+		_ = json.Unmarshal([]byte(ec.DNSCredentials), &creds)
+	}
+	return creds
+}
+
+// exitCertTrusted reports whether subscriptions may verify the exit's cert.
+func exitCertTrusted(ec model.ExitCert) bool {
+	return ec.Domain != "" && ec.Status == "ok" && ec.NotAfter > time.Now().UnixMilli()
+}
+
+// exitCertMap loads the trusted certificates of the given nodes.
+func exitCertMap(nodeIDs []int64) map[int64]model.ExitCert {
+	out := map[int64]model.ExitCert{}
+	if len(nodeIDs) == 0 {
+		return out
+	}
+	var rows []model.ExitCert
+	dbpkg.DB.Where("node_id IN ?", nodeIDs).Find(&rows)
+	for _, ec := range rows {
+		if exitCertTrusted(ec) {
+			out[ec.NodeID] = ec
+		}
+	}
+	return out
+}
+
+func exitCertView(ec model.ExitCert) map[string]any {
+	keys := make([]string, 0)
+	for k := range exitCertCredentials(ec) {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return map[string]any{
+		"nodeId": ec.NodeID, "domain": ec.Domain, "email": ec.Email, "ca": ec.CA, "caRoot": ec.CARoot,
+		"challenge": ec.Challenge, "dnsProvider": ec.DNSProvider, "dnsCredentialKeys": keys,
+		"altHttpPort": ec.AltHTTPPort, "altTlsAlpnPort": ec.AltTLSALPNPort,
+		"status": ec.Status, "notAfter": ec.NotAfter, "issuer": ec.Issuer, "error": ec.Error,
+		"certPath": ec.CertPath, "keyPath": ec.KeyPath, "reportedTime": ec.ReportedTime,
+		"trusted": exitCertTrusted(ec),
+	}
+}
+
+// NodeExitCertGet 获取出口节点的证书域名与签发状态（管理员）
+// @Summary 获取出口节点证书
+// @Tags node
+// @Accept json
+// @Produce json
+// @Param data body SwaggerNodeSimpleReq true "节点ID"
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/node/exit-cert/get [post]
+func NodeExitCertGet(c *gin.Context) {
+	var p struct {
+		NodeID int64 `json:"nodeId" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	var ec model.ExitCert
+	if err := dbpkg.DB.Where("node_id = ?", p.NodeID).First(&ec).Error; err != nil {
+		c.JSON(http.StatusOK, response.Ok(nil))
+		return
+	}
+	c.JSON(http.StatusOK, response.Ok(exitCertView(ec)))
+}
+
+// NodeExitCertSet 为出口节点分配域名并通过 ACME 签发证书（管理员）；domain 为空则移除
+// @Summary 设置出口节点证书域名
+// @Tags node
+// @Accept json
+// @Produce json
+// @Param data body object true "{nodeId, domain, email?, ca?, caRoot?, challenge?(http/tls-alpn/dns), dnsProvider?(cloudflare/alidns), dnsCredentials?, altHttpPort?, altTlsAlpnPort?}"
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/node/exit-cert/set [post]
+func NodeExitCertSet(c *gin.Context) {
+	var p struct {
+		NodeID         int64             `json:"nodeId" binding:"required"`
+		Domain         string            `json:"domain"`
+		Email          string            `json:"email"`
+		CA             string            `json:"ca"`
+		CARoot         string            `json:"caRoot"`
+		Challenge      string            `json:"challenge"`
+		DNSProvider    string            `json:"dnsProvider"`
+		DNSCredentials map[string]string `json:"dnsCredentials"` // omitted: keep the stored ones
+		AltHTTPPort    int               `json:"altHttpPort"`
+		AltTLSALPNPort int               `json:"altTlsAlpnPort"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	var node model.Node
+	if err := dbpkg.DB.First(&node, p.NodeID).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("节点不存在"))
+		return
+	}
+	var ec model.ExitCert
+	dbpkg.DB.Where("node_id = ?", p.NodeID).First(&ec)
+	p.Domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(p.Domain)), ".")
+	if p.Domain == "" {
+		if ec.ID > 0 {
+			dbpkg.DB.Delete(&ec)
+			pushExitUsersToNode(p.NodeID)
+		}
+		c.JSON(http.StatusOK, response.OkMsg("已移除证书域名"))
+		return
+	}
+	if net.ParseIP(p.Domain) != nil || !exitCertDomainRe.MatchString(p.Domain) {
+		c.JSON(http.StatusOK, response.ErrMsg("域名格式不正确"))
+		return
+	}
+	p.Challenge = strings.ToLower(strings.TrimSpace(p.Challenge))
+	p.DNSProvider = strings.ToLower(strings.TrimSpace(p.DNSProvider))
+	switch p.Challenge {
+	case "", "http", "tls-alpn":
+		p.DNSProvider = ""
+	case "dns":
+		if !exitCertDNSProviders[p.DNSProvider] {
+			c.JSON(http.StatusOK, response.ErrMsg("DNS 服务商仅支持 cloudflare 或 alidns"))
+			return
+		}
+	default:
+		c.JSON(http.StatusOK, response.ErrMsg("验证方式仅支持 http、tls-alpn 或 dns"))
+		return
+	}
+	if p.AltHTTPPort < 0 || p.AltHTTPPort > 65535 || p.AltTLSALPNPort < 0 || p.AltTLSALPNPort > 65535 {
+		c.JSON(http.StatusOK, response.ErrMsg("端口无效"))
+		return
+	}
+	if ca := strings.TrimSpace(p.CA); ca != "" && !strings.HasPrefix(ca, "https://") {
+		c.JSON(http.StatusOK, response.ErrMsg("ACME 目录地址需为 https"))
+		return
+	}
+	now := time.Now().UnixMilli()
+	if ec.ID == 0 {
+		ec = model.ExitCert{NodeID: p.NodeID, CreatedTime: now}
+	}
+	if ec.Domain != p.Domain || ec.CA != strings.TrimSpace(p.CA) {
+		// a different certificate: forget the old state until the agent reports
+		ec.Status, ec.NotAfter, ec.Issuer, ec.Error, ec.CertPath, ec.KeyPath, ec.ReportedTime = "pending", 0, "", "", "", "", 0
+	}
+	ec.Domain = p.Domain
+	ec.Email = strings.TrimSpace(p.Email)
+	ec.CA = strings.TrimSpace(p.CA)
+	ec.CARoot = strings.TrimSpace(p.CARoot)
+	ec.Challenge = p.Challenge
+	ec.DNSProvider = p.DNSProvider
+	if p.DNSCredentials != nil {
+		b, _ := json.Marshal(p.DNSCredentials)
+		ec.DNSCredentials = string(b)
+	}
+	if ec.DNSProvider == "" {
+		ec.DNSCredentials = ""
+	}
+	ec.AltHTTPPort, ec.AltTLSALPNPort = p.AltHTTPPort, p.AltTLSALPNPort
+	ec.UpdatedTime = now
+	if err := dbpkg.DB.Save(&ec).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("保存失败"))
+		return
+	}
+	pushExitUsersToNode(p.NodeID)
+	c.JSON(http.StatusOK, response.Ok(exitCertView(ec)))
+}
+
+// POST /api/v1/agent/report-cert
+// {secret, domain, status: pending|ok|error, notAfter?, issuer?, error?, certPath?, keyPath?}
+func AgentReportCert(c *gin.Context) {
+	var p struct {
+		Secret   string `json:"secret" binding:"required"`
+		Domain   string `json:"domain"`
+		Status   string `json:"status"`
+		NotAfter int64  `json:"notAfter"`
+		Issuer   string `json:"issuer"`
+		Error    string `json:"error"`
+		CertPath string `json:"certPath"`
+		KeyPath  string `json:"keyPath"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	var node model.Node
+	if err := dbpkg.DB.Where("secret = ?", p.Secret).First(&node).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("节点不存在"))
+		return
+	}
+	var ec model.ExitCert
+	if err := dbpkg.DB.Where("node_id = ?", node.ID).First(&ec).Error; err != nil || !strings.EqualFold(ec.Domain, p.Domain) {
+		// stale report for a domain that is no longer assigned
+		c.JSON(http.StatusOK, response.OkNoData())
+		return
+	}
+	if p.Status != "ok" && p.Status != "error" {
+		p.Status = "pending"
+	}
+	if len(p.Error) > 512 {
+		p.Error = p.Error[:512]
+	}
+	if len(p.Issuer) > 255 {
+		p.Issuer = p.Issuer[:255]
+	}
+	prev := ec.Status
+	ec.Status, ec.NotAfter, ec.Issuer, ec.Error = p.Status, p.NotAfter, p.Issuer, p.Error
+	ec.CertPath, ec.KeyPath = p.CertPath, p.KeyPath
+	ec.ReportedTime = time.Now().UnixMilli()
+	if err := dbpkg.DB.Save(&ec).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("保存失败"))
+		return
+	}
+	if p.Status == "error" && prev != "error" {
+		nid, name := node.ID, node.Name
+		msg := fmt.Sprintf("出口域名 %s 证书签发失败: %s", ec.Domain, ec.Error)
+		enqueueAlert(model.Alert{TimeMs: ec.ReportedTime, Type: "cert", NodeID: &nid, NodeName: &name, Message: msg})
+	}
+	c.JSON(http.StatusOK, response.OkNoData())
+}
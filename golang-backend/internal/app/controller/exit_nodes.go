@@ -23,6 +23,8 @@ type exitNodeItem struct {
 	SSPort      *int    `json:"ssPort,omitempty"`
 	AnyTLSPort  *int    `json:"anytlsPort,omitempty"`
 	AnyTLSExitIP *string `json:"anytlsExitIp,omitempty"`
+	AnyTLSDecoyMode *string `json:"anytlsDecoyMode,omitempty"`
+	AnyTLSDecoyURL  *string `json:"anytlsDecoyUrl,omitempty"`
 	Protocol    *string `json:"protocol,omitempty"`
 	Port        *int    `json:"port,omitempty"`
 	Config      json.RawMessage `json:"config,omitempty"`
@@ -63,6 +65,10 @@ func ExitNodeList(c *gin.Context) {
 		if v := getAnyTLSExitIP(a.NodeID); v != "" {
 			item.AnyTLSExitIP = strPtr(v)
 		}
+		item.AnyTLSDecoyMode = strPtr(anytlsDecoyMode(a.DecoyMode))
+		if a.DecoyURL != "" {
+			item.AnyTLSDecoyURL = strPtr(a.DecoyURL)
+		}
 	}
 
 	// attach node info
@@ -91,6 +97,8 @@ func ExitNodeList(c *gin.Context) {
 		if len(staleIDs) > 0 {
 			dbpkg.DB.Where("node_id IN ?", staleIDs).Delete(&model.ExitSetting{})
 			dbpkg.DB.Where("node_id IN ?", staleIDs).Delete(&model.AnyTLSSetting{})
+			dbpkg.DB.Where("node_id IN ?", staleIDs).Delete(&model.AnyTLSInbound{})
+			dbpkg.DB.Where("node_id IN ?", staleIDs).Delete(&model.NativeExit{})
 		}
 	}
 
@@ -185,6 +193,8 @@ func ExitCleanup(c *gin.Context) {
 	}
 	resExit := dbpkg.DB.Where("node_id IN ?", staleIDs).Delete(&model.ExitSetting{})
 	resAny := dbpkg.DB.Where("node_id IN ?", staleIDs).Delete(&model.AnyTLSSetting{})
+	dbpkg.DB.Where("node_id IN ?", staleIDs).Delete(&model.AnyTLSInbound{})
+	dbpkg.DB.Where("node_id IN ?", staleIDs).Delete(&model.NativeExit{})
 	c.JSON(http.StatusOK, response.Ok(map[string]interface{}{
 		"deletedExit":   resExit.RowsAffected,
 		"deletedAnyTLS": resAny.RowsAffected,
@@ -0,0 +1,431 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"network-panel/golang-backend/internal/app/model"
+	"network-panel/golang-backend/internal/app/response"
+	dbpkg "network-panel/golang-backend/internal/db"
+)
+
+// ---- Exit outbound rules ----
+//
+// A rule set blocks destinations (private/LAN ranges, ports, domain/IP/CIDR
+// lists) and routes matching destinations out of an alternate local exit IP
+// or through an upstream SOCKS5 proxy. AnyTLS exits receive it as "outbound"
+// with SetAnyTLS; shadowsocks exits get a gost bypass for blocking and a chain
+// whose nodes only take the matching destinations for routing.
+//
+// Matchers follow gost bypass syntax: IP, CIDR, "example.com" (exact),
+// ".example.com" (domain and subdomains), "*.example.com" (subdomains).
+
+type exitRoute struct {
+	Match  []string `json:"match"`
+	ExitIP string   `json:"exitIp,omitempty"`
+	Socks5 string   `json:"socks5,omitempty"` // socks5://[user:pass@]host:port
+}
+
+type exitRules struct {
+	BlockPrivate bool        `json:"blockPrivate,omitempty"`
+	BlockPorts   []string    `json:"blockPorts,omitempty"`
+	Block        []string    `json:"block,omitempty"`
+	Routes       []exitRoute `json:"routes,omitempty"`
+}
+
+func (r exitRules) empty() bool {
+	return !r.BlockPrivate && len(r.BlockPorts) == 0 && len(r.Block) == 0 && len(r.Routes) == 0
+}
+
+// exitPrivateMatchers is what blockPrivate expands to for gost; the agent
+// checks resolved addresses against the same ranges.
+var exitPrivateMatchers = []string{
+	"localhost", "0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16",
+	"172.16.0.0/12", "192.168.0.0/16", "224.0.0.0/4", "::1/128", "fc00::/7", "fe80::/10", "ff00::/8",
+}
+
+var exitDomainRe = regexp.MustCompile(`^(\*\.|\.)?([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)*[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+const exitMaxMatchers = 2000
+
+func normalizeExitMatchers(in []string) ([]string, string) {
+	out := make([]string, 0, len(in))
+	seen := map[string]bool{}
+	for _, s := range in {
+		s = strings.ToLower(strings.TrimSpace(s))
+		if s == "" || seen[s] {
+			continue
+		}
+		if _, n, err := net.ParseCIDR(s); err == nil {
+			s = n.String()
+		} else if net.ParseIP(s) == nil && !exitDomainRe.MatchString(s) {
+			return nil, "无效的匹配规则: " + s
+		}
+		seen[s] = true
+		out = append(out, s)
+	}
+	if len(out) > exitMaxMatchers {
+		return nil, fmt.Sprintf("匹配规则不能超过 %d 条", exitMaxMatchers)
+	}
+	return out, ""
+}
+
+func normalizeExitPorts(in []string) ([]string, string) {
+	out := make([]string, 0, len(in))
+	for _, s := range in {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		lo, hi, isRange := strings.Cut(s, "-")
+		a, err1 := strconv.Atoi(strings.TrimSpace(lo))
+		b := a
+		var err2 error
+		if isRange {
+			b, err2 = strconv.Atoi(strings.TrimSpace(hi))
+		}
+		if err1 != nil || err2 != nil || a < 1 || b > 65535 || a > b {
+			return nil, "无效的端口: " + s
+		}
+		if a == b {
+			out = append(out, strconv.Itoa(a))
+		} else {
+			out = append(out, fmt.Sprintf("%d-%d", a, b))
+		}
+	}
+	return out, ""
+}
+
+func normalizeExitRoute(rt *exitRoute) string {
+	m, msg := normalizeExitMatchers(rt.Match)
+	if msg != "" {
+		return msg
+	}
+	if len(m) == 0 {
+		return "路由规则需至少一个匹配项"
+	}
+	rt.Match = m
+	rt.ExitIP = strings.TrimSpace(rt.ExitIP)
+	rt.Socks5 = strings.TrimSpace(rt.Socks5)
+	if (rt.ExitIP == "") == (rt.Socks5 == "") {
+		return "路由规则需指定出口 IP 或 SOCKS5 上游之一"
+	}
+	if rt.ExitIP != "" {
+		if net.ParseIP(rt.ExitIP) == nil {
+			return "无效的出口 IP: " + rt.ExitIP
+		}
+		return ""
+	}
+	if !strings.Contains(rt.Socks5, "://") {
+		rt.Socks5 = "socks5://" + rt.Socks5
+	}
+	u, err := url.Parse(rt.Socks5)
+	if err != nil || (u.Scheme != "socks5" && u.Scheme != "socks") || u.Hostname() == "" || u.Port() == "" {
+		return "SOCKS5 上游需为 socks5://[用户名:密码@]主机:端口"
+	}
+	u.Scheme = "socks5"
+	rt.Socks5 = u.String()
+	return ""
+}
+
+func (r *exitRules) normalize() string {
+	var msg string
+	if r.BlockPorts, msg = normalizeExitPorts(r.BlockPorts); msg != "" {
+		return msg
+	}
+	if r.Block, msg = normalizeExitMatchers(r.Block); msg != "" {
+		return msg
+	}
+	for i := range r.Routes {
+		if msg = normalizeExitRoute(&r.Routes[i]); msg != "" {
+			return msg
+		}
+	}
+	return ""
+}
+
+func parseExitRuleSet(rs model.ExitRuleSet) exitRules {
+	r := exitRules{BlockPrivate: rs.BlockPrivate}
+	if strings.TrimSpace(rs.BlockPorts) != "" {
+		_ = json.Unmarshal([]byte(rs.BlockPorts), &r.BlockPorts)
+	}
+	if strings.TrimSpace(rs.Block) != "" {
+		_ = json.Unmarshal([]byte(rs.Block), &r.Block)
+	}
+	if strings.TrimSpace(rs.Routes) != "" {
+		_ = json.Unmarshal([]byte(rs.Routes), &r.Routes)
+	}
+	return r
+}
+
+// loadExitRules returns the rules of a rule set; nil when unset or empty.
+func loadExitRules(id *int64) *exitRules {
+	if id == nil || *id <= 0 {
+		return nil
+	}
+	var rs model.ExitRuleSet
+	if err := dbpkg.DB.First(&rs, *id).Error; err != nil {
+		return nil
+	}
+	r := parseExitRuleSet(rs)
+	if r.empty() {
+		return nil
+	}
+	return &r
+}
+
+// validExitRuleSetID checks a rule set reference from set-exit; 0 clears it.
+func validExitRuleSetID(id int64) bool {
+	if id == 0 {
+		return true
+	}
+	var cnt int64
+	dbpkg.DB.Model(&model.ExitRuleSet{}).Where("id = ?", id).Count(&cnt)
+	return cnt > 0
+}
+
+// applyExitRulesToSS adds blocking (service bypass) and routing (chain with
+// one node per route, each admitting only its matches) to an SS exit service.
+// The gost objects travel with the service as _bypasses/_chains.
+func applyExitRulesToSS(svc map[string]any, name string, r *exitRules) {
+	if r == nil {
+		return
+	}
+	var bypasses []any
+	block := append([]string{}, r.Block...)
+	if r.BlockPrivate {
+		block = append(block, exitPrivateMatchers...)
+	}
+	for _, p := range r.BlockPorts {
+		block = append(block, "*:"+p)
+	}
+	if len(block) > 0 {
+		bp := "bypass_" + name
+		bypasses = append(bypasses, map[string]any{"name": bp, "matchers": block})
+		svc["bypass"] = bp
+	}
+	if len(r.Routes) > 0 {
+		var nodes []any
+		var all []string
+		for i, rt := range r.Routes {
+			bp := fmt.Sprintf("bypass_%s_route_%d", name, i)
+			bypasses = append(bypasses, map[string]any{"name": bp, "whitelist": true, "matchers": rt.Match})
+			all = append(all, rt.Match...)
+			node := map[string]any{"name": fmt.Sprintf("%s_route_%d", name, i), "bypass": bp}
+			if rt.Socks5 != "" {
+				u, _ := url.Parse(rt.Socks5)
+				connector := map[string]any{"type": "socks5"}
+				if u.User != nil {
+					pass, _ := u.User.Password()
+					connector["auth"] = map[string]any{"username": u.User.Username(), "password": pass}
+				}
+				node["addr"] = u.Host
+				node["connector"] = connector
+				node["dialer"] = map[string]any{"type": "tcp"}
+			} else {
+				// virtual node: dial directly from the alternate address
+				node["addr"] = ":0"
+				node["interface"] = rt.ExitIP
+				node["connector"] = map[string]any{"type": "direct"}
+				node["dialer"] = map[string]any{"type": "direct"}
+			}
+			nodes = append(nodes, node)
+		}
+		// destinations matching no route skip the hop and go out directly
+		hopBypass := "bypass_" + name + "_routes"
+		bypasses = append(bypasses, map[string]any{"name": hopBypass, "whitelist": true, "matchers": all})
+		chainName := "chain_" + name
+		svc["_chains"] = []any{map[string]any{"name": chainName, "hops": []any{map[string]any{"name": "hop_" + name, "bypass": hopBypass, "nodes": nodes}}}}
+		if h, ok := svc["handler"].(map[string]any); ok {
+			h["chain"] = chainName
+		}
+	}
+	if len(bypasses) > 0 {
+		svc["_bypasses"] = bypasses
+	}
+}
+
+// exitRuleSetUsers returns the AnyTLS and SS exit nodes using a rule set.
+func exitRuleSetUsers(id int64) (anytlsNodes, ssNodes []int64) {
+	dbpkg.DB.Model(&model.AnyTLSSetting{}).Where("rule_set_id = ?", id).Order("node_id asc").Pluck("node_id", &anytlsNodes)
+	dbpkg.DB.Model(&model.ExitSetting{}).Where("rule_set_id = ?", id).Order("node_id asc").Pluck("node_id", &ssNodes)
+	return anytlsNodes, ssNodes
+}
+
+// repushExitRuleSet re-applies exits using a rule set after it changed.
+func repushExitRuleSet(anytlsNodes, ssNodes []int64) {
+	for _, id := range anytlsNodes {
+		go pushExitUsersToNode(id)
+	}
+	for _, id := range ssNodes {
+		go pushSSExitToNode(id)
+	}
+}
+
+// ExitRuleSetList 出口出站规则集列表（管理员）
+// @Summary 出口出站规则集列表
+// @Tags node
+// @Produce json
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/node/exit-rules/list [post]
+func ExitRuleSetList(c *gin.Context) {
+	var sets []model.ExitRuleSet
+	dbpkg.DB.Order("name asc").Find(&sets)
+	out := make([]map[string]any, 0, len(sets))
+	for _, rs := range sets {
+		r := parseExitRuleSet(rs)
+		anytlsNodes, ssNodes := exitRuleSetUsers(rs.ID)
+		out = append(out, map[string]any{
+			"id": rs.ID, "name": rs.Name, "description": rs.Description,
+			"blockPrivate": r.BlockPrivate, "blockPorts": r.BlockPorts, "block": r.Block, "routes": r.Routes,
+			"anytlsNodeIds": anytlsNodes, "ssNodeIds": ssNodes,
+			"createdTime": rs.CreatedTime, "updatedTime": rs.UpdatedTime,
+		})
+	}
+	c.JSON(http.StatusOK, response.Ok(out))
+}
+
+type exitRuleSetReq struct {
+	ID           int64       `json:"id"`
+	Name         *string     `json:"name"`
+	Description  *string     `json:"description"`
+	BlockPrivate *bool       `json:"blockPrivate"`
+	BlockPorts   []string    `json:"blockPorts"`
+	Block        []string    `json:"block"`
+	Routes       []exitRoute `json:"routes"`
+}
+
+func (p exitRuleSetReq) applyTo(rs *model.ExitRuleSet) string {
+	if p.Name != nil {
+		rs.Name = strings.TrimSpace(*p.Name)
+	}
+	if rs.Name == "" {
+		return "规则集名称不能为空"
+	}
+	if p.Description != nil {
+		rs.Description = strings.TrimSpace(*p.Description)
+	}
+	r := parseExitRuleSet(*rs)
+	if p.BlockPrivate != nil {
+		r.BlockPrivate = *p.BlockPrivate
+	}
+	if p.BlockPorts != nil {
+		r.BlockPorts = p.BlockPorts
+	}
+	if p.Block != nil {
+		r.Block = p.Block
+	}
+	if p.Routes != nil {
+		r.Routes = p.Routes
+	}
+	if msg := r.normalize(); msg != "" {
+		return msg
+	}
+	rs.BlockPrivate = r.BlockPrivate
+	b, _ := json.Marshal(r.BlockPorts)
+	rs.BlockPorts = string(b)
+	b, _ = json.Marshal(r.Block)
+	rs.Block = string(b)
+	b, _ = json.Marshal(r.Routes)
+	rs.Routes = string(b)
+	var dup int64
+	dbpkg.DB.Model(&model.ExitRuleSet{}).Where("name = ? AND id <> ?", rs.Name, rs.ID).Count(&dup)
+	if dup > 0 {
+		return "规则集名称已存在"
+	}
+	return ""
+}
+
+// ExitRuleSetCreate 创建出口出站规则集（管理员）
+// @Summary 创建出口出站规则集
+// @Tags node
+// @Accept json
+// @Produce json
+// @Param data body object true "{name, description?, blockPrivate?, blockPorts?: [\"25\"], block?: [], routes?: [{match, exitIp | socks5}]}"
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/node/exit-rules/create [post]
+func ExitRuleSetCreate(c *gin.Context) {
+	var p exitRuleSetReq
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	now := time.Now().UnixMilli()
+	rs := model.ExitRuleSet{CreatedTime: now, UpdatedTime: now}
+	if msg := p.applyTo(&rs); msg != "" {
+		c.JSON(http.StatusOK, response.ErrMsg(msg))
+		return
+	}
+	if err := dbpkg.DB.Create(&rs).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("保存失败"))
+		return
+	}
+	c.JSON(http.StatusOK, response.Ok(rs))
+}
+
+// ExitRuleSetUpdate 更新出口出站规则集（管理员），并重新下发到使用它的出口
+// @Summary 更新出口出站规则集
+// @Tags node
+// @Accept json
+// @Produce json
+// @Param data body object true "{id, name?, description?, blockPrivate?, blockPorts?, block?, routes?}"
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/node/exit-rules/update [post]
+func ExitRuleSetUpdate(c *gin.Context) {
+	var p exitRuleSetReq
+	if err := c.ShouldBindJSON(&p); err != nil || p.ID <= 0 {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	var rs model.ExitRuleSet
+	if err := dbpkg.DB.First(&rs, p.ID).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("规则集不存在"))
+		return
+	}
+	if msg := p.applyTo(&rs); msg != "" {
+		c.JSON(http.StatusOK, response.ErrMsg(msg))
+		return
+	}
+	rs.UpdatedTime = time.Now().UnixMilli()
+	if err := dbpkg.DB.Save(&rs).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("保存失败"))
+		return
+	}
+	repushExitRuleSet(exitRuleSetUsers(rs.ID))
+	c.JSON(http.StatusOK, response.Ok(rs))
+}
+
+// ExitRuleSetDelete 删除出口出站规则集（管理员），使用它的出口恢复为不限制
+// @Summary 删除出口出站规则集
+// @Tags node
+// @Accept json
+// @Produce json
+// @Param data body object true "{id}"
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/node/exit-rules/delete [post]
+func ExitRuleSetDelete(c *gin.Context) {
+	var p struct {
+		ID int64 `json:"id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	anytlsNodes, ssNodes := exitRuleSetUsers(p.ID)
+	if err := dbpkg.DB.Delete(&model.ExitRuleSet{}, p.ID).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("删除失败"))
+		return
+	}
+	dbpkg.DB.Model(&model.AnyTLSSetting{}).Where("rule_set_id = ?", p.ID).Update("rule_set_id", nil)
+	dbpkg.DB.Model(&model.ExitSetting{}).Where("rule_set_id = ?", p.ID).Update("rule_set_id", nil)
+	repushExitRuleSet(anytlsNodes, ssNodes)
+	c.JSON(http.StatusOK, response.OkNoData())
+}
@@ -13,14 +13,14 @@ import (
 	dbpkg "network-panel/golang-backend/internal/db"
 
 	"github.com/gin-gonic/gin"
-	"gorm.io/gorm"
 )
 
 func FlowConfig(c *gin.Context) { c.String(http.StatusOK, "ok") }
 func FlowTest(c *gin.Context)   { c.String(http.StatusOK, "test") }
 
 // POST /flow/upload?secret=...
-// Updates forward/user/usertunnel flow counters and pauses when limits exceeded.
+// Queues forward/user/usertunnel flow increments; see flow_buffer.go for the
+// batched write and quota enforcement.
 func FlowUpload(c *gin.Context) {
 
 	secret := c.Query("secret")
@@ -90,101 +90,8 @@ func FlowUpload(c *gin.Context) {
 			c.String(http.StatusOK, "ok")
 			return
 		}
-		// load forward and tunnel
-		var fwd model.Forward
-		if err := dbpkg.DB.First(&fwd, fwdID).Error; err != nil {
-			c.String(http.StatusOK, "ok")
-			return
-		}
-		var tun model.Tunnel
-		_ = dbpkg.DB.First(&tun, fwd.TunnelID).Error
-
-		inInc, outInc := inBytes, outBytes
-		// 配额判断：按单向（取本次入/出中较大的值）
-		quotaInc := inInc
-		if outInc > quotaInc {
-			quotaInc = outInc
-		}
-		now := time.Now()
-		nowCST := now.In(time.FixedZone("UTC+8", 8*3600))
-
-		// apply increments (forward, user, user_tunnel)
-		dbpkg.DB.Model(&model.Forward{}).Where("id = ?", fwdID).
-			Updates(map[string]any{"in_flow": gorm.Expr("in_flow + ?", inInc), "out_flow": gorm.Expr("out_flow + ?", outInc), "updated_time": time.Now().UnixMilli()})
-		dbpkg.DB.Model(&model.User{}).Where("id = ?", fwd.UserID).
-			Updates(map[string]any{"in_flow": gorm.Expr("in_flow + ?", inInc), "out_flow": gorm.Expr("out_flow + ?", outInc), "updated_time": time.Now().UnixMilli()})
-		// user_tunnel
-		var ut model.UserTunnel
-		if err := dbpkg.DB.Where("user_id=? and tunnel_id=?", fwd.UserID, fwd.TunnelID).First(&ut).Error; err == nil && ut.ID > 0 {
-			dbpkg.DB.Model(&model.UserTunnel{}).Where("id = ?", ut.ID).
-				Updates(map[string]any{"in_flow": gorm.Expr("in_flow + ?", inInc), "out_flow": gorm.Expr("out_flow + ?", outInc)})
-		}
-		// user_node (entry node) flow
-		var un model.UserNode
-		if err := dbpkg.DB.Where("user_id=? AND node_id=?", fwd.UserID, tun.InNodeID).First(&un).Error; err == nil && un.ID > 0 {
-			dbpkg.DB.Model(&model.UserNode{}).Where("id = ?", un.ID).
-				Updates(map[string]any{"in_flow": gorm.Expr("in_flow + ?", inInc), "out_flow": gorm.Expr("out_flow + ?", outInc)})
-			un.InFlow += inInc
-			un.OutFlow += outInc
-			if overUserNodeLimit(un) || expired(un.ExpTime) || un.Status != 1 {
-				un.Status = 0
-				_ = dbpkg.DB.Save(&un).Error
-				pauseUserNodeForwards(un.UserID, un.NodeID)
-				go pushAnyTLSConfigToNode(un.NodeID)
-			}
-		}
-		// 24h statistics (per user, bucket by hour). For single-flow tunnel, count max(in,out); else sum.
-		func() {
-			calc := inInc + outInc
-			if tun.Flow == 1 {
-				if outInc > inInc {
-					calc = outInc
-				} else {
-					calc = inInc
-				}
-			}
-			// bucket key: MM-DD HH:00 (UTC+8)
-			now := nowCST
-			hourKey := now.Format("01-02 15:00")
-			var rec model.StatisticsFlow
-			if err := dbpkg.DB.Where("user_id = ? AND time = ?", fwd.UserID, hourKey).First(&rec).Error; err == nil && rec.ID > 0 {
-				dbpkg.DB.Model(&model.StatisticsFlow{}).Where("id = ?", rec.ID).
-					Updates(map[string]any{"flow": gorm.Expr("flow + ?", calc), "total_flow": gorm.Expr("total_flow + ?", calc)})
-			} else {
-				rec = model.StatisticsFlow{UserID: fwd.UserID, Flow: calc, TotalFlow: calc, Time: hourKey, CreatedTime: now.UnixMilli()}
-				_ = dbpkg.DB.Create(&rec).Error
-			}
-			// append to timeseries for precise 24h chart
-			_ = dbpkg.DB.Create(&model.FlowTimeseries{
-				UserID:      fwd.UserID,
-				InBytes:     inInc,
-				OutBytes:    outInc,
-				BilledBytes: calc,
-				Source:      "gost",
-				TimeMs:      now.UnixMilli(),
-				CreatedTime: now.UnixMilli(),
-			}).Error
-		}()
-
-		// limits：仅在配额判断时使用单向增量估算
-		var user model.User
-		if err := dbpkg.DB.First(&user, fwd.UserID).Error; err == nil {
-			limit := user.Flow * 1024 * 1024 * 1024
-			used := user.InFlow + user.OutFlow
-			projected := used + quotaInc - (inInc + outInc)
-			if (limit > 0 && projected > limit) || expired(user.ExpTime) || (user.Status != nil && *user.Status != 1) {
-				pauseAllUserForwards(user.ID)
-				s := 0
-				user.Status = &s
-				_ = dbpkg.DB.Save(&user).Error
-			}
-		}
-		if ut.ID != 0 {
-			if overUTunnelLimit(ut) || expired(ut.ExpTime) || ut.Status != 1 {
-				pauseUserTunnelForwards(ut.UserID, ut.TunnelID)
-				ut.Status = 0
-				_ = dbpkg.DB.Save(&ut).Error
-			}
+		if rec, ok, _ := forwardFlowRecord(fwdID, 0, inBytes, outBytes); ok {
+			bufferFlow(rec)
 		}
 		c.String(http.StatusOK, "ok")
 		return
@@ -207,92 +114,8 @@ func FlowUpload(c *gin.Context) {
 	}
 	fwdID, _ := strconv.ParseInt(parts[0], 10, 64)
 	userID, _ := strconv.ParseInt(parts[1], 10, 64)
-	utID, _ := strconv.ParseInt(parts[2], 10, 64)
-	var fwd model.Forward
-	if err := dbpkg.DB.First(&fwd, fwdID).Error; err != nil {
-		c.String(http.StatusOK, "ok")
-		return
-	}
-	var tun model.Tunnel
-	_ = dbpkg.DB.First(&tun, fwd.TunnelID).Error
-	inInc, outInc := payload.U, payload.D
-	quotaInc := inInc
-	if outInc > quotaInc {
-		quotaInc = outInc
-	}
-	now := time.Now()
-	nowCST := now.In(time.FixedZone("UTC+8", 8*3600))
-	dbpkg.DB.Model(&model.Forward{}).Where("id = ?", fwdID).Updates(map[string]any{"in_flow": gorm.Expr("in_flow + ?", inInc), "out_flow": gorm.Expr("out_flow + ?", outInc), "updated_time": time.Now().UnixMilli()})
-	dbpkg.DB.Model(&model.User{}).Where("id = ?", userID).Updates(map[string]any{"in_flow": gorm.Expr("in_flow + ?", inInc), "out_flow": gorm.Expr("out_flow + ?", outInc), "updated_time": time.Now().UnixMilli()})
-	// user_node (entry node) flow for legacy payloads
-	var un model.UserNode
-	if err := dbpkg.DB.Where("user_id=? AND node_id=?", userID, tun.InNodeID).First(&un).Error; err == nil && un.ID > 0 {
-		dbpkg.DB.Model(&model.UserNode{}).Where("id = ?", un.ID).
-			Updates(map[string]any{"in_flow": gorm.Expr("in_flow + ?", inInc), "out_flow": gorm.Expr("out_flow + ?", outInc)})
-		un.InFlow += inInc
-		un.OutFlow += outInc
-		if overUserNodeLimit(un) || expired(un.ExpTime) || un.Status != 1 {
-			un.Status = 0
-			_ = dbpkg.DB.Save(&un).Error
-			pauseUserNodeForwards(un.UserID, un.NodeID)
-			go pushAnyTLSConfigToNode(un.NodeID)
-		}
-	}
-	if utID != 0 {
-		dbpkg.DB.Model(&model.UserTunnel{}).Where("id = ?", utID).Updates(map[string]any{"in_flow": gorm.Expr("in_flow + ?", inInc), "out_flow": gorm.Expr("out_flow + ?", outInc)})
-	}
-	// 24h statistics bucket update (same rule as above)
-	func() {
-		calc := inInc + outInc
-		if tun.Flow == 1 {
-			if outInc > inInc {
-				calc = outInc
-			} else {
-				calc = inInc
-			}
-		}
-		now := nowCST
-		hourKey := now.Format("01-02 15:00")
-		var rec model.StatisticsFlow
-		if err := dbpkg.DB.Where("user_id = ? AND time = ?", userID, hourKey).First(&rec).Error; err == nil && rec.ID > 0 {
-			dbpkg.DB.Model(&model.StatisticsFlow{}).Where("id = ?", rec.ID).
-				Updates(map[string]any{"flow": gorm.Expr("flow + ?", calc), "total_flow": gorm.Expr("total_flow + ?", calc)})
-		} else {
-			rec = model.StatisticsFlow{UserID: userID, Flow: calc, TotalFlow: calc, Time: hourKey, CreatedTime: now.UnixMilli()}
-			_ = dbpkg.DB.Create(&rec).Error
-		}
-		_ = dbpkg.DB.Create(&model.FlowTimeseries{
-			UserID:      userID,
-			InBytes:     inInc,
-			OutBytes:    outInc,
-			BilledBytes: calc,
-			Source:      "gost",
-			TimeMs:      now.UnixMilli(),
-			CreatedTime: now.UnixMilli(),
-		}).Error
-	}()
-
-	var user model.User
-	if err := dbpkg.DB.First(&user, userID).Error; err == nil {
-		limit := user.Flow * 1024 * 1024 * 1024
-		used := user.InFlow + user.OutFlow
-		projected := used + quotaInc - (inInc + outInc)
-		if (limit > 0 && projected > limit) || expired(user.ExpTime) || (user.Status != nil && *user.Status != 1) {
-			pauseAllUserForwards(user.ID)
-			s := 0
-			user.Status = &s
-			_ = dbpkg.DB.Save(&user).Error
-		}
-	}
-	if utID != 0 {
-		var ut model.UserTunnel
-		if err := dbpkg.DB.First(&ut, utID).Error; err == nil {
-			if overUTunnelLimit(ut) || expired(ut.ExpTime) || ut.Status != 1 {
-				pauseUserTunnelForwards(ut.UserID, ut.TunnelID)
-				ut.Status = 0
-				_ = dbpkg.DB.Save(&ut).Error
-			}
-		}
+	if rec, ok, _ := forwardFlowRecord(fwdID, userID, payload.U, payload.D); ok {
+		bufferFlow(rec)
 	}
 	c.String(http.StatusOK, "ok")
 }
@@ -0,0 +1,114 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"network-panel/golang-backend/internal/app/model"
+	dbpkg "network-panel/golang-backend/internal/db"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type flowBatchEntry struct {
+	Kind      string `json:"kind"` // anytls | native | forward | exit
+	UserID    int64  `json:"userId"`
+	ForwardID int64  `json:"forwardId"`
+	InBytes   int64  `json:"inBytes"`
+	OutBytes  int64  `json:"outBytes"`
+}
+
+// POST /flow/batch?secret=...
+// Spooled traffic from flux-agent: {source, seq, timeMs, entries:[{kind, userId, forwardId, inBytes, outBytes}]}.
+// Agents resend a batch until it is acknowledged, so a sequence at or below the
+// last accepted one for (node, source) is acknowledged without booking it again.
+// The reply waits for the flow buffer to commit the batch; a non-2xx reply
+// makes the agent keep the batch and retry.
+func FlowBatchUpload(c *gin.Context) {
+	secret := strings.TrimSpace(c.Query("secret"))
+	if secret == "" {
+		c.String(http.StatusOK, "ok")
+		return
+	}
+	var node model.Node
+	if err := dbpkg.DB.Select("id").Where("secret = ?", secret).First(&node).Error; err != nil || node.ID == 0 {
+		// unknown secret: acknowledge so the agent drops the batch; other
+		// database errors are retried
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			c.String(http.StatusInternalServerError, "retry")
+			return
+		}
+		c.String(http.StatusOK, "ok")
+		return
+	}
+	var req struct {
+		Source  string           `json:"source"`
+		Seq     int64            `json:"seq"`
+		Entries []flowBatchEntry `json:"entries"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Seq <= 0 {
+		c.String(http.StatusOK, "ok")
+		return
+	}
+	source := strings.TrimSpace(req.Source)
+	if source == "" {
+		source = "agent1"
+	}
+	if len(source) > 16 {
+		source = source[:16]
+	}
+	recs := make([]flowRecord, 0, len(req.Entries))
+	for _, e := range req.Entries {
+		if e.InBytes < 0 {
+			e.InBytes = 0
+		}
+		if e.OutBytes < 0 {
+			e.OutBytes = 0
+		}
+		if e.InBytes == 0 && e.OutBytes == 0 {
+			continue
+		}
+		switch e.Kind {
+		case "anytls":
+			if e.UserID > 0 {
+				recs = append(recs, anytlsFlowRecord(node.ID, e.UserID, e.InBytes, e.OutBytes))
+			}
+		case "native":
+			if e.UserID > 0 {
+				recs = append(recs, nativeExitFlowRecord(node.ID, e.UserID, e.InBytes, e.OutBytes))
+			}
+		case "forward":
+			rec, ok, err := forwardFlowRecord(e.ForwardID, 0, e.InBytes, e.OutBytes)
+			if err != nil {
+				// the seq is not marked yet, so the retried batch is booked in full
+				c.String(http.StatusInternalServerError, "retry")
+				return
+			}
+			if ok {
+				recs = append(recs, rec)
+			}
+		case "exit":
+			if e.UserID > 0 {
+				recs = append(recs, exitFlowRecord(node.ID, e.UserID, e.InBytes, e.OutBytes))
+			}
+		}
+	}
+	fresh, gen, err := bufferFlowBatch(node.ID, source, req.Seq, recs)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "retry")
+		return
+	}
+	// acknowledge only once the batch is in the database
+	if !waitFlowCommit(gen, 5*time.Second) {
+		c.String(http.StatusServiceUnavailable, "retry")
+		return
+	}
+	if !fresh {
+		c.String(http.StatusOK, "dup")
+		return
+	}
+	c.String(http.StatusOK, "ok")
+}
@@ -0,0 +1,666 @@
+package controller
+
+import (
+	"errors"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"network-panel/golang-backend/internal/app/model"
+	dbpkg "network-panel/golang-backend/internal/db"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Flow accounting buffer.
+//
+// Flow reports (gost observers, AnyTLS, exit and spooled agent batches) are
+// turned into flowRecords and summed in memory per entity. Every FLOW_FLUSH_MS
+// (default 2000) the coalesced deltas for forward, user, user_tunnel,
+// user_node and statistics_flow, one flow_timeseries row per user and source,
+// and the agent batch sequence marks are written in a single transaction.
+// Quotas of the entities that received traffic are checked right after the
+// commit, so a user over the limit is paused within one flush interval.
+// Observer, AnyTLS and exit reports are acknowledged on enqueue; the buffer is
+// written out by FlushFlowBuffer before the server exits or restarts.
+
+// flowRecord is one traffic report resolved to the rows it is booked on.
+type flowRecord struct {
+	source    string // flow_timeseries source: gost | anytls | native
+	userID    int64
+	forwardID int64
+	tunnelID  int64 // user_tunnel (userID, tunnelID); 0 = none
+	nodeID    int64 // user_node (userID, nodeID); 0 = none
+	createUN  bool  // create the user_node row when missing
+	in, out   int64
+	billed    int64 // statistics_flow / timeseries billed bytes
+	hour      string
+	// quota handling after commit
+	checkUser bool
+	checkUN   bool
+	checkUT   bool
+	// subtracted from the user's usage when checking the quota; forward
+	// traffic is judged by its larger direction only
+	quotaDiscount int64
+	// also disable the user's user_node when the user is over quota (AnyTLS)
+	disableUN bool
+}
+
+type flowInOut struct{ in, out int64 }
+
+type flowUNKey struct{ userID, nodeID int64 }
+type flowUTKey struct{ userID, tunnelID int64 }
+type flowStatKey struct {
+	userID int64
+	hour   string
+}
+type flowSeriesKey struct {
+	userID int64
+	source string
+}
+type flowSeqKey struct {
+	nodeID int64
+	source string
+}
+
+type flowPending struct {
+	forwards    map[int64]*flowInOut
+	users       map[int64]*flowInOut
+	userTunnels map[flowUTKey]*flowInOut
+	userNodes   map[flowUNKey]*flowInOut
+	createUN    map[flowUNKey]bool
+	stats       map[flowStatKey]int64
+	series      map[flowSeriesKey]*[3]int64 // in, out, billed
+	seqs        map[flowSeqKey]int64
+	checkUsers  map[int64]int64 // userID -> quota discount
+	checkUNs    map[flowUNKey]bool
+	checkUTs    map[flowUTKey]bool
+	disableUNs  map[flowUNKey]bool
+	records     int
+}
+
+func newFlowPending() *flowPending {
+	return &flowPending{
+		forwards:    map[int64]*flowInOut{},
+		users:       map[int64]*flowInOut{},
+		userTunnels: map[flowUTKey]*flowInOut{},
+		userNodes:   map[flowUNKey]*flowInOut{},
+		createUN:    map[flowUNKey]bool{},
+		stats:       map[flowStatKey]int64{},
+		series:      map[flowSeriesKey]*[3]int64{},
+		seqs:        map[flowSeqKey]int64{},
+		checkUsers:  map[int64]int64{},
+		checkUNs:    map[flowUNKey]bool{},
+		checkUTs:    map[flowUTKey]bool{},
+		disableUNs:  map[flowUNKey]bool{},
+	}
+}
+
+var (
+	flowBufMu sync.Mutex
+	flowBuf   = newFlowPending()
+	// generation being filled; flowCommitted is the last one written to the DB
+	flowGen       uint64 = 1
+	flowCommitted uint64
+	flowCommitCh  = make(chan struct{})
+	// highest batch sequence accepted per (node, source), committed or not
+	flowSeqHWM = map[flowSeqKey]int64{}
+	// serializes flushes (ticker and shutdown)
+	flowFlushMu sync.Mutex
+)
+
+func init() {
+	go flowFlusher()
+}
+
+func flowFlusher() {
+	ms := 2000
+	if v := os.Getenv("FLOW_FLUSH_MS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 100 {
+			ms = n
+		}
+	}
+	ticker := time.NewTicker(time.Duration(ms) * time.Millisecond)
+	defer ticker.Stop()
+	for range ticker.C {
+		flushFlowBuffer()
+	}
+}
+
+func addFlowInOut[K comparable](m map[K]*flowInOut, k K, in, out int64) {
+	d := m[k]
+	if d == nil {
+		d = &flowInOut{}
+		m[k] = d
+	}
+	d.in += in
+	d.out += out
+}
+
+func (p *flowPending) add(r flowRecord) {
+	p.records++
+	if r.forwardID > 0 {
+		addFlowInOut(p.forwards, r.forwardID, r.in, r.out)
+	}
+	if r.userID <= 0 {
+		return
+	}
+	addFlowInOut(p.users, r.userID, r.in, r.out)
+	if r.tunnelID > 0 {
+		k := flowUTKey{r.userID, r.tunnelID}
+		addFlowInOut(p.userTunnels, k, r.in, r.out)
+		if r.checkUT {
+			p.checkUTs[k] = true
+		}
+	}
+	if r.nodeID > 0 {
+		k := flowUNKey{r.userID, r.nodeID}
+		addFlowInOut(p.userNodes, k, r.in, r.out)
+		if r.createUN {
+			p.createUN[k] = true
+		}
+		if r.checkUN {
+			p.checkUNs[k] = true
+		}
+		if r.disableUN {
+			p.disableUNs[k] = true
+		}
+	}
+	if r.billed > 0 {
+		p.stats[flowStatKey{r.userID, r.hour}] += r.billed
+	}
+	sk := flowSeriesKey{r.userID, r.source}
+	s := p.series[sk]
+	if s == nil {
+		s = &[3]int64{}
+		p.series[sk] = s
+	}
+	s[0] += r.in
+	s[1] += r.out
+	s[2] += r.billed
+	if r.checkUser {
+		p.checkUsers[r.userID] += r.quotaDiscount
+	}
+}
+
+// merge folds a batch that failed to commit back into p.
+func (p *flowPending) merge(o *flowPending) {
+	for k, d := range o.forwards {
+		addFlowInOut(p.forwards, k, d.in, d.out)
+	}
+	for k, d := range o.users {
+		addFlowInOut(p.users, k, d.in, d.out)
+	}
+	for k, d := range o.userTunnels {
+		addFlowInOut(p.userTunnels, k, d.in, d.out)
+	}
+	for k, d := range o.userNodes {
+		addFlowInOut(p.userNodes, k, d.in, d.out)
+	}
+	for k, v := range o.stats {
+		p.stats[k] += v
+	}
+	for k, s := range o.series {
+		t := p.series[k]
+		if t == nil {
+			t = &[3]int64{}
+			p.series[k] = t
+		}
+		t[0] += s[0]
+		t[1] += s[1]
+		t[2] += s[2]
+	}
+	for k, v := range o.seqs {
+		if v > p.seqs[k] {
+			p.seqs[k] = v
+		}
+	}
+	for k, v := range o.checkUsers {
+		p.checkUsers[k] += v
+	}
+	for k := range o.createUN {
+		p.createUN[k] = true
+	}
+	for k := range o.checkUNs {
+		p.checkUNs[k] = true
+	}
+	for k := range o.checkUTs {
+		p.checkUTs[k] = true
+	}
+	for k := range o.disableUNs {
+		p.disableUNs[k] = true
+	}
+	p.records += o.records
+}
+
+func (p *flowPending) empty() bool {
+	return p.records == 0 && len(p.seqs) == 0
+}
+
+// bufferFlow queues records and returns the generation they will be committed in.
+func bufferFlow(recs ...flowRecord) uint64 {
+	flowBufMu.Lock()
+	defer flowBufMu.Unlock()
+	for _, r := range recs {
+		flowBuf.add(r)
+	}
+	return flowGen
+}
+
+// bufferFlowBatch queues an agent batch unless (node, source, seq) was already
+// accepted. The sequence mark is committed in the same transaction as the
+// traffic, so a batch is either fully booked or retried by the agent.
+func bufferFlowBatch(nodeID int64, source string, seq int64, recs []flowRecord) (bool, uint64, error) {
+	k := flowSeqKey{nodeID, source}
+	flowBufMu.Lock()
+	_, known := flowSeqHWM[k]
+	flowBufMu.Unlock()
+	if !known {
+		var cur model.FlowReportSeq
+		last := int64(0)
+		if err := dbpkg.DB.Where("node_id = ? AND source = ?", nodeID, source).Limit(1).Find(&cur).Error; err != nil {
+			return false, 0, err
+		}
+		if cur.NodeID > 0 {
+			last = cur.LastSeq
+		}
+		flowBufMu.Lock()
+		if cur, ok := flowSeqHWM[k]; !ok || last > cur {
+			flowSeqHWM[k] = last
+		}
+		flowBufMu.Unlock()
+	}
+	flowBufMu.Lock()
+	defer flowBufMu.Unlock()
+	if seq <= flowSeqHWM[k] {
+		return false, flowGen, nil
+	}
+	flowSeqHWM[k] = seq
+	flowBuf.seqs[k] = seq
+	for _, r := range recs {
+		flowBuf.add(r)
+	}
+	return true, flowGen, nil
+}
+
+// waitFlowCommit blocks until generation gen is in the database.
+func waitFlowCommit(gen uint64, timeout time.Duration) bool {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		flowBufMu.Lock()
+		done := flowCommitted >= gen
+		ch := flowCommitCh
+		flowBufMu.Unlock()
+		if done {
+			return true
+		}
+		select {
+		case <-ch:
+		case <-deadline.C:
+			return false
+		}
+	}
+}
+
+func flowPendingDepth() int {
+	flowBufMu.Lock()
+	defer flowBufMu.Unlock()
+	return flowBuf.records
+}
+
+func flushFlowBuffer() {
+	flowFlushMu.Lock()
+	defer flowFlushMu.Unlock()
+	flowBufMu.Lock()
+	if flowBuf.empty() {
+		// everything accepted so far is in the database
+		if flowCommitted < flowGen {
+			flowCommitted = flowGen
+			flowGen++
+			close(flowCommitCh)
+			flowCommitCh = make(chan struct{})
+		}
+		flowBufMu.Unlock()
+		return
+	}
+	p := flowBuf
+	gen := flowGen
+	flowBuf = newFlowPending()
+	flowGen++
+	flowBufMu.Unlock()
+
+	start := time.Now()
+	if err := commitFlowPending(p); err != nil {
+		log.Printf("flow flush failed, will retry: %v", err)
+		flowBufMu.Lock()
+		flowBuf.merge(p)
+		flowBufMu.Unlock()
+		return
+	}
+	recordFlush(start, map[string]int{"flow": p.records})
+	flowBufMu.Lock()
+	flowCommitted = gen
+	close(flowCommitCh)
+	flowCommitCh = make(chan struct{})
+	flowBufMu.Unlock()
+
+	enforceFlowQuotas(p)
+}
+
+// FlushFlowBuffer writes everything buffered so far, retrying a failed commit
+// for a few seconds (the launcher kills the server 5s after SIGTERM). Call it
+// before the process exits.
+func FlushFlowBuffer() {
+	for i := 0; i < 3; i++ {
+		flushFlowBuffer()
+		flowBufMu.Lock()
+		done := flowBuf.empty()
+		flowBufMu.Unlock()
+		if done {
+			return
+		}
+		time.Sleep(time.Second)
+	}
+	log.Printf("flow flush on shutdown failed, %d records lost", flowPendingDepth())
+}
+
+func sortedFlowKeys[K comparable, V any](m map[K]V, less func(a, b K) bool) []K {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return less(keys[i], keys[j]) })
+	return keys
+}
+
+func lessInt64(a, b int64) bool { return a < b }
+func lessUN(a, b flowUNKey) bool {
+	return a.userID < b.userID || (a.userID == b.userID && a.nodeID < b.nodeID)
+}
+func lessUT(a, b flowUTKey) bool {
+	return a.userID < b.userID || (a.userID == b.userID && a.tunnelID < b.tunnelID)
+}
+
+func flowIncr(d *flowInOut) map[string]any {
+	return map[string]any{"in_flow": gorm.Expr("in_flow + ?", d.in), "out_flow": gorm.Expr("out_flow + ?", d.out)}
+}
+
+// commitFlowPending writes one coalesced batch. Rows are touched in key order
+// so concurrent writers (traffic reset, user edits) do not deadlock with it.
+func commitFlowPending(p *flowPending) error {
+	nowMs := time.Now().UnixMilli()
+	return dbpkg.DB.Transaction(func(tx *gorm.DB) error {
+		for _, id := range sortedFlowKeys(p.forwards, lessInt64) {
+			d := p.forwards[id]
+			upd := flowIncr(d)
+			upd["updated_time"] = nowMs
+			if err := tx.Model(&model.Forward{}).Where("id = ?", id).Updates(upd).Error; err != nil {
+				return err
+			}
+		}
+		for _, id := range sortedFlowKeys(p.users, lessInt64) {
+			d := p.users[id]
+			upd := flowIncr(d)
+			upd["updated_time"] = nowMs
+			if err := tx.Model(&model.User{}).Where("id = ?", id).Updates(upd).Error; err != nil {
+				return err
+			}
+		}
+		for _, k := range sortedFlowKeys(p.userTunnels, lessUT) {
+			if err := tx.Model(&model.UserTunnel{}).Where("user_id = ? AND tunnel_id = ?", k.userID, k.tunnelID).
+				Updates(flowIncr(p.userTunnels[k])).Error; err != nil {
+				return err
+			}
+		}
+		for _, k := range sortedFlowKeys(p.userNodes, lessUN) {
+			d := p.userNodes[k]
+			if d.in == 0 && d.out == 0 {
+				continue
+			}
+			res := tx.Model(&model.UserNode{}).Where("user_id = ? AND node_id = ?", k.userID, k.nodeID).Updates(flowIncr(d))
+			if res.Error != nil {
+				return res.Error
+			}
+			if res.RowsAffected == 0 && p.createUN[k] {
+				if err := tx.Create(&model.UserNode{UserID: k.userID, NodeID: k.nodeID, InFlow: d.in, OutFlow: d.out, Status: 1}).Error; err != nil {
+					return err
+				}
+			}
+		}
+		statKeys := sortedFlowKeys(p.stats, func(a, b flowStatKey) bool {
+			return a.userID < b.userID || (a.userID == b.userID && a.hour < b.hour)
+		})
+		for _, k := range statKeys {
+			v := p.stats[k]
+			res := tx.Model(&model.StatisticsFlow{}).Where("user_id = ? AND time = ?", k.userID, k.hour).
+				Updates(map[string]any{"flow": gorm.Expr("flow + ?", v), "total_flow": gorm.Expr("total_flow + ?", v)})
+			if res.Error != nil {
+				return res.Error
+			}
+			if res.RowsAffected == 0 {
+				if err := tx.Create(&model.StatisticsFlow{UserID: k.userID, Flow: v, TotalFlow: v, Time: k.hour, CreatedTime: nowMs}).Error; err != nil {
+					return err
+				}
+			}
+		}
+		if len(p.series) > 0 {
+			rows := make([]model.FlowTimeseries, 0, len(p.series))
+			for k, s := range p.series {
+				rows = append(rows, model.FlowTimeseries{UserID: k.userID, InBytes: s[0], OutBytes: s[1], BilledBytes: s[2], Source: k.source, TimeMs: nowMs, CreatedTime: nowMs})
+			}
+			if err := tx.CreateInBatches(&rows, 200).Error; err != nil {
+				return err
+			}
+		}
+		if len(p.seqs) > 0 {
+			rows := make([]model.FlowReportSeq, 0, len(p.seqs))
+			for k, v := range p.seqs {
+				rows = append(rows, model.FlowReportSeq{NodeID: k.nodeID, Source: k.source, LastSeq: v, UpdatedTime: nowMs})
+			}
+			if err := tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "node_id"}, {Name: "source"}},
+				DoUpdates: clause.AssignmentColumns([]string{"last_seq", "updated_time"}),
+			}).Create(&rows).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// enforceFlowQuotas pauses users, user_nodes and user_tunnels that received
+// traffic in the committed batch and are now over quota, expired or disabled.
+func enforceFlowQuotas(p *flowPending) {
+	pushNodes := map[int64]bool{}
+	if len(p.checkUsers) > 0 {
+		ids := sortedFlowKeys(p.checkUsers, lessInt64)
+		var users []model.User
+		dbpkg.DB.Where("id IN ?", ids).Find(&users)
+		for _, u := range users {
+			limit := u.Flow * 1024 * 1024 * 1024
+			used := u.InFlow + u.OutFlow - p.checkUsers[u.ID]
+			if (limit > 0 && used > limit) || expired(u.ExpTime) || (u.Status != nil && *u.Status != 1) {
+				pauseAllUserForwards(u.ID)
+				dbpkg.DB.Model(&model.User{}).Where("id = ?", u.ID).Update("status", 0)
+				for k := range p.disableUNs {
+					if k.userID == u.ID {
+						dbpkg.DB.Model(&model.UserNode{}).Where("user_id = ? AND node_id = ?", k.userID, k.nodeID).Update("status", 0)
+						pushNodes[k.nodeID] = true
+					}
+				}
+			}
+		}
+	}
+	if len(p.checkUNs) > 0 {
+		uids := map[int64]bool{}
+		for k := range p.checkUNs {
+			uids[k.userID] = true
+		}
+		var uns []model.UserNode
+		dbpkg.DB.Where("user_id IN ?", sortedFlowKeys(uids, lessInt64)).Find(&uns)
+		for _, un := range uns {
+			if !p.checkUNs[flowUNKey{un.UserID, un.NodeID}] {
+				continue
+			}
+			if overUserNodeLimit(un) || expired(un.ExpTime) || un.Status != 1 {
+				dbpkg.DB.Model(&model.UserNode{}).Where("id = ?", un.ID).Update("status", 0)
+				pauseUserNodeForwards(un.UserID, un.NodeID)
+				pushNodes[un.NodeID] = true
+			}
+		}
+	}
+	if len(p.checkUTs) > 0 {
+		uids := map[int64]bool{}
+		for k := range p.checkUTs {
+			uids[k.userID] = true
+		}
+		var uts []model.UserTunnel
+		dbpkg.DB.Where("user_id IN ?", sortedFlowKeys(uids, lessInt64)).Find(&uts)
+		for _, ut := range uts {
+			if !p.checkUTs[flowUTKey{ut.UserID, ut.TunnelID}] {
+				continue
+			}
+			if overUTunnelLimit(ut) || expired(ut.ExpTime) || ut.Status != 1 {
+				pauseUserTunnelForwards(ut.UserID, ut.TunnelID)
+				dbpkg.DB.Model(&model.UserTunnel{}).Where("id = ?", ut.ID).Update("status", 0)
+			}
+		}
+	}
+	for nodeID := range pushNodes {
+		go pushExitUsersToNode(nodeID)
+	}
+}
+
+// ---- record builders ----
+
+func flowHourKey(t time.Time) string {
+	return t.In(time.FixedZone("UTC+8", 8*3600)).Format("01-02 15:00")
+}
+
+type flowForwardMeta struct {
+	ok       bool
+	userID   int64
+	tunnelID int64
+	inNodeID int64
+	single   bool // tunnel.flow == 1: bill the larger direction only
+	at       int64
+}
+
+var (
+	flowFwdMetaMu sync.Mutex
+	flowFwdMeta   = map[int64]flowForwardMeta{}
+)
+
+// forwardMeta caches the forward/tunnel fields needed to book traffic; reports
+// arrive every few seconds per forward, edits are picked up within 30s. A
+// missing forward is cached as !ok; database errors are returned uncached.
+func forwardMeta(fwdID int64) (flowForwardMeta, error) {
+	now := time.Now().UnixMilli()
+	flowFwdMetaMu.Lock()
+	m, hit := flowFwdMeta[fwdID]
+	flowFwdMetaMu.Unlock()
+	if hit && now-m.at < 30_000 {
+		return m, nil
+	}
+	m = flowForwardMeta{at: now}
+	var fwd model.Forward
+	if err := dbpkg.DB.First(&fwd, fwdID).Error; err == nil {
+		var tun model.Tunnel
+		if err := dbpkg.DB.First(&tun, fwd.TunnelID).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return flowForwardMeta{}, err
+		}
+		m.ok = true
+		m.userID = fwd.UserID
+		m.tunnelID = fwd.TunnelID
+		m.inNodeID = tun.InNodeID
+		m.single = tun.Flow == 1
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return flowForwardMeta{}, err
+	}
+	flowFwdMetaMu.Lock()
+	if len(flowFwdMeta) > 100000 {
+		flowFwdMeta = map[int64]flowForwardMeta{}
+	}
+	flowFwdMeta[fwdID] = m
+	flowFwdMetaMu.Unlock()
+	return m, nil
+}
+
+// forwardFlowRecord books observer traffic of one forward onto the forward,
+// its user, user_tunnel and entry user_node. userID overrides the forward's
+// owner (legacy payloads carry it in the service name). ok is false when the
+// forward no longer exists.
+func forwardFlowRecord(fwdID, userID int64, in, out int64) (rec flowRecord, ok bool, err error) {
+	m, err := forwardMeta(fwdID)
+	if err != nil || !m.ok {
+		return flowRecord{}, false, err
+	}
+	if userID <= 0 {
+		userID = m.userID
+	}
+	larger := max64(in, out)
+	billed := in + out
+	if m.single {
+		billed = larger
+	}
+	return flowRecord{
+		source:        "gost",
+		userID:        userID,
+		forwardID:     fwdID,
+		tunnelID:      m.tunnelID,
+		nodeID:        m.inNodeID,
+		in:            in,
+		out:           out,
+		billed:        billed,
+		hour:          flowHourKey(time.Now()),
+		checkUser:     true,
+		checkUN:       true,
+		checkUT:       true,
+		quotaDiscount: in + out - larger,
+	}, true, nil
+}
+
+// anytlsFlowRecord books AnyTLS traffic of one user on a node; the user is
+// disabled on that node once a limit is hit.
+func anytlsFlowRecord(nodeID, userID int64, in, out int64) flowRecord {
+	return flowRecord{
+		source:    "anytls",
+		userID:    userID,
+		nodeID:    nodeID,
+		createUN:  true,
+		in:        in,
+		out:       out,
+		billed:    in + out,
+		hour:      flowHourKey(time.Now()),
+		checkUser: true,
+		checkUN:   true,
+		disableUN: true,
+	}
+}
+
+// nativeExitFlowRecord books VLESS / Trojan / Hysteria2 exit traffic with the
+// same limits as AnyTLS.
+func nativeExitFlowRecord(nodeID, userID int64, in, out int64) flowRecord {
+	r := anytlsFlowRecord(nodeID, userID, in, out)
+	r.source = "native"
+	return r
+}
+
+// exitFlowRecord books exit (gost) traffic of one user on a node.
+func exitFlowRecord(nodeID, userID int64, in, out int64) flowRecord {
+	return flowRecord{
+		source:   "gost",
+		userID:   userID,
+		nodeID:   nodeID,
+		createUN: true,
+		in:       in,
+		out:      out,
+		billed:   in + out,
+		hour:     flowHourKey(time.Now()),
+	}
+}
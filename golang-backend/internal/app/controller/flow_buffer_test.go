@@ -0,0 +1,65 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"network-panel/golang-backend/internal/app/model"
+	dbpkg "network-panel/golang-backend/internal/db"
+)
+
+func TestFlushFlowBufferCommits(t *testing.T) {
+	useTestDB(t)
+	one := 1
+	u := model.User{User: "flow-test", BaseEntity: model.BaseEntity{Status: &one}}
+	if err := dbpkg.DB.Create(&u).Error; err != nil {
+		t.Fatal(err)
+	}
+	gen := bufferFlow(exitFlowRecord(99, u.ID, 100, 50))
+	FlushFlowBuffer()
+	if !waitFlowCommit(gen, time.Second) {
+		t.Fatal("generation not committed after flush")
+	}
+	if flowPendingDepth() != 0 {
+		t.Fatal("records left in the buffer")
+	}
+	var got model.User
+	dbpkg.DB.First(&got, u.ID)
+	if got.InFlow != 100 || got.OutFlow != 50 {
+		t.Fatalf("user flow %d/%d, want 100/50", got.InFlow, got.OutFlow)
+	}
+	var un model.UserNode
+	if err := dbpkg.DB.Where("user_id = ? AND node_id = ?", u.ID, 99).First(&un).Error; err != nil || un.InFlow != 100 {
+		t.Fatalf("user_node not booked: %v %+v", err, un)
+	}
+}
+
+func TestFlowBatchRetriesOnDBError(t *testing.T) {
+	useTestDB(t)
+	n := model.Node{Name: "batch-node", Secret: "batch-secret"}
+	if err := dbpkg.DB.Create(&n).Error; err != nil {
+		t.Fatal(err)
+	}
+	// a failing forward lookup must not be mistaken for a deleted forward
+	if err := dbpkg.DB.Migrator().DropTable(&model.Forward{}); err != nil {
+		t.Fatal(err)
+	}
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	body := `{"source":"agent1","seq":1,"entries":[{"kind":"forward","forwardId":424242,"inBytes":10}]}`
+	c.Request = httptest.NewRequest("POST", "/flow/batch?secret=batch-secret", strings.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	FlowBatchUpload(c)
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("status %d, want 500", w.Code)
+	}
+	if fresh, _, err := bufferFlowBatch(n.ID, "agent1", 1, nil); err != nil || !fresh {
+		t.Fatal("seq accepted although the batch was rejected")
+	}
+}
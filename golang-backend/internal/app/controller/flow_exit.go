@@ -6,17 +6,15 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
-	"time"
 
 	"network-panel/golang-backend/internal/app/model"
 	dbpkg "network-panel/golang-backend/internal/db"
 
 	"github.com/gin-gonic/gin"
-	"gorm.io/gorm"
 )
 
 // POST /flow/exit?secret=...&userId=...&nodeId=...&port=...
-// Updates user/user_node flow counters for exit (gost) traffic.
+// Queues user/user_node flow increments for exit (gost) traffic.
 func FlowExitUpload(c *gin.Context) {
 	secret := strings.TrimSpace(c.Query("secret"))
 	if secret == "" {
@@ -69,45 +67,6 @@ func FlowExitUpload(c *gin.Context) {
 		c.String(http.StatusOK, "ok")
 		return
 	}
-	now := time.Now()
-	nowMs := now.UnixMilli()
-	nowCST := now.In(time.FixedZone("UTC+8", 8*3600))
-	calc := inBytes + outBytes
-
-	dbpkg.DB.Model(&model.User{}).Where("id = ?", uid).
-		Updates(map[string]any{"in_flow": gorm.Expr("in_flow + ?", inBytes), "out_flow": gorm.Expr("out_flow + ?", outBytes), "updated_time": nowMs})
-	// user_node upsert
-	ur := dbpkg.DB.Model(&model.UserNode{}).Where("user_id = ? AND node_id = ?", uid, node.ID).
-		Updates(map[string]any{"in_flow": gorm.Expr("in_flow + ?", inBytes), "out_flow": gorm.Expr("out_flow + ?", outBytes)})
-	if ur.RowsAffected == 0 {
-		status := 1
-		_ = dbpkg.DB.Create(&model.UserNode{
-			UserID:        uid,
-			NodeID:        node.ID,
-			InFlow:        inBytes,
-			OutFlow:       outBytes,
-			Status:        status,
-		}).Error
-	}
-	// statistics_flow (hourly bucket)
-	hourKey := nowCST.Format("01-02 15:00")
-	var rec model.StatisticsFlow
-	if err := dbpkg.DB.Where("user_id = ? AND time = ?", uid, hourKey).First(&rec).Error; err == nil && rec.ID > 0 {
-		dbpkg.DB.Model(&model.StatisticsFlow{}).Where("id = ?", rec.ID).
-			Updates(map[string]any{"flow": gorm.Expr("flow + ?", calc), "total_flow": gorm.Expr("total_flow + ?", calc)})
-	} else {
-		rec = model.StatisticsFlow{UserID: uid, Flow: calc, TotalFlow: calc, Time: hourKey, CreatedTime: nowMs}
-		_ = dbpkg.DB.Create(&rec).Error
-	}
-	_ = dbpkg.DB.Create(&model.FlowTimeseries{
-		UserID:      uid,
-		InBytes:     inBytes,
-		OutBytes:    outBytes,
-		BilledBytes: calc,
-		Source:      "gost",
-		TimeMs:      nowMs,
-		CreatedTime: nowMs,
-	}).Error
-
+	bufferFlow(exitFlowRecord(node.ID, uid, inBytes, outBytes))
 	c.String(http.StatusOK, "ok")
 }
@@ -2,6 +2,7 @@ package controller
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net"
@@ -36,10 +37,18 @@ func ForwardCreate(c *gin.Context) {
 		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
 		return
 	}
-	uidInf, _ := c.Get("user_id")
-	uid := uidInf.(int64)
-	roleInf, _ := c.Get("role_id")
-	if roleInf != 0 {
+	_, opID, err := createForward(req, c.GetInt64("user_id"), c.GetInt("role_id"))
+	if err != nil {
+		replyErr(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, response.Ok(map[string]any{"requestId": opID}))
+}
+
+// createForward creates a forward owned by uid and pushes its services;
+// non-admin roles are held to their quotas and tunnel permissions.
+func createForward(req dto.ForwardDto, uid int64, role int) (model.Forward, string, error) {
+	if role != 0 {
 		// forward quota
 		var cfg model.ViteConfig
 		dbpkg.DB.Where("name=?", "registration_default_forward").First(&cfg)
@@ -50,8 +59,7 @@ func ForwardCreate(c *gin.Context) {
 		var cnt int64
 		dbpkg.DB.Model(&model.Forward{}).Where("user_id=?", uid).Count(&cnt)
 		if int(cnt) >= limit {
-			c.JSON(http.StatusOK, response.ErrMsg("超出转发数量上限"))
-			return
+			return model.Forward{}, "", errors.New("超出转发数量上限")
 		}
 	}
 	var tun model.Tunnel
@@ -59,26 +67,22 @@ func ForwardCreate(c *gin.Context) {
 	var ut model.UserTunnel
 	if req.TunnelID != 0 {
 		if err := dbpkg.DB.First(&tun, req.TunnelID).Error; err != nil {
-			c.JSON(http.StatusOK, response.ErrMsg("隧道不存在"))
-			return
+			return model.Forward{}, "", errors.New("隧道不存在")
 		}
-		if roleInf != 0 {
+		if role != 0 {
 			if err := dbpkg.DB.Where("user_id=? and tunnel_id=?", uid, req.TunnelID).First(&ut).Error; err != nil {
-				c.JSON(http.StatusOK, response.ErrMsg("你没有该隧道权限"))
-				return
+				return model.Forward{}, "", errors.New("你没有该隧道权限")
 			}
 		}
 	} else {
 		if req.EntryNodeID == nil || *req.EntryNodeID == 0 {
-			c.JSON(http.StatusOK, response.ErrMsg("请选择入口节点"))
-			return
+			return model.Forward{}, "", errors.New("请选择入口节点")
 		}
 		var in model.Node
 		if err := dbpkg.DB.First(&in, *req.EntryNodeID).Error; err != nil {
-			c.JSON(http.StatusOK, response.ErrMsg("入口节点不存在"))
-			return
+			return model.Forward{}, "", errors.New("入口节点不存在")
 		}
-		if roleInf != 0 {
+		if role != 0 {
 			var cfg model.ViteConfig
 			dbpkg.DB.Where("name=?", "registration_default_num").First(&cfg)
 			limit := 10
@@ -88,8 +92,7 @@ func ForwardCreate(c *gin.Context) {
 			var cnt int64
 			dbpkg.DB.Model(&model.Tunnel{}).Where("owner_id=?", uid).Count(&cnt)
 			if int(cnt) >= limit {
-				c.JSON(http.StatusOK, response.ErrMsg("超出隧道数量上限"))
-				return
+				return model.Forward{}, "", errors.New("超出隧道数量上限")
 			}
 		}
 		now := time.Now().UnixMilli()
@@ -112,11 +115,10 @@ func ForwardCreate(c *gin.Context) {
 			Flow:       1,
 		}
 		if err := dbpkg.DB.Create(&tun).Error; err != nil {
-			c.JSON(http.StatusOK, response.ErrMsg("直连线路创建失败"))
-			return
+			return model.Forward{}, "", errors.New("直连线路创建失败")
 		}
 		req.TunnelID = tun.ID
-		if roleInf != 0 {
+		if role != 0 {
 			dbpkg.DB.Where("user_id=? and tunnel_id=?", uid, tun.ID).First(&ut)
 			if ut.ID == 0 {
 				ut = model.UserTunnel{UserID: uid, TunnelID: tun.ID, Flow: 0, Num: 0, Status: 1}
@@ -153,14 +155,12 @@ func ForwardCreate(c *gin.Context) {
 		}
 		inPort = findFreePortOnNode(tun.InNodeID, inPort, minP, maxP)
 		if inPort == 0 {
-			c.JSON(http.StatusOK, response.ErrMsg("隧道入口端口已满，无法分配新端口"))
-			return
+			return model.Forward{}, "", errors.New("隧道入口端口已满，无法分配新端口")
 		}
 	}
-	if roleInf != 0 {
+	if role != 0 {
 		if err := enforceUserNodePort(uid, tun.InNodeID, inPort); err != nil {
-			c.JSON(http.StatusOK, response.ErrMsg(err.Error()))
-			return
+			return model.Forward{}, "", err
 		}
 	}
 	now := time.Now().UnixMilli()
@@ -178,14 +178,12 @@ func ForwardCreate(c *gin.Context) {
 	// allocate outPort for tunnel-forward
 	if tun.Type == 2 {
 		if !isExternalExit(tun) && tun.OutNodeID == nil {
-			c.JSON(http.StatusOK, response.ErrMsg("隧道出口节点无效"))
-			return
+			return model.Forward{}, "", errors.New("隧道出口节点无效")
 		}
 		if isExternalExit(tun) {
 			ext, ok := loadExternalExit(tun)
 			if !ok || ext.Host == "" || ext.Port <= 0 || ext.Port > 65535 {
-				c.JSON(http.StatusOK, response.ErrMsg("外部出口节点无效"))
-				return
+				return model.Forward{}, "", errors.New("外部出口节点无效")
 			}
 			port := ext.Port
 			f.OutPort = &port
@@ -208,25 +206,21 @@ func ForwardCreate(c *gin.Context) {
 			}
 			free := findFreePortOnNode(exitID, op, minO, maxO)
 			if free == 0 {
-				c.JSON(http.StatusOK, response.ErrMsg("隧道出口端口已满，无法分配新端口"))
-				return
+				return model.Forward{}, "", errors.New("隧道出口端口已满，无法分配新端口")
 			}
 			f.OutPort = &free
 		} else {
-			c.JSON(http.StatusOK, response.ErrMsg("隧道出口端口已满，无法分配新端口"))
-			return
+			return model.Forward{}, "", errors.New("隧道出口端口已满，无法分配新端口")
 		}
 	}
 	if err := dbpkg.DB.Create(&f).Error; err != nil {
-		c.JSON(http.StatusOK, response.ErrMsg("端口转发创建失败"))
-		return
+		return model.Forward{}, "", errors.New("端口转发创建失败")
 	}
 	// push to node(s)
 	opId := RandUUID()
 	name := buildServiceName(f.ID, f.UserID, f.TunnelID)
 	if isDirectExitForward(tun, f.InPort) {
-		c.JSON(http.StatusOK, response.Ok(map[string]any{"requestId": opId}))
-		return
+		return f, opId, nil
 	}
 	if tun.Type == 2 && f.OutPort != nil {
 		// gRPC HTTP 隧道（出口=relay+grpc，入口=http+chain(dialer=grpc, connector=relay)）
@@ -471,8 +465,7 @@ func ForwardCreate(c *gin.Context) {
 		bindMap := getTunnelBindMap(tun.ID)
 		linkModes := normalizeLinkModes(getTunnelLinkModes(tun.ID), len(path)+1, "direct")
 		if isExternalExit(tun) && len(linkModes) > 0 && linkModes[len(linkModes)-1] == "tunnel" {
-			c.JSON(http.StatusOK, response.ErrMsg("外部出口不支持隧道链路"))
-			return
+			return model.Forward{}, "", errors.New("外部出口不支持隧道链路")
 		}
 		auth := relayAuthForForward(tun, f)
 		if len(path) == 0 {
@@ -697,7 +690,7 @@ func ForwardCreate(c *gin.Context) {
 			}
 		}
 	}
-	c.JSON(http.StatusOK, response.Ok(map[string]any{"requestId": opId}))
+	return f, opId, nil
 }
 
 // ForwardList 转发列表
@@ -801,25 +794,29 @@ func ForwardUpdate(c *gin.Context) {
 		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
 		return
 	}
+	opID, err := updateForward(req, c.GetInt64("user_id"), c.GetInt("role_id"))
+	if err != nil {
+		replyErr(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, response.Ok(map[string]any{"msg": "端口转发更新成功", "requestId": opID}))
+}
+
+// updateForward saves a forward and re-pushes its services; non-admin roles
+// may only change their own forwards within their node port ranges.
+func updateForward(req dto.ForwardUpdateDto, uid int64, role int) (string, error) {
 	var f model.Forward
 	if err := dbpkg.DB.First(&f, req.ID).Error; err != nil {
-		c.JSON(http.StatusOK, response.ErrMsg("转发不存在"))
-		return
+		return "", errors.New("转发不存在")
 	}
 	// permission: non-admin can only update own forward
-	if roleInf, ok := c.Get("role_id"); ok && roleInf != 0 {
-		if uidInf, ok2 := c.Get("user_id"); ok2 {
-			if f.UserID != uidInf.(int64) {
-				c.JSON(http.StatusForbidden, response.ErrMsg("无权限"))
-				return
-			}
-		}
+	if role != 0 && f.UserID != uid {
+		return "", errNoPermission
 	}
 	// ensure tunnel exists
 	var tun model.Tunnel
 	if err := dbpkg.DB.First(&tun, req.TunnelID).Error; err != nil {
-		c.JSON(http.StatusOK, response.ErrMsg("隧道不存在"))
-		return
+		return "", errors.New("隧道不存在")
 	}
 	if req.Name != "" {
 		f.Name = req.Name
@@ -848,19 +845,15 @@ func ForwardUpdate(c *gin.Context) {
 			if v < minP || v > maxP {
 				v = findFreePortOnNode(tun.InNodeID, 0, minP, maxP)
 				if v == 0 {
-					c.JSON(http.StatusOK, response.ErrMsg("入口端口超出范围且无法分配可用端口"))
-					return
+					return "", errors.New("入口端口超出范围且无法分配可用端口")
 				}
 			}
 			f.InPort = v
 		}
 	}
-	if roleInf, ok := c.Get("role_id"); ok && roleInf != 0 {
-		if uidInf, ok2 := c.Get("user_id"); ok2 {
-			if err := enforceUserNodePort(uidInf.(int64), tun.InNodeID, f.InPort); err != nil {
-				c.JSON(http.StatusOK, response.ErrMsg(err.Error()))
-				return
-			}
+	if role != 0 {
+		if err := enforceUserNodePort(uid, tun.InNodeID, f.InPort); err != nil {
+			return "", err
 		}
 	}
 	if req.RemoteAddr != "" {
@@ -869,8 +862,7 @@ func ForwardUpdate(c *gin.Context) {
 	f.InterfaceName, f.Strategy = req.InterfaceName, req.Strategy
 	f.UpdatedTime = time.Now().UnixMilli()
 	if err := dbpkg.DB.Save(&f).Error; err != nil {
-		c.JSON(http.StatusOK, response.ErrMsg("端口转发更新失败"))
-		return
+		return "", errors.New("端口转发更新失败")
 	}
 	// push update
 	opId := RandUUID()
@@ -902,8 +894,7 @@ func ForwardUpdate(c *gin.Context) {
 		if externalExit {
 			ext, ok := loadExternalExit(tun)
 			if !ok || ext.Host == "" || ext.Port <= 0 || ext.Port > 65535 {
-				c.JSON(http.StatusOK, response.ErrMsg("外部出口节点无效"))
-				return
+				return "", errors.New("外部出口节点无效")
 			}
 			if f.OutPort == nil || *f.OutPort != ext.Port {
 				p := ext.Port
@@ -913,8 +904,7 @@ func ForwardUpdate(c *gin.Context) {
 		} else {
 			exitID := outNodeIDOr0(tun)
 			if exitID == 0 {
-				c.JSON(http.StatusOK, response.ErrMsg("隧道出口节点无效"))
-				return
+				return "", errors.New("隧道出口节点无效")
 			}
 			var outNode model.Node
 			_ = dbpkg.DB.First(&outNode, exitID).Error
@@ -938,18 +928,15 @@ func ForwardUpdate(c *gin.Context) {
 			}
 			if requestedOut > 0 {
 				if requestedOut < minO || requestedOut > maxO {
-					c.JSON(http.StatusOK, response.ErrMsg("出口端口超出范围"))
-					return
+					return "", errors.New("出口端口超出范围")
 				}
 				if !(svcOK && svcPort == requestedOut) {
 					if !portAvailableForService(exitID, name, requestedOut, svcList, getUsedPorts(exitID)) {
 						suggest := findFreePortOnNode(exitID, requestedOut, minO, maxO)
 						if suggest > 0 && suggest != requestedOut {
-							c.JSON(http.StatusOK, response.ErrMsg(fmt.Sprintf("出口端口已占用，建议端口 %d", suggest)))
-						} else {
-							c.JSON(http.StatusOK, response.ErrMsg("出口端口已占用"))
+							return "", fmt.Errorf("出口端口已占用，建议端口 %d", suggest)
 						}
-						return
+						return "", errors.New("出口端口已占用")
 					}
 				}
 				if f.OutPort == nil || *f.OutPort != requestedOut {
@@ -964,8 +951,7 @@ func ForwardUpdate(c *gin.Context) {
 			} else {
 				free := findFreePortOnNode(exitID, 0, minO, maxO)
 				if free == 0 {
-					c.JSON(http.StatusOK, response.ErrMsg("隧道出口端口已满，无法分配新端口"))
-					return
+					return "", errors.New("隧道出口端口已满，无法分配新端口")
 				}
 				if f.OutPort == nil || *f.OutPort != free {
 					f.OutPort = &free
@@ -1037,8 +1023,7 @@ func ForwardUpdate(c *gin.Context) {
 				}
 				if requested > 0 {
 					if requested < minP || requested > maxP {
-						c.JSON(http.StatusOK, response.ErrMsg(fmt.Sprintf("中继%d端口超出范围", i+1)))
-						return
+						return "", fmt.Errorf("中继%d端口超出范围", i+1)
 					}
 					if !(svcOK && svcPort == requested) {
 						if !portAvailableForService(nid, midName, requested, svcList, getUsedPorts(nid)) {
@@ -1049,11 +1034,9 @@ func ForwardUpdate(c *gin.Context) {
 								suggest = findFreePortOnNode(nid, requested, minP, maxP)
 							}
 							if suggest > 0 && suggest != requested {
-								c.JSON(http.StatusOK, response.ErrMsg(fmt.Sprintf("中继%d端口已占用，建议端口 %d", i+1, suggest)))
-							} else {
-								c.JSON(http.StatusOK, response.ErrMsg(fmt.Sprintf("中继%d端口已占用", i+1)))
+								return "", fmt.Errorf("中继%d端口已占用，建议端口 %d", i+1, suggest)
 							}
-							return
+							return "", fmt.Errorf("中继%d端口已占用", i+1)
 						}
 					}
 					midPorts[i] = requested
@@ -1200,15 +1183,13 @@ func ForwardUpdate(c *gin.Context) {
 		path := getTunnelPathNodes(tun.ID)
 		if isDirectExitForward(tun, f.InPort) && len(path) == 0 {
 			_ = sendWSCommand(tun.InNodeID, "DeleteService", map[string]any{"services": expandNamesWithRUDP([]string{name})})
-			c.JSON(http.StatusOK, response.Ok(map[string]any{"msg": "端口转发更新成功", "requestId": opId}))
-			return
+			return opId, nil
 		}
 		ifaceMap := getTunnelIfaceMap(tun.ID)
 		bindMap := getTunnelBindMap(tun.ID)
 		linkModes := normalizeLinkModes(getTunnelLinkModes(tun.ID), len(path)+1, "direct")
 		if isExternalExit(tun) && len(linkModes) > 0 && linkModes[len(linkModes)-1] == "tunnel" {
-			c.JSON(http.StatusOK, response.ErrMsg("外部出口不支持隧道链路"))
-			return
+			return "", errors.New("外部出口不支持隧道链路")
 		}
 		auth := relayAuthForForward(tun, f)
 		if len(path) == 0 {
@@ -1415,7 +1396,7 @@ func ForwardUpdate(c *gin.Context) {
 			}
 		}
 	}
-	c.JSON(http.StatusOK, response.Ok(map[string]any{"msg": "端口转发更新成功", "requestId": opId}))
+	return opId, nil
 }
 
 // ForwardDelete 删除转发
@@ -2642,6 +2623,16 @@ func appendLocalPorts(nodeID int64, ports map[int]bool) map[int]bool {
 			ports[at.Port] = true
 		}
 	}
+	var inboundPorts []int
+	dbpkg.DB.Model(&model.AnyTLSInbound{}).Where("node_id = ?", nodeID).Pluck("port", &inboundPorts)
+	for _, p := range inboundPorts {
+		ports[p] = true
+	}
+	var nativePorts []int
+	dbpkg.DB.Model(&model.NativeExit{}).Where("node_id = ?", nodeID).Pluck("port", &nativePorts)
+	for _, p := range nativePorts {
+		ports[p] = true
+	}
 	// include agent-reported used ports snapshot (best-effort)
 	var rt model.NodeRuntime
 	if err := dbpkg.DB.Where("node_id = ?", nodeID).First(&rt).Error; err == nil {
@@ -2930,8 +2921,6 @@ func findFreePortOnNodeAny(nodeID int64, prefer int, min int) int {
 	return 0
 }
 
-func isOverlayIP(ip string) bool { return strings.HasPrefix(ip, "10.126.126.") }
-
 // build shadowsocks server service on exit node
 func buildSSService(name string, listenPort int, password string, method string, opts ...map[string]any) map[string]any {
 	if method == "" {
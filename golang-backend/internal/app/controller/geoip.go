@@ -0,0 +1,90 @@
+package controller
+
+import (
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// Country lookups against a local MaxMind-format database (GeoLite2-Country,
+// DB-IP country lite, ...). The file is optional: without it lookups return "".
+// It is re-read when the path or modification time changes, checked at most
+// once a minute.
+
+const defaultGeoIPPath = "./GeoLite2-Country.mmdb"
+
+var geoip struct {
+	mu      sync.Mutex
+	path    string
+	modTime time.Time
+	checked time.Time
+	db      *maxminddb.Reader
+}
+
+func geoipPath() string {
+	if v := strings.TrimSpace(os.Getenv("GEOIP_DB_PATH")); v != "" {
+		return v
+	}
+	if v := strings.TrimSpace(getCfg("geoip_db_path")); v != "" {
+		return v
+	}
+	return defaultGeoIPPath
+}
+
+func geoipReader() *maxminddb.Reader {
+	geoip.mu.Lock()
+	defer geoip.mu.Unlock()
+	if time.Since(geoip.checked) < time.Minute {
+		return geoip.db
+	}
+	geoip.checked = time.Now()
+	path := geoipPath()
+	st, err := os.Stat(path)
+	if err != nil {
+		geoip.path, geoip.db = path, nil
+		return nil
+	}
+	if geoip.db != nil && path == geoip.path && st.ModTime().Equal(geoip.modTime) {
+		return geoip.db
+	}
+	geoip.path, geoip.modTime, geoip.db = path, st.ModTime(), nil
+	b, err := os.ReadFile(path)
+	if err == nil {
+		geoip.db, err = maxminddb.FromBytes(b)
+	}
+	if err != nil {
+		jlog(map[string]any{"event": "geoip_open_err", "path": path, "error": err.Error()})
+	}
+	return geoip.db
+}
+
+// geoipCountry returns the ISO country code of ip, or "" when unknown.
+func geoipCountry(ip string) string {
+	addr := net.ParseIP(strings.TrimSpace(ip))
+	if addr == nil || addr.IsPrivate() || addr.IsLoopback() {
+		return ""
+	}
+	db := geoipReader()
+	if db == nil {
+		return ""
+	}
+	var rec struct {
+		Country struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+		RegisteredCountry struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"registered_country"`
+	}
+	if err := db.Lookup(addr, &rec); err != nil {
+		return ""
+	}
+	if rec.Country.ISOCode != "" {
+		return rec.Country.ISOCode
+	}
+	return rec.RegisteredCountry.ISOCode
+}
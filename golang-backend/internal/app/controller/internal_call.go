@@ -0,0 +1,59 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"network-panel/golang-backend/internal/app/dto"
+	"network-panel/golang-backend/internal/app/model"
+	"network-panel/golang-backend/internal/app/response"
+	dbpkg "network-panel/golang-backend/internal/db"
+)
+
+// Handlers that background jobs also need (config apply, IPAM re-push) keep
+// their logic in plain functions returning an error; the handler only binds
+// the request and writes the reply.
+
+// errNoPermission is answered with 403 by replyErr.
+var errNoPermission = errors.New("无权限")
+
+// replyErr writes err as the error reply of a handler.
+func replyErr(c *gin.Context, err error) {
+	status := http.StatusOK
+	if errors.Is(err, errNoPermission) {
+		status = http.StatusForbidden
+	}
+	c.JSON(status, response.ErrMsg(err.Error()))
+}
+
+func adminUserID() int64 {
+	var u model.User
+	if err := dbpkg.DB.Where("role_id = ?", 0).Order("id asc").First(&u).Error; err == nil {
+		return u.ID
+	}
+	return 1
+}
+
+// reapplyForward re-pushes a forward's services with its current settings.
+func reapplyForward(f model.Forward) error {
+	req := dto.ForwardUpdateDto{
+		ID:            f.ID,
+		Name:          f.Name,
+		Group:         f.Group,
+		TunnelID:      f.TunnelID,
+		InPort:        &f.InPort,
+		OutPort:       f.OutPort,
+		RemoteAddr:    f.RemoteAddr,
+		Strategy:      f.Strategy,
+		InterfaceName: f.InterfaceName,
+	}
+	// keep previously allocated mid-hop ports stable
+	var mids []model.ForwardMidPort
+	dbpkg.DB.Where("forward_id = ?", f.ID).Order("idx asc").Find(&mids)
+	for _, m := range mids {
+		req.MidPorts = append(req.MidPorts, dto.ForwardMidPortDto{Idx: m.Idx, Port: m.Port})
+	}
+	_, err := updateForward(req, adminUserID(), 0)
+	return err
+}
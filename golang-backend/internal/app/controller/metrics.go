@@ -0,0 +1,353 @@
+package controller
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"network-panel/golang-backend/internal/app/model"
+	"network-panel/golang-backend/internal/app/response"
+	"network-panel/golang-backend/internal/app/util"
+	dbpkg "network-panel/golang-backend/internal/db"
+)
+
+// Prometheus text exposition for the panel.
+//
+// GET /metrics is authorized by the metrics token (env METRICS_TOKEN or config
+// metrics_token) sent as "Authorization: Bearer <token>" or ?token=, or by an
+// admin JWT. Values are read from the database, the batch buffers and the
+// in-memory websocket state on every scrape.
+
+// promWriter collects samples per metric family so that each family is
+// written contiguously, as the exposition format requires, regardless of the
+// order samples are produced in.
+type promWriter struct {
+	order []string
+	heads map[string]string
+	lines map[string][]string
+}
+
+func (w *promWriter) head(name, typ, help string) {
+	if w.heads == nil {
+		w.heads, w.lines = map[string]string{}, map[string][]string{}
+	}
+	if _, ok := w.heads[name]; ok {
+		return
+	}
+	w.order = append(w.order, name)
+	w.heads[name] = fmt.Sprintf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// sample records one line; labels are key/value pairs.
+func (w *promWriter) sample(name string, v float64, labels ...string) {
+	var b strings.Builder
+	b.WriteString(name)
+	if len(labels) > 1 {
+		b.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			fmt.Fprintf(&b, "%s=\"%s\"", labels[i], promEscape(labels[i+1]))
+		}
+		b.WriteByte('}')
+	}
+	b.WriteByte(' ')
+	switch {
+	case math.IsNaN(v):
+		b.WriteString("NaN")
+	case math.IsInf(v, 1):
+		b.WriteString("+Inf")
+	case math.IsInf(v, -1):
+		b.WriteString("-Inf")
+	default:
+		b.WriteString(strconv.FormatFloat(v, 'g', -1, 64))
+	}
+	b.WriteByte('\n')
+	family := name
+	if _, ok := w.heads[family]; !ok {
+		family = strings.TrimSuffix(strings.TrimSuffix(name, "_sum"), "_count")
+	}
+	w.lines[family] = append(w.lines[family], b.String())
+}
+
+func (w *promWriter) String() string {
+	var b strings.Builder
+	for _, name := range w.order {
+		b.WriteString(w.heads[name])
+		for _, l := range w.lines[name] {
+			b.WriteString(l)
+		}
+	}
+	return b.String()
+}
+
+var promEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func promEscape(s string) string { return promEscaper.Replace(s) }
+
+func i64s(v int64) string { return strconv.FormatInt(v, 10) }
+
+func boolf(b bool) float64 { return ifThen(b, 1.0, 0.0) }
+
+func metricsToken() string {
+	if v := strings.TrimSpace(os.Getenv("METRICS_TOKEN")); v != "" {
+		return v
+	}
+	return strings.TrimSpace(getCfg("metrics_token"))
+}
+
+func newMetricsToken() string {
+	b := make([]byte, 24)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func metricsAuthorized(c *gin.Context) bool {
+	auth := strings.TrimSpace(c.GetHeader("Authorization"))
+	if tok := metricsToken(); tok != "" {
+		given := c.Query("token")
+		if strings.HasPrefix(auth, "Bearer ") {
+			given = strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+		}
+		if given != "" && subtle.ConstantTimeCompare([]byte(given), []byte(tok)) == 1 {
+			return true
+		}
+	}
+	return auth != "" && util.ValidateToken(auth) && util.GetRoleID(auth) == 0
+}
+
+// Metrics Prometheus 指标
+// @Summary Prometheus 指标（文本格式）
+// @Tags stats
+// @Produce plain
+// @Success 200 {string} string "text/plain; version=0.0.4"
+// @Router /metrics [get]
+func Metrics(c *gin.Context) {
+	if !metricsAuthorized(c) {
+		c.String(http.StatusUnauthorized, "unauthorized\n")
+		return
+	}
+	w := &promWriter{}
+	now := time.Now()
+	var nodes []model.Node
+	dbpkg.DB.Order("id asc").Find(&nodes)
+	writeNodeMetrics(w, nodes, now.UnixMilli())
+	writeProbeMetrics(w, nodes, now.UnixMilli())
+	writeFlowMetrics(w)
+	writeRuntimeMetrics(w)
+	c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", []byte(w.String()))
+}
+
+func writeNodeMetrics(w *promWriter, nodes []model.Node, now int64) {
+	healthMu.RLock()
+	health := make(map[int64][2]bool, len(nodeHealth))
+	for id, h := range nodeHealth {
+		health[id] = [2]bool{h.GostAPI, h.GostRunning}
+	}
+	healthMu.RUnlock()
+	snap := &alertSnapshot{now: now, nodes: nodes}
+	snap.loadSys()
+
+	w.head("flux_node_online", "gauge", "1 if the node agent websocket is connected.")
+	for _, n := range nodes {
+		w.sample("flux_node_online", boolf(isNodeConnected(n.ID)), "node_id", i64s(n.ID), "node", n.Name)
+	}
+	w.head("flux_node_gost_running", "gauge", "1 if the agent reports gost running.")
+	w.head("flux_node_gost_api", "gauge", "1 if the agent reports the gost API reachable.")
+	for _, n := range nodes {
+		if h, ok := health[n.ID]; ok {
+			w.sample("flux_node_gost_running", boolf(h[1]), "node_id", i64s(n.ID), "node", n.Name)
+			w.sample("flux_node_gost_api", boolf(h[0]), "node_id", i64s(n.ID), "node", n.Name)
+		}
+	}
+	type sysGauge struct {
+		name, typ, help string
+		val             func(model.NodeSysInfo) float64
+	}
+	gauges := []sysGauge{
+		{"flux_node_cpu_percent", "gauge", "Latest CPU usage percent.", func(s model.NodeSysInfo) float64 { return s.CPU }},
+		{"flux_node_mem_percent", "gauge", "Latest memory usage percent.", func(s model.NodeSysInfo) float64 { return s.Mem }},
+		{"flux_node_uptime_seconds", "gauge", "Host uptime reported by the agent.", func(s model.NodeSysInfo) float64 { return float64(s.Uptime) }},
+		{"flux_node_network_receive_bytes_total", "counter", "Host received bytes.", func(s model.NodeSysInfo) float64 { return float64(s.BytesRx) }},
+		{"flux_node_network_transmit_bytes_total", "counter", "Host transmitted bytes.", func(s model.NodeSysInfo) float64 { return float64(s.BytesTx) }},
+		{"flux_node_sysinfo_timestamp_seconds", "gauge", "Time of the latest sysinfo sample.", func(s model.NodeSysInfo) float64 { return float64(s.TimeMs) / 1000 }},
+	}
+	for _, g := range gauges {
+		w.head(g.name, g.typ, g.help)
+		for _, n := range nodes {
+			if list := snap.sys[n.ID]; len(list) > 0 {
+				w.sample(g.name, g.val(list[len(list)-1]), "node_id", i64s(n.ID), "node", n.Name)
+			}
+		}
+	}
+}
+
+func writeProbeMetrics(w *promWriter, nodes []model.Node, now int64) {
+	snap := &alertSnapshot{now: now, nodes: nodes}
+	snap.loadProbes()
+	if len(snap.probes) == 0 {
+		return
+	}
+	var targets []model.ProbeTarget
+	dbpkg.DB.Find(&targets)
+	tmap := map[int64]model.ProbeTarget{}
+	for _, t := range targets {
+		tmap[t.ID] = t
+	}
+	names := map[int64]string{}
+	for _, n := range nodes {
+		names[n.ID] = n.Name
+	}
+	keys := make([][2]int64, 0, len(snap.probes))
+	for k := range snap.probes {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i][0] < keys[j][0] || (keys[i][0] == keys[j][0] && keys[i][1] < keys[j][1])
+	})
+	w.head("flux_probe_up", "gauge", "1 if the latest probe succeeded.")
+	w.head("flux_probe_rtt_ms", "gauge", "Latest probe round-trip time in milliseconds.")
+	w.head("flux_probe_loss_percent", "gauge", "Latest probe packet loss percent.")
+	w.head("flux_probe_jitter_ms", "gauge", "Latest probe jitter in milliseconds.")
+	for _, k := range keys {
+		r := snap.probes[k]
+		t, ok := tmap[k[1]]
+		if !ok {
+			continue
+		}
+		lb := []string{"node_id", i64s(k[0]), "node", names[k[0]], "target_id", i64s(t.ID), "target", t.Name, "type", t.Type}
+		w.sample("flux_probe_up", boolf(r.OK == 1), lb...)
+		if r.OK == 1 {
+			w.sample("flux_probe_rtt_ms", float64(r.RTTMs), lb...)
+		}
+		w.sample("flux_probe_loss_percent", r.LossPct, lb...)
+		w.sample("flux_probe_jitter_ms", r.JitterMs, lb...)
+	}
+}
+
+func writeFlowMetrics(w *promWriter) {
+	var forwards []model.Forward
+	dbpkg.DB.Order("id asc").Find(&forwards)
+	w.head("flux_forward_in_bytes_total", "counter", "Forward inbound bytes since the last reset.")
+	w.head("flux_forward_out_bytes_total", "counter", "Forward outbound bytes since the last reset.")
+	for _, f := range forwards {
+		lb := []string{"forward_id", i64s(f.ID), "forward", f.Name, "user_id", i64s(f.UserID), "tunnel_id", i64s(f.TunnelID)}
+		w.sample("flux_forward_in_bytes_total", float64(f.InFlow), lb...)
+		w.sample("flux_forward_out_bytes_total", float64(f.OutFlow), lb...)
+	}
+
+	var users []model.User
+	dbpkg.DB.Order("id asc").Find(&users)
+	w.head("flux_user_in_bytes_total", "counter", "User inbound bytes in the current billing cycle.")
+	w.head("flux_user_out_bytes_total", "counter", "User outbound bytes in the current billing cycle.")
+	w.head("flux_user_quota_bytes", "gauge", "User traffic quota in bytes (0 = unlimited).")
+	for _, u := range users {
+		lb := []string{"user_id", i64s(u.ID), "user", u.User}
+		w.sample("flux_user_in_bytes_total", float64(u.InFlow), lb...)
+		w.sample("flux_user_out_bytes_total", float64(u.OutFlow), lb...)
+		w.sample("flux_user_quota_bytes", float64(u.Flow*1024*1024*1024), lb...)
+	}
+
+	var tunnels []struct {
+		ID      int64  `gorm:"column:id"`
+		Name    string `gorm:"column:name"`
+		InFlow  int64  `gorm:"column:in_flow"`
+		OutFlow int64  `gorm:"column:out_flow"`
+	}
+	dbpkg.DB.Table("tunnel t").
+		Select("t.id, t.name, COALESCE(SUM(f.in_flow),0) as in_flow, COALESCE(SUM(f.out_flow),0) as out_flow").
+		Joins("left join forward f on f.tunnel_id = t.id").
+		Group("t.id, t.name").Order("t.id asc").Scan(&tunnels)
+	w.head("flux_tunnel_in_bytes_total", "counter", "Sum of forward inbound bytes per tunnel.")
+	w.head("flux_tunnel_out_bytes_total", "counter", "Sum of forward outbound bytes per tunnel.")
+	for _, t := range tunnels {
+		lb := []string{"tunnel_id", i64s(t.ID), "tunnel", t.Name}
+		w.sample("flux_tunnel_in_bytes_total", float64(t.InFlow), lb...)
+		w.sample("flux_tunnel_out_bytes_total", float64(t.OutFlow), lb...)
+	}
+}
+
+func writeRuntimeMetrics(w *promWriter) {
+	nodeConnMu.RLock()
+	conns, connected := 0, 0
+	for _, list := range nodeConns {
+		conns += len(list)
+		if len(list) > 0 {
+			connected++
+		}
+	}
+	nodeConnMu.RUnlock()
+	adminMu.RLock()
+	admins := len(adminConns)
+	adminMu.RUnlock()
+	termMu.RLock()
+	terms := 0
+	for _, m := range termClients {
+		terms += len(m)
+	}
+	termMu.RUnlock()
+	w.head("flux_ws_connections", "gauge", "Open websocket connections by kind.")
+	w.sample("flux_ws_connections", float64(conns), "kind", "agent")
+	w.sample("flux_ws_connections", float64(admins), "kind", "admin")
+	w.sample("flux_ws_connections", float64(terms), "kind", "terminal")
+	w.head("flux_ws_nodes_connected", "gauge", "Nodes with at least one agent websocket.")
+	w.sample("flux_ws_nodes_connected", float64(connected))
+
+	w.head("flux_batch_buffer_depth", "gauge", "Rows waiting in a batch buffer.")
+	w.head("flux_batch_buffer_capacity", "gauge", "Maximum rows kept in a batch buffer (0 = unbounded).")
+	for _, d := range bufferDepths() {
+		w.sample("flux_batch_buffer_depth", float64(d.Depth), "buffer", d.Name)
+		w.sample("flux_batch_buffer_capacity", float64(d.Max), "buffer", d.Name)
+	}
+	flushStatMu.Lock()
+	count, sum, last, lastMs := flushCount, flushSumSec, flushLastSec, flushLastMs
+	rows := make(map[string]int64, len(flushRowTotal))
+	for k, v := range flushRowTotal {
+		rows[k] = v
+	}
+	flushStatMu.Unlock()
+	w.head("flux_batch_flush_duration_seconds", "summary", "Duration of batch buffer flushes.")
+	w.sample("flux_batch_flush_duration_seconds_sum", sum)
+	w.sample("flux_batch_flush_duration_seconds_count", float64(count))
+	w.head("flux_batch_flush_last_duration_seconds", "gauge", "Duration of the most recent flush.")
+	w.sample("flux_batch_flush_last_duration_seconds", last)
+	w.head("flux_batch_flush_last_timestamp_seconds", "gauge", "Start time of the most recent flush.")
+	w.sample("flux_batch_flush_last_timestamp_seconds", float64(lastMs)/1000)
+	w.head("flux_batch_flushed_rows_total", "counter", "Rows written to the database by batch flushes.")
+	for _, k := range sortedKeys(rows) {
+		w.sample("flux_batch_flushed_rows_total", float64(rows[k]), "buffer", k)
+	}
+}
+
+// MetricsToken 查看或重置 /metrics 访问令牌（管理员）
+// @Summary 查看或重置 Prometheus 访问令牌
+// @Tags stats
+// @Accept json
+// @Produce json
+// @Param data body object false "{rotate?: bool}"
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/stats/metrics-token [post]
+func MetricsToken(c *gin.Context) {
+	var p struct {
+		Rotate bool `json:"rotate"`
+	}
+	_ = c.ShouldBindJSON(&p)
+	if strings.TrimSpace(os.Getenv("METRICS_TOKEN")) != "" {
+		c.JSON(http.StatusOK, response.Ok(map[string]any{"token": metricsToken(), "source": "env"}))
+		return
+	}
+	if p.Rotate || metricsToken() == "" {
+		setCfg("metrics_token", newMetricsToken())
+	}
+	c.JSON(http.StatusOK, response.Ok(map[string]any{"token": metricsToken(), "source": "config"}))
+}
@@ -0,0 +1,497 @@
+package controller
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"network-panel/golang-backend/internal/app/model"
+	"network-panel/golang-backend/internal/app/response"
+	dbpkg "network-panel/golang-backend/internal/db"
+)
+
+// ---- Native VLESS / Trojan / Hysteria2 exits ----
+//
+// Served by flux-agent through the embedded sing-box (cmd/flux-agent/
+// native_exit.go). Every user assigned to the node gets a credential derived
+// from the exit secret, the node's speed limit, session/IP limits, outbound
+// rule set and flow accounting like AnyTLS; subscriptions list one direct
+// proxy per exit.
+
+var nativeExitProtocols = map[string]bool{"vless": true, "trojan": true, "hysteria2": true}
+
+var nativeExitFlows = map[string]bool{"": true, "xtls-rprx-vision": true}
+
+var nativeExitUUIDRe = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// nativeExitCredential derives the credential of a user: a name-based UUID
+// for VLESS, "u<id>:<secret>" for password protocols.
+func nativeExitCredential(ex model.NativeExit, userID int64) string {
+	if strings.TrimSpace(ex.Secret) == "" || userID <= 0 {
+		return ""
+	}
+	if ex.Protocol != "vless" {
+		return anytlsUserPassword(ex.Secret, userID)
+	}
+	sum := sha1.Sum([]byte(fmt.Sprintf("%s:%d", strings.ToLower(ex.Secret), userID)))
+	b := sum[:16]
+	b[6] = (b[6] & 0x0f) | 0x50 // version 5
+	b[8] = (b[8] & 0x3f) | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+func nativeExitReality(ex model.NativeExit) bool {
+	return ex.Protocol == "vless" && ex.RealityHandshake != ""
+}
+
+// nativeExitRealityKeys generates an X25519 key pair in the encoding used by
+// sing-box and clients.
+func nativeExitRealityKeys() (string, string, string, error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", "", err
+	}
+	sid := make([]byte, 4)
+	if _, err := rand.Read(sid); err != nil {
+		return "", "", "", err
+	}
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString(key.Bytes()), enc.EncodeToString(key.PublicKey().Bytes()), hex.EncodeToString(sid), nil
+}
+
+// nativeExitRandomSecret returns a random UUIDv4, valid for every protocol.
+func nativeExitRandomSecret() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// pushNativeExitsToNode sends every native exit of the node with its users;
+// an empty list stops them on the agent.
+func pushNativeExitsToNode(nodeID int64) {
+	if nodeID == 0 {
+		return
+	}
+	var rows []model.NativeExit
+	dbpkg.DB.Where("node_id = ?", nodeID).Order("id asc").Find(&rows)
+	var uns []model.UserNode
+	if len(rows) > 0 {
+		dbpkg.DB.Where("node_id = ? AND status = 1", nodeID).Order("user_id asc").Find(&uns)
+	}
+	exits := make([]map[string]any, 0, len(rows))
+	for _, ex := range rows {
+		users := make([]map[string]any, 0, len(uns))
+		for _, un := range uns {
+			cred := nativeExitCredential(ex, un.UserID)
+			if cred == "" {
+				continue
+			}
+			u := map[string]any{"userId": un.UserID, "credential": cred, "speedBps": speedLimitBytesByUserNode(un)}
+			if un.MaxSessions > 0 || un.MaxIPs > 0 {
+				u["maxSessions"] = un.MaxSessions
+				u["maxIps"] = un.MaxIPs
+				u["limitMode"] = ifThen(un.LimitMode == "", "reject", un.LimitMode)
+			}
+			users = append(users, u)
+		}
+		item := map[string]any{"id": ex.ID, "protocol": ex.Protocol, "port": ex.Port, "users": users}
+		if ex.Flow != "" {
+			item["flow"] = ex.Flow
+		}
+		if ex.SNI != "" {
+			item["sni"] = ex.SNI
+		}
+		if nativeExitReality(ex) {
+			item["reality"] = map[string]any{"handshake": ex.RealityHandshake, "serverName": nativeExitServerName(ex), "privateKey": ex.RealityPrivateKey, "shortId": ex.RealityShortID}
+		}
+		if ex.Protocol == "hysteria2" {
+			item["obfsPassword"] = ex.ObfsPassword
+			item["upMbps"] = ex.UpMbps
+			item["downMbps"] = ex.DownMbps
+		}
+		exits = append(exits, item)
+	}
+	req := map[string]any{"requestId": RandUUID(), "exits": exits}
+	var st model.AnyTLSSetting
+	if len(rows) > 0 && dbpkg.DB.Where("node_id = ?", nodeID).First(&st).Error == nil {
+		req["outbound"] = loadExitRules(st.RuleSetID)
+	}
+	if ip := getAnyTLSExitIP(nodeID); ip != "" {
+		req["exitIp"] = ip
+	}
+	var ec model.ExitCert
+	if err := dbpkg.DB.Where("node_id = ?", nodeID).First(&ec).Error; err == nil && ec.Domain != "" {
+		req["domain"] = ec.Domain
+	}
+	_, _ = RequestOp(nodeID, "SetNativeExits", req, 10*time.Second)
+}
+
+// pushExitUsersToNode re-sends every exit of the node after its users, their
+// limits or their status changed.
+func pushExitUsersToNode(nodeID int64) {
+	pushAnyTLSConfigToNode(nodeID)
+	pushNativeExitsToNode(nodeID)
+}
+
+// nativeExitServerName is the Reality server name: the configured SNI or the
+// handshake host.
+func nativeExitServerName(ex model.NativeExit) string {
+	if ex.SNI != "" {
+		return ex.SNI
+	}
+	host, _, _ := net.SplitHostPort(ex.RealityHandshake)
+	return host
+}
+
+// nativeExitParams builds the subscription params of a user on an exit. The
+// node certificate is verified only while it is trusted and matches the SNI.
+func nativeExitParams(ex model.NativeExit, userID int64, cert *model.ExitCert) (string, map[string]interface{}) {
+	cred := nativeExitCredential(ex, userID)
+	params := map[string]interface{}{"udp": true}
+	switch ex.Protocol {
+	case "vless":
+		params["uuid"] = cred
+		params["tls"] = true
+		params["client-fingerprint"] = "chrome"
+		if ex.Flow != "" {
+			params["flow"] = ex.Flow
+		}
+	case "hysteria2":
+		params["password"] = cred
+		if ex.ObfsPassword != "" {
+			params["obfs"] = "salamander"
+			params["obfs-password"] = ex.ObfsPassword
+		}
+	default:
+		params["password"] = cred
+	}
+	if nativeExitReality(ex) {
+		params["sni"] = nativeExitServerName(ex)
+		real := map[string]interface{}{"public-key": ex.RealityPublicKey}
+		if ex.RealityShortID != "" {
+			real["short-id"] = ex.RealityShortID
+		}
+		params["reality-opts"] = real
+		return cred, params
+	}
+	sni := strings.TrimSpace(ex.SNI)
+	if cert != nil && (sni == "" || strings.EqualFold(sni, cert.Domain)) {
+		params["sni"] = cert.Domain
+		params["skip-cert-verify"] = false
+		return cred, params
+	}
+	if sni != "" {
+		params["sni"] = sni
+	}
+	params["skip-cert-verify"] = true
+	return cred, params
+}
+
+// nativeExitItems returns one proxy per native exit on the nodes assigned to
+// the user.
+func nativeExitItems(user model.User, used map[string]int) []subProxy {
+	nodeIDs, nodeMap := subscriptionUserNodes(user.ID)
+	if len(nodeIDs) == 0 {
+		return nil
+	}
+	var rows []model.NativeExit
+	dbpkg.DB.Where("node_id IN ?", nodeIDs).Order("node_id asc, id asc").Find(&rows)
+	if len(rows) == 0 {
+		return nil
+	}
+	certMap := exitCertMap(nodeIDs)
+	var items []subProxy
+	for _, ex := range rows {
+		n := nodeMap[ex.NodeID]
+		host := subscriptionNodeHost(n)
+		if host == "" {
+			continue
+		}
+		var cert *model.ExitCert
+		if ec, ok := certMap[ex.NodeID]; ok {
+			cert = &ec
+		}
+		cred, params := nativeExitParams(ex, user.ID, cert)
+		base := strings.TrimSpace(ex.Name)
+		if base == "" {
+			base = fmt.Sprintf("%s-%s-%d", n.Name, ex.Protocol, ex.Port)
+		}
+		items = append(items, subProxy{
+			ID:       -ex.ID,
+			Name:     uniqueName(base, -ex.ID, used),
+			Group:    ifThen(strings.TrimSpace(n.Name) != "", n.Name, strings.ToUpper(ex.Protocol)),
+			Type:     ex.Protocol,
+			Server:   host,
+			Port:     ex.Port,
+			Password: ifThen(ex.Protocol == "vless", "", cred),
+			Params:   params,
+		})
+	}
+	return items
+}
+
+// nativeExitForTarget finds the native exit a forward targets by its port.
+func nativeExitForTarget(exits []model.NativeExit, nodeID int64, remoteAddr string) (model.NativeExit, bool) {
+	_, portStr, err := net.SplitHostPort(firstTargetHost(remoteAddr))
+	if err != nil {
+		return model.NativeExit{}, false
+	}
+	port, _ := strconv.Atoi(portStr)
+	for _, ex := range exits {
+		if ex.NodeID == nodeID && ex.Port == port {
+			return ex, true
+		}
+	}
+	return model.NativeExit{}, false
+}
+
+// nodeExitPortTaken checks a port against every exit listener of the node;
+// the except* IDs skip the record being updated.
+func nodeExitPortTaken(nodeID int64, port int, exceptInbound, exceptNative int64) bool {
+	if anytlsInboundPortTaken(nodeID, port, exceptInbound) {
+		return true
+	}
+	var cnt int64
+	dbpkg.DB.Model(&model.NativeExit{}).Where("node_id = ? AND port = ? AND id <> ?", nodeID, port, exceptNative).Count(&cnt)
+	return cnt > 0
+}
+
+type nativeExitReq struct {
+	ID               int64   `json:"id"`
+	NodeID           int64   `json:"nodeId"`
+	Protocol         string  `json:"protocol"`
+	Name             *string `json:"name"`
+	Port             *int    `json:"port"`
+	Secret           *string `json:"secret"`
+	Flow             *string `json:"flow"`
+	SNI              *string `json:"sni"`
+	RealityHandshake *string `json:"realityHandshake"`
+	RealityRegen     bool    `json:"realityRegen"`
+	ObfsPassword     *string `json:"obfsPassword"`
+	UpMbps           *int    `json:"upMbps"`
+	DownMbps         *int    `json:"downMbps"`
+}
+
+func (p nativeExitReq) applyTo(ex *model.NativeExit) string {
+	if p.Name != nil {
+		ex.Name = strings.TrimSpace(*p.Name)
+		if len(ex.Name) > 64 {
+			return "名称过长"
+		}
+	}
+	if p.Port != nil {
+		ex.Port = *p.Port
+	}
+	if ex.Port <= 0 || ex.Port > 65535 {
+		return "无效的端口"
+	}
+	if nodeExitPortTaken(ex.NodeID, ex.Port, 0, ex.ID) {
+		return "端口已被该节点的其他出口占用"
+	}
+	if p.Secret != nil {
+		ex.Secret = strings.TrimSpace(*p.Secret)
+	}
+	if ex.Secret == "" {
+		ex.Secret = nativeExitRandomSecret()
+	}
+	if ex.Protocol == "vless" && !nativeExitUUIDRe.MatchString(ex.Secret) {
+		return "VLESS 密钥需为 UUID"
+	}
+	if p.Flow != nil {
+		ex.Flow = strings.TrimSpace(*p.Flow)
+	}
+	if ex.Protocol != "vless" {
+		ex.Flow = ""
+	}
+	if !nativeExitFlows[ex.Flow] {
+		return "不支持的 flow"
+	}
+	if p.SNI != nil {
+		ex.SNI = strings.ToLower(strings.TrimSpace(*p.SNI))
+	}
+	if p.RealityHandshake != nil {
+		ex.RealityHandshake = strings.TrimSpace(*p.RealityHandshake)
+	}
+	if ex.Protocol != "vless" {
+		ex.RealityHandshake = ""
+	}
+	if ex.RealityHandshake != "" {
+		host, port, err := net.SplitHostPort(ex.RealityHandshake)
+		if n, _ := strconv.Atoi(port); err != nil || host == "" || n <= 0 || n > 65535 {
+			return "Reality 握手目标格式应为 host:port"
+		}
+		if ex.RealityPrivateKey == "" || p.RealityRegen {
+			priv, pub, sid, err := nativeExitRealityKeys()
+			if err != nil {
+				return "生成 Reality 密钥失败"
+			}
+			ex.RealityPrivateKey, ex.RealityPublicKey, ex.RealityShortID = priv, pub, sid
+		}
+	}
+	if p.ObfsPassword != nil {
+		ex.ObfsPassword = strings.TrimSpace(*p.ObfsPassword)
+	}
+	if p.UpMbps != nil {
+		ex.UpMbps = *p.UpMbps
+	}
+	if p.DownMbps != nil {
+		ex.DownMbps = *p.DownMbps
+	}
+	if ex.Protocol != "hysteria2" {
+		ex.ObfsPassword, ex.UpMbps, ex.DownMbps = "", 0, 0
+	}
+	if ex.UpMbps < 0 || ex.DownMbps < 0 {
+		return "带宽无效"
+	}
+	return ""
+}
+
+func nativeExitView(ex model.NativeExit) map[string]any {
+	return map[string]any{
+		"id": ex.ID, "nodeId": ex.NodeID, "name": ex.Name, "protocol": ex.Protocol, "port": ex.Port,
+		"secret": ex.Secret, "flow": ex.Flow, "sni": ex.SNI, "realityHandshake": ex.RealityHandshake,
+		"realityPublicKey": ex.RealityPublicKey, "realityShortId": ex.RealityShortID,
+		"obfsPassword": ex.ObfsPassword, "upMbps": ex.UpMbps, "downMbps": ex.DownMbps,
+		"createdTime": ex.CreatedTime, "updatedTime": ex.UpdatedTime,
+	}
+}
+
+// NativeExitList 原生出口（VLESS/Trojan/Hysteria2）列表（管理员）
+// @Summary 原生出口列表
+// @Tags node
+// @Accept json
+// @Produce json
+// @Param data body object true "{nodeId?}"
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/node/native-exit/list [post]
+func NativeExitList(c *gin.Context) {
+	var p struct {
+		NodeID int64 `json:"nodeId"`
+	}
+	_ = c.ShouldBindJSON(&p)
+	q := dbpkg.DB.Model(&model.NativeExit{})
+	if p.NodeID > 0 {
+		q = q.Where("node_id = ?", p.NodeID)
+	}
+	var rows []model.NativeExit
+	q.Order("node_id asc, port asc").Find(&rows)
+	out := make([]map[string]any, 0, len(rows))
+	for _, ex := range rows {
+		out = append(out, nativeExitView(ex))
+	}
+	c.JSON(http.StatusOK, response.Ok(out))
+}
+
+// NativeExitCreate 创建原生出口（管理员）
+// @Summary 创建原生出口
+// @Tags node
+// @Accept json
+// @Produce json
+// @Param data body object true "{nodeId, protocol(vless/trojan/hysteria2), port, name?, secret?, flow?, sni?, realityHandshake?, obfsPassword?, upMbps?, downMbps?}"
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/node/native-exit/create [post]
+func NativeExitCreate(c *gin.Context) {
+	var p nativeExitReq
+	if err := c.ShouldBindJSON(&p); err != nil || p.NodeID <= 0 || p.Port == nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	p.Protocol = strings.ToLower(strings.TrimSpace(p.Protocol))
+	if p.Protocol == "hy2" {
+		p.Protocol = "hysteria2"
+	}
+	if !nativeExitProtocols[p.Protocol] {
+		c.JSON(http.StatusOK, response.ErrMsg("协议仅支持 vless、trojan 或 hysteria2"))
+		return
+	}
+	var node model.Node
+	if err := dbpkg.DB.First(&node, p.NodeID).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("节点不存在"))
+		return
+	}
+	now := time.Now().UnixMilli()
+	ex := model.NativeExit{NodeID: p.NodeID, Protocol: p.Protocol, CreatedTime: now, UpdatedTime: now}
+	if msg := p.applyTo(&ex); msg != "" {
+		c.JSON(http.StatusOK, response.ErrMsg(msg))
+		return
+	}
+	if err := dbpkg.DB.Create(&ex).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("保存失败"))
+		return
+	}
+	pushNativeExitsToNode(ex.NodeID)
+	c.JSON(http.StatusOK, response.Ok(nativeExitView(ex)))
+}
+
+// NativeExitUpdate 更新原生出口（管理员），协议不可修改
+// @Summary 更新原生出口
+// @Tags node
+// @Accept json
+// @Produce json
+// @Param data body object true "{id, port?, name?, secret?, flow?, sni?, realityHandshake?, realityRegen?, obfsPassword?, upMbps?, downMbps?}"
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/node/native-exit/update [post]
+func NativeExitUpdate(c *gin.Context) {
+	var p nativeExitReq
+	if err := c.ShouldBindJSON(&p); err != nil || p.ID <= 0 {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	var ex model.NativeExit
+	if err := dbpkg.DB.First(&ex, p.ID).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("出口不存在"))
+		return
+	}
+	if msg := p.applyTo(&ex); msg != "" {
+		c.JSON(http.StatusOK, response.ErrMsg(msg))
+		return
+	}
+	ex.UpdatedTime = time.Now().UnixMilli()
+	if err := dbpkg.DB.Save(&ex).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("保存失败"))
+		return
+	}
+	pushNativeExitsToNode(ex.NodeID)
+	c.JSON(http.StatusOK, response.Ok(nativeExitView(ex)))
+}
+
+// NativeExitDelete 删除原生出口（管理员）
+// @Summary 删除原生出口
+// @Tags node
+// @Accept json
+// @Produce json
+// @Param data body object true "{id}"
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/node/native-exit/delete [post]
+func NativeExitDelete(c *gin.Context) {
+	var p struct {
+		ID int64 `json:"id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	var ex model.NativeExit
+	if err := dbpkg.DB.First(&ex, p.ID).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("出口不存在"))
+		return
+	}
+	if err := dbpkg.DB.Delete(&ex).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("删除失败"))
+		return
+	}
+	pushNativeExitsToNode(ex.NodeID)
+	c.JSON(http.StatusOK, response.OkMsg("删除成功"))
+}
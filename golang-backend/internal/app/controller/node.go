@@ -3,6 +3,7 @@ package controller
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -37,17 +38,25 @@ func NodeCreate(c *gin.Context) {
 		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
 		return
 	}
-	if req.PortSta < 1 || req.PortSta > 65535 || req.PortEnd < 1 || req.PortEnd > 65535 || req.PortEnd < req.PortSta {
-		c.JSON(http.StatusOK, response.ErrMsg("端口范围无效"))
-		return
-	}
-	now := time.Now().UnixMilli()
-	status := 0
 	var owner *int64
 	if uidInf, ok := c.Get("user_id"); ok {
 		uid := uidInf.(int64)
 		owner = &uid
 	}
+	if _, err := createNode(req, owner); err != nil {
+		replyErr(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, response.OkMsg("节点创建成功"))
+}
+
+// createNode saves a new node owned by owner.
+func createNode(req dto.NodeDto, owner *int64) (model.Node, error) {
+	if req.PortSta < 1 || req.PortSta > 65535 || req.PortEnd < 1 || req.PortEnd > 65535 || req.PortEnd < req.PortSta {
+		return model.Node{}, errors.New("端口范围无效")
+	}
+	now := time.Now().UnixMilli()
+	status := 0
 	n := model.Node{BaseEntity: model.BaseEntity{CreatedTime: now, UpdatedTime: now, Status: &status}, Name: req.Name, IP: req.IP, ServerIP: req.ServerIP, PortSta: req.PortSta, PortEnd: req.PortEnd, OwnerID: owner}
 	n.PriceCents = req.PriceCents
 	// prefer cycleMonths, fallback to cycleDays
@@ -63,10 +72,9 @@ func NodeCreate(c *gin.Context) {
 	// simple secret
 	n.Secret = RandUUID()
 	if err := dbpkg.DB.Create(&n).Error; err != nil {
-		c.JSON(http.StatusOK, response.ErrMsg("节点创建失败"))
-		return
+		return model.Node{}, errors.New("节点创建失败")
 	}
-	c.JSON(http.StatusOK, response.OkMsg("节点创建成功"))
+	return n, nil
 }
 
 // NodeList 节点列表
@@ -75,10 +83,13 @@ func NodeCreate(c *gin.Context) {
 // @Accept json
 // @Produce json
 // @Param offline_threshold_ms query int false "判定离线的阈值(毫秒)，默认30000"
+// @Param data body object false "管理员筛选 {groupId?, labels?: {region: hk}, nodeIds?}"
 // @Success 200 {object} SwaggerResp
 // @Router /api/v1/node/list [post]
 // POST /api/v1/node/list
 func NodeList(c *gin.Context) {
+	var filter nodeSelector
+	_ = c.ShouldBindJSON(&filter)
 	var nodes []model.Node
 	var userNodeMap map[int64]model.UserNode
 	forwardNodes := map[int64]bool{}
@@ -168,6 +179,38 @@ func NodeList(c *gin.Context) {
 	} else {
 		dbpkg.DB.Find(&nodes)
 	}
+	// admin view: labels, group membership and filtering by them
+	var labels map[int64]map[string]string
+	nodeGroups := map[int64][]string{}
+	if uid == 0 {
+		if !filter.empty() {
+			ids, msg := filter.resolve()
+			if msg != "" {
+				c.JSON(http.StatusOK, response.ErrMsg(msg))
+				return
+			}
+			keep := map[int64]bool{}
+			for _, id := range ids {
+				keep[id] = true
+			}
+			filtered := nodes[:0]
+			for _, n := range nodes {
+				if keep[n.ID] {
+					filtered = append(filtered, n)
+				}
+			}
+			nodes = filtered
+		}
+		labels = loadNodeLabels(nil)
+		var groups []model.NodeGroup
+		dbpkg.DB.Order("name asc").Find(&groups)
+		all := allNodeIDs()
+		for _, g := range groups {
+			for id := range nodeGroupMembers(g, all, labels) {
+				nodeGroups[id] = append(nodeGroups[id], g.Name)
+			}
+		}
+	}
 	// websocket status already persisted in node.status; no sysinfo-based override
 	// map to output adding cycleMonths for clarity; keep other fields
 	// runtime snapshots (interfaces / used ports)
@@ -219,6 +262,10 @@ func NodeList(c *gin.Context) {
 			"gostApi":     ifThen(ok && hf.GostAPI, 1, 0),
 			"gostRunning": ifThen(ok && hf.GostRunning, 1, 0),
 		}
+		if labels != nil {
+			m["labels"] = ifThen(labels[n.ID] != nil, labels[n.ID], map[string]string{})
+			m["groups"] = ifThen(nodeGroups[n.ID] != nil, nodeGroups[n.ID], []string{})
+		}
 		if rt, ok := runtimeMap[n.ID]; ok {
 			if !isShared && rt.UsedPorts != nil && *rt.UsedPorts != "" {
 				var list []int
@@ -273,22 +320,25 @@ func NodeUpdate(c *gin.Context) {
 		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
 		return
 	}
+	if err := updateNode(req, c.GetInt64("user_id"), c.GetInt("role_id")); err != nil {
+		replyErr(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, response.OkMsg("节点更新成功"))
+}
+
+// updateNode saves a node and the tunnel IPs derived from it; non-admin
+// roles may only change their own nodes.
+func updateNode(req dto.NodeUpdateDto, uid int64, role int) error {
 	var n model.Node
 	if err := dbpkg.DB.First(&n, req.ID).Error; err != nil {
-		c.JSON(http.StatusOK, response.ErrMsg("节点不存在"))
-		return
+		return errors.New("节点不存在")
 	}
-	if roleInf, ok := c.Get("role_id"); ok && roleInf != 0 {
-		if uidInf, ok2 := c.Get("user_id"); ok2 {
-			if n.OwnerID == nil || *n.OwnerID != uidInf.(int64) {
-				c.JSON(http.StatusForbidden, response.ErrMsg("无权限"))
-				return
-			}
-		}
+	if role != 0 && (n.OwnerID == nil || *n.OwnerID != uid) {
+		return errNoPermission
 	}
 	if req.PortSta < 1 || req.PortSta > 65535 || req.PortEnd < 1 || req.PortEnd > 65535 || req.PortEnd < req.PortSta {
-		c.JSON(http.StatusOK, response.ErrMsg("端口范围无效"))
-		return
+		return errors.New("端口范围无效")
 	}
 	n.Name, n.IP, n.ServerIP, n.PortSta, n.PortEnd = req.Name, req.IP, req.ServerIP, req.PortSta, req.PortEnd
 	if req.PriceCents != nil {
@@ -307,13 +357,12 @@ func NodeUpdate(c *gin.Context) {
 	}
 	n.UpdatedTime = time.Now().UnixMilli()
 	if err := dbpkg.DB.Save(&n).Error; err != nil {
-		c.JSON(http.StatusOK, response.ErrMsg("节点更新失败"))
-		return
+		return errors.New("节点更新失败")
 	}
 	// update tunnels referencing IPs
 	dbpkg.DB.Model(&model.Tunnel{}).Where("in_node_id = ?", n.ID).Update("in_ip", n.IP)
 	dbpkg.DB.Model(&model.Tunnel{}).Where("out_node_id = ?", n.ID).Update("out_ip", n.ServerIP)
-	c.JSON(http.StatusOK, response.OkMsg("节点更新成功"))
+	return nil
 }
 
 // NodeSelfCheck runs a quick outbound connectivity check from the node.
@@ -334,22 +383,27 @@ func NodeSelfCheck(c *gin.Context) {
 		c.JSON(http.StatusOK, response.ErrMsg(errMsg))
 		return
 	}
+	c.JSON(http.StatusOK, response.Ok(nodeSelfCheck(req.NodeID)))
+}
+
+// nodeSelfCheck pings 1.1.1.1 and connects to 1.1.1.1:80 from the node.
+func nodeSelfCheck(nodeID int64) map[string]any {
 	avg, loss, ok, msg, rid := diagnosePingFromNodeCtx(
-		req.NodeID,
+		nodeID,
 		"1.1.1.1",
 		3,
 		1500,
-		map[string]any{"src": "node", "step": "ping", "nodeId": req.NodeID},
+		map[string]any{"src": "node", "step": "ping", "nodeId": nodeID},
 	)
 	avg2, loss2, ok2, msg2, rid2 := diagnoseFromNodeCtx(
-		req.NodeID,
+		nodeID,
 		"1.1.1.1",
 		80,
 		2,
 		1500,
-		map[string]any{"src": "node", "step": "tcp", "nodeId": req.NodeID},
+		map[string]any{"src": "node", "step": "tcp", "nodeId": nodeID},
 	)
-	c.JSON(http.StatusOK, response.Ok(map[string]any{
+	return map[string]any{
 		"ping": map[string]any{
 			"success":     ok,
 			"averageTime": avg,
@@ -368,7 +422,7 @@ func NodeSelfCheck(c *gin.Context) {
 			"target":      "1.1.1.1:80",
 			"targetType":  "tcp",
 		},
-	}))
+	}
 }
 
 func monthsToDays(m int) int {
@@ -417,13 +471,6 @@ func NodeDelete(c *gin.Context) {
 			return
 		}
 	}
-	// usage checks
-	var cnt int64
-	dbpkg.DB.Model(&model.Tunnel{}).Where("in_node_id = ?", p.ID).Or("out_node_id = ?", p.ID).Count(&cnt)
-	if cnt > 0 {
-		c.JSON(http.StatusOK, response.ErrMsg("该节点仍被隧道使用"))
-		return
-	}
 	// permission
 	if roleInf, ok := c.Get("role_id"); ok && roleInf != 0 {
 		var node model.Node
@@ -436,15 +483,37 @@ func NodeDelete(c *gin.Context) {
 			}
 		}
 	}
-	// best-effort notify agent to self-uninstall when node is removed
-	_ = sendWSCommand(p.ID, "UninstallAgent", map[string]any{"reason": "node_deleted"})
-	if err := dbpkg.DB.Delete(&model.Node{}, p.ID).Error; err != nil {
-		c.JSON(http.StatusOK, response.ErrMsg("节点删除失败"))
+	if err := deleteNode(p.ID); err != nil {
+		replyErr(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, response.OkMsg("节点删除成功"))
 }
 
+// deleteNode removes a node no tunnel uses, together with its per-node rows,
+// and asks its agent to uninstall itself.
+func deleteNode(id int64) error {
+	// usage checks
+	var cnt int64
+	dbpkg.DB.Model(&model.Tunnel{}).Where("in_node_id = ?", id).Or("out_node_id = ?", id).Count(&cnt)
+	if cnt > 0 {
+		return errors.New("该节点仍被隧道使用")
+	}
+	// best-effort notify agent to self-uninstall when node is removed
+	_ = sendWSCommand(id, "UninstallAgent", map[string]any{"reason": "node_deleted"})
+	if err := dbpkg.DB.Delete(&model.Node{}, id).Error; err != nil {
+		return errors.New("节点删除失败")
+	}
+	releaseOverlayIP(id)
+	_ = dbpkg.DB.Where("node_id = ?", id).Delete(&model.NodeLabel{}).Error
+	_ = dbpkg.DB.Where("node_id = ?", id).Delete(&model.FlowReportSeq{}).Error
+	_ = dbpkg.DB.Where("node_id = ?", id).Delete(&model.AnyTLSViolation{}).Error
+	_ = dbpkg.DB.Where("node_id = ?", id).Delete(&model.ExitCert{}).Error
+	_ = dbpkg.DB.Where("node_id = ?", id).Delete(&model.AnyTLSInbound{}).Error
+	_ = dbpkg.DB.Where("node_id = ?", id).Delete(&model.NativeExit{}).Error
+	return nil
+}
+
 // NodeInstallCmd 获取节点安装命令
 // @Summary 获取节点安装命令
 // @Tags node
@@ -589,9 +658,15 @@ func NodeRestartGost(c *gin.Context) {
 		c.JSON(http.StatusOK, response.ErrMsg("节点不存在"))
 		return
 	}
+	succ, msg := restartGostOnNode(p.NodeID)
+	c.JSON(http.StatusOK, response.Ok(map[string]any{"success": succ, "message": msg}))
+}
+
+// restartGostOnNode asks the agent to restart gost and waits for its result.
+func restartGostOnNode(nodeID int64) (bool, string) {
 	// Prefer RestartService with name=gost to get explicit success/failure
 	req := map[string]interface{}{"requestId": RandUUID(), "name": "gost"}
-	if res, ok := RequestOp(p.NodeID, "RestartService", req, 8*time.Second); ok {
+	if res, ok := RequestOp(nodeID, "RestartService", req, 8*time.Second); ok {
 		// parse result
 		data, _ := res["data"].(map[string]interface{})
 		succ := false
@@ -604,12 +679,11 @@ func NodeRestartGost(c *gin.Context) {
 				msg = v
 			}
 		}
-		c.JSON(http.StatusOK, response.Ok(map[string]any{"success": succ, "message": msg}))
-		return
+		return succ, msg
 	}
 	// Fallback: fire-and-forget old command; return timeout message
-	_ = sendWSCommand(p.NodeID, "RestartGost", map[string]any{"reason": "manual_from_ui"})
-	c.JSON(http.StatusOK, response.Ok(map[string]any{"success": false, "message": "agent未回执，已下发重启命令"}))
+	_ = sendWSCommand(nodeID, "RestartGost", map[string]any{"reason": "manual_from_ui"})
+	return false, "agent未回执，已下发重启命令"
 }
 
 // NodeEnableGostAPI 启用gost API
@@ -0,0 +1,547 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"network-panel/golang-backend/internal/app/dto"
+	"network-panel/golang-backend/internal/app/model"
+	"network-panel/golang-backend/internal/app/response"
+	dbpkg "network-panel/golang-backend/internal/db"
+)
+
+// ---- Node labels and groups ----
+//
+// Labels are key/value tags (region, provider, role, ...). A group is a named
+// node set: explicit members plus nodes matching its label selector. Bulk
+// actions take a node selector {groupId, labels, nodeIds}; the given filters
+// are intersected.
+
+var nodeLabelKeyRe = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{0,31}$`)
+
+var nodeRoles = map[string]bool{"entry": true, "relay": true, "exit": true}
+
+func validateNodeLabels(labels map[string]string) string {
+	for k, v := range labels {
+		if !nodeLabelKeyRe.MatchString(k) {
+			return "标签名仅支持小写字母、数字及 _.-，最长 32 位"
+		}
+		if v = strings.TrimSpace(v); v == "" || len(v) > 64 {
+			return "标签值不能为空且不超过 64 位"
+		}
+		if k == "role" && !nodeRoles[v] {
+			return "role 仅支持 entry/relay/exit"
+		}
+	}
+	return ""
+}
+
+// loadNodeLabels returns labels per node (all nodes when ids is nil).
+func loadNodeLabels(ids []int64) map[int64]map[string]string {
+	var rows []model.NodeLabel
+	q := dbpkg.DB
+	if ids != nil {
+		if len(ids) == 0 {
+			return map[int64]map[string]string{}
+		}
+		q = q.Where("node_id IN ?", ids)
+	}
+	q.Find(&rows)
+	out := map[int64]map[string]string{}
+	for _, r := range rows {
+		if out[r.NodeID] == nil {
+			out[r.NodeID] = map[string]string{}
+		}
+		out[r.NodeID][r.Key] = r.Value
+	}
+	return out
+}
+
+func labelsMatch(have, want map[string]string) bool {
+	for k, v := range want {
+		if have[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func parseNodeGroup(g model.NodeGroup) ([]int64, map[string]string) {
+	var ids []int64
+	var sel map[string]string
+	if strings.TrimSpace(g.NodeIDs) != "" {
+		_ = json.Unmarshal([]byte(g.NodeIDs), &ids)
+	}
+	if strings.TrimSpace(g.Selector) != "" {
+		_ = json.Unmarshal([]byte(g.Selector), &sel)
+	}
+	return ids, sel
+}
+
+// nodeGroupMembers resolves a group to existing node ids.
+func nodeGroupMembers(g model.NodeGroup, allNodes []int64, labels map[int64]map[string]string) map[int64]bool {
+	ids, sel := parseNodeGroup(g)
+	exists := map[int64]bool{}
+	for _, id := range allNodes {
+		exists[id] = true
+	}
+	out := map[int64]bool{}
+	for _, id := range ids {
+		if exists[id] {
+			out[id] = true
+		}
+	}
+	if len(sel) > 0 {
+		for _, id := range allNodes {
+			if labelsMatch(labels[id], sel) {
+				out[id] = true
+			}
+		}
+	}
+	return out
+}
+
+func allNodeIDs() []int64 {
+	var ids []int64
+	dbpkg.DB.Model(&model.Node{}).Order("id asc").Pluck("id", &ids)
+	return ids
+}
+
+// nodeGroupMemberSet resolves a group id; nil when the group does not exist.
+func nodeGroupMemberSet(groupID int64) map[int64]bool {
+	var g model.NodeGroup
+	if err := dbpkg.DB.First(&g, groupID).Error; err != nil {
+		return nil
+	}
+	return nodeGroupMembers(g, allNodeIDs(), loadNodeLabels(nil))
+}
+
+type nodeSelector struct {
+	GroupID int64             `json:"groupId"`
+	Labels  map[string]string `json:"labels"`
+	NodeIDs []int64           `json:"nodeIds"`
+}
+
+func (s nodeSelector) empty() bool {
+	return s.GroupID == 0 && len(s.Labels) == 0 && len(s.NodeIDs) == 0
+}
+
+// resolve returns the selected node ids in ascending order.
+func (s nodeSelector) resolve() ([]int64, string) {
+	all := allNodeIDs()
+	labels := loadNodeLabels(nil)
+	var group map[int64]bool
+	if s.GroupID > 0 {
+		var g model.NodeGroup
+		if err := dbpkg.DB.First(&g, s.GroupID).Error; err != nil {
+			return nil, "节点分组不存在"
+		}
+		group = nodeGroupMembers(g, all, labels)
+	}
+	var listed map[int64]bool
+	if len(s.NodeIDs) > 0 {
+		listed = map[int64]bool{}
+		for _, id := range s.NodeIDs {
+			listed[id] = true
+		}
+	}
+	out := make([]int64, 0)
+	for _, id := range all {
+		if group != nil && !group[id] {
+			continue
+		}
+		if listed != nil && !listed[id] {
+			continue
+		}
+		if len(s.Labels) > 0 && !labelsMatch(labels[id], s.Labels) {
+			continue
+		}
+		out = append(out, id)
+	}
+	return out, ""
+}
+
+// NodeGroupList 节点分组列表（管理员）
+// @Summary 节点分组列表（含解析后的成员）
+// @Tags node
+// @Produce json
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/node/groups/list [post]
+func NodeGroupList(c *gin.Context) {
+	var groups []model.NodeGroup
+	dbpkg.DB.Order("name asc").Find(&groups)
+	all := allNodeIDs()
+	labels := loadNodeLabels(nil)
+	out := make([]map[string]any, 0, len(groups))
+	for _, g := range groups {
+		ids, sel := parseNodeGroup(g)
+		members := make([]int64, 0)
+		for id := range nodeGroupMembers(g, all, labels) {
+			members = append(members, id)
+		}
+		sort.Slice(members, func(i, j int) bool { return members[i] < members[j] })
+		out = append(out, map[string]any{
+			"id": g.ID, "name": g.Name, "description": g.Description, "nodeIds": ids, "selector": sel,
+			"members": members, "createdTime": g.CreatedTime, "updatedTime": g.UpdatedTime,
+		})
+	}
+	c.JSON(http.StatusOK, response.Ok(out))
+}
+
+type nodeGroupReq struct {
+	ID          int64             `json:"id"`
+	Name        *string           `json:"name"`
+	Description *string           `json:"description"`
+	NodeIDs     []int64           `json:"nodeIds"`
+	Selector    map[string]string `json:"selector"`
+}
+
+func (p nodeGroupReq) applyTo(g *model.NodeGroup) string {
+	if p.Name != nil {
+		g.Name = strings.TrimSpace(*p.Name)
+	}
+	if g.Name == "" {
+		return "分组名称不能为空"
+	}
+	if p.Description != nil {
+		g.Description = strings.TrimSpace(*p.Description)
+	}
+	if p.NodeIDs != nil {
+		b, _ := json.Marshal(p.NodeIDs)
+		g.NodeIDs = string(b)
+	}
+	if p.Selector != nil {
+		if msg := validateNodeLabels(p.Selector); msg != "" {
+			return msg
+		}
+		g.Selector = ""
+		if len(p.Selector) > 0 {
+			b, _ := json.Marshal(p.Selector)
+			g.Selector = string(b)
+		}
+	}
+	var dup int64
+	dbpkg.DB.Model(&model.NodeGroup{}).Where("name = ? AND id <> ?", g.Name, g.ID).Count(&dup)
+	if dup > 0 {
+		return "分组名称已存在"
+	}
+	return ""
+}
+
+// NodeGroupCreate 创建节点分组（管理员）
+// @Summary 创建节点分组
+// @Tags node
+// @Accept json
+// @Produce json
+// @Param data body object true "{name, description?, nodeIds?: [], selector?: {region: hk}}"
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/node/groups/create [post]
+func NodeGroupCreate(c *gin.Context) {
+	var p nodeGroupReq
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	now := time.Now().UnixMilli()
+	g := model.NodeGroup{NodeIDs: "[]", CreatedTime: now, UpdatedTime: now}
+	if msg := p.applyTo(&g); msg != "" {
+		c.JSON(http.StatusOK, response.ErrMsg(msg))
+		return
+	}
+	if err := dbpkg.DB.Create(&g).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("保存失败"))
+		return
+	}
+	c.JSON(http.StatusOK, response.Ok(g))
+}
+
+// NodeGroupUpdate 更新节点分组（管理员）
+// @Summary 更新节点分组
+// @Tags node
+// @Accept json
+// @Produce json
+// @Param data body object true "{id, name?, description?, nodeIds?, selector?}"
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/node/groups/update [post]
+func NodeGroupUpdate(c *gin.Context) {
+	var p nodeGroupReq
+	if err := c.ShouldBindJSON(&p); err != nil || p.ID <= 0 {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	var g model.NodeGroup
+	if err := dbpkg.DB.First(&g, p.ID).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("节点分组不存在"))
+		return
+	}
+	if msg := p.applyTo(&g); msg != "" {
+		c.JSON(http.StatusOK, response.ErrMsg(msg))
+		return
+	}
+	g.UpdatedTime = time.Now().UnixMilli()
+	if err := dbpkg.DB.Save(&g).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("保存失败"))
+		return
+	}
+	c.JSON(http.StatusOK, response.Ok(g))
+}
+
+// NodeGroupDelete 删除节点分组（管理员）；引用该分组的探测目标与告警规则改回按节点列表匹配
+// @Summary 删除节点分组
+// @Tags node
+// @Accept json
+// @Produce json
+// @Param data body object true "{id}"
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/node/groups/delete [post]
+func NodeGroupDelete(c *gin.Context) {
+	var p struct {
+		ID int64 `json:"id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	_ = dbpkg.DB.Model(&model.ProbeTarget{}).Where("group_id = ?", p.ID).Update("group_id", nil).Error
+	_ = dbpkg.DB.Model(&model.AlertRule{}).Where("group_id = ?", p.ID).Update("group_id", nil).Error
+	_ = dbpkg.DB.Delete(&model.NodeGroup{}, p.ID).Error
+	c.JSON(http.StatusOK, response.OkNoData())
+}
+
+func setNodeLabels(nodeID int64, set map[string]string, remove []string, replace bool) error {
+	q := dbpkg.DB.Where("node_id = ?", nodeID)
+	if !replace {
+		keys := append([]string(nil), remove...)
+		for k := range set {
+			keys = append(keys, k)
+		}
+		if len(keys) == 0 {
+			return nil
+		}
+		q = q.Where("label_key IN ?", keys)
+	}
+	if err := q.Delete(&model.NodeLabel{}).Error; err != nil {
+		return err
+	}
+	for _, k := range sortedKeys(set) {
+		if err := dbpkg.DB.Create(&model.NodeLabel{NodeID: nodeID, Key: k, Value: strings.TrimSpace(set[k])}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NodeLabelSet 设置节点标签（管理员，整体替换）
+// @Summary 设置节点标签
+// @Tags node
+// @Accept json
+// @Produce json
+// @Param data body object true "{nodeId, labels: {region, provider, role, ...}}"
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/node/labels/set [post]
+func NodeLabelSet(c *gin.Context) {
+	var p struct {
+		NodeID int64             `json:"nodeId" binding:"required"`
+		Labels map[string]string `json:"labels"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	if msg := validateNodeLabels(p.Labels); msg != "" {
+		c.JSON(http.StatusOK, response.ErrMsg(msg))
+		return
+	}
+	var n model.Node
+	if err := dbpkg.DB.First(&n, p.NodeID).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("节点不存在"))
+		return
+	}
+	if err := setNodeLabels(n.ID, p.Labels, nil, true); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("保存失败"))
+		return
+	}
+	c.JSON(http.StatusOK, response.OkNoData())
+}
+
+// NodeLabelBulk 批量修改节点标签（管理员）
+// @Summary 批量修改节点标签
+// @Tags node
+// @Accept json
+// @Produce json
+// @Param data body object true "{groupId?, labels?, nodeIds?, set?: {k: v}, remove?: [k]}"
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/node/labels/bulk [post]
+func NodeLabelBulk(c *gin.Context) {
+	var p struct {
+		nodeSelector
+		Set    map[string]string `json:"set"`
+		Remove []string          `json:"remove"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil || p.empty() || (len(p.Set) == 0 && len(p.Remove) == 0) {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	if msg := validateNodeLabels(p.Set); msg != "" {
+		c.JSON(http.StatusOK, response.ErrMsg(msg))
+		return
+	}
+	ids, msg := p.resolve()
+	if msg != "" {
+		c.JSON(http.StatusOK, response.ErrMsg(msg))
+		return
+	}
+	for _, id := range ids {
+		if err := setNodeLabels(id, p.Set, p.Remove, false); err != nil {
+			c.JSON(http.StatusOK, response.ErrMsg("保存失败"))
+			return
+		}
+	}
+	c.JSON(http.StatusOK, response.Ok(map[string]any{"nodeIds": ids}))
+}
+
+// NodeLabelKeys 已使用的标签及取值（用于筛选）
+// @Summary 节点标签取值
+// @Tags node
+// @Produce json
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/node/labels/keys [post]
+func NodeLabelKeys(c *gin.Context) {
+	var rows []model.NodeLabel
+	dbpkg.DB.Select("DISTINCT label_key, label_value").Order("label_key asc, label_value asc").Find(&rows)
+	out := map[string][]string{}
+	for _, r := range rows {
+		out[r.Key] = append(out[r.Key], r.Value)
+	}
+	c.JSON(http.StatusOK, response.Ok(out))
+}
+
+// NodeBulkAction 按分组/标签批量操作节点（管理员）
+// @Summary 节点批量操作：restart-gost | upgrade-agent | self-check | reapply | assign-user
+// @Tags node
+// @Accept json
+// @Produce json
+// @Param data body object true "{groupId?, labels?, nodeIds?, action, assign?: {userId, flow, num, portRanges, flowResetTime, expTime, speedMbps, status}}"
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/node/bulk [post]
+func NodeBulkAction(c *gin.Context) {
+	var p struct {
+		nodeSelector
+		Action string           `json:"action" binding:"required"`
+		Assign *dto.UserNodeDto `json:"assign"`
+	}
+	// assign.nodeId is filled per node, so bind leniently
+	if err := json.NewDecoder(c.Request.Body).Decode(&p); err != nil || p.empty() {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	var run func(n model.Node) (bool, string, any)
+	switch p.Action {
+	case "restart-gost":
+		run = func(n model.Node) (bool, string, any) {
+			ok, msg := restartGostOnNode(n.ID)
+			return ok, msg, nil
+		}
+	case "upgrade-agent":
+		run = func(n model.Node) (bool, string, any) {
+			nodeConnMu.RLock()
+			conns := append([]*nodeConn(nil), nodeConns[n.ID]...)
+			nodeConnMu.RUnlock()
+			if len(conns) == 0 {
+				return false, "节点离线", nil
+			}
+			outdated := false
+			for _, nc := range conns {
+				outdated = outdated || nc.ver != expectedAgentVersion(ifThen(strings.HasPrefix(nc.ver, "go-agent2-"), "agent2", "agent"))
+			}
+			if !outdated {
+				return true, "已是最新版本", nil
+			}
+			// the command reaches every connection of the node and each agent
+			// upgrades its own binary, so one send covers agent and agent2
+			if err := sendWSCommand(n.ID, "UpgradeAgent", map[string]any{"to": expectedAgentVersion("agent")}); err != nil {
+				return false, err.Error(), nil
+			}
+			return true, "已下发升级命令", nil
+		}
+	case "self-check":
+		run = func(n model.Node) (bool, string, any) {
+			res := nodeSelfCheck(n.ID)
+			ping, _ := res["ping"].(map[string]any)
+			tcp, _ := res["tcp"].(map[string]any)
+			ok := ping["success"] == true && tcp["success"] == true
+			return ok, "", res
+		}
+	case "reapply":
+		run = func(n model.Node) (bool, string, any) {
+			pushed, err := reapplyNodeServices(n.ID)
+			if err != nil {
+				return false, "节点离线", nil
+			}
+			return true, "", map[string]any{"pushed": pushed}
+		}
+	case "assign-user":
+		if p.Assign == nil || p.Assign.UserID <= 0 {
+			c.JSON(http.StatusOK, response.ErrMsg("请指定分配的用户"))
+			return
+		}
+		var cnt int64
+		dbpkg.DB.Model(&model.User{}).Where("id = ?", p.Assign.UserID).Count(&cnt)
+		if cnt == 0 {
+			c.JSON(http.StatusOK, response.ErrMsg("用户不存在"))
+			return
+		}
+		run = func(n model.Node) (bool, string, any) {
+			req := *p.Assign
+			req.NodeID = n.ID
+			if msg := assignUserNode(req); msg != "" {
+				return false, msg, nil
+			}
+			return true, "", nil
+		}
+	default:
+		c.JSON(http.StatusOK, response.ErrMsg("不支持的操作"))
+		return
+	}
+	ids, msg := p.resolve()
+	if msg != "" {
+		c.JSON(http.StatusOK, response.ErrMsg(msg))
+		return
+	}
+	var nodes []model.Node
+	if len(ids) > 0 {
+		dbpkg.DB.Where("id IN ?", ids).Order("id asc").Find(&nodes)
+	}
+	results := make([]map[string]any, len(nodes))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, 8)
+	for i, n := range nodes {
+		wg.Add(1)
+		go func(i int, n model.Node) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			ok, msg, data := run(n)
+			item := map[string]any{"nodeId": n.ID, "name": n.Name, "success": ok, "message": msg}
+			if data != nil {
+				item["data"] = data
+			}
+			results[i] = item
+		}(i, n)
+	}
+	wg.Wait()
+	succ := 0
+	for _, r := range results {
+		if r["success"] == true {
+			succ++
+		}
+	}
+	jlog(map[string]any{"event": "node_bulk_action", "action": p.Action, "nodes": len(nodes), "success": succ})
+	c.JSON(http.StatusOK, response.Ok(map[string]any{"total": len(nodes), "success": succ, "results": results}))
+}
@@ -0,0 +1,430 @@
+package controller
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"network-panel/golang-backend/internal/app/model"
+	"network-panel/golang-backend/internal/app/response"
+	dbpkg "network-panel/golang-backend/internal/db"
+)
+
+// Full-mesh node latency: every agent pings every other node over its public
+// IP and (for EasyTier members) its overlay IP on a schedule. Samples land in
+// node_mesh_result; the matrix API aggregates current + percentile RTT/loss
+// per (src, dst, path).
+
+const (
+	meshPathPublic  = "public"
+	meshPathOverlay = "overlay"
+)
+
+type meshTarget struct {
+	NodeID int64  `json:"nodeId"`
+	Path   string `json:"path"`
+	IP     string `json:"ip"`
+}
+
+// nodePublicIP returns the address other nodes should use to reach n.
+func nodePublicIP(n model.Node) string {
+	if ip := strings.TrimSpace(n.ServerIP); ip != "" {
+		return ip
+	}
+	for _, p := range strings.Split(n.IP, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			return p
+		}
+	}
+	return ""
+}
+
+// meshTargetsFor lists the public/overlay addresses node nodeID should probe.
+func meshTargetsFor(nodeID int64) []meshTarget {
+	var nodes []model.Node
+	dbpkg.DB.Select("id, name, ip, server_ip").Order("id asc").Find(&nodes)
+	overlay := map[int64]string{}
+	for _, en := range loadEtNodes() {
+		if ip := strings.TrimSpace(en.IPv4); ip != "" {
+			if i := strings.Index(ip, "/"); i >= 0 {
+				ip = ip[:i]
+			}
+			overlay[en.NodeID] = ip
+		}
+	}
+	_, selfInOverlay := overlay[nodeID]
+	out := make([]meshTarget, 0, len(nodes)*2)
+	for _, n := range nodes {
+		if n.ID == nodeID {
+			continue
+		}
+		if ip := nodePublicIP(n); ip != "" {
+			out = append(out, meshTarget{NodeID: n.ID, Path: meshPathPublic, IP: ip})
+		}
+		if ip := overlay[n.ID]; selfInOverlay && ip != "" {
+			out = append(out, meshTarget{NodeID: n.ID, Path: meshPathOverlay, IP: ip})
+		}
+	}
+	return out
+}
+
+func meshSettings() map[string]any {
+	return map[string]any{
+		"enabled":     getCfg("mesh_probe_enabled") == "1",
+		"intervalSec": getCfgInt("mesh_probe_interval_sec", 300),
+		"count":       getCfgInt("mesh_probe_count", 5),
+		"timeoutMs":   getCfgInt("mesh_probe_timeout_ms", 1000),
+	}
+}
+
+// POST /api/v1/agent/mesh-targets {secret}
+func AgentMeshTargets(c *gin.Context) {
+	var p struct {
+		Secret string `json:"secret" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	var node model.Node
+	if err := dbpkg.DB.Where("secret = ?", p.Secret).First(&node).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("节点不存在"))
+		return
+	}
+	out := meshSettings()
+	if out["enabled"] == true {
+		out["targets"] = meshTargetsFor(node.ID)
+	} else {
+		out["targets"] = []meshTarget{}
+	}
+	c.JSON(http.StatusOK, response.Ok(out))
+}
+
+// POST /api/v1/agent/report-mesh {secret, results:[{nodeId, path, ip, rttMs, ok, lossPct, jitterMs, error, timeMs}]}
+func AgentReportMesh(c *gin.Context) {
+	var p struct {
+		Secret  string `json:"secret" binding:"required"`
+		Results []struct {
+			NodeID   int64   `json:"nodeId"`
+			Path     string  `json:"path"`
+			IP       string  `json:"ip"`
+			RTTMs    int     `json:"rttMs"`
+			OK       int     `json:"ok"`
+			LossPct  float64 `json:"lossPct"`
+			JitterMs float64 `json:"jitterMs"`
+			Error    string  `json:"error"`
+			TimeMs   int64   `json:"timeMs"`
+		} `json:"results"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	var node model.Node
+	if err := dbpkg.DB.Where("secret = ?", p.Secret).First(&node).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("节点不存在"))
+		return
+	}
+	now := time.Now().UnixMilli()
+	rows := make([]model.NodeMeshResult, 0, len(p.Results))
+	for _, r := range p.Results {
+		if r.NodeID <= 0 || r.NodeID == node.ID || (r.Path != meshPathPublic && r.Path != meshPathOverlay) {
+			continue
+		}
+		t := r.TimeMs
+		if t <= 0 {
+			t = now
+		}
+		errText := r.Error
+		if len(errText) > 255 {
+			errText = errText[:255]
+		}
+		rows = append(rows, model.NodeMeshResult{SrcNodeID: node.ID, DstNodeID: r.NodeID, Path: r.Path, IP: r.IP, RTTMs: r.RTTMs, OK: r.OK,
+			LossPct: r.LossPct, JitterMs: r.JitterMs, Error: errText, TimeMs: t})
+	}
+	enqueueMesh(rows)
+	c.JSON(http.StatusOK, response.OkNoData())
+}
+
+// percentileInt returns the nearest-rank percentile of sorted values.
+func percentileInt(sorted []int, p float64) int {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	if idx >= len(sorted) {
+		idx = len(sorted) - 1
+	}
+	return sorted[idx]
+}
+
+// loadMeshRows returns samples since from; for ranges longer than a day the part
+// already rolled up is returned as one averaged row per bucket (RTT = avg, OK =
+// majority up), since raw rows are pruned after prune_hours.
+func loadMeshRows(src, dst int64, path string, from int64) []model.NodeMeshResult {
+	var rows []model.NodeMeshResult
+	if res, wm, ok := rollupHistorySplit(from); ok {
+		paths := []string{meshPathPublic, meshPathOverlay}
+		if path != "" {
+			paths = []string{path}
+		}
+		series := make([]string, 0, 6)
+		for _, pt := range paths {
+			series = append(series, meshSeries("rtt", pt), meshSeries("loss", pt), meshSeries("up", pt))
+		}
+		rows = meshRowsFromRollups(loadGaugeRollups(res, from, wm, src, dst, series))
+		from = wm
+	}
+	q := dbpkg.DB.Where("time_ms >= ?", from)
+	if src > 0 {
+		q = q.Where("src_node_id = ?", src)
+	}
+	if dst > 0 {
+		q = q.Where("dst_node_id = ?", dst)
+	}
+	if path != "" {
+		q = q.Where("path = ?", path)
+	}
+	var raw []model.NodeMeshResult
+	q.Order("time_ms asc").Find(&raw)
+	rows = append(rows, raw...)
+	for _, r := range readBufferedMesh(src, dst, from) {
+		if path == "" || r.Path == path {
+			rows = append(rows, r)
+		}
+	}
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i].TimeMs < rows[j].TimeMs })
+	return rows
+}
+
+func meshRowsFromRollups(gs []model.GaugeRollup) []model.NodeMeshResult {
+	type key struct {
+		t, src, dst int64
+		path        string
+	}
+	idx := map[key]int{}
+	out := make([]model.NodeMeshResult, 0, len(gs)/3)
+	for _, g := range gs {
+		dot := strings.LastIndexByte(g.Series, '.')
+		if dot < 0 {
+			continue
+		}
+		path := meshPathPublic
+		if g.Series[dot+1:] == "ovl" {
+			path = meshPathOverlay
+		}
+		k := key{g.BucketMs, g.NodeID, g.RefID, path}
+		i, ok := idx[k]
+		if !ok {
+			i = len(out)
+			idx[k] = i
+			out = append(out, model.NodeMeshResult{SrcNodeID: g.NodeID, DstNodeID: g.RefID, Path: path, TimeMs: g.BucketMs})
+		}
+		switch g.Series[:dot] {
+		case "mesh_rtt":
+			out[i].RTTMs = int(math.Round(g.Avg))
+		case "mesh_loss":
+			out[i].LossPct = math.Round(g.Avg*100) / 100
+		case "mesh_up":
+			out[i].OK = ifThen(g.Avg >= 0.5, 1, 0)
+		}
+	}
+	return out
+}
+
+// NodeMeshMatrix 节点互测延迟矩阵
+// @Summary 节点互测延迟矩阵（当前值与分位数；超过 1 天的区间较早部分按聚合桶计算）
+// @Tags node
+// @Accept json
+// @Produce json
+// @Param data body object true "{range: 1h/12h/1d/7d/30d, path?: public|overlay}"
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/node/mesh/matrix [post]
+func NodeMeshMatrix(c *gin.Context) {
+	var p struct {
+		Range string `json:"range"`
+		Path  string `json:"path"`
+	}
+	_ = c.ShouldBindJSON(&p)
+	now := time.Now().UnixMilli()
+	from := now - rangeWindowMs(p.Range)
+	rows := loadMeshRows(0, 0, p.Path, from)
+
+	type cell struct {
+		Src      int64                 `json:"srcNodeId"`
+		Dst      int64                 `json:"dstNodeId"`
+		Path     string                `json:"path"`
+		Current  *model.NodeMeshResult `json:"current"`
+		Samples  int                   `json:"samples"`
+		AvgRTT   int                   `json:"avgRttMs"`
+		P50      int                   `json:"p50RttMs"`
+		P90      int                   `json:"p90RttMs"`
+		P95      int                   `json:"p95RttMs"`
+		P99      int                   `json:"p99RttMs"`
+		AvgLoss  float64               `json:"avgLossPct"`
+		P95Loss  float64               `json:"p95LossPct"`
+		AvgJit   float64               `json:"avgJitterMs"`
+		rtts     []int
+		losses   []float64
+		jitSum   float64
+		jitCount int
+	}
+	type key struct {
+		src, dst int64
+		path     string
+	}
+	cells := map[key]*cell{}
+	for i := range rows {
+		r := rows[i]
+		k := key{r.SrcNodeID, r.DstNodeID, r.Path}
+		ce := cells[k]
+		if ce == nil {
+			ce = &cell{Src: r.SrcNodeID, Dst: r.DstNodeID, Path: r.Path}
+			cells[k] = ce
+		}
+		ce.Current = &rows[i]
+		ce.Samples++
+		ce.losses = append(ce.losses, r.LossPct)
+		if r.OK == 1 && r.RTTMs > 0 {
+			ce.rtts = append(ce.rtts, r.RTTMs)
+			ce.jitSum += r.JitterMs
+			ce.jitCount++
+		}
+	}
+	out := make([]*cell, 0, len(cells))
+	nodeSet := map[int64]struct{}{}
+	for _, ce := range cells {
+		sort.Ints(ce.rtts)
+		sort.Float64s(ce.losses)
+		if n := len(ce.rtts); n > 0 {
+			sum := 0
+			for _, v := range ce.rtts {
+				sum += v
+			}
+			ce.AvgRTT = sum / n
+			ce.P50 = percentileInt(ce.rtts, 50)
+			ce.P90 = percentileInt(ce.rtts, 90)
+			ce.P95 = percentileInt(ce.rtts, 95)
+			ce.P99 = percentileInt(ce.rtts, 99)
+		}
+		if n := len(ce.losses); n > 0 {
+			sum := 0.0
+			for _, v := range ce.losses {
+				sum += v
+			}
+			ce.AvgLoss = math.Round(sum/float64(n)*100) / 100
+			idx := int(math.Ceil(0.95*float64(n))) - 1
+			if idx < 0 {
+				idx = 0
+			}
+			ce.P95Loss = ce.losses[idx]
+		}
+		if ce.jitCount > 0 {
+			ce.AvgJit = math.Round(ce.jitSum/float64(ce.jitCount)*100) / 100
+		}
+		nodeSet[ce.Src] = struct{}{}
+		nodeSet[ce.Dst] = struct{}{}
+		out = append(out, ce)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Src != out[j].Src {
+			return out[i].Src < out[j].Src
+		}
+		if out[i].Dst != out[j].Dst {
+			return out[i].Dst < out[j].Dst
+		}
+		return out[i].Path < out[j].Path
+	})
+	var nodes []model.Node
+	dbpkg.DB.Select("id, name").Order("id asc").Find(&nodes)
+	nodeList := make([]map[string]any, 0, len(nodes))
+	for _, n := range nodes {
+		_, seen := nodeSet[n.ID]
+		nodeList = append(nodeList, map[string]any{"id": n.ID, "name": n.Name, "online": isNodeConnected(n.ID), "hasData": seen})
+	}
+	c.JSON(http.StatusOK, response.Ok(map[string]any{
+		"nodes":    nodeList,
+		"cells":    out,
+		"settings": meshSettings(),
+		"from":     from,
+		"to":       now,
+	}))
+}
+
+// NodeMeshHistory 节点对延迟历史
+// @Summary 节点对延迟历史
+// @Tags node
+// @Accept json
+// @Produce json
+// @Param data body object true "{srcNodeId, dstNodeId, path?, range}"
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/node/mesh/history [post]
+func NodeMeshHistory(c *gin.Context) {
+	var p struct {
+		SrcNodeID int64  `json:"srcNodeId" binding:"required"`
+		DstNodeID int64  `json:"dstNodeId" binding:"required"`
+		Path      string `json:"path"`
+		Range     string `json:"range"`
+		Both      bool   `json:"bothDirections"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	now := time.Now().UnixMilli()
+	from := now - rangeWindowMs(p.Range)
+	data := map[string]any{"from": from, "to": now, "forward": loadMeshRows(p.SrcNodeID, p.DstNodeID, p.Path, from)}
+	if p.Both {
+		data["reverse"] = loadMeshRows(p.DstNodeID, p.SrcNodeID, p.Path, from)
+	}
+	c.JSON(http.StatusOK, response.Ok(data))
+}
+
+// NodeMeshSettings 节点互测设置（不带参数时仅返回当前设置）
+// @Summary 节点互测设置
+// @Tags node
+// @Accept json
+// @Produce json
+// @Param data body object false "{enabled?, intervalSec?, count?, timeoutMs?}"
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/node/mesh/settings [post]
+func NodeMeshSettings(c *gin.Context) {
+	var p struct {
+		Enabled     *bool `json:"enabled"`
+		IntervalSec *int  `json:"intervalSec"`
+		Count       *int  `json:"count"`
+		TimeoutMs   *int  `json:"timeoutMs"`
+	}
+	_ = c.ShouldBindJSON(&p)
+	if p.IntervalSec != nil && *p.IntervalSec < 30 {
+		c.JSON(http.StatusOK, response.ErrMsg("间隔不能小于 30 秒"))
+		return
+	}
+	if p.Count != nil && (*p.Count < 1 || *p.Count > 20) {
+		c.JSON(http.StatusOK, response.ErrMsg("次数范围 1-20"))
+		return
+	}
+	if p.TimeoutMs != nil && (*p.TimeoutMs < 100 || *p.TimeoutMs > 10000) {
+		c.JSON(http.StatusOK, response.ErrMsg("超时范围 100-10000 毫秒"))
+		return
+	}
+	if p.Enabled != nil {
+		setCfg("mesh_probe_enabled", ifThen(*p.Enabled, "1", "0"))
+	}
+	if p.IntervalSec != nil {
+		setCfg("mesh_probe_interval_sec", itoa(*p.IntervalSec))
+	}
+	if p.Count != nil {
+		setCfg("mesh_probe_count", itoa(*p.Count))
+	}
+	if p.TimeoutMs != nil {
+		setCfg("mesh_probe_timeout_ms", itoa(*p.TimeoutMs))
+	}
+	c.JSON(http.StatusOK, response.Ok(meshSettings()))
+}
@@ -1,6 +1,7 @@
 package controller
 
 import (
+	"errors"
 	"net/http"
 	"strings"
 	"time"
@@ -44,16 +45,35 @@ func NodeUserAssign(c *gin.Context) {
 		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
 		return
 	}
+	if msg := assignUserNode(req); msg != "" {
+		c.JSON(http.StatusOK, response.ErrMsg(msg))
+		return
+	}
+	c.JSON(http.StatusOK, response.OkMsg("用户节点权限分配成功"))
+}
+
+// assignUserNode grants a node to a user; returns an error message on failure.
+func assignUserNode(req dto.UserNodeDto) string {
 	var cnt int64
 	db.DB.Model(&model.UserNode{}).Where("user_id=? and node_id=?", req.UserID, req.NodeID).Count(&cnt)
 	if cnt > 0 {
-		c.JSON(http.StatusOK, response.ErrMsg("该用户已拥有此节点权限"))
-		return
+		return "该用户已拥有此节点权限"
 	}
 	speedMbps := val(req.SpeedMbps, 0)
 	if speedMbps < 0 {
 		speedMbps = 0
 	}
+	maxSessions, maxIPs := val(req.MaxSessions, 0), val(req.MaxIPs, 0)
+	if maxSessions < 0 {
+		maxSessions = 0
+	}
+	if maxIPs < 0 {
+		maxIPs = 0
+	}
+	limitMode, ok := anytlsLimitMode(req.LimitMode)
+	if !ok {
+		return "超限处理方式仅支持 reject 或 evict"
+	}
 	un := model.UserNode{
 		UserID:        req.UserID,
 		NodeID:        req.NodeID,
@@ -63,14 +83,16 @@ func NodeUserAssign(c *gin.Context) {
 		FlowResetTime: req.FlowResetTime,
 		ExpTime:       req.ExpTime,
 		SpeedMbps:     speedMbps,
+		MaxSessions:   maxSessions,
+		MaxIPs:        maxIPs,
+		LimitMode:     limitMode,
 		Status:        val(req.Status, 1),
 	}
 	if err := db.DB.Create(&un).Error; err != nil {
-		c.JSON(http.StatusOK, response.ErrMsg("用户节点权限分配失败"))
-		return
+		return "用户节点权限分配失败"
 	}
-	go pushAnyTLSConfigToNode(un.NodeID)
-	c.JSON(http.StatusOK, response.OkMsg("用户节点权限分配成功"))
+	go pushExitUsersToNode(un.NodeID)
+	return ""
 }
 
 // NodeUserList 用户节点权限列表
@@ -134,16 +156,24 @@ func NodeUserRemove(c *gin.Context) {
 		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
 		return
 	}
-	var un model.UserNode
-	if err := db.DB.First(&un, p.ID).Error; err != nil {
-		c.JSON(http.StatusOK, response.ErrMsg("未找到对应的用户节点权限记录"))
+	if err := removeUserNode(p.ID); err != nil {
+		replyErr(c, err)
 		return
 	}
-	db.DB.Delete(&un)
-	go pushAnyTLSConfigToNode(un.NodeID)
 	c.JSON(http.StatusOK, response.OkMsg("用户节点权限删除成功"))
 }
 
+// removeUserNode revokes a user's node access and refreshes the node's exit users.
+func removeUserNode(id int64) error {
+	var un model.UserNode
+	if err := db.DB.First(&un, id).Error; err != nil {
+		return errors.New("未找到对应的用户节点权限记录")
+	}
+	db.DB.Delete(&un)
+	go pushExitUsersToNode(un.NodeID)
+	return nil
+}
+
 // NodeUserUpdate 更新用户节点权限
 // @Summary 更新用户节点权限
 // @Tags node
@@ -158,10 +188,19 @@ func NodeUserUpdate(c *gin.Context) {
 		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
 		return
 	}
+	if err := updateUserNode(req); err != nil {
+		replyErr(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, response.OkMsg("用户节点权限更新成功"))
+}
+
+// updateUserNode saves a user's quota and limits on a node and refreshes the
+// node's exit users.
+func updateUserNode(req dto.UserNodeUpdateDto) error {
 	var un model.UserNode
 	if err := db.DB.First(&un, req.ID).Error; err != nil {
-		c.JSON(http.StatusOK, response.ErrMsg("用户节点权限不存在"))
-		return
+		return errors.New("用户节点权限不存在")
 	}
 	un.Flow, un.Num = req.Flow, req.Num
 	if req.PortRanges != nil {
@@ -183,12 +222,30 @@ func NodeUserUpdate(c *gin.Context) {
 			un.SpeedMbps = *req.SpeedMbps
 		}
 	}
+	if req.MaxSessions != nil {
+		un.MaxSessions = *req.MaxSessions
+		if un.MaxSessions < 0 {
+			un.MaxSessions = 0
+		}
+	}
+	if req.MaxIPs != nil {
+		un.MaxIPs = *req.MaxIPs
+		if un.MaxIPs < 0 {
+			un.MaxIPs = 0
+		}
+	}
+	if req.LimitMode != nil {
+		mode, ok := anytlsLimitMode(*req.LimitMode)
+		if !ok {
+			return errors.New("超限处理方式仅支持 reject 或 evict")
+		}
+		un.LimitMode = mode
+	}
 	if err := db.DB.Save(&un).Error; err != nil {
-		c.JSON(http.StatusOK, response.ErrMsg("用户节点权限更新失败"))
-		return
+		return errors.New("用户节点权限更新失败")
 	}
-	go pushAnyTLSConfigToNode(un.NodeID)
-	c.JSON(http.StatusOK, response.OkMsg("用户节点权限更新成功"))
+	go pushExitUsersToNode(un.NodeID)
+	return nil
 }
 
 // NodeUserUsageByNode 管理员查看指定节点的用户用量
@@ -0,0 +1,780 @@
+package controller
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"network-panel/golang-backend/internal/app/model"
+	"network-panel/golang-backend/internal/app/response"
+	dbpkg "network-panel/golang-backend/internal/db"
+)
+
+// Notification channels.
+//
+// notifyCallback is the single entry point used by monitors (offline, due,
+// EasyTier peers, alert rules). It renders a message once and fans it out to
+// every enabled channel whose event filter and minimum severity match. Each
+// delivery is retried with backoff and logged to notify_delivery.
+
+var notifyTypes = []string{"webhook", "telegram", "smtp", "bark", "dingtalk", "feishu", "wecom", "discord", "slack"}
+
+var notifyEventTitles = map[string]string{
+	"agent_offline":           "节点离线",
+	"node_due":                "节点即将到期",
+	"easytier_peer_lost":      "组网对端丢失",
+	"easytier_peer_recovered": "组网对端恢复",
+	"alert_firing":            "告警触发",
+	"alert_resolved":          "告警恢复",
+	"user_quota":              "流量配额提醒",
+	"user_expiry":             "账户到期提醒",
+	"sub_anomaly":             "订阅异常访问",
+	"test":                    "测试通知",
+}
+
+var severityRank = map[string]int{"info": 1, "warning": 2, "critical": 3}
+
+// config keys whose values are masked in API responses
+// (bot webhook URLs embed their access token)
+var notifySecretKeys = map[string]bool{"botToken": true, "password": true, "secret": true, "deviceKey": true, "headers": true, "webhook": true}
+
+// events the single legacy callback used to receive
+var legacyCallbackEvents = []string{"agent_offline", "node_due", "easytier_peer_lost", "easytier_peer_recovered", "alert_firing", "alert_resolved"}
+
+const notifyMask = "******"
+
+type notifyMessage struct {
+	Event    string
+	Title    string
+	Text     string
+	Severity string
+	Node     model.Node
+	TimeMs   int64
+	Payload  map[string]any
+	Extra    map[string]any
+}
+
+// notifyCallback dispatches an event to all matching notification channels.
+func notifyCallback(event string, node model.Node, extra map[string]any) {
+	msg := buildNotifyMessage(event, node, extra)
+	for _, ch := range loadNotifyChannels() {
+		if !ch.Enabled || !notifyChannelMatches(ch, msg) {
+			continue
+		}
+		go deliverNotify(ch, msg)
+	}
+}
+
+func buildNotifyMessage(event string, node model.Node, extra map[string]any) notifyMessage {
+	now := time.Now().UnixMilli()
+	payload := map[string]any{"event": event, "nodeId": node.ID, "name": node.Name, "time": now}
+	for k, v := range extra {
+		payload[k] = v
+	}
+	title := notifyEventTitles[event]
+	if title == "" {
+		title = event
+	}
+	sev, _ := extra["severity"].(string)
+	lines := []string{}
+	if node.Name != "" {
+		lines = append(lines, "节点: "+node.Name)
+	}
+	if m, ok := extra["message"].(string); ok && m != "" {
+		lines = append(lines, m)
+	}
+	if v, ok := extra["remainMs"]; ok {
+		if ms, ok := toInt64Any(v); ok {
+			lines = append(lines, fmt.Sprintf("剩余: %.1f 天", float64(ms)/86400000))
+		}
+	}
+	for _, k := range []string{"lostNodeIds", "recoveredNodeIds"} {
+		if v, ok := extra[k]; ok {
+			if ids, ok := v.([]int64); ok {
+				lines = append(lines, "对端: "+joinNodeNames(ids, nodeNames(ids)))
+			}
+		}
+	}
+	lines = append(lines, "时间: "+time.UnixMilli(now).Format("2006-01-02 15:04:05"))
+	if sev != "" {
+		title = fmt.Sprintf("[%s] %s", ifThen(severityLabel[sev] != "", severityLabel[sev], sev), title)
+	}
+	return notifyMessage{Event: event, Title: title, Text: strings.Join(lines, "\n"), Severity: sev, Node: node, TimeMs: now, Payload: payload, Extra: extra}
+}
+
+func toInt64Any(v any) (int64, bool) {
+	switch x := v.(type) {
+	case int64:
+		return x, true
+	case int:
+		return int64(x), true
+	case float64:
+		return int64(x), true
+	}
+	return 0, false
+}
+
+var notifyLegacyOnce sync.Once
+
+func loadNotifyChannels() []model.NotifyChannel {
+	notifyLegacyOnce.Do(migrateLegacyCallback)
+	var list []model.NotifyChannel
+	dbpkg.DB.Order("id asc").Find(&list)
+	return list
+}
+
+// migrateLegacyCallback turns the old single callback_* settings into a webhook channel.
+func migrateLegacyCallback() {
+	u := strings.TrimSpace(getCfg("callback_url"))
+	if u == "" || getCfg("notify_legacy_migrated") == "1" {
+		return
+	}
+	cfg := map[string]any{"url": u, "method": getCfg("callback_method"), "template": getCfg("callback_template")}
+	if h := getCfg("callback_headers"); h != "" {
+		var m map[string]string
+		if json.Unmarshal([]byte(h), &m) == nil {
+			cfg["headers"] = m
+		}
+	}
+	b, _ := json.Marshal(cfg)
+	events, _ := json.Marshal(legacyCallbackEvents)
+	now := time.Now().UnixMilli()
+	_ = dbpkg.DB.Create(&model.NotifyChannel{Name: "回调(迁移)", Type: "webhook", Config: string(b), Events: string(events), Enabled: true, CreatedTime: now, UpdatedTime: now}).Error
+	setCfg("notify_legacy_migrated", "1")
+}
+
+func notifyChannelMatches(ch model.NotifyChannel, msg notifyMessage) bool {
+	if msg.Severity != "" && ch.MinSeverity != "" && severityRank[msg.Severity] < severityRank[ch.MinSeverity] {
+		return false
+	}
+	var events []string
+	if strings.TrimSpace(ch.Events) != "" {
+		_ = json.Unmarshal([]byte(ch.Events), &events)
+	}
+	if len(events) == 0 {
+		return true
+	}
+	for _, e := range events {
+		if e == msg.Event || e == "*" || (strings.HasSuffix(e, "*") && strings.HasPrefix(msg.Event, strings.TrimSuffix(e, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// deliverNotify sends with retries (backoff 2s, 10s, 30s) and records the outcome.
+func deliverNotify(ch model.NotifyChannel, msg notifyMessage) error {
+	backoff := []time.Duration{2 * time.Second, 10 * time.Second, 30 * time.Second}
+	start := time.Now()
+	var err error
+	attempts := 0
+	for {
+		attempts++
+		err = sendNotify(ch, msg)
+		if err == nil || attempts > len(backoff) {
+			break
+		}
+		time.Sleep(backoff[attempts-1])
+	}
+	rec := model.NotifyDelivery{ChannelID: ch.ID, ChannelName: ch.Name, ChannelType: ch.Type, Event: msg.Event, Title: msg.Title,
+		Status: "success", Attempts: attempts, DurationMs: time.Since(start).Milliseconds(), TimeMs: start.UnixMilli()}
+	if err != nil {
+		rec.Status = "failed"
+		rec.Error = err.Error()
+		if len(rec.Error) > 500 {
+			rec.Error = rec.Error[:500]
+		}
+		jlog(map[string]any{"event": "notify_failed", "channelId": ch.ID, "type": ch.Type, "notifyEvent": msg.Event, "attempts": attempts, "error": rec.Error})
+	}
+	_ = dbpkg.DB.Create(&rec).Error
+	return err
+}
+
+func sendNotify(ch model.NotifyChannel, msg notifyMessage) error {
+	cfg := map[string]any{}
+	if strings.TrimSpace(ch.Config) != "" {
+		if err := json.Unmarshal([]byte(ch.Config), &cfg); err != nil {
+			return fmt.Errorf("配置解析失败: %v", err)
+		}
+	}
+	str := func(k string) string { v, _ := cfg[k].(string); return strings.TrimSpace(v) }
+	text := msg.Title + "\n" + msg.Text
+	switch ch.Type {
+	case "webhook":
+		return sendWebhookNotify(cfg, msg)
+	case "telegram":
+		base := str("apiBase")
+		if base == "" {
+			base = "https://api.telegram.org"
+		}
+		if str("botToken") == "" || str("chatId") == "" {
+			return errors.New("缺少 botToken/chatId")
+		}
+		body := map[string]any{"chat_id": str("chatId"), "text": text, "disable_web_page_preview": true}
+		err := notifyPostJSON(strings.TrimRight(base, "/")+"/bot"+str("botToken")+"/sendMessage", body, nil, "ok")
+		if err != nil {
+			// transport errors embed the URL, which carries the bot token
+			return errors.New(strings.ReplaceAll(err.Error(), str("botToken"), notifyMask))
+		}
+		return nil
+	case "bark":
+		server := str("server")
+		if server == "" {
+			server = "https://api.day.app"
+		}
+		if str("deviceKey") == "" {
+			return errors.New("缺少 deviceKey")
+		}
+		body := map[string]any{"device_key": str("deviceKey"), "title": msg.Title, "body": msg.Text}
+		if g := str("group"); g != "" {
+			body["group"] = g
+		}
+		if s := str("sound"); s != "" {
+			body["sound"] = s
+		}
+		return notifyPostJSON(strings.TrimRight(server, "/")+"/push", body, nil, "code200")
+	case "dingtalk":
+		u := str("webhook")
+		if u == "" {
+			return errors.New("缺少 webhook")
+		}
+		if sec := str("secret"); sec != "" {
+			ts := strconv.FormatInt(time.Now().UnixMilli(), 10)
+			mac := hmac.New(sha256.New, []byte(sec))
+			mac.Write([]byte(ts + "\n" + sec))
+			u += ifThen(strings.Contains(u, "?"), "&", "?") + "timestamp=" + ts + "&sign=" + url.QueryEscape(base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+		}
+		return notifyPostJSON(u, map[string]any{"msgtype": "text", "text": map[string]any{"content": text}}, nil, "errcode")
+	case "feishu":
+		u := str("webhook")
+		if u == "" {
+			return errors.New("缺少 webhook")
+		}
+		body := map[string]any{"msg_type": "text", "content": map[string]any{"text": text}}
+		if sec := str("secret"); sec != "" {
+			ts := strconv.FormatInt(time.Now().Unix(), 10)
+			mac := hmac.New(sha256.New, []byte(ts+"\n"+sec))
+			body["timestamp"] = ts
+			body["sign"] = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+		}
+		return notifyPostJSON(u, body, nil, "code")
+	case "wecom":
+		if str("webhook") == "" {
+			return errors.New("缺少 webhook")
+		}
+		return notifyPostJSON(str("webhook"), map[string]any{"msgtype": "text", "text": map[string]any{"content": text}}, nil, "errcode")
+	case "discord":
+		if str("webhook") == "" {
+			return errors.New("缺少 webhook")
+		}
+		return notifyPostJSON(str("webhook"), map[string]any{"content": "**" + msg.Title + "**\n" + msg.Text}, nil, "")
+	case "slack":
+		if str("webhook") == "" {
+			return errors.New("缺少 webhook")
+		}
+		return notifyPostJSON(str("webhook"), map[string]any{"text": "*" + msg.Title + "*\n" + msg.Text}, nil, "")
+	case "smtp":
+		return sendSMTPNotify(cfg, msg)
+	}
+	return fmt.Errorf("不支持的通知类型: %s", ch.Type)
+}
+
+// notifyPostJSON posts JSON and checks the HTTP status plus an optional
+// provider-specific result field: "errcode"/"code" must be 0, "ok" must be true,
+// "code200" expects code == 200 (Bark).
+func notifyPostJSON(u string, body any, headers map[string]string, check string) error {
+	b, _ := json.Marshal(body)
+	req, err := http.NewRequest("POST", u, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	return doNotifyRequest(req, check)
+}
+
+func doNotifyRequest(req *http.Request, check string) error {
+	return doNotifyRequestWith(&http.Client{Timeout: 10 * time.Second}, req, check)
+}
+
+func doNotifyRequestWith(client *http.Client, req *http.Request, check string) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	out, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(out)))
+	}
+	if check == "" {
+		return nil
+	}
+	var r map[string]any
+	if json.Unmarshal(out, &r) != nil {
+		return fmt.Errorf("响应解析失败: %s", strings.TrimSpace(string(out)))
+	}
+	switch check {
+	case "errcode", "code":
+		if v, ok := r[check].(float64); ok && v != 0 {
+			return fmt.Errorf("%s=%v: %s", check, v, strings.TrimSpace(string(out)))
+		}
+	case "ok":
+		if v, _ := r["ok"].(bool); !v {
+			return fmt.Errorf("%v", r["description"])
+		}
+	case "code200":
+		if v, ok := r["code"].(float64); ok && v != 200 {
+			return fmt.Errorf("code=%v: %v", v, r["message"])
+		}
+	}
+	return nil
+}
+
+// sendWebhookNotify keeps the legacy callback semantics: GET/POST, custom
+// headers and an optional template with {event} {nodeId} {name} {time}
+// {downAt} {upAt} {duration} {title} {text} placeholders. publicOnly (set for
+// user contacts) restricts delivery to public addresses without redirects.
+func sendWebhookNotify(cfg map[string]any, msg notifyMessage) error {
+	u, _ := cfg["url"].(string)
+	if strings.TrimSpace(u) == "" {
+		return errors.New("缺少 url")
+	}
+	method, _ := cfg["method"].(string)
+	method = strings.ToUpper(method)
+	if method != "GET" {
+		method = "POST"
+	}
+	headers := map[string]string{}
+	if h, ok := cfg["headers"].(map[string]any); ok {
+		for k, v := range h {
+			headers[k] = fmt.Sprint(v)
+		}
+	}
+	tpl, _ := cfg["template"].(string)
+	b, _ := json.Marshal(msg.Payload)
+	if tpl != "" {
+		repl := map[string]string{
+			"{event}":  msg.Event,
+			"{nodeId}": fmt.Sprintf("%d", msg.Node.ID),
+			"{name}":   msg.Node.Name,
+			"{time}":   fmt.Sprintf("%d", msg.TimeMs),
+			"{title}":  msg.Title,
+			"{text}":   msg.Text,
+		}
+		if v, ok := msg.Extra["downAtMs"]; ok {
+			repl["{downAt}"] = fmt.Sprintf("%v", v)
+		}
+		if v, ok := msg.Extra["upAtMs"]; ok {
+			repl["{upAt}"] = fmt.Sprintf("%v", v)
+		}
+		if v, ok := msg.Extra["durationS"]; ok {
+			repl["{duration}"] = fmt.Sprintf("%v", v)
+		}
+		t := tpl
+		for k, v := range repl {
+			t = strings.ReplaceAll(t, k, v)
+		}
+		if method == "GET" {
+			u += ifThen(strings.Contains(u, "?"), "&", "?") + t
+		} else {
+			b = []byte(t)
+		}
+	}
+	var req *http.Request
+	var err error
+	if method == "GET" {
+		req, err = http.NewRequest("GET", u, nil)
+	} else {
+		req, err = http.NewRequest("POST", u, bytes.NewReader(b))
+	}
+	if err != nil {
+		return err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	if method == "POST" && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if v, _ := cfg["publicOnly"].(bool); v {
+		return doNotifyRequestWith(publicNotifyClient, req, "")
+	}
+	return doNotifyRequest(req, "")
+}
+
+// sendSMTPNotify sends a plain-text mail. security: ssl (implicit TLS, default
+// for port 465) | starttls | none.
+func sendSMTPNotify(cfg map[string]any, msg notifyMessage) error {
+	str := func(k string) string { v, _ := cfg[k].(string); return strings.TrimSpace(v) }
+	host := str("host")
+	port := 0
+	switch v := cfg["port"].(type) {
+	case float64:
+		port = int(v)
+	case string:
+		port, _ = strconv.Atoi(v)
+	}
+	if port == 0 {
+		port = 465
+	}
+	from := str("from")
+	var to []string
+	switch v := cfg["to"].(type) {
+	case string:
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				to = append(to, s)
+			}
+		}
+	case []any:
+		for _, s := range v {
+			if addr, ok := s.(string); ok && strings.TrimSpace(addr) != "" {
+				to = append(to, strings.TrimSpace(addr))
+			}
+		}
+	}
+	if host == "" || from == "" || len(to) == 0 {
+		return errors.New("缺少 host/from/to")
+	}
+	security := str("security")
+	if security == "" {
+		security = ifThen(port == 465, "ssl", "starttls")
+	}
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if security == "ssl" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: host})
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	_ = conn.SetDeadline(time.Now().Add(30 * time.Second))
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if security == "starttls" {
+		// never fall back to plaintext: credentials and content would leak
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return errors.New("服务器不支持 STARTTLS")
+		}
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if user := str("username"); user != "" {
+		if err := c.Auth(smtp.PlainAuth("", user, str("password"), host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	for _, r := range to {
+		if err := c.Rcpt(r); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	subject := "=?UTF-8?B?" + base64.StdEncoding.EncodeToString([]byte(msg.Title)) + "?="
+	body := "From: " + from + "\r\nTo: " + strings.Join(to, ", ") + "\r\nSubject: " + subject +
+		"\r\nMIME-Version: 1.0\r\nContent-Type: text/plain; charset=UTF-8\r\nContent-Transfer-Encoding: base64\r\n\r\n" +
+		base64.StdEncoding.EncodeToString([]byte(msg.Text)) + "\r\n"
+	if _, err := w.Write([]byte(body)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// ---- API ----
+
+func maskNotifyConfig(raw string) map[string]any {
+	cfg := map[string]any{}
+	_ = json.Unmarshal([]byte(raw), &cfg)
+	for k, v := range cfg {
+		if notifySecretKeys[k] && v != nil && v != "" {
+			cfg[k] = notifyMask
+		}
+	}
+	return cfg
+}
+
+// mergeNotifyConfig keeps previous secret values when the client sends the mask back.
+func mergeNotifyConfig(old string, next map[string]any) string {
+	prev := map[string]any{}
+	_ = json.Unmarshal([]byte(old), &prev)
+	for k, v := range next {
+		if s, ok := v.(string); ok && s == notifyMask {
+			next[k] = prev[k]
+		}
+	}
+	b, _ := json.Marshal(next)
+	return string(b)
+}
+
+func notifyChannelView(ch model.NotifyChannel) map[string]any {
+	var events []string
+	_ = json.Unmarshal([]byte(ch.Events), &events)
+	if events == nil {
+		events = []string{}
+	}
+	var last model.NotifyDelivery
+	lastOut := any(nil)
+	if dbpkg.DB.Where("channel_id = ?", ch.ID).Order("id desc").First(&last).Error == nil {
+		lastOut = last
+	}
+	return map[string]any{"id": ch.ID, "name": ch.Name, "type": ch.Type, "config": maskNotifyConfig(ch.Config), "events": events,
+		"minSeverity": ch.MinSeverity, "enabled": ch.Enabled, "createdTime": ch.CreatedTime, "updatedTime": ch.UpdatedTime, "lastDelivery": lastOut}
+}
+
+type notifyChannelReq struct {
+	ID          int64          `json:"id"`
+	Name        *string        `json:"name"`
+	Type        *string        `json:"type"`
+	Config      map[string]any `json:"config"`
+	Events      []string       `json:"events"`
+	MinSeverity *string        `json:"minSeverity"`
+	Enabled     *bool          `json:"enabled"`
+}
+
+func (p notifyChannelReq) applyTo(ch *model.NotifyChannel) string {
+	if p.Name != nil {
+		ch.Name = strings.TrimSpace(*p.Name)
+	}
+	if p.Type != nil {
+		ch.Type = *p.Type
+	}
+	if p.Config != nil {
+		ch.Config = mergeNotifyConfig(ch.Config, p.Config)
+	}
+	if p.Events != nil {
+		b, _ := json.Marshal(p.Events)
+		ch.Events = string(b)
+	}
+	if p.MinSeverity != nil {
+		ch.MinSeverity = *p.MinSeverity
+	}
+	if p.Enabled != nil {
+		ch.Enabled = *p.Enabled
+	}
+	if ch.Name == "" {
+		return "名称不能为空"
+	}
+	known := false
+	for _, t := range notifyTypes {
+		known = known || t == ch.Type
+	}
+	if !known {
+		return "不支持的通知类型"
+	}
+	if _, ok := severityRank[ch.MinSeverity]; ch.MinSeverity != "" && !ok {
+		return "级别仅支持 info/warning/critical"
+	}
+	return ""
+}
+
+// NotifyChannelList 通知渠道列表
+// @Summary 通知渠道列表
+// @Tags notify
+// @Produce json
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/notify/channels/list [post]
+func NotifyChannelList(c *gin.Context) {
+	list := loadNotifyChannels()
+	out := make([]map[string]any, 0, len(list))
+	for _, ch := range list {
+		out = append(out, notifyChannelView(ch))
+	}
+	events := make([]map[string]string, 0, len(notifyEventTitles))
+	for _, e := range []string{"agent_offline", "node_due", "easytier_peer_lost", "easytier_peer_recovered", "alert_firing", "alert_resolved"} {
+		events = append(events, map[string]string{"event": e, "title": notifyEventTitles[e]})
+	}
+	c.JSON(http.StatusOK, response.Ok(map[string]any{"channels": out, "types": notifyTypes, "events": events}))
+}
+
+// NotifyChannelCreate 新建通知渠道
+// @Summary 新建通知渠道
+// @Tags notify
+// @Accept json
+// @Produce json
+// @Param data body object true "{name, type, config, events?, minSeverity?, enabled?}"
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/notify/channels/create [post]
+func NotifyChannelCreate(c *gin.Context) {
+	var p notifyChannelReq
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	now := time.Now().UnixMilli()
+	ch := model.NotifyChannel{Enabled: true, Events: "[]", Config: "{}", CreatedTime: now, UpdatedTime: now}
+	if msg := p.applyTo(&ch); msg != "" {
+		c.JSON(http.StatusOK, response.ErrMsg(msg))
+		return
+	}
+	if err := dbpkg.DB.Create(&ch).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("保存失败"))
+		return
+	}
+	c.JSON(http.StatusOK, response.Ok(notifyChannelView(ch)))
+}
+
+// NotifyChannelUpdate 更新通知渠道（敏感字段回传 ****** 表示不修改）
+// @Summary 更新通知渠道
+// @Tags notify
+// @Accept json
+// @Produce json
+// @Param data body object true "{id, ...同新建}"
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/notify/channels/update [post]
+func NotifyChannelUpdate(c *gin.Context) {
+	var p notifyChannelReq
+	if err := c.ShouldBindJSON(&p); err != nil || p.ID == 0 {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	var ch model.NotifyChannel
+	if err := dbpkg.DB.First(&ch, p.ID).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("渠道不存在"))
+		return
+	}
+	if msg := p.applyTo(&ch); msg != "" {
+		c.JSON(http.StatusOK, response.ErrMsg(msg))
+		return
+	}
+	ch.UpdatedTime = time.Now().UnixMilli()
+	if err := dbpkg.DB.Save(&ch).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("保存失败"))
+		return
+	}
+	c.JSON(http.StatusOK, response.Ok(notifyChannelView(ch)))
+}
+
+// NotifyChannelDelete 删除通知渠道
+// @Summary 删除通知渠道
+// @Tags notify
+// @Accept json
+// @Produce json
+// @Param data body object true "{id}"
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/notify/channels/delete [post]
+func NotifyChannelDelete(c *gin.Context) {
+	var p struct {
+		ID int64 `json:"id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	_ = dbpkg.DB.Delete(&model.NotifyChannel{}, p.ID).Error
+	c.JSON(http.StatusOK, response.OkNoData())
+}
+
+// NotifyChannelTest 发送测试通知（同步，不重试）
+// @Summary 发送测试通知
+// @Tags notify
+// @Accept json
+// @Produce json
+// @Param data body object true "{id} 或 {type, config} 未保存的配置"
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/notify/channels/test [post]
+func NotifyChannelTest(c *gin.Context) {
+	var p notifyChannelReq
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	ch := model.NotifyChannel{Name: "测试", Config: "{}"}
+	if p.ID > 0 {
+		if err := dbpkg.DB.First(&ch, p.ID).Error; err != nil {
+			c.JSON(http.StatusOK, response.ErrMsg("渠道不存在"))
+			return
+		}
+	}
+	if p.Type != nil {
+		ch.Type = *p.Type
+	}
+	if p.Config != nil {
+		ch.Config = mergeNotifyConfig(ch.Config, p.Config)
+	}
+	msg := buildNotifyMessage("test", model.Node{Name: "network-panel"}, map[string]any{"message": "这是一条测试通知"})
+	start := time.Now()
+	err := sendNotify(ch, msg)
+	if ch.ID > 0 {
+		rec := model.NotifyDelivery{ChannelID: ch.ID, ChannelName: ch.Name, ChannelType: ch.Type, Event: "test", Title: msg.Title, Status: "success",
+			Attempts: 1, DurationMs: time.Since(start).Milliseconds(), TimeMs: start.UnixMilli()}
+		if err != nil {
+			rec.Status, rec.Error = "failed", err.Error()
+		}
+		_ = dbpkg.DB.Create(&rec).Error
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("发送失败: "+err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, response.OkMsg("发送成功"))
+}
+
+// NotifyDeliveryList 通知发送记录
+// @Summary 通知发送记录
+// @Tags notify
+// @Accept json
+// @Produce json
+// @Param data body object false "{channelId?, status?, event?, limit?}"
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/notify/deliveries [post]
+func NotifyDeliveryList(c *gin.Context) {
+	var p struct {
+		ChannelID int64  `json:"channelId"`
+		Status    string `json:"status"`
+		Event     string `json:"event"`
+		Limit     int    `json:"limit"`
+	}
+	_ = c.ShouldBindJSON(&p)
+	if p.Limit <= 0 || p.Limit > 500 {
+		p.Limit = 100
+	}
+	q := dbpkg.DB.Model(&model.NotifyDelivery{})
+	if p.ChannelID > 0 {
+		q = q.Where("channel_id = ?", p.ChannelID)
+	}
+	if p.Status != "" {
+		q = q.Where("status = ?", p.Status)
+	}
+	if p.Event != "" {
+		q = q.Where("event = ?", p.Event)
+	}
+	var list []model.NotifyDelivery
+	q.Order("id desc").Limit(p.Limit).Find(&list)
+	c.JSON(http.StatusOK, response.Ok(list))
+}
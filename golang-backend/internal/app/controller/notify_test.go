@@ -0,0 +1,277 @@
+package controller
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"network-panel/golang-backend/internal/app/model"
+	dbpkg "network-panel/golang-backend/internal/db"
+)
+
+var testDBOnce sync.Once
+
+// useTestDB points dbpkg.DB at a throwaway sqlite database shared by the package tests.
+func useTestDB(t *testing.T) {
+	t.Helper()
+	testDBOnce.Do(func() {
+		dir, err := os.MkdirTemp("", "np-test-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		os.Setenv("DB_DIALECT", "sqlite")
+		os.Setenv("DB_SQLITE_PATH", filepath.Join(dir, "test.db"))
+		if err := dbpkg.Init(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if dbpkg.DB == nil {
+		t.Fatal("test database unavailable")
+	}
+}
+
+func TestSendWebhookNotifyTemplate(t *testing.T) {
+	var got *http.Request
+	var body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		got, body = r, string(b)
+	}))
+	defer srv.Close()
+
+	msg := notifyMessage{Event: "agent_offline", Title: "离线", Node: model.Node{Name: "n1"}, TimeMs: 1000}
+	msg.Node.ID = 7
+	cfg := map[string]any{"url": srv.URL + "/hook", "method": "get", "template": "e={event}&id={nodeId}&t={time}", "headers": map[string]any{"X-Token": "abc"}}
+	if err := sendWebhookNotify(cfg, msg); err != nil {
+		t.Fatal(err)
+	}
+	if got.Method != "GET" || got.URL.Query().Get("e") != "agent_offline" || got.URL.Query().Get("id") != "7" || got.URL.Query().Get("t") != "1000" {
+		t.Fatalf("unexpected request %s %s", got.Method, got.URL)
+	}
+	if got.Header.Get("X-Token") != "abc" {
+		t.Fatalf("header not sent: %v", got.Header)
+	}
+
+	cfg = map[string]any{"url": srv.URL, "template": `{"name":"{name}"}`}
+	if err := sendWebhookNotify(cfg, msg); err != nil {
+		t.Fatal(err)
+	}
+	if got.Method != "POST" || body != `{"name":"n1"}` || got.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("unexpected POST %s %q %q", got.Method, body, got.Header.Get("Content-Type"))
+	}
+}
+
+func TestNotifyPostJSONChecks(t *testing.T) {
+	reply := ""
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(reply))
+	}))
+	defer srv.Close()
+
+	cases := []struct {
+		check, reply string
+		status       int
+		ok           bool
+	}{
+		{"errcode", `{"errcode":0}`, 200, true},
+		{"errcode", `{"errcode":310000,"errmsg":"sign not match"}`, 200, false},
+		{"code", `{"code":19021}`, 200, false},
+		{"ok", `{"ok":true}`, 200, true},
+		{"ok", `{"ok":false,"description":"chat not found"}`, 200, false},
+		{"code200", `{"code":200}`, 200, true},
+		{"code200", `{"code":400,"message":"bad"}`, 200, false},
+		{"", `not json`, 200, true},
+		{"", ``, 500, false},
+	}
+	for _, tc := range cases {
+		reply, status = tc.reply, tc.status
+		err := notifyPostJSON(srv.URL, map[string]any{"a": 1}, nil, tc.check)
+		if (err == nil) != tc.ok {
+			t.Errorf("check=%q reply=%s status=%d: err=%v", tc.check, tc.reply, tc.status, err)
+		}
+	}
+}
+
+func TestSendNotifyTelegramHidesToken(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"ok":true}`))
+	}))
+	defer srv.Close()
+	ch := model.NotifyChannel{Type: "telegram", Config: `{"apiBase":"` + srv.URL + `","botToken":"123:SECRET","chatId":"1"}`}
+	if err := sendNotify(ch, notifyMessage{Title: "t", Text: "x"}); err != nil {
+		t.Fatal(err)
+	}
+	srv.Close()
+	err := sendNotify(ch, notifyMessage{Title: "t", Text: "x"})
+	if err == nil || strings.Contains(err.Error(), "SECRET") {
+		t.Fatalf("expected an error without the token, got %v", err)
+	}
+}
+
+func TestMaskNotifyConfig(t *testing.T) {
+	raw := `{"webhook":"https://oapi.dingtalk.com/robot/send?access_token=abc","secret":"s","chatId":"1"}`
+	masked := maskNotifyConfig(raw)
+	if masked["webhook"] != notifyMask || masked["secret"] != notifyMask || masked["chatId"] != "1" {
+		t.Fatalf("unexpected mask result %v", masked)
+	}
+	merged := map[string]any{}
+	_ = json.Unmarshal([]byte(mergeNotifyConfig(raw, masked)), &merged)
+	if merged["webhook"] != "https://oapi.dingtalk.com/robot/send?access_token=abc" || merged["secret"] != "s" {
+		t.Fatalf("masked values not restored: %v", merged)
+	}
+}
+
+func TestNotifyChannelMatches(t *testing.T) {
+	ch := model.NotifyChannel{Events: `["alert_*","node_due"]`, MinSeverity: "warning"}
+	for _, tc := range []struct {
+		event, sev string
+		want       bool
+	}{
+		{"alert_firing", "critical", true},
+		{"alert_firing", "info", false},
+		{"node_due", "", true},
+		{"agent_offline", "", false},
+	} {
+		if got := notifyChannelMatches(ch, notifyMessage{Event: tc.event, Severity: tc.sev}); got != tc.want {
+			t.Errorf("%s/%s: got %v", tc.event, tc.sev, got)
+		}
+	}
+}
+
+func TestMigrateLegacyCallback(t *testing.T) {
+	useTestDB(t)
+	setCfg("notify_legacy_migrated", "")
+	setCfg("callback_url", "http://example.invalid/cb")
+	setCfg("callback_method", "GET")
+	dbpkg.DB.Where("1 = 1").Delete(&model.NotifyChannel{})
+	migrateLegacyCallback()
+
+	var ch model.NotifyChannel
+	if err := dbpkg.DB.Where("type = ?", "webhook").First(&ch).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range legacyCallbackEvents {
+		if !notifyChannelMatches(ch, notifyMessage{Event: e}) {
+			t.Errorf("migrated channel misses %s", e)
+		}
+	}
+	for _, e := range []string{"test", "forward_traffic_exceeded"} {
+		if notifyChannelMatches(ch, notifyMessage{Event: e}) {
+			t.Errorf("migrated channel receives %s", e)
+		}
+	}
+	migrateLegacyCallback()
+	var n int64
+	dbpkg.DB.Model(&model.NotifyChannel{}).Count(&n)
+	if n != 1 {
+		t.Fatalf("migration ran twice: %d channels", n)
+	}
+}
+
+// fakeSMTP is a minimal SMTP server for one session; it records the message body.
+func fakeSMTP(t *testing.T, starttls bool) (addr string, data <-chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	out := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		say := func(s string) { _, _ = conn.Write([]byte(s + "\r\n")) }
+		say("220 fake ESMTP")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			cmd := strings.ToUpper(strings.TrimSpace(line))
+			switch {
+			case strings.HasPrefix(cmd, "EHLO"):
+				if starttls {
+					say("250-fake")
+					say("250 STARTTLS")
+				} else {
+					say("250-fake")
+					say("250 8BITMIME")
+				}
+			case strings.HasPrefix(cmd, "STARTTLS"):
+				say("454 TLS not available")
+			case strings.HasPrefix(cmd, "MAIL"), strings.HasPrefix(cmd, "RCPT"):
+				say("250 ok")
+			case cmd == "DATA":
+				say("354 go ahead")
+				var sb strings.Builder
+				for {
+					l, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if l == ".\r\n" {
+						break
+					}
+					sb.WriteString(l)
+				}
+				out <- sb.String()
+				say("250 queued")
+			case cmd == "QUIT":
+				say("221 bye")
+				return
+			default:
+				say("250 ok")
+			}
+		}
+	}()
+	return ln.Addr().String(), out
+}
+
+func smtpTestConfig(addr, security string) map[string]any {
+	host, port, _ := net.SplitHostPort(addr)
+	return map[string]any{"host": host, "port": port, "security": security, "from": "panel@example.com", "to": "a@example.com, b@example.com"}
+}
+
+func TestSendSMTPNotifyPlain(t *testing.T) {
+	addr, data := fakeSMTP(t, false)
+	if err := sendSMTPNotify(smtpTestConfig(addr, "none"), notifyMessage{Title: "标题", Text: "正文"}); err != nil {
+		t.Fatal(err)
+	}
+	mail := <-data
+	if !strings.Contains(mail, "To: a@example.com, b@example.com") || !strings.Contains(mail, base64.StdEncoding.EncodeToString([]byte("正文"))) {
+		t.Fatalf("unexpected mail:\n%s", mail)
+	}
+}
+
+func TestSendSMTPNotifyStartTLSRequired(t *testing.T) {
+	addr, data := fakeSMTP(t, false)
+	err := sendSMTPNotify(smtpTestConfig(addr, "starttls"), notifyMessage{Title: "t", Text: "x"})
+	if err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Fatalf("expected STARTTLS error, got %v", err)
+	}
+	select {
+	case <-data:
+		t.Fatal("mail was sent without TLS")
+	default:
+	}
+
+	addr, _ = fakeSMTP(t, true)
+	if err := sendSMTPNotify(smtpTestConfig(addr, "starttls"), notifyMessage{Title: "t", Text: "x"}); err == nil {
+		t.Fatal("expected the refused STARTTLS to fail")
+	}
+}
@@ -11,27 +11,37 @@ import (
 	dbpkg "network-panel/golang-backend/internal/db"
 )
 
-// GET /api/v1/open_api/sub_store?user=...&pwd=...&tunnel=-1|id
+// GET /api/v1/open_api/sub_store?token=...|user=...&pwd=...&tunnel=-1|id
 func OpenAPISubStore(c *gin.Context) {
 	user := c.Query("user")
 	pwd := c.Query("pwd")
 	tunnel := c.DefaultQuery("tunnel", "-1")
-	if user == "" {
-		c.JSON(http.StatusOK, response.ErrMsg("用户不能为空"))
-		return
-	}
-	if pwd == "" {
-		c.JSON(http.StatusOK, response.ErrMsg("密码不能为空"))
-		return
-	}
 	var u model.User
-	if err := dbpkg.DB.Where("user = ?", user).First(&u).Error; err != nil {
-		c.JSON(http.StatusOK, response.ErrMsg("鉴权失败"))
-		return
-	}
-	if u.Pwd != util.MD5(pwd) {
-		c.JSON(http.StatusOK, response.ErrMsg("鉴权失败"))
-		return
+	if token := extractToken(c); token != "" && user == "" {
+		var tok *model.SubToken
+		var ok bool
+		if u, _, tok, ok = subscriptionAuth(token); !ok {
+			c.JSON(http.StatusOK, response.ErrMsg("鉴权失败"))
+			return
+		}
+		recordSubAccess(c, u, tok, "sub_store")
+	} else {
+		if user == "" {
+			c.JSON(http.StatusOK, response.ErrMsg("用户不能为空"))
+			return
+		}
+		if pwd == "" {
+			c.JSON(http.StatusOK, response.ErrMsg("密码不能为空"))
+			return
+		}
+		if err := dbpkg.DB.Where("user = ?", user).First(&u).Error; err != nil {
+			c.JSON(http.StatusOK, response.ErrMsg("鉴权失败"))
+			return
+		}
+		if u.Pwd != util.MD5(pwd) {
+			c.JSON(http.StatusOK, response.ErrMsg("鉴权失败"))
+			return
+		}
 	}
 
 	const GIGA int64 = 1024 * 1024 * 1024
@@ -1,7 +1,11 @@
 package controller
 
 import (
+	"encoding/json"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -19,18 +23,181 @@ func ProbeList(c *gin.Context) {
 	c.JSON(http.StatusOK, response.Ok(list))
 }
 
-// POST /api/v1/probe/create {name, ip}
-func ProbeCreate(c *gin.Context) {
-	var p struct {
-		Name string `json:"name" binding:"required"`
-		IP   string `json:"ip" binding:"required"`
+// probeTargetReq is the create/update body. `type` also accepts the "tcp:443" shorthand.
+type probeTargetReq struct {
+	ID           int64   `json:"id"`
+	Name         string  `json:"name"`
+	IP           string  `json:"ip"`
+	Status       *int    `json:"status"`
+	Type         *string `json:"type"`
+	Port         *int    `json:"port"`
+	URL          *string `json:"url"`
+	ExpectStatus *int    `json:"expectStatus"`
+	ExpectBody   *string `json:"expectBody"`
+	DNSName      *string `json:"dnsName"`
+	DNSType      *string `json:"dnsType"`
+	Count        *int    `json:"count"`
+	IntervalSec  *int    `json:"intervalSec"`
+	TimeoutMs    *int    `json:"timeoutMs"`
+	NodeIDs      []int64 `json:"nodeIds"`
+	GroupID      *int64  `json:"groupId"`
+}
+
+func (p probeTargetReq) applyTo(rec *model.ProbeTarget) {
+	if p.Name != "" {
+		rec.Name = p.Name
+	}
+	if p.IP != "" {
+		rec.IP = p.IP
+	}
+	if p.Status != nil {
+		rec.Status = *p.Status
+	}
+	if p.Type != nil {
+		t := strings.ToLower(strings.TrimSpace(*p.Type))
+		if strings.HasPrefix(t, "tcp:") {
+			if port, err := strconv.Atoi(strings.TrimPrefix(t, "tcp:")); err == nil {
+				rec.Port = port
+			}
+			t = "tcp"
+		}
+		rec.Type = t
+	}
+	if p.Port != nil {
+		rec.Port = *p.Port
+	}
+	if p.URL != nil {
+		rec.URL = strings.TrimSpace(*p.URL)
+	}
+	if p.ExpectStatus != nil {
+		rec.ExpectStatus = *p.ExpectStatus
+	}
+	if p.ExpectBody != nil {
+		rec.ExpectBody = *p.ExpectBody
+	}
+	if p.DNSName != nil {
+		rec.DNSName = strings.TrimSpace(*p.DNSName)
+	}
+	if p.DNSType != nil {
+		rec.DNSType = strings.ToUpper(strings.TrimSpace(*p.DNSType))
+	}
+	if p.Count != nil {
+		rec.Count = *p.Count
+	}
+	if p.IntervalSec != nil {
+		rec.IntervalSec = *p.IntervalSec
+	}
+	if p.TimeoutMs != nil {
+		rec.TimeoutMs = *p.TimeoutMs
+	}
+	if p.NodeIDs != nil {
+		if len(p.NodeIDs) == 0 {
+			rec.NodeIDs = nil
+		} else {
+			b, _ := json.Marshal(p.NodeIDs)
+			v := string(b)
+			rec.NodeIDs = &v
+		}
+	}
+	if p.GroupID != nil {
+		if *p.GroupID > 0 {
+			rec.GroupID = p.GroupID
+		} else {
+			rec.GroupID = nil
+		}
+	}
+}
+
+// normalizeProbeTarget fills defaults (also for rows created before probe types existed).
+func normalizeProbeTarget(t *model.ProbeTarget) {
+	if t.Type == "" {
+		t.Type = "icmp"
+	}
+	if t.Count <= 0 {
+		t.Count = 1
+	}
+	if t.IntervalSec <= 0 {
+		t.IntervalSec = 60
+	}
+	if t.TimeoutMs <= 0 {
+		t.TimeoutMs = 1000
+	}
+	if t.Type == "dns" && t.DNSType == "" {
+		t.DNSType = "A"
+	}
+}
+
+func validateProbeTarget(t model.ProbeTarget) string {
+	if t.Name == "" {
+		return "名称不能为空"
+	}
+	if t.Count > 20 || t.IntervalSec < 10 || t.TimeoutMs > 30000 {
+		return "次数(≤20)/间隔(≥10s)/超时(≤30s) 超出范围"
 	}
+	switch t.Type {
+	case "icmp":
+		if t.IP == "" {
+			return "ICMP 探测需要目标地址"
+		}
+	case "tcp":
+		if t.IP == "" || t.Port <= 0 || t.Port > 65535 {
+			return "TCP 探测需要目标地址和端口"
+		}
+	case "http":
+		u, err := url.Parse(t.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return "HTTP 探测需要有效的 http(s) URL"
+		}
+	case "dns":
+		if t.DNSName == "" {
+			return "DNS 探测需要查询域名"
+		}
+		if t.DNSType != "A" && t.DNSType != "AAAA" {
+			return "DNS 查询类型仅支持 A/AAAA"
+		}
+	default:
+		return "不支持的探测类型"
+	}
+	return ""
+}
+
+// probeTargetForNode reports whether a target is assigned to the node (no
+// assignment = all nodes); group members count as assigned.
+func probeTargetForNode(t model.ProbeTarget, nodeID int64) bool {
+	var ids []int64
+	if t.NodeIDs != nil && strings.TrimSpace(*t.NodeIDs) != "" {
+		_ = json.Unmarshal([]byte(*t.NodeIDs), &ids)
+	}
+	if t.GroupID != nil && *t.GroupID > 0 {
+		if nodeGroupMemberSet(*t.GroupID)[nodeID] {
+			return true
+		}
+	} else if len(ids) == 0 {
+		return true
+	}
+	for _, id := range ids {
+		if id == nodeID {
+			return true
+		}
+	}
+	return false
+}
+
+// POST /api/v1/probe/create {name, type, ip, port, url, expectStatus, expectBody, dnsName, dnsType, count, intervalSec, timeoutMs, nodeIds, groupId}
+func ProbeCreate(c *gin.Context) {
+	var p probeTargetReq
 	if err := c.ShouldBindJSON(&p); err != nil {
 		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
 		return
 	}
 	now := time.Now().UnixMilli()
-	rec := model.ProbeTarget{CreatedTime: now, UpdatedTime: now, Status: 1, Name: p.Name, IP: p.IP}
+	rec := model.ProbeTarget{CreatedTime: now, UpdatedTime: now, Status: 1}
+	p.applyTo(&rec)
+	normalizeProbeTarget(&rec)
+	if msg := validateProbeTarget(rec); msg != "" {
+		c.JSON(http.StatusOK, response.ErrMsg(msg))
+		return
+	}
 	if err := dbpkg.DB.Create(&rec).Error; err != nil {
 		c.JSON(http.StatusOK, response.ErrMsg("保存失败"))
 		return
@@ -38,15 +205,10 @@ func ProbeCreate(c *gin.Context) {
 	c.JSON(http.StatusOK, response.Ok(rec))
 }
 
-// POST /api/v1/probe/update {id, name, ip, status?}
+// POST /api/v1/probe/update {id, ...same as create, status?}
 func ProbeUpdate(c *gin.Context) {
-	var p struct {
-		ID     int64  `json:"id" binding:"required"`
-		Name   string `json:"name"`
-		IP     string `json:"ip"`
-		Status *int   `json:"status"`
-	}
-	if err := c.ShouldBindJSON(&p); err != nil {
+	var p probeTargetReq
+	if err := c.ShouldBindJSON(&p); err != nil || p.ID == 0 {
 		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
 		return
 	}
@@ -55,14 +217,11 @@ func ProbeUpdate(c *gin.Context) {
 		c.JSON(http.StatusOK, response.ErrMsg("不存在"))
 		return
 	}
-	if p.Name != "" {
-		rec.Name = p.Name
-	}
-	if p.IP != "" {
-		rec.IP = p.IP
-	}
-	if p.Status != nil {
-		rec.Status = *p.Status
+	p.applyTo(&rec)
+	normalizeProbeTarget(&rec)
+	if msg := validateProbeTarget(rec); msg != "" {
+		c.JSON(http.StatusOK, response.ErrMsg(msg))
+		return
 	}
 	rec.UpdatedTime = time.Now().UnixMilli()
 	if err := dbpkg.DB.Save(&rec).Error; err != nil {
@@ -87,10 +246,12 @@ func ProbeDelete(c *gin.Context) {
 
 // ---- Agent endpoints ----
 
-// POST /api/v1/agent/probe-targets {secret}
+// POST /api/v1/agent/probe-targets {secret, types?}
+// Agents that don't send `types` only understand ICMP and get ICMP targets only.
 func AgentProbeTargets(c *gin.Context) {
 	var p struct {
-		Secret string `json:"secret" binding:"required"`
+		Secret string   `json:"secret" binding:"required"`
+		Types  []string `json:"types"`
 	}
 	if err := c.ShouldBindJSON(&p); err != nil {
 		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
@@ -101,20 +262,36 @@ func AgentProbeTargets(c *gin.Context) {
 		c.JSON(http.StatusOK, response.ErrMsg("节点不存在"))
 		return
 	}
+	supported := map[string]bool{"icmp": true}
+	for _, t := range p.Types {
+		supported[strings.ToLower(t)] = true
+	}
 	var list []model.ProbeTarget
 	dbpkg.DB.Where("status = 1").Order("id asc").Find(&list)
-	c.JSON(http.StatusOK, response.Ok(list))
+	out := make([]model.ProbeTarget, 0, len(list))
+	for _, t := range list {
+		normalizeProbeTarget(&t)
+		if !supported[t.Type] || !probeTargetForNode(t, node.ID) {
+			continue
+		}
+		t.NodeIDs = nil
+		out = append(out, t)
+	}
+	c.JSON(http.StatusOK, response.Ok(out))
 }
 
-// POST /api/v1/agent/report-probe {secret, results:[{targetId, rttMs, ok, timeMs?}]}
+// POST /api/v1/agent/report-probe {secret, results:[{targetId, rttMs, ok, lossPct?, jitterMs?, error?, timeMs?}]}
 func AgentReportProbe(c *gin.Context) {
 	var p struct {
 		Secret  string `json:"secret" binding:"required"`
 		Results []struct {
-			TargetID int64  `json:"targetId"`
-			RTTMs    int    `json:"rttMs"`
-			OK       int    `json:"ok"`
-			TimeMs   *int64 `json:"timeMs"`
+			TargetID int64    `json:"targetId"`
+			RTTMs    int      `json:"rttMs"`
+			OK       int      `json:"ok"`
+			LossPct  *float64 `json:"lossPct"`
+			JitterMs float64  `json:"jitterMs"`
+			Error    string   `json:"error"`
+			TimeMs   *int64   `json:"timeMs"`
 		} `json:"results"`
 	}
 	if err := c.ShouldBindJSON(&p); err != nil {
@@ -137,7 +314,16 @@ func AgentReportProbe(c *gin.Context) {
 		if r.TimeMs != nil && *r.TimeMs > 0 {
 			t = *r.TimeMs
 		}
-		rows = append(rows, model.NodeProbeResult{NodeID: node.ID, TargetID: r.TargetID, RTTMs: r.RTTMs, OK: r.OK, TimeMs: t})
+		// older agents send a single ICMP packet without loss: derive it from ok
+		loss := float64(100 * (1 - r.OK))
+		if r.LossPct != nil {
+			loss = *r.LossPct
+		}
+		errText := r.Error
+		if len(errText) > 255 {
+			errText = errText[:255]
+		}
+		rows = append(rows, model.NodeProbeResult{NodeID: node.ID, TargetID: r.TargetID, RTTMs: r.RTTMs, OK: r.OK, TimeMs: t, LossPct: loss, JitterMs: r.JitterMs, Error: errText})
 	}
 	enqueueProbes(rows)
 	c.JSON(http.StatusOK, response.OkNoData())
@@ -204,7 +390,8 @@ func NodeNetworkStats(c *gin.Context) {
 		var tgts []model.ProbeTarget
 		dbpkg.DB.Where("id IN ?", targetIDs).Find(&tgts)
 		for _, t := range tgts {
-			m[t.ID] = map[string]string{"name": t.Name, "ip": t.IP}
+			normalizeProbeTarget(&t)
+			m[t.ID] = map[string]string{"name": t.Name, "ip": t.IP, "type": t.Type, "url": t.URL, "dnsName": t.DNSName, "port": strconv.Itoa(t.Port)}
 		}
 	}
 
@@ -0,0 +1,574 @@
+package controller
+
+import (
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"network-panel/golang-backend/internal/app/model"
+	"network-panel/golang-backend/internal/app/response"
+	dbpkg "network-panel/golang-backend/internal/db"
+)
+
+// Time-series rollups.
+//
+// Raw samples (node_sysinfo, node_probe_result, node_mesh_result,
+// easytier_peer_stat, flow_timeseries) are folded into 5-minute buckets once a
+// bucket is closed; hourly buckets are built from
+// the 5-minute rows and daily buckets (midnight UTC+8) from the hourly rows.
+// Gauges keep count/min/avg/max/p95 (p95 of coarser buckets is the 95th
+// percentile of the child p95 values), traffic keeps sums. Each resolution
+// advances its own watermark (config rollup_wm_*) and has its own retention;
+// raw pruning never passes the 5-minute watermark.
+
+type rollupRes struct {
+	name         string
+	step         int64
+	gaugeTable   string
+	trafficTable string
+	retentionKey string
+	defDays      int
+}
+
+var rollupResolutions = []rollupRes{
+	{"5m", 5 * 60 * 1000, "rollup_gauge_5m", "rollup_traffic_5m", "rollup_retention_5m_days", 14},
+	{"1h", 3600 * 1000, "rollup_gauge_1h", "rollup_traffic_1h", "rollup_retention_1h_days", 90},
+	{"1d", 24 * 3600 * 1000, "rollup_gauge_1d", "rollup_traffic_1d", "rollup_retention_1d_days", 730},
+}
+
+const (
+	rollupLagMs     = 5 * 60 * 1000 // leave time for batch buffers to flush
+	rollupMaxPerRun = 288
+	cstOffsetMs     = 8 * 3600 * 1000
+)
+
+func (r rollupRes) bucket(t int64) int64 {
+	if r.name == "1d" {
+		return (t+cstOffsetMs)/r.step*r.step - cstOffsetMs
+	}
+	return t / r.step * r.step
+}
+
+func rollupResByName(name string) (rollupRes, bool) {
+	for _, r := range rollupResolutions {
+		if r.name == name {
+			return r, true
+		}
+	}
+	return rollupRes{}, false
+}
+
+var (
+	rollupMu        sync.Mutex
+	rollupOnce      sync.Once
+	rollupLastPrune time.Time
+)
+
+// StartRollupJob runs RunRollups every ROLLUP_INTERVAL_SEC (default 300).
+func StartRollupJob() {
+	rollupOnce.Do(func() {
+		go func() {
+			sec := 300
+			if v := os.Getenv("ROLLUP_INTERVAL_SEC"); v != "" {
+				if n, err := strconv.Atoi(v); err == nil && n > 0 {
+					sec = n
+				}
+			}
+			ticker := time.NewTicker(time.Duration(sec) * time.Second)
+			defer ticker.Stop()
+			for {
+				RunRollups()
+				<-ticker.C
+			}
+		}()
+	})
+}
+
+// RollupWatermark returns the end of the last rolled-up 5-minute bucket (0 if none).
+func RollupWatermark() int64 {
+	v, _ := strconv.ParseInt(getCfg("rollup_wm_5m"), 10, 64)
+	return v
+}
+
+// RunRollups advances every resolution as far as its source allows and
+// applies retention. Safe to call concurrently (runs are serialized).
+func RunRollups() {
+	rollupMu.Lock()
+	defer rollupMu.Unlock()
+	limit := time.Now().UnixMilli() - rollupLagMs
+	for i, res := range rollupResolutions {
+		key := "rollup_wm_" + res.name
+		wm, _ := strconv.ParseInt(getCfg(key), 10, 64)
+		if wm == 0 {
+			wm = rollupInitialWatermark(i)
+			if wm == 0 {
+				return
+			}
+		}
+		done := 0
+		for wm+res.step <= limit && done < rollupMaxPerRun {
+			var err error
+			if i == 0 {
+				err = rollupRawBucket(res, wm)
+			} else {
+				err = rollupChildBucket(res, rollupResolutions[i-1], wm)
+			}
+			if err != nil {
+				jlog(map[string]any{"event": "rollup_error", "res": res.name, "bucketMs": wm, "error": err.Error()})
+				break
+			}
+			wm += res.step
+			done++
+		}
+		setCfg(key, strconv.FormatInt(wm, 10))
+		// a coarser bucket may only close once its source has passed it
+		limit = wm
+	}
+	if time.Since(rollupLastPrune) >= time.Hour {
+		rollupLastPrune = time.Now()
+		now := time.Now().UnixMilli()
+		for _, res := range rollupResolutions {
+			cut := now - int64(getCfgInt(res.retentionKey, res.defDays))*24*3600*1000
+			_ = dbpkg.DB.Table(res.gaugeTable).Where("bucket_ms < ?", cut).Delete(&model.GaugeRollup{}).Error
+			_ = dbpkg.DB.Table(res.trafficTable).Where("bucket_ms < ?", cut).Delete(&model.TrafficRollup{}).Error
+		}
+	}
+}
+
+// rollupInitialWatermark starts at the oldest available source row.
+func rollupInitialWatermark(i int) int64 {
+	res := rollupResolutions[i]
+	var first int64
+	min := func(table string, col string) {
+		var v *int64
+		dbpkg.DB.Table(table).Select("MIN(" + col + ")").Scan(&v)
+		if v != nil && *v > 0 && (first == 0 || *v < first) {
+			first = *v
+		}
+	}
+	if i == 0 {
+		min("node_sysinfo", "time_ms")
+		min("node_probe_result", "time_ms")
+		min("node_mesh_result", "time_ms")
+		min("easytier_peer_stat", "time_ms")
+		min("flow_timeseries", "time_ms")
+	} else {
+		min(rollupResolutions[i-1].gaugeTable, "bucket_ms")
+		min(rollupResolutions[i-1].trafficTable, "bucket_ms")
+	}
+	if first == 0 {
+		return 0
+	}
+	return res.bucket(first)
+}
+
+func percentileFloat(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	if idx >= len(sorted) {
+		idx = len(sorted) - 1
+	}
+	return sorted[idx]
+}
+
+type gaugeKey struct {
+	node   int64
+	series string
+	ref    int64
+}
+
+func gaugeFromSamples(bucket int64, k gaugeKey, vals []float64) model.GaugeRollup {
+	sort.Float64s(vals)
+	sum := 0.0
+	for _, v := range vals {
+		sum += v
+	}
+	return model.GaugeRollup{BucketMs: bucket, NodeID: k.node, Series: k.series, RefID: k.ref, Count: len(vals),
+		Min: vals[0], Max: vals[len(vals)-1], Avg: sum / float64(len(vals)), P95: percentileFloat(vals, 95)}
+}
+
+func sortedGaugeKeys[V any](m map[gaugeKey]V) []gaugeKey {
+	keys := make([]gaugeKey, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.node != b.node {
+			return a.node < b.node
+		}
+		if a.series != b.series {
+			return a.series < b.series
+		}
+		return a.ref < b.ref
+	})
+	return keys
+}
+
+func writeRollupBucket(res rollupRes, bucket int64, gauges []model.GaugeRollup, traffic []model.TrafficRollup) error {
+	return dbpkg.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Table(res.gaugeTable).Where("bucket_ms = ?", bucket).Delete(&model.GaugeRollup{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Table(res.trafficTable).Where("bucket_ms = ?", bucket).Delete(&model.TrafficRollup{}).Error; err != nil {
+			return err
+		}
+		if len(gauges) > 0 {
+			if err := tx.Table(res.gaugeTable).CreateInBatches(&gauges, 500).Error; err != nil {
+				return err
+			}
+		}
+		if len(traffic) > 0 {
+			if err := tx.Table(res.trafficTable).CreateInBatches(&traffic, 500).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// rollupRawBucket folds raw samples of [start, start+5m) into the 5-minute tables.
+func rollupRawBucket(res rollupRes, start int64) error {
+	end := start + res.step
+	samples := map[gaugeKey][]float64{}
+
+	// sysinfo: gauges plus interface counter deltas (previous sample may sit in the prior bucket)
+	var sys []model.NodeSysInfo
+	if err := dbpkg.DB.Where("time_ms >= ? AND time_ms < ?", start-res.step, end).Order("node_id asc, time_ms asc").Find(&sys).Error; err != nil {
+		return err
+	}
+	nodeTraffic := map[int64]*model.TrafficRollup{}
+	var prev *model.NodeSysInfo
+	for i := range sys {
+		s := &sys[i]
+		if s.TimeMs >= start {
+			samples[gaugeKey{s.NodeID, "cpu", 0}] = append(samples[gaugeKey{s.NodeID, "cpu", 0}], s.CPU)
+			samples[gaugeKey{s.NodeID, "mem", 0}] = append(samples[gaugeKey{s.NodeID, "mem", 0}], s.Mem)
+			if prev != nil && prev.NodeID == s.NodeID {
+				t := nodeTraffic[s.NodeID]
+				if t == nil {
+					t = &model.TrafficRollup{BucketMs: start, Kind: "node", RefID: s.NodeID}
+					nodeTraffic[s.NodeID] = t
+				}
+				// counters reset on host reboot; ignore negative steps
+				if d := s.BytesRx - prev.BytesRx; d > 0 {
+					t.InBytes += d
+				}
+				if d := s.BytesTx - prev.BytesTx; d > 0 {
+					t.OutBytes += d
+				}
+				t.Samples++
+			}
+		}
+		prev = s
+	}
+
+	var probes []model.NodeProbeResult
+	if err := dbpkg.DB.Where("time_ms >= ? AND time_ms < ?", start, end).Find(&probes).Error; err != nil {
+		return err
+	}
+	for _, p := range probes {
+		if p.OK == 1 {
+			samples[gaugeKey{p.NodeID, "probe_rtt", p.TargetID}] = append(samples[gaugeKey{p.NodeID, "probe_rtt", p.TargetID}], float64(p.RTTMs))
+		}
+		samples[gaugeKey{p.NodeID, "probe_loss", p.TargetID}] = append(samples[gaugeKey{p.NodeID, "probe_loss", p.TargetID}], p.LossPct)
+		samples[gaugeKey{p.NodeID, "probe_up", p.TargetID}] = append(samples[gaugeKey{p.NodeID, "probe_up", p.TargetID}], float64(p.OK))
+	}
+
+	// node mesh: node = source, ref = destination, path folded into the series name
+	var mesh []model.NodeMeshResult
+	if err := dbpkg.DB.Where("time_ms >= ? AND time_ms < ?", start, end).Find(&mesh).Error; err != nil {
+		return err
+	}
+	for _, m := range mesh {
+		if m.OK == 1 && m.RTTMs > 0 {
+			k := gaugeKey{m.SrcNodeID, meshSeries("rtt", m.Path), m.DstNodeID}
+			samples[k] = append(samples[k], float64(m.RTTMs))
+		}
+		k := gaugeKey{m.SrcNodeID, meshSeries("loss", m.Path), m.DstNodeID}
+		samples[k] = append(samples[k], m.LossPct)
+		k = gaugeKey{m.SrcNodeID, meshSeries("up", m.Path), m.DstNodeID}
+		samples[k] = append(samples[k], float64(m.OK))
+	}
+
+	// EasyTier peers: ref = peer member; peers that are not panel nodes are not kept
+	var peers []model.EasyTierPeerStat
+	if err := dbpkg.DB.Where("time_ms >= ? AND time_ms < ? AND peer_node_id > 0", start, end).Find(&peers).Error; err != nil {
+		return err
+	}
+	for _, p := range peers {
+		if p.LatencyMs > 0 {
+			k := gaugeKey{p.NodeID, "etpeer_rtt", p.PeerNodeID}
+			samples[k] = append(samples[k], p.LatencyMs)
+		}
+		k := gaugeKey{p.NodeID, "etpeer_loss", p.PeerNodeID}
+		samples[k] = append(samples[k], p.LossPct)
+	}
+
+	gauges := make([]model.GaugeRollup, 0, len(samples))
+	for _, k := range sortedGaugeKeys(samples) {
+		gauges = append(gauges, gaugeFromSamples(start, k, samples[k]))
+	}
+
+	var flows []struct {
+		UserID  int64 `gorm:"column:user_id"`
+		In      int64 `gorm:"column:in_bytes"`
+		Out     int64 `gorm:"column:out_bytes"`
+		Billed  int64 `gorm:"column:billed_bytes"`
+		Samples int   `gorm:"column:samples"`
+	}
+	if err := dbpkg.DB.Model(&model.FlowTimeseries{}).
+		Select("user_id, SUM(in_bytes) as in_bytes, SUM(out_bytes) as out_bytes, SUM(billed_bytes) as billed_bytes, COUNT(*) as samples").
+		Where("time_ms >= ? AND time_ms < ?", start, end).Group("user_id").Order("user_id asc").Scan(&flows).Error; err != nil {
+		return err
+	}
+	traffic := make([]model.TrafficRollup, 0, len(flows)+len(nodeTraffic))
+	for _, f := range flows {
+		traffic = append(traffic, model.TrafficRollup{BucketMs: start, Kind: "user", RefID: f.UserID, InBytes: f.In, OutBytes: f.Out, BilledBytes: f.Billed, Samples: f.Samples})
+	}
+	for _, t := range nodeTraffic {
+		traffic = append(traffic, *t)
+	}
+	return writeRollupBucket(res, start, gauges, traffic)
+}
+
+// rollupChildBucket builds one coarse bucket from the finer resolution.
+func rollupChildBucket(res, child rollupRes, start int64) error {
+	end := start + res.step
+	var rows []model.GaugeRollup
+	if err := dbpkg.DB.Table(child.gaugeTable).Where("bucket_ms >= ? AND bucket_ms < ?", start, end).Find(&rows).Error; err != nil {
+		return err
+	}
+	groups := map[gaugeKey][]model.GaugeRollup{}
+	for _, r := range rows {
+		k := gaugeKey{r.NodeID, r.Series, r.RefID}
+		groups[k] = append(groups[k], r)
+	}
+	gauges := make([]model.GaugeRollup, 0, len(groups))
+	for _, k := range sortedGaugeKeys(groups) {
+		list := groups[k]
+		g := model.GaugeRollup{BucketMs: start, NodeID: k.node, Series: k.series, RefID: k.ref, Min: list[0].Min, Max: list[0].Max}
+		sum := 0.0
+		p95s := make([]float64, 0, len(list))
+		for _, r := range list {
+			g.Count += r.Count
+			sum += r.Avg * float64(r.Count)
+			g.Min = math.Min(g.Min, r.Min)
+			g.Max = math.Max(g.Max, r.Max)
+			p95s = append(p95s, r.P95)
+		}
+		if g.Count > 0 {
+			g.Avg = sum / float64(g.Count)
+		}
+		sort.Float64s(p95s)
+		g.P95 = percentileFloat(p95s, 95)
+		gauges = append(gauges, g)
+	}
+
+	var traffic []model.TrafficRollup
+	if err := dbpkg.DB.Table(child.trafficTable).
+		Select("kind, ref_id, SUM(in_bytes) as in_bytes, SUM(out_bytes) as out_bytes, SUM(billed_bytes) as billed_bytes, SUM(samples) as samples").
+		Where("bucket_ms >= ? AND bucket_ms < ?", start, end).Group("kind, ref_id").Order("kind asc, ref_id asc").Scan(&traffic).Error; err != nil {
+		return err
+	}
+	for i := range traffic {
+		traffic[i].ID = 0
+		traffic[i].BucketMs = start
+	}
+	return writeRollupBucket(res, start, gauges, traffic)
+}
+
+// meshSeries names a mesh gauge: mesh_<metric>.<pub|ovl> (series is varchar(16)).
+func meshSeries(metric, path string) string {
+	if path == meshPathOverlay {
+		return "mesh_" + metric + ".ovl"
+	}
+	return "mesh_" + metric + ".pub"
+}
+
+// rollupHistorySplit decides how a history query over [from, now) is served:
+// ranges up to a day read raw rows only; longer ranges read the auto-selected
+// resolution up to its watermark and raw rows after it. ok=false means raw only.
+func rollupHistorySplit(from int64) (res rollupRes, wm int64, ok bool) {
+	now := time.Now().UnixMilli()
+	if now-from <= 24*3600*1000 {
+		return res, 0, false
+	}
+	_, _, res, msg := rollupQueryRange(from, now, "", "auto")
+	if msg != "" {
+		return res, 0, false
+	}
+	wm, _ = strconv.ParseInt(getCfg("rollup_wm_"+res.name), 10, 64)
+	if wm <= from {
+		return res, 0, false
+	}
+	return res, wm, true
+}
+
+// loadGaugeRollups reads buckets of [from, to) for the given series; node/ref 0 match any.
+func loadGaugeRollups(res rollupRes, from, to, node, ref int64, series []string) []model.GaugeRollup {
+	q := dbpkg.DB.Table(res.gaugeTable).Where("bucket_ms >= ? AND bucket_ms < ? AND series IN ?", res.bucket(from), to, series)
+	if node > 0 {
+		q = q.Where("node_id = ?", node)
+	}
+	if ref > 0 {
+		q = q.Where("ref_id = ?", ref)
+	}
+	var rows []model.GaugeRollup
+	q.Order("bucket_ms asc, node_id asc, ref_id asc").Find(&rows)
+	return rows
+}
+
+// ---- API ----
+
+// rollupQueryRange resolves from/to (ms) or a range name, and the resolution:
+// explicit, or auto (<= 2d: 5m, <= 30d: 1h, otherwise 1d).
+func rollupQueryRange(from, to int64, rng, resName string) (int64, int64, rollupRes, string) {
+	now := time.Now().UnixMilli()
+	if to <= 0 || to > now {
+		to = now
+	}
+	if from <= 0 {
+		from = to - rangeWindowMs(rng)
+	}
+	if from >= to {
+		return 0, 0, rollupRes{}, "时间范围错误"
+	}
+	if resName == "" || resName == "auto" {
+		switch span := to - from; {
+		case span <= 2*24*3600*1000:
+			resName = "5m"
+		case span <= 30*24*3600*1000:
+			resName = "1h"
+		default:
+			resName = "1d"
+		}
+	}
+	res, ok := rollupResByName(resName)
+	if !ok {
+		return 0, 0, res, "粒度仅支持 auto/5m/1h/1d"
+	}
+	if (to-from)/res.step > 5000 {
+		return 0, 0, res, "数据点过多，请选择更粗的粒度"
+	}
+	return res.bucket(from), to, res, ""
+}
+
+// RollupGauge 指标聚合曲线
+// @Summary 指标聚合曲线（cpu/mem/probe_rtt/probe_loss/probe_up/mesh_rtt.pub 等/etpeer_rtt/etpeer_loss）
+// @Tags rollup
+// @Accept json
+// @Produce json
+// @Param data body object true "{nodeId, series, refId?, range?: 1h|12h|1d|7d|30d|90d|365d, from?, to?, resolution?: auto|5m|1h|1d}"
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/rollup/gauge [post]
+func RollupGauge(c *gin.Context) {
+	var p struct {
+		NodeID     int64  `json:"nodeId"`
+		Series     string `json:"series" binding:"required"`
+		RefID      int64  `json:"refId"`
+		Range      string `json:"range"`
+		From       int64  `json:"from"`
+		To         int64  `json:"to"`
+		Resolution string `json:"resolution"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil || p.NodeID <= 0 {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	from, to, res, msg := rollupQueryRange(p.From, p.To, p.Range, p.Resolution)
+	if msg != "" {
+		c.JSON(http.StatusOK, response.ErrMsg(msg))
+		return
+	}
+	var rows []model.GaugeRollup
+	dbpkg.DB.Table(res.gaugeTable).
+		Where("bucket_ms >= ? AND bucket_ms < ? AND node_id = ? AND series = ? AND ref_id = ?", from, to, p.NodeID, p.Series, p.RefID).
+		Order("bucket_ms asc").Find(&rows)
+	c.JSON(http.StatusOK, response.Ok(map[string]any{"resolution": res.name, "stepMs": res.step, "from": from, "to": to, "points": rows}))
+}
+
+// RollupTraffic 流量聚合曲线（普通用户仅可查询自己的 user 流量）
+// @Summary 流量聚合曲线
+// @Tags rollup
+// @Accept json
+// @Produce json
+// @Param data body object true "{kind: user|node, refId, range?, from?, to?, resolution?}"
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/rollup/traffic [post]
+func RollupTraffic(c *gin.Context) {
+	var p struct {
+		Kind       string `json:"kind" binding:"required"`
+		RefID      int64  `json:"refId"`
+		Range      string `json:"range"`
+		From       int64  `json:"from"`
+		To         int64  `json:"to"`
+		Resolution string `json:"resolution"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil || (p.Kind != "user" && p.Kind != "node") {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	if c.GetInt("role_id") != 0 {
+		if p.Kind != "user" {
+			c.JSON(http.StatusOK, response.ErrMsg("权限不足"))
+			return
+		}
+		p.RefID = c.GetInt64("user_id")
+	}
+	from, to, res, msg := rollupQueryRange(p.From, p.To, p.Range, p.Resolution)
+	if msg != "" {
+		c.JSON(http.StatusOK, response.ErrMsg(msg))
+		return
+	}
+	var rows []model.TrafficRollup
+	dbpkg.DB.Table(res.trafficTable).
+		Where("bucket_ms >= ? AND bucket_ms < ? AND kind = ? AND ref_id = ?", from, to, p.Kind, p.RefID).
+		Order("bucket_ms asc").Find(&rows)
+	var in, out, billed int64
+	for _, r := range rows {
+		in, out, billed = in+r.InBytes, out+r.OutBytes, billed+r.BilledBytes
+	}
+	c.JSON(http.StatusOK, response.Ok(map[string]any{"resolution": res.name, "stepMs": res.step, "from": from, "to": to, "points": rows,
+		"total": map[string]int64{"inBytes": in, "outBytes": out, "billedBytes": billed}}))
+}
+
+// RollupSettings 聚合保留天数设置（不带参数时仅返回当前设置与进度）
+// @Summary 聚合保留设置
+// @Tags rollup
+// @Accept json
+// @Produce json
+// @Param data body object false "{retention5mDays?, retention1hDays?, retention1dDays?}"
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/rollup/settings [post]
+func RollupSettings(c *gin.Context) {
+	var p map[string]int
+	_ = c.ShouldBindJSON(&p)
+	for _, res := range rollupResolutions {
+		if v, ok := p["retention"+res.name+"Days"]; ok {
+			if v < 1 || v > 3650 {
+				c.JSON(http.StatusOK, response.ErrMsg("保留天数范围 1-3650"))
+				return
+			}
+			setCfg(res.retentionKey, itoa(v))
+		}
+	}
+	out := map[string]any{}
+	for _, res := range rollupResolutions {
+		wm, _ := strconv.ParseInt(getCfg("rollup_wm_"+res.name), 10, 64)
+		out["retention"+res.name+"Days"] = getCfgInt(res.retentionKey, res.defDays)
+		out["watermark"+res.name] = wm
+	}
+	c.JSON(http.StatusOK, response.Ok(out))
+}
@@ -299,7 +299,7 @@ func buildSingboxOutboundForForward(f model.Forward, t model.Tunnel, testNodeID
 		}
 	}
 
-	proto, cipher, password, params := resolveExitProtocol(t, ext, ssMap, anyTLSMap)
+	proto, cipher, password, params := resolveExitProtocol(t, ext, ssMap, anyTLSMap, exitCertMap(idList))
 	if strings.TrimSpace(proto) == "" {
 		return nil, fmt.Errorf("出口协议为空")
 	}
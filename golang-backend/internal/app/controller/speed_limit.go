@@ -1,6 +1,7 @@
 package controller
 
 import (
+	"errors"
 	"net/http"
 	"time"
 
@@ -18,21 +19,29 @@ func SpeedLimitCreate(c *gin.Context) {
 		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
 		return
 	}
+	if _, err := createSpeedLimit(req); err != nil {
+		replyErr(c, err)
+		return
+	}
+	// Gost limiter add is stubbed
+	c.JSON(http.StatusOK, response.OkNoData())
+}
+
+// createSpeedLimit saves a speed limit rule and applies it to the tunnel's
+// entry services.
+func createSpeedLimit(req dto.SpeedLimitDto) (model.SpeedLimit, error) {
 	var t model.Tunnel
 	if err := dbpkg.DB.First(&t, req.TunnelID).Error; err != nil {
-		c.JSON(http.StatusOK, response.ErrMsg("隧道不存在"))
-		return
+		return model.SpeedLimit{}, errors.New("隧道不存在")
 	}
 	// create
 	now := time.Now().UnixMilli()
 	sl := model.SpeedLimit{CreatedTime: now, UpdatedTime: now, Status: 1, Name: req.Name, Speed: req.Speed, TunnelID: req.TunnelID, TunnelName: req.TunnelName}
 	if err := dbpkg.DB.Create(&sl).Error; err != nil {
-		c.JSON(http.StatusOK, response.ErrMsg("限速规则创建失败"))
-		return
+		return model.SpeedLimit{}, errors.New("限速规则创建失败")
 	}
 	applyLimiterForTunnel(sl.TunnelID)
-	// Gost limiter add is stubbed
-	c.JSON(http.StatusOK, response.OkNoData())
+	return sl, nil
 }
 
 // POST /api/v1/speed-limit/list
@@ -49,30 +58,37 @@ func SpeedLimitUpdate(c *gin.Context) {
 		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
 		return
 	}
+	if err := updateSpeedLimit(req); err != nil {
+		replyErr(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, response.OkMsg("限速规则更新成功"))
+}
+
+// updateSpeedLimit saves a speed limit rule and re-applies it to the tunnel
+// and to the exit nodes whose user rules reference it.
+func updateSpeedLimit(req dto.SpeedLimitUpdateDto) error {
 	var sl model.SpeedLimit
 	if err := dbpkg.DB.First(&sl, req.ID).Error; err != nil {
-		c.JSON(http.StatusOK, response.ErrMsg("限速规则不存在"))
-		return
+		return errors.New("限速规则不存在")
 	}
 	var t model.Tunnel
 	if err := dbpkg.DB.First(&t, req.TunnelID).Error; err != nil {
-		c.JSON(http.StatusOK, response.ErrMsg("隧道不存在"))
-		return
+		return errors.New("隧道不存在")
 	}
 	sl.Name, sl.Speed, sl.TunnelID, sl.TunnelName = req.Name, req.Speed, req.TunnelID, req.TunnelName
 	sl.UpdatedTime = time.Now().UnixMilli()
 	if err := dbpkg.DB.Save(&sl).Error; err != nil {
-		c.JSON(http.StatusOK, response.ErrMsg("限速规则更新失败"))
-		return
+		return errors.New("限速规则更新失败")
 	}
 	applyLimiterForTunnel(sl.TunnelID)
-	// refresh anytls per-user speed rules for nodes referencing this speed id
+	// refresh per-user exit speed rules for nodes referencing this speed id
 	var nodeIDs []int64
 	dbpkg.DB.Model(&model.UserNode{}).Distinct("node_id").Where("speed_id = ?", sl.ID).Pluck("node_id", &nodeIDs)
 	for _, nodeID := range nodeIDs {
-		go pushAnyTLSConfigToNode(nodeID)
+		go pushExitUsersToNode(nodeID)
 	}
-	c.JSON(http.StatusOK, response.OkMsg("限速规则更新成功"))
+	return nil
 }
 
 // POST /api/v1/speed-limit/delete
@@ -84,17 +100,24 @@ func SpeedLimitDelete(c *gin.Context) {
 		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
 		return
 	}
+	if err := deleteSpeedLimit(p.ID); err != nil {
+		replyErr(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, response.OkMsg("限速规则删除成功"))
+}
+
+// deleteSpeedLimit removes a speed limit rule no user assignment refers to.
+func deleteSpeedLimit(id int64) error {
 	var cnt int64
-	dbpkg.DB.Model(&model.UserTunnel{}).Where("speed_id = ?", p.ID).Count(&cnt)
+	dbpkg.DB.Model(&model.UserTunnel{}).Where("speed_id = ?", id).Count(&cnt)
 	if cnt > 0 {
-		c.JSON(http.StatusOK, response.ErrMsg("该限速规则还有用户在使用 请先取消分配"))
-		return
+		return errors.New("该限速规则还有用户在使用 请先取消分配")
 	}
-	if err := dbpkg.DB.Delete(&model.SpeedLimit{}, p.ID).Error; err != nil {
-		c.JSON(http.StatusOK, response.ErrMsg("限速规则删除失败"))
-		return
+	if err := dbpkg.DB.Delete(&model.SpeedLimit{}, id).Error; err != nil {
+		return errors.New("限速规则删除失败")
 	}
-	c.JSON(http.StatusOK, response.OkMsg("限速规则删除成功"))
+	return nil
 }
 
 // POST /api/v1/speed-limit/tunnels
@@ -0,0 +1,690 @@
+package controller
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"network-panel/golang-backend/internal/app/model"
+	"network-panel/golang-backend/internal/app/response"
+	dbpkg "network-panel/golang-backend/internal/db"
+)
+
+// Public status page.
+//
+// Admins choose which nodes/tunnels are published (status_page_item) and post
+// notices (status_notice). Uptime is derived from node_disconnect_log; a
+// tunnel is down while any node on its path is down. Downtime inside a
+// maintenance notice covering the item does not count against uptime.
+// Public responses only carry status item ids and display names.
+//
+// The page is off until an admin enables it. Public requests share one
+// snapshot for statusCacheTTL so unauthenticated traffic cannot drive the
+// underlying queries; admin edits drop the snapshot.
+
+const (
+	statusMaxDays  = 90
+	statusCacheTTL = 10 * time.Second
+)
+
+var statusCache struct {
+	mu   sync.Mutex
+	at   time.Time
+	data *statusPageData
+}
+
+var statusUptimeWindows = []struct {
+	key string
+	ms  int64
+}{
+	{"24h", 24 * 3600 * 1000},
+	{"7d", 7 * 24 * 3600 * 1000},
+	{"30d", 30 * 24 * 3600 * 1000},
+	{"90d", 90 * 24 * 3600 * 1000},
+}
+
+type spInterval struct{ s, e int64 }
+
+func mergeIntervals(in []spInterval) []spInterval {
+	if len(in) == 0 {
+		return nil
+	}
+	list := append([]spInterval(nil), in...)
+	sort.Slice(list, func(i, j int) bool { return list[i].s < list[j].s })
+	out := []spInterval{list[0]}
+	for _, iv := range list[1:] {
+		last := &out[len(out)-1]
+		if iv.s <= last.e {
+			last.e = max64(last.e, iv.e)
+			continue
+		}
+		out = append(out, iv)
+	}
+	return out
+}
+
+// subtractIntervals removes b from a; both must be merged.
+func subtractIntervals(a, b []spInterval) []spInterval {
+	out := make([]spInterval, 0, len(a))
+	for _, iv := range a {
+		cur := iv.s
+		for _, cut := range b {
+			if cut.e <= cur || cut.s >= iv.e {
+				continue
+			}
+			if cut.s > cur {
+				out = append(out, spInterval{cur, cut.s})
+			}
+			cur = max64(cur, cut.e)
+		}
+		if cur < iv.e {
+			out = append(out, spInterval{cur, iv.e})
+		}
+	}
+	return out
+}
+
+func sumIntervals(list []spInterval, from, to int64) int64 {
+	var total int64
+	for _, iv := range list {
+		if s, e := max64(iv.s, from), min64(iv.e, to); e > s {
+			total += e - s
+		}
+	}
+	return total
+}
+
+func uptimePercent(down, window int64) float64 {
+	if window <= 0 {
+		return 100
+	}
+	v := float64(window-down) / float64(window) * 100
+	return math.Round(v*1000) / 1000
+}
+
+type statusItemState struct {
+	item   model.StatusPageItem
+	nodes  []int64
+	online bool
+	outage []spInterval // raw outages
+	down   []spInterval // outages minus maintenance
+	maint  bool         // under maintenance now
+}
+
+type statusPageData struct {
+	now     int64
+	from    int64
+	items   []*statusItemState
+	notices []model.StatusNotice
+}
+
+func noticeItemIDs(n model.StatusNotice) []int64 {
+	if n.ItemIDs == nil || strings.TrimSpace(*n.ItemIDs) == "" {
+		return nil
+	}
+	var ids []int64
+	_ = json.Unmarshal([]byte(*n.ItemIDs), &ids)
+	return ids
+}
+
+func noticeCovers(n model.StatusNotice, itemID int64) bool {
+	ids := noticeItemIDs(n)
+	if len(ids) == 0 {
+		return true
+	}
+	for _, id := range ids {
+		if id == itemID {
+			return true
+		}
+	}
+	return false
+}
+
+// statusItemNodes resolves the nodes an item depends on; ok=false when the
+// referenced node/tunnel no longer exists.
+func statusItemNodes(it model.StatusPageItem) ([]int64, string, bool) {
+	switch it.Kind {
+	case "node":
+		var n model.Node
+		if err := dbpkg.DB.Select("id, name").First(&n, it.RefID).Error; err != nil {
+			return nil, "", false
+		}
+		return []int64{n.ID}, n.Name, true
+	case "tunnel":
+		var t model.Tunnel
+		if err := dbpkg.DB.First(&t, it.RefID).Error; err != nil {
+			return nil, "", false
+		}
+		ids := []int64{t.InNodeID}
+		ids = append(ids, getTunnelPathNodes(t.ID)...)
+		if t.OutNodeID != nil && *t.OutNodeID > 0 {
+			ids = append(ids, *t.OutNodeID)
+		}
+		return ids, t.Name, true
+	}
+	return nil, "", false
+}
+
+// cachedStatusPage returns the shared snapshot, rebuilding it when stale.
+func cachedStatusPage() *statusPageData {
+	statusCache.mu.Lock()
+	defer statusCache.mu.Unlock()
+	if statusCache.data == nil || time.Since(statusCache.at) > statusCacheTTL {
+		statusCache.data, statusCache.at = loadStatusPage(), time.Now()
+	}
+	return statusCache.data
+}
+
+func invalidateStatusPage() {
+	statusCache.mu.Lock()
+	statusCache.data = nil
+	statusCache.mu.Unlock()
+}
+
+func loadStatusPage() *statusPageData {
+	now := time.Now().UnixMilli()
+	d := &statusPageData{now: now, from: now - statusMaxDays*24*3600*1000}
+	var items []model.StatusPageItem
+	dbpkg.DB.Order("group_name asc, sort_order asc, id asc").Find(&items)
+	dbpkg.DB.Where("end_ms = 0 OR end_ms >= ?", d.from).Order("start_ms desc").Find(&d.notices)
+
+	nodeSet := map[int64]struct{}{}
+	for _, it := range items {
+		ids, _, ok := statusItemNodes(it)
+		if !ok {
+			continue
+		}
+		d.items = append(d.items, &statusItemState{item: it, nodes: ids})
+		for _, id := range ids {
+			nodeSet[id] = struct{}{}
+		}
+	}
+	if len(nodeSet) == 0 {
+		return d
+	}
+	ids := make([]int64, 0, len(nodeSet))
+	for id := range nodeSet {
+		ids = append(ids, id)
+	}
+	online := map[int64]bool{}
+	var nodes []model.Node
+	dbpkg.DB.Select("id, status").Where("id IN ?", ids).Find(&nodes)
+	for _, n := range nodes {
+		online[n.ID] = n.Status != nil && *n.Status == 1
+	}
+	var logs []model.NodeDisconnectLog
+	dbpkg.DB.Where("node_id IN ? AND (up_at_ms IS NULL OR up_at_ms >= ?)", ids, d.from).Find(&logs)
+	down := map[int64][]spInterval{}
+	for _, l := range logs {
+		e := now
+		if l.UpAtMs != nil {
+			e = *l.UpAtMs
+		}
+		if e > l.DownAtMs {
+			down[l.NodeID] = append(down[l.NodeID], spInterval{max64(l.DownAtMs, d.from), e})
+		}
+	}
+
+	for _, st := range d.items {
+		st.online = true
+		var raw []spInterval
+		for _, nid := range st.nodes {
+			st.online = st.online && online[nid]
+			raw = append(raw, down[nid]...)
+		}
+		st.outage = mergeIntervals(raw)
+		var maint []spInterval
+		for _, n := range d.notices {
+			if n.Type != "maintenance" || !noticeCovers(n, st.item.ID) || n.StartMs > now {
+				continue
+			}
+			e := n.EndMs
+			if e == 0 || e > now {
+				e = now
+				st.maint = true
+			}
+			maint = append(maint, spInterval{n.StartMs, e})
+		}
+		st.down = subtractIntervals(st.outage, mergeIntervals(maint))
+	}
+	return d
+}
+
+func (st *statusItemState) status() string {
+	switch {
+	case st.online:
+		return "up"
+	case st.maint:
+		return "maintenance"
+	default:
+		return "down"
+	}
+}
+
+func (st *statusItemState) publicView(now int64) map[string]any {
+	uptime := map[string]float64{}
+	for _, w := range statusUptimeWindows {
+		uptime[w.key] = uptimePercent(sumIntervals(st.down, now-w.ms, now), w.ms)
+	}
+	return map[string]any{
+		"id": st.item.ID, "kind": st.item.Kind, "name": st.item.DisplayName, "description": st.item.Description,
+		"group": st.item.GroupName, "status": st.status(), "uptime": uptime,
+	}
+}
+
+func (d *statusPageData) itemByID(id int64) *statusItemState {
+	for _, st := range d.items {
+		if st.item.ID == id {
+			return st
+		}
+	}
+	return nil
+}
+
+func (d *statusPageData) noticeView(n model.StatusNotice) map[string]any {
+	ids := noticeItemIDs(n)
+	items := make([]map[string]any, 0, len(ids))
+	for _, id := range ids {
+		if st := d.itemByID(id); st != nil {
+			items = append(items, map[string]any{"id": id, "name": st.item.DisplayName})
+		}
+	}
+	return map[string]any{
+		"id": n.ID, "type": n.Type, "title": n.Title, "content": n.Content, "startMs": n.StartMs, "endMs": n.EndMs,
+		"active": n.StartMs <= d.now && (n.EndMs == 0 || n.EndMs > d.now), "allItems": len(ids) == 0, "items": items,
+	}
+}
+
+func statusPageEnabled(c *gin.Context) bool {
+	if getCfg("status_page_enabled") != "1" {
+		c.JSON(http.StatusOK, response.ErrMsg("状态页未开启"))
+		return false
+	}
+	return true
+}
+
+func statusDays(days int) int {
+	if days <= 0 || days > statusMaxDays {
+		return statusMaxDays
+	}
+	return days
+}
+
+// StatusPage 公开状态页概览
+// @Summary 公开状态页：展示对象、可用率与当前/计划公告
+// @Tags status
+// @Produce json
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/status/page [post]
+func StatusPage(c *gin.Context) {
+	if !statusPageEnabled(c) {
+		return
+	}
+	d := cachedStatusPage()
+	items := make([]map[string]any, 0, len(d.items))
+	up, down, maint := 0, 0, 0
+	for _, st := range d.items {
+		items = append(items, st.publicView(d.now))
+		switch st.status() {
+		case "up":
+			up++
+		case "down":
+			down++
+		default:
+			maint++
+		}
+	}
+	overall := "operational"
+	switch {
+	case down > 0 && up == 0 && maint == 0:
+		overall = "major_outage"
+	case down > 0:
+		overall = "partial_outage"
+	case maint > 0:
+		overall = "maintenance"
+	}
+	notices := make([]map[string]any, 0)
+	for _, n := range d.notices {
+		if n.EndMs == 0 || n.EndMs > d.now {
+			notices = append(notices, d.noticeView(n))
+		}
+	}
+	c.JSON(http.StatusOK, response.Ok(map[string]any{
+		"title": getCfg("status_page_title"), "description": getCfg("status_page_description"),
+		"status": overall, "items": items, "notices": notices, "updatedMs": d.now,
+	}))
+}
+
+// StatusItem 单个展示对象的每日可用率与故障记录
+// @Summary 公开状态页：对象详情
+// @Tags status
+// @Accept json
+// @Produce json
+// @Param data body object true "{id, days?: 1-90}"
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/status/item [post]
+func StatusItem(c *gin.Context) {
+	if !statusPageEnabled(c) {
+		return
+	}
+	var p struct {
+		ID   int64 `json:"id" binding:"required"`
+		Days int   `json:"days"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	d := cachedStatusPage()
+	st := d.itemByID(p.ID)
+	if st == nil {
+		c.JSON(http.StatusOK, response.ErrMsg("对象不存在"))
+		return
+	}
+	days := statusDays(p.Days)
+	const dayMs = 24 * 3600 * 1000
+	cst := time.FixedZone("CST", 8*3600)
+	today := (d.now+cstOffsetMs)/dayMs*dayMs - cstOffsetMs
+	bars := make([]map[string]any, 0, days)
+	for i := days - 1; i >= 0; i-- {
+		s := today - int64(i)*dayMs
+		e := min64(s+dayMs, d.now)
+		dm := sumIntervals(st.down, s, e)
+		bars = append(bars, map[string]any{
+			"date": time.UnixMilli(s).In(cst).Format("2006-01-02"), "startMs": s,
+			"uptime": uptimePercent(dm, e-s), "downMs": dm,
+		})
+	}
+	from := today - int64(days-1)*dayMs
+	incidents := make([]map[string]any, 0)
+	for i := len(st.outage) - 1; i >= 0; i-- {
+		iv := st.outage[i]
+		if iv.e < from {
+			break
+		}
+		incidents = append(incidents, statusOutageView(st, iv, d.now))
+	}
+	view := st.publicView(d.now)
+	view["days"] = bars
+	view["incidents"] = incidents
+	c.JSON(http.StatusOK, response.Ok(view))
+}
+
+func statusOutageView(st *statusItemState, iv spInterval, now int64) map[string]any {
+	ongoing := iv.e >= now && !st.online
+	m := map[string]any{
+		"type": "outage", "itemId": st.item.ID, "name": st.item.DisplayName, "startMs": iv.s,
+		"durationS": (iv.e - iv.s) / 1000, "ongoing": ongoing,
+		// fully inside a maintenance window, not counted against uptime
+		"inMaintenance": sumIntervals(st.down, iv.s, iv.e) == 0,
+	}
+	if !ongoing {
+		m["endMs"] = iv.e
+	}
+	return m
+}
+
+// StatusIncidents 故障与公告时间线
+// @Summary 公开状态页：时间线（故障 + 公告，按开始时间倒序）
+// @Tags status
+// @Accept json
+// @Produce json
+// @Param data body object false "{days?: 1-90, 默认 30}"
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/status/incidents [post]
+func StatusIncidents(c *gin.Context) {
+	if !statusPageEnabled(c) {
+		return
+	}
+	var p struct {
+		Days int `json:"days"`
+	}
+	_ = c.ShouldBindJSON(&p)
+	if p.Days <= 0 {
+		p.Days = 30
+	}
+	d := cachedStatusPage()
+	from := d.now - int64(statusDays(p.Days))*24*3600*1000
+	events := make([]map[string]any, 0)
+	for _, st := range d.items {
+		for _, iv := range st.outage {
+			if iv.e >= from {
+				events = append(events, statusOutageView(st, iv, d.now))
+			}
+		}
+	}
+	for _, n := range d.notices {
+		if n.StartMs <= d.now && (n.EndMs == 0 || n.EndMs >= from) {
+			events = append(events, d.noticeView(n))
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i]["startMs"].(int64) > events[j]["startMs"].(int64) })
+	if len(events) > 200 {
+		events = events[:200]
+	}
+	c.JSON(http.StatusOK, response.Ok(events))
+}
+
+// ---- admin ----
+
+// StatusItemList 状态页展示对象列表（管理员）
+// @Summary 状态页对象列表
+// @Tags status
+// @Produce json
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/status/admin/items [post]
+func StatusItemList(c *gin.Context) {
+	var items []model.StatusPageItem
+	dbpkg.DB.Order("group_name asc, sort_order asc, id asc").Find(&items)
+	out := make([]map[string]any, 0, len(items))
+	for _, it := range items {
+		_, name, ok := statusItemNodes(it)
+		out = append(out, map[string]any{"item": it, "refName": name, "exists": ok})
+	}
+	c.JSON(http.StatusOK, response.Ok(out))
+}
+
+// StatusItemSave 新增/更新状态页展示对象（管理员）
+// @Summary 保存状态页对象
+// @Tags status
+// @Accept json
+// @Produce json
+// @Param data body object true "{id?, kind: node|tunnel, refId, displayName, description?, group?, sortOrder?}"
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/status/admin/items/save [post]
+func StatusItemSave(c *gin.Context) {
+	var p struct {
+		ID          int64  `json:"id"`
+		Kind        string `json:"kind" binding:"required"`
+		RefID       int64  `json:"refId" binding:"required"`
+		DisplayName string `json:"displayName"`
+		Description string `json:"description"`
+		Group       string `json:"group"`
+		SortOrder   int    `json:"sortOrder"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil || (p.Kind != "node" && p.Kind != "tunnel") {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	p.DisplayName = strings.TrimSpace(p.DisplayName)
+	if p.DisplayName == "" {
+		c.JSON(http.StatusOK, response.ErrMsg("请填写展示名称"))
+		return
+	}
+	it := model.StatusPageItem{Kind: p.Kind, RefID: p.RefID}
+	if _, _, ok := statusItemNodes(it); !ok {
+		c.JSON(http.StatusOK, response.ErrMsg(ifThen(p.Kind == "node", "节点不存在", "隧道不存在")))
+		return
+	}
+	var dup int64
+	dbpkg.DB.Model(&model.StatusPageItem{}).Where("kind = ? AND ref_id = ? AND id <> ?", p.Kind, p.RefID, p.ID).Count(&dup)
+	if dup > 0 {
+		c.JSON(http.StatusOK, response.ErrMsg("该对象已在状态页中"))
+		return
+	}
+	now := time.Now().UnixMilli()
+	if p.ID > 0 {
+		if err := dbpkg.DB.First(&it, p.ID).Error; err != nil {
+			c.JSON(http.StatusOK, response.ErrMsg("对象不存在"))
+			return
+		}
+	} else {
+		it.CreatedTime = now
+	}
+	it.Kind, it.RefID, it.DisplayName = p.Kind, p.RefID, p.DisplayName
+	it.Description, it.GroupName, it.SortOrder = strings.TrimSpace(p.Description), strings.TrimSpace(p.Group), p.SortOrder
+	it.UpdatedTime = now
+	if err := dbpkg.DB.Save(&it).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("保存失败"))
+		return
+	}
+	invalidateStatusPage()
+	c.JSON(http.StatusOK, response.Ok(it))
+}
+
+// StatusItemDelete 移除状态页展示对象（管理员）
+// @Summary 删除状态页对象
+// @Tags status
+// @Accept json
+// @Produce json
+// @Param data body object true "{id}"
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/status/admin/items/delete [post]
+func StatusItemDelete(c *gin.Context) {
+	var p struct {
+		ID int64 `json:"id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	_ = dbpkg.DB.Delete(&model.StatusPageItem{}, p.ID).Error
+	invalidateStatusPage()
+	c.JSON(http.StatusOK, response.OkNoData())
+}
+
+// StatusNoticeList 公告列表（管理员）
+// @Summary 状态页公告列表
+// @Tags status
+// @Produce json
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/status/admin/notices [post]
+func StatusNoticeList(c *gin.Context) {
+	var list []model.StatusNotice
+	dbpkg.DB.Order("start_ms desc").Limit(100).Find(&list)
+	c.JSON(http.StatusOK, response.Ok(list))
+}
+
+// StatusNoticeSave 发布/编辑公告（管理员）
+// @Summary 保存状态页公告
+// @Tags status
+// @Accept json
+// @Produce json
+// @Param data body object true "{id?, type: maintenance|incident|info, title, content, itemIds?: [], startMs?, endMs?}"
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/status/admin/notices/save [post]
+func StatusNoticeSave(c *gin.Context) {
+	var p struct {
+		ID      int64   `json:"id"`
+		Type    string  `json:"type" binding:"required"`
+		Title   string  `json:"title" binding:"required"`
+		Content string  `json:"content"`
+		ItemIDs []int64 `json:"itemIds"`
+		StartMs int64   `json:"startMs"`
+		EndMs   int64   `json:"endMs"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	if p.Type != "maintenance" && p.Type != "incident" && p.Type != "info" {
+		c.JSON(http.StatusOK, response.ErrMsg("公告类型仅支持 maintenance/incident/info"))
+		return
+	}
+	now := time.Now().UnixMilli()
+	if p.StartMs <= 0 {
+		p.StartMs = now
+	}
+	if p.EndMs != 0 && p.EndMs <= p.StartMs {
+		c.JSON(http.StatusOK, response.ErrMsg("结束时间必须晚于开始时间"))
+		return
+	}
+	n := model.StatusNotice{CreatedTime: now}
+	if p.ID > 0 {
+		if err := dbpkg.DB.First(&n, p.ID).Error; err != nil {
+			c.JSON(http.StatusOK, response.ErrMsg("公告不存在"))
+			return
+		}
+	} else {
+		n.CreatedBy = c.GetInt64("user_id")
+	}
+	n.Type, n.Title, n.Content = p.Type, strings.TrimSpace(p.Title), p.Content
+	n.StartMs, n.EndMs, n.UpdatedTime = p.StartMs, p.EndMs, now
+	n.ItemIDs = nil
+	if len(p.ItemIDs) > 0 {
+		b, _ := json.Marshal(p.ItemIDs)
+		s := string(b)
+		n.ItemIDs = &s
+	}
+	if err := dbpkg.DB.Save(&n).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("保存失败"))
+		return
+	}
+	invalidateStatusPage()
+	c.JSON(http.StatusOK, response.Ok(n))
+}
+
+// StatusNoticeDelete 删除公告（管理员）
+// @Summary 删除状态页公告
+// @Tags status
+// @Accept json
+// @Produce json
+// @Param data body object true "{id}"
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/status/admin/notices/delete [post]
+func StatusNoticeDelete(c *gin.Context) {
+	var p struct {
+		ID int64 `json:"id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	_ = dbpkg.DB.Delete(&model.StatusNotice{}, p.ID).Error
+	invalidateStatusPage()
+	c.JSON(http.StatusOK, response.OkNoData())
+}
+
+// StatusPageSettings 状态页开关与标题（管理员，不带参数时返回当前设置）
+// @Summary 状态页设置
+// @Tags status
+// @Accept json
+// @Produce json
+// @Param data body object false "{enabled?, title?, description?}"
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/status/admin/settings [post]
+func StatusPageSettings(c *gin.Context) {
+	var p struct {
+		Enabled     *bool   `json:"enabled"`
+		Title       *string `json:"title"`
+		Description *string `json:"description"`
+	}
+	_ = c.ShouldBindJSON(&p)
+	if p.Enabled != nil {
+		setCfg("status_page_enabled", ifThen(*p.Enabled, "1", "0"))
+	}
+	if p.Title != nil {
+		setCfg("status_page_title", strings.TrimSpace(*p.Title))
+	}
+	if p.Description != nil {
+		setCfg("status_page_description", strings.TrimSpace(*p.Description))
+	}
+	c.JSON(http.StatusOK, response.Ok(map[string]any{
+		"enabled": getCfg("status_page_enabled") == "1", "title": getCfg("status_page_title"), "description": getCfg("status_page_description"),
+	}))
+}
@@ -0,0 +1,30 @@
+package controller
+
+import (
+	"testing"
+)
+
+func TestStatusPageOptIn(t *testing.T) {
+	useTestDB(t)
+	setCfg("status_page_enabled", "")
+	if out := callHandler(StatusPage, ""); out["code"] == float64(0) {
+		t.Fatalf("status page served before being enabled: %v", out)
+	}
+	callHandler(StatusPageSettings, `{"enabled":true}`)
+	if out := callHandler(StatusPage, ""); out["code"] != float64(0) {
+		t.Fatalf("enabled status page not served: %v", out)
+	}
+}
+
+func TestStatusPageCache(t *testing.T) {
+	useTestDB(t)
+	invalidateStatusPage()
+	a := cachedStatusPage()
+	if b := cachedStatusPage(); a != b {
+		t.Fatal("snapshot rebuilt within the cache window")
+	}
+	invalidateStatusPage()
+	if b := cachedStatusPage(); a == b {
+		t.Fatal("snapshot kept after invalidation")
+	}
+}
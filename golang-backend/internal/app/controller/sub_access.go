@@ -0,0 +1,311 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"network-panel/golang-backend/internal/app/model"
+	"network-panel/golang-backend/internal/app/response"
+	dbpkg "network-panel/golang-backend/internal/db"
+)
+
+// Subscription access log and anomaly detection.
+//
+// Every successful fetch of /subscription/* and /open_api/sub_store is logged
+// with the token, format, User-Agent, client IP and its GeoIP country. When a
+// token is fetched from more than maxIpsPerDay distinct IPs within one day
+// (UTC+8), an alert is raised once per token and day; optionally the token is
+// reset and the user is told through their reminder contacts.
+
+type subAccessSettingsView struct {
+	Enabled        bool   `json:"enabled"`
+	AnomalyEnabled bool   `json:"anomalyEnabled"`
+	MaxIPsPerDay   int    `json:"maxIpsPerDay"`
+	AutoRotate     bool   `json:"autoRotate"`
+	NotifyUser     bool   `json:"notifyUser"`
+	RetentionDays  int    `json:"retentionDays"`
+	GeoIPPath      string `json:"geoipPath"`
+	GeoIPLoaded    bool   `json:"geoipLoaded"`
+}
+
+func subAccessSettings() subAccessSettingsView {
+	return subAccessSettingsView{
+		Enabled:        getCfg("sub_access_log") != "0",
+		AnomalyEnabled: getCfg("sub_anomaly_enabled") != "0",
+		MaxIPsPerDay:   getCfgInt("sub_anomaly_max_ips", 10),
+		AutoRotate:     getCfg("sub_anomaly_auto_rotate") == "1",
+		NotifyUser:     getCfg("sub_anomaly_notify_user") != "0",
+		RetentionDays:  getCfgInt("sub_access_retention_days", 30),
+	}
+}
+
+// SubAccessCutoff is the time before which access logs are pruned.
+func SubAccessCutoff() int64 {
+	return time.Now().Add(-time.Duration(getCfgInt("sub_access_retention_days", 30)) * 24 * time.Hour).UnixMilli()
+}
+
+// subAccessDay returns the UTC+8 date of ms and the start of that day.
+func subAccessDay(ms int64) (string, int64) {
+	t := time.UnixMilli(ms).In(time.FixedZone("UTC+8", 8*3600))
+	start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	return start.Format("2006-01-02"), start.UnixMilli()
+}
+
+// recordSubAccess logs a fetch and runs the anomaly check in the background.
+func recordSubAccess(c *gin.Context, user model.User, tok *model.SubToken, format string) {
+	st := subAccessSettings()
+	if !st.Enabled {
+		return
+	}
+	rec := model.SubAccessLog{
+		UserID:    user.ID,
+		Format:    format,
+		UserAgent: c.GetHeader("User-Agent"),
+		IP:        strings.TrimSpace(c.ClientIP()),
+		TimeMs:    time.Now().UnixMilli(),
+	}
+	if tok != nil {
+		rec.TokenID = tok.ID
+	}
+	if r := []rune(rec.UserAgent); len(r) > 255 {
+		rec.UserAgent = string(r[:255])
+	}
+	go func() {
+		rec.Country = geoipCountry(rec.IP)
+		if err := dbpkg.DB.Create(&rec).Error; err != nil {
+			return
+		}
+		if st.AnomalyEnabled {
+			checkSubAnomaly(user, rec, st)
+		}
+	}()
+}
+
+// subFormat names the subscription format served by the current route.
+func subFormat(c *gin.Context) string {
+	if p := c.FullPath(); p != "" {
+		return path.Base(p)
+	}
+	return path.Base(c.Request.URL.Path)
+}
+
+func checkSubAnomaly(user model.User, rec model.SubAccessLog, st subAccessSettingsView) {
+	day, start := subAccessDay(rec.TimeMs)
+	var ips int64
+	dbpkg.DB.Model(&model.SubAccessLog{}).
+		Where("user_id = ? AND token_id = ? AND time_ms >= ?", rec.UserID, rec.TokenID, start).
+		Distinct("ip").Count(&ips)
+	if ips <= int64(st.MaxIPsPerDay) {
+		return
+	}
+	// the unique index of user_notify_sent makes this once per token and day
+	if !markUserNotified(rec.UserID, "sub_ips", rec.TokenID, st.MaxIPsPerDay, day) {
+		return
+	}
+	label := "登录令牌"
+	var tok model.SubToken
+	if rec.TokenID > 0 && dbpkg.DB.First(&tok, rec.TokenID).Error == nil {
+		label = "订阅令牌 " + tok.Label
+	}
+	rotated := false
+	if st.AutoRotate && tok.ID > 0 {
+		now := time.Now().UnixMilli()
+		issueSubToken(&tok)
+		rotated = dbpkg.DB.Model(&model.SubToken{}).Where("id = ?", tok.ID).
+			Updates(map[string]any{"token": tok.Token, "hint": tok.Hint, "last_used_time": 0, "updated_time": now}).Error == nil
+	}
+	msg := fmt.Sprintf("%s 今日已被 %d 个不同 IP 拉取（上限 %d），最近来源 %s", label, ips, st.MaxIPsPerDay, rec.IP)
+	if rec.Country != "" {
+		msg += "（" + rec.Country + "）"
+	}
+	if rotated {
+		msg += "，令牌已自动停用，请在面板重置该令牌获取新的订阅链接"
+	}
+	jlog(map[string]any{"event": "sub_anomaly", "userId": rec.UserID, "tokenId": rec.TokenID, "ips": ips, "limit": st.MaxIPsPerDay, "rotated": rotated})
+	enqueueAlert(model.Alert{TimeMs: rec.TimeMs, Type: "sub_anomaly", Message: "用户 " + user.User + " " + msg})
+	if st.NotifyUser {
+		notifyUserContacts(user, buildUserNotifyMessage("sub_anomaly", user, label, map[string]any{
+			"tokenId": rec.TokenID, "ips": ips, "limit": st.MaxIPsPerDay, "lastIp": rec.IP, "country": rec.Country,
+			"rotated": rotated, "message": msg,
+		}))
+	}
+}
+
+// SubAccessList 订阅访问记录（登录用户仅本人；管理员可按用户筛选或查看全部）
+// @Summary 订阅访问记录
+// @Tags user
+// @Accept json
+// @Produce json
+// @Param data body object false "{userId?, tokenId?, range?(1h/12h/1d/7d/30d), limit?}"
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/user/sub-access/list [post]
+func SubAccessList(c *gin.Context) {
+	var p struct {
+		UserID  int64  `json:"userId"`
+		TokenID int64  `json:"tokenId"`
+		Range   string `json:"range"`
+		Limit   int    `json:"limit"`
+	}
+	_ = c.ShouldBindJSON(&p)
+	if p.Limit <= 0 || p.Limit > 1000 {
+		p.Limit = 200
+	}
+	if p.Range == "" {
+		p.Range = "7d"
+	}
+	from := time.Now().UnixMilli() - rangeWindowMs(p.Range)
+	q := dbpkg.DB.Model(&model.SubAccessLog{}).Where("time_ms >= ?", from)
+	if c.GetInt("role_id") != 0 {
+		q = q.Where("user_id = ?", c.GetInt64("user_id"))
+	} else if p.UserID > 0 {
+		q = q.Where("user_id = ?", p.UserID)
+	}
+	if p.TokenID > 0 {
+		q = q.Where("token_id = ?", p.TokenID)
+	}
+	q = q.Session(&gorm.Session{})
+	var rows []model.SubAccessLog
+	q.Order("time_ms desc").Limit(p.Limit).Find(&rows)
+	// the daily summary covers the whole range, not only the listed rows
+	var all []model.SubAccessLog
+	q.Select("user_id, token_id, ip, time_ms").Limit(50000).Find(&all)
+
+	var userIDs, tokenIDs []int64
+	seenUser, seenToken := map[int64]bool{}, map[int64]bool{}
+	for _, r := range rows {
+		if !seenUser[r.UserID] {
+			seenUser[r.UserID] = true
+			userIDs = append(userIDs, r.UserID)
+		}
+		if !seenToken[r.TokenID] {
+			seenToken[r.TokenID] = true
+			tokenIDs = append(tokenIDs, r.TokenID)
+		}
+	}
+	names := map[int64]string{}
+	if len(userIDs) > 0 {
+		var users []model.User
+		dbpkg.DB.Select("id, user").Where("id in ?", userIDs).Find(&users)
+		for _, u := range users {
+			names[u.ID] = u.User
+		}
+	}
+	labels := map[int64]string{0: "登录令牌"}
+	if len(tokenIDs) > 0 {
+		var toks []model.SubToken
+		dbpkg.DB.Select("id, label").Where("id in ?", tokenIDs).Find(&toks)
+		for _, t := range toks {
+			labels[t.ID] = t.Label
+		}
+	}
+
+	// per token and day: fetches and distinct IPs
+	type daily struct {
+		UserID  int64  `json:"userId"`
+		TokenID int64  `json:"tokenId"`
+		Day     string `json:"day"`
+		Fetches int    `json:"fetches"`
+		IPs     int    `json:"ips"`
+		Anomaly bool   `json:"anomaly"`
+		ipSet   map[string]bool
+	}
+	st := subAccessSettings()
+	days := map[string]*daily{}
+	items := make([]map[string]any, 0, len(rows))
+	for _, r := range rows {
+		items = append(items, map[string]any{
+			"id": r.ID, "userId": r.UserID, "user": names[r.UserID], "tokenId": r.TokenID, "tokenLabel": labels[r.TokenID],
+			"format": r.Format, "userAgent": r.UserAgent, "ip": r.IP, "country": r.Country, "timeMs": r.TimeMs,
+		})
+	}
+	for _, r := range all {
+		day, _ := subAccessDay(r.TimeMs)
+		k := fmt.Sprintf("%d:%d:%s", r.UserID, r.TokenID, day)
+		d := days[k]
+		if d == nil {
+			d = &daily{UserID: r.UserID, TokenID: r.TokenID, Day: day, ipSet: map[string]bool{}}
+			days[k] = d
+		}
+		d.Fetches++
+		d.ipSet[r.IP] = true
+	}
+	summary := make([]*daily, 0, len(days))
+	for _, d := range days {
+		d.IPs = len(d.ipSet)
+		d.Anomaly = st.AnomalyEnabled && d.IPs > st.MaxIPsPerDay
+		summary = append(summary, d)
+	}
+	sort.Slice(summary, func(i, j int) bool {
+		if summary[i].Day != summary[j].Day {
+			return summary[i].Day > summary[j].Day
+		}
+		if summary[i].UserID != summary[j].UserID {
+			return summary[i].UserID < summary[j].UserID
+		}
+		return summary[i].TokenID < summary[j].TokenID
+	})
+	c.JSON(http.StatusOK, response.Ok(map[string]any{"items": items, "daily": summary, "maxIpsPerDay": st.MaxIPsPerDay}))
+}
+
+// SubAccessSettingsAPI 订阅访问记录与异常检测设置（管理员，不带参数时仅返回当前设置）
+// @Summary 订阅访问记录与异常检测设置
+// @Tags user
+// @Accept json
+// @Produce json
+// @Param data body object false "{enabled?, anomalyEnabled?, maxIpsPerDay?, autoRotate?, notifyUser?, retentionDays?, geoipPath?}"
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/user/sub-access/settings [post]
+func SubAccessSettingsAPI(c *gin.Context) {
+	var p struct {
+		Enabled        *bool   `json:"enabled"`
+		AnomalyEnabled *bool   `json:"anomalyEnabled"`
+		MaxIPsPerDay   *int    `json:"maxIpsPerDay"`
+		AutoRotate     *bool   `json:"autoRotate"`
+		NotifyUser     *bool   `json:"notifyUser"`
+		RetentionDays  *int    `json:"retentionDays"`
+		GeoIPPath      *string `json:"geoipPath"`
+	}
+	_ = c.ShouldBindJSON(&p)
+	if p.MaxIPsPerDay != nil && (*p.MaxIPsPerDay < 1 || *p.MaxIPsPerDay > 1000) {
+		c.JSON(http.StatusOK, response.ErrMsg("每日 IP 上限范围 1-1000"))
+		return
+	}
+	if p.RetentionDays != nil && (*p.RetentionDays < 1 || *p.RetentionDays > 365) {
+		c.JSON(http.StatusOK, response.ErrMsg("保留天数范围 1-365"))
+		return
+	}
+	if p.Enabled != nil {
+		setCfg("sub_access_log", ifThen(*p.Enabled, "1", "0"))
+	}
+	if p.AnomalyEnabled != nil {
+		setCfg("sub_anomaly_enabled", ifThen(*p.AnomalyEnabled, "1", "0"))
+	}
+	if p.MaxIPsPerDay != nil {
+		setCfg("sub_anomaly_max_ips", itoa(*p.MaxIPsPerDay))
+	}
+	if p.AutoRotate != nil {
+		setCfg("sub_anomaly_auto_rotate", ifThen(*p.AutoRotate, "1", "0"))
+	}
+	if p.NotifyUser != nil {
+		setCfg("sub_anomaly_notify_user", ifThen(*p.NotifyUser, "1", "0"))
+	}
+	if p.RetentionDays != nil {
+		setCfg("sub_access_retention_days", itoa(*p.RetentionDays))
+	}
+	if p.GeoIPPath != nil {
+		setCfg("geoip_db_path", strings.TrimSpace(*p.GeoIPPath))
+		geoip.mu.Lock()
+		geoip.checked = time.Time{}
+		geoip.mu.Unlock()
+	}
+	st := subAccessSettings()
+	st.GeoIPPath, st.GeoIPLoaded = geoipPath(), geoipReader() != nil
+	c.JSON(http.StatusOK, response.Ok(st))
+}
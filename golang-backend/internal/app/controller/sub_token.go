@@ -0,0 +1,310 @@
+package controller
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"network-panel/golang-backend/internal/app/model"
+	"network-panel/golang-backend/internal/app/response"
+	"network-panel/golang-backend/internal/app/util"
+	dbpkg "network-panel/golang-backend/internal/db"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Subscription tokens are random read-only credentials accepted only by the
+// /subscription/* and /open_api/sub_store routes; they are never valid JWTs,
+// so the Auth middleware rejects them everywhere else. They are stored as
+// SHA-256 hashes: the token is returned once by create, reset and the list
+// call that generates the default token, and can only be reset afterwards.
+
+const (
+	subTokenMaxPerUser   = 10
+	subTokenDefaultLabel = "默认"
+	// "0" stops accepting login JWTs on the subscription routes
+	cfgSubLoginToken = "subscription_login_token"
+	subTokenHintLen  = 6
+)
+
+// subTokenView is a token as returned right after it was issued.
+type subTokenView struct {
+	model.SubToken
+	Token string `json:"token,omitempty"`
+}
+
+func newSubToken() string {
+	b := make([]byte, 24)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func hashSubToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueSubToken stores a fresh token in st and returns it in clear.
+func issueSubToken(st *model.SubToken) string {
+	token := newSubToken()
+	st.Token = hashSubToken(token)
+	st.Hint = token[:subTokenHintLen]
+	return token
+}
+
+var subTokenHashOnce sync.Once
+
+// hashLegacySubTokens hashes the tokens saved in clear by older versions; a
+// stored hash is 64 hex characters, an issued token 48.
+func hashLegacySubTokens() {
+	var list []model.SubToken
+	dbpkg.DB.Where("LENGTH(token) <> 64").Find(&list)
+	for _, st := range list {
+		dbpkg.DB.Model(&model.SubToken{}).Where("id = ?", st.ID).
+			Updates(map[string]any{"token": hashSubToken(st.Token), "hint": st.Token[:min(len(st.Token), subTokenHintLen)]})
+	}
+}
+
+// ensureSubToken creates the default token of a user that has none yet and
+// returns it in clear.
+func ensureSubToken(userID int64) (model.SubToken, string) {
+	var st model.SubToken
+	var n int64
+	dbpkg.DB.Model(&model.SubToken{}).Where("user_id = ?", userID).Count(&n)
+	if n > 0 {
+		return st, ""
+	}
+	now := time.Now().UnixMilli()
+	st = model.SubToken{UserID: userID, Label: subTokenDefaultLabel, CreatedTime: now, UpdatedTime: now}
+	token := issueSubToken(&st)
+	if dbpkg.DB.Create(&st).Error != nil {
+		return st, ""
+	}
+	return st, token
+}
+
+// subscriptionUserActive rejects disabled and expired accounts.
+func subscriptionUserActive(user model.User) bool {
+	return !(user.Status != nil && *user.Status == 0) && !expired(user.ExpTime)
+}
+
+// subscriptionAuth resolves the caller of a subscription route from a
+// subscription token or, unless disabled, a login JWT. tok is nil for JWTs.
+func subscriptionAuth(token string) (user model.User, role int, tok *model.SubToken, ok bool) {
+	if token == "" {
+		return user, 0, nil, false
+	}
+	subTokenHashOnce.Do(hashLegacySubTokens)
+	var st model.SubToken
+	if err := dbpkg.DB.Where("token = ?", hashSubToken(token)).First(&st).Error; err == nil {
+		if dbpkg.DB.First(&user, st.UserID).Error != nil || !subscriptionUserActive(user) {
+			return user, 0, nil, false
+		}
+		if now := time.Now().UnixMilli(); now-st.LastUsedTime > 60_000 {
+			st.LastUsedTime = now
+			dbpkg.DB.Model(&model.SubToken{}).Where("id = ?", st.ID).UpdateColumn("last_used_time", now)
+		}
+		return user, user.RoleID, &st, true
+	}
+	if getConfigString(cfgSubLoginToken) == "0" || !util.ValidateToken(token) {
+		return user, 0, nil, false
+	}
+	if dbpkg.DB.First(&user, util.GetUserID(token)).Error != nil || !subscriptionUserActive(user) {
+		return user, 0, nil, false
+	}
+	return user, util.GetRoleID(token), nil, true
+}
+
+// subTokenOwner is the user whose tokens are managed: admins may pass userId.
+func subTokenOwner(c *gin.Context, userID int64) int64 {
+	if userID > 0 && c.GetInt("role_id") == 0 {
+		return userID
+	}
+	return c.GetInt64("user_id")
+}
+
+func loadSubToken(c *gin.Context, id int64) (model.SubToken, bool) {
+	var st model.SubToken
+	q := dbpkg.DB.Where("id = ?", id)
+	if c.GetInt("role_id") != 0 {
+		q = q.Where("user_id = ?", c.GetInt64("user_id"))
+	}
+	if id == 0 || q.First(&st).Error != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("订阅令牌不存在"))
+		return st, false
+	}
+	return st, true
+}
+
+func normalizeSubTokenLabel(label string) (string, string) {
+	label = strings.TrimSpace(label)
+	if label == "" {
+		label = subTokenDefaultLabel
+	}
+	if len([]rune(label)) > 32 {
+		return "", "标签过长"
+	}
+	return label, ""
+}
+
+// SubTokenList 订阅令牌列表（无令牌时自动生成默认令牌，仅此次返回令牌明文）
+// @Summary 订阅令牌列表
+// @Tags user
+// @Accept json
+// @Produce json
+// @Param data body object false "{userId?}（仅管理员可指定）"
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/user/sub-tokens/list [post]
+func SubTokenList(c *gin.Context) {
+	var p struct {
+		UserID int64 `json:"userId"`
+	}
+	_ = c.ShouldBindJSON(&p)
+	uid := subTokenOwner(c, p.UserID)
+	subTokenHashOnce.Do(hashLegacySubTokens)
+	fresh, token := ensureSubToken(uid)
+	var list []model.SubToken
+	dbpkg.DB.Where("user_id = ?", uid).Order("id asc").Find(&list)
+	out := make([]subTokenView, 0, len(list))
+	for _, st := range list {
+		v := subTokenView{SubToken: st}
+		if st.ID == fresh.ID && token != "" {
+			v.Token = token
+		}
+		out = append(out, v)
+	}
+	c.JSON(http.StatusOK, response.Ok(out))
+}
+
+// SubTokenCreate 新增订阅令牌（如按设备区分），令牌明文仅返回一次
+// @Summary 新增订阅令牌
+// @Tags user
+// @Accept json
+// @Produce json
+// @Param data body object true "{label?, userId?}"
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/user/sub-tokens/create [post]
+func SubTokenCreate(c *gin.Context) {
+	var p struct {
+		UserID int64  `json:"userId"`
+		Label  string `json:"label"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	label, msg := normalizeSubTokenLabel(p.Label)
+	if msg != "" {
+		c.JSON(http.StatusOK, response.ErrMsg(msg))
+		return
+	}
+	uid := subTokenOwner(c, p.UserID)
+	var n int64
+	dbpkg.DB.Model(&model.SubToken{}).Where("user_id = ?", uid).Count(&n)
+	if n >= subTokenMaxPerUser {
+		c.JSON(http.StatusOK, response.ErrMsg(fmt.Sprintf("最多创建 %d 个订阅令牌", subTokenMaxPerUser)))
+		return
+	}
+	now := time.Now().UnixMilli()
+	st := model.SubToken{UserID: uid, Label: label, CreatedTime: now, UpdatedTime: now}
+	token := issueSubToken(&st)
+	if err := dbpkg.DB.Create(&st).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("保存失败"))
+		return
+	}
+	c.JSON(http.StatusOK, response.Ok(subTokenView{SubToken: st, Token: token}))
+}
+
+// SubTokenUpdate 修改订阅令牌标签
+// @Summary 修改订阅令牌标签
+// @Tags user
+// @Accept json
+// @Produce json
+// @Param data body object true "{id, label}"
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/user/sub-tokens/update [post]
+func SubTokenUpdate(c *gin.Context) {
+	var p struct {
+		ID    int64  `json:"id" binding:"required"`
+		Label string `json:"label"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	st, ok := loadSubToken(c, p.ID)
+	if !ok {
+		return
+	}
+	label, msg := normalizeSubTokenLabel(p.Label)
+	if msg != "" {
+		c.JSON(http.StatusOK, response.ErrMsg(msg))
+		return
+	}
+	st.Label = label
+	st.UpdatedTime = time.Now().UnixMilli()
+	if err := dbpkg.DB.Save(&st).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("保存失败"))
+		return
+	}
+	c.JSON(http.StatusOK, response.Ok(st))
+}
+
+// SubTokenReset 重置订阅令牌，旧链接立即失效，新令牌明文仅返回一次
+// @Summary 重置订阅令牌
+// @Tags user
+// @Accept json
+// @Produce json
+// @Param data body object true "{id}"
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/user/sub-tokens/reset [post]
+func SubTokenReset(c *gin.Context) {
+	var p struct {
+		ID int64 `json:"id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	st, ok := loadSubToken(c, p.ID)
+	if !ok {
+		return
+	}
+	token := issueSubToken(&st)
+	st.LastUsedTime = 0
+	st.UpdatedTime = time.Now().UnixMilli()
+	if err := dbpkg.DB.Save(&st).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("保存失败"))
+		return
+	}
+	c.JSON(http.StatusOK, response.Ok(subTokenView{SubToken: st, Token: token}))
+}
+
+// SubTokenDelete 删除订阅令牌
+// @Summary 删除订阅令牌
+// @Tags user
+// @Accept json
+// @Produce json
+// @Param data body object true "{id}"
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/user/sub-tokens/delete [post]
+func SubTokenDelete(c *gin.Context) {
+	var p struct {
+		ID int64 `json:"id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	st, ok := loadSubToken(c, p.ID)
+	if !ok {
+		return
+	}
+	_ = dbpkg.DB.Delete(&model.SubToken{}, st.ID).Error
+	c.JSON(http.StatusOK, response.OkNoData())
+}
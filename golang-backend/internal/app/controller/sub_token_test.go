@@ -0,0 +1,59 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"network-panel/golang-backend/internal/app/model"
+	dbpkg "network-panel/golang-backend/internal/db"
+)
+
+func TestSubTokenStoredHashed(t *testing.T) {
+	useTestDB(t)
+	status := 1
+	u := model.User{BaseEntity: model.BaseEntity{Status: &status}, User: "sub-hash"}
+	dbpkg.DB.Create(&u)
+
+	st, token := ensureSubToken(u.ID)
+	if token == "" || st.Token == token || st.Token != hashSubToken(token) || st.Hint != token[:subTokenHintLen] {
+		t.Fatalf("token not stored hashed: %+v", st)
+	}
+	if _, again := ensureSubToken(u.ID); again != "" {
+		t.Fatal("default token issued twice")
+	}
+	if got, _, tok, ok := subscriptionAuth(token); !ok || got.ID != u.ID || tok.ID != st.ID {
+		t.Fatal("issued token rejected")
+	}
+	if _, _, _, ok := subscriptionAuth(st.Token); ok {
+		t.Fatal("stored hash accepted as a token")
+	}
+
+	legacy := newSubToken()
+	dbpkg.DB.Create(&model.SubToken{UserID: u.ID, Token: legacy, Label: "old"})
+	hashLegacySubTokens()
+	if _, _, _, ok := subscriptionAuth(legacy); !ok {
+		t.Fatal("legacy token lost by the migration")
+	}
+}
+
+func TestSubscriptionAuthRejectsInactiveUsers(t *testing.T) {
+	useTestDB(t)
+	status := 1
+	u := model.User{BaseEntity: model.BaseEntity{Status: &status}, User: "sub-inactive"}
+	dbpkg.DB.Create(&u)
+	_, token := ensureSubToken(u.ID)
+
+	dbpkg.DB.Model(&model.User{}).Where("id = ?", u.ID).Update("status", 0)
+	if _, _, _, ok := subscriptionAuth(token); ok {
+		t.Fatal("disabled user accepted")
+	}
+	past := time.Now().Add(-time.Hour).UnixMilli()
+	dbpkg.DB.Model(&model.User{}).Where("id = ?", u.ID).Updates(map[string]any{"status": 1, "exp_time": past})
+	if _, _, _, ok := subscriptionAuth(token); ok {
+		t.Fatal("expired user accepted")
+	}
+	dbpkg.DB.Model(&model.User{}).Where("id = ?", u.ID).Update("exp_time", 0)
+	if _, _, _, ok := subscriptionAuth(token); !ok {
+		t.Fatal("active user rejected")
+	}
+}
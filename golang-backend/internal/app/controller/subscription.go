@@ -16,7 +16,6 @@ import (
 	"github.com/gin-gonic/gin"
 	"network-panel/golang-backend/internal/app/model"
 	"network-panel/golang-backend/internal/app/response"
-	"network-panel/golang-backend/internal/app/util"
 	dbpkg "network-panel/golang-backend/internal/db"
 	tpl "network-panel/golang-backend/template"
 )
@@ -152,18 +151,13 @@ func SubscriptionQX(c *gin.Context) {
 }
 
 func subscriptionItems(c *gin.Context) (model.User, []subProxy, []subSkip, bool) {
-	token := extractToken(c)
-	if token == "" || !util.ValidateToken(token) {
-		c.JSON(http.StatusUnauthorized, response.ErrMsg("未登录或token无效"))
-		return model.User{}, nil, nil, false
-	}
-	uid := util.GetUserID(token)
-	role := util.GetRoleID(token)
-	var user model.User
-	if err := dbpkg.DB.First(&user, uid).Error; err != nil {
+	user, role, tok, ok := subscriptionAuth(extractToken(c))
+	if !ok {
 		c.JSON(http.StatusUnauthorized, response.ErrMsg("未登录或token无效"))
 		return model.User{}, nil, nil, false
 	}
+	recordSubAccess(c, user, tok, subFormat(c))
+	uid := user.ID
 
 	var forwards []model.Forward
 	q := dbpkg.DB.Model(&model.Forward{})
@@ -177,7 +171,9 @@ func subscriptionItems(c *gin.Context) (model.User, []subProxy, []subSkip, bool)
 		return model.User{}, nil, nil, false
 	}
 	if len(forwards) == 0 {
-		return user, []subProxy{}, []subSkip{}, true
+		used := map[string]int{}
+		items := append(anytlsInboundItems(user, used), nativeExitItems(user, used)...)
+		return user, items, []subSkip{}, true
 	}
 
 	tunnelIDs := make([]int64, 0, len(forwards))
@@ -249,6 +245,13 @@ func subscriptionItems(c *gin.Context) (model.User, []subProxy, []subSkip, bool)
 			anyTLSMap[a.NodeID] = a
 		}
 	}
+	certMap := exitCertMap(outNodeIDList)
+	var anyTLSInbounds []model.AnyTLSInbound
+	var nativeExits []model.NativeExit
+	if len(outNodeIDList) > 0 {
+		dbpkg.DB.Where("node_id IN ?", outNodeIDList).Find(&anyTLSInbounds)
+		dbpkg.DB.Where("node_id IN ?", outNodeIDList).Find(&nativeExits)
+	}
 
 	extMap := map[int64]model.ExitNodeExternal{}
 	if len(outExitIDs) > 0 {
@@ -311,7 +314,31 @@ func subscriptionItems(c *gin.Context) (model.User, []subProxy, []subSkip, bool)
 				ext = &e
 			}
 		}
-		proto, cipher, password, params := resolveExitProtocol(t, ext, ssMap, anyTLSMap)
+		proto, cipher, password, params := resolveExitProtocol(t, ext, ssMap, anyTLSMap, certMap)
+		if ext == nil {
+			outID := outNodeIDOr0(t)
+			if outID == 0 {
+				outID = t.InNodeID
+			}
+			var cert *model.ExitCert
+			if ec, ok := certMap[outID]; ok {
+				cert = &ec
+			}
+			if nx, ok := nativeExitForTarget(nativeExits, outID, f.RemoteAddr); ok {
+				// the forward targets a native exit: credentials are per user
+				proto, cipher = nx.Protocol, ""
+				password, params = nativeExitParams(nx, user.ID, cert)
+				if proto == "vless" {
+					password = ""
+				}
+			} else if normalizeProtocol(proto) == "anytls" {
+				// the forward targets an additional inbound of the exit
+				if in, ok := anytlsInboundForTarget(anyTLSInbounds, outID, f.RemoteAddr); ok {
+					password, params = in.Password, map[string]interface{}{}
+					anytlsInboundSNI(params, in, cert)
+				}
+			}
+		}
 		if normalizeProtocol(proto) == "anytls" && user.ID > 0 {
 			if paramString(params, "password") == "" {
 				derived := anytlsUserPassword(password, user.ID)
@@ -389,6 +416,8 @@ func subscriptionItems(c *gin.Context) (model.User, []subProxy, []subSkip, bool)
 			Params:   params,
 		})
 	}
+	items = append(items, anytlsInboundItems(user, usedNames)...)
+	items = append(items, nativeExitItems(user, usedNames)...)
 	return user, items, skipped, true
 }
 
@@ -425,6 +454,34 @@ func subscriptionEntryHost(t model.Tunnel, n model.Node) string {
 	return t.InIP
 }
 
+// subscriptionUserNodes loads the nodes assigned to a user (active user_node
+// rows), whose exits the user connects to directly.
+func subscriptionUserNodes(userID int64) ([]int64, map[int64]model.Node) {
+	nodeMap := map[int64]model.Node{}
+	if userID <= 0 {
+		return nil, nodeMap
+	}
+	var nodeIDs []int64
+	dbpkg.DB.Model(&model.UserNode{}).Where("user_id = ? AND status = 1", userID).Pluck("node_id", &nodeIDs)
+	if len(nodeIDs) == 0 {
+		return nil, nodeMap
+	}
+	var nodes []model.Node
+	dbpkg.DB.Where("id IN ?", nodeIDs).Find(&nodes)
+	for _, n := range nodes {
+		nodeMap[n.ID] = n
+	}
+	return nodeIDs, nodeMap
+}
+
+// subscriptionNodeHost is the address clients use to reach a node directly.
+func subscriptionNodeHost(n model.Node) string {
+	if h := strings.TrimSpace(n.IP); h != "" {
+		return h
+	}
+	return strings.TrimSpace(n.ServerIP)
+}
+
 func listenAddrHost(addr *string) string {
 	if addr == nil {
 		return ""
@@ -483,7 +540,7 @@ func normalizeProtocol(proto string) string {
 	}
 }
 
-func resolveExitProtocol(t model.Tunnel, ext *model.ExitNodeExternal, ssMap map[int64]model.ExitSetting, anyTLSMap map[int64]model.AnyTLSSetting) (string, string, string, map[string]interface{}) {
+func resolveExitProtocol(t model.Tunnel, ext *model.ExitNodeExternal, ssMap map[int64]model.ExitSetting, anyTLSMap map[int64]model.AnyTLSSetting, certMap map[int64]model.ExitCert) (string, string, string, map[string]interface{}) {
 	if ext != nil {
 		params := parseExitConfig(ext.Config)
 		proto := normalizeProtocol(ptrString(ext.Protocol))
@@ -521,7 +578,13 @@ func resolveExitProtocol(t model.Tunnel, ext *model.ExitNodeExternal, ssMap map[
 		}
 	case "anytls":
 		if a, ok := anyTLSMap[outID]; ok {
-			return "anytls", "", a.Password, map[string]interface{}{}
+			params := map[string]interface{}{}
+			if ec, ok := certMap[outID]; ok {
+				// the exit serves an ACME certificate for its domain
+				params["sni"] = ec.Domain
+				params["skip-cert-verify"] = false
+			}
+			return "anytls", "", a.Password, params
 		}
 	default:
 		return preferred, "", "", map[string]interface{}{}
@@ -692,6 +755,39 @@ func writeClashAnyTLS(buf *bytes.Buffer, it subProxy, params map[string]interfac
 	buf.WriteString("    skip-cert-verify: " + strconv.FormatBool(paramBoolDefault(params, "skip-cert-verify", true)) + "\n")
 }
 
+// anytlsVerifiedSNI returns the SNI to verify an AnyTLS exit against, or ""
+// when the exit only has the self-signed certificate and clients must skip
+// verification.
+func anytlsVerifiedSNI(params map[string]interface{}) string {
+	if sni := paramString(params, "sni"); sni != "" && !paramBoolDefault(params, "skip-cert-verify", true) {
+		return sni
+	}
+	return ""
+}
+
+// anytlsURIQuery renders the query of an anytls:// URI: the SNI, marked
+// insecure unless it is verified.
+func anytlsURIQuery(params map[string]interface{}) string {
+	if sni := anytlsVerifiedSNI(params); sni != "" {
+		return "?sni=" + url.QueryEscape(sni)
+	}
+	if sni := paramString(params, "sni"); sni != "" {
+		return "?sni=" + url.QueryEscape(sni) + "&insecure=1"
+	}
+	return ""
+}
+
+// surgeAnyTLSTLS renders the TLS options of a Surge anytls line.
+func surgeAnyTLSTLS(params map[string]interface{}) string {
+	if sni := anytlsVerifiedSNI(params); sni != "" {
+		return "sni=" + sni + ", skip-cert-verify=false"
+	}
+	if sni := paramString(params, "sni"); sni != "" {
+		return "sni=" + sni + ", skip-cert-verify=true"
+	}
+	return "skip-cert-verify=true"
+}
+
 func buildClashConfigLegacy(items []subProxy, skipped []subSkip) string {
 	if len(items) == 0 {
 		return appendSkipComments("proxies: []\nproxy-groups: []\nrules:\n  - MATCH,DIRECT\n", skipped, "#")
@@ -758,10 +854,18 @@ func buildClashConfigLegacy(items []subProxy, skipped []subSkip) string {
 			buf.WriteString("    udp: true\n")
 		case "anytls":
 			writeClashAnyTLS(&buf, it, params)
+		case "vless":
+			// Clash names the vless SNI "servername"
+			if sni := paramString(params, "sni"); sni != "" && paramString(params, "servername") == "" {
+				buf.WriteString("    servername: " + yamlQuote(sni) + "\n")
+			}
 		}
 		skip := map[string]struct{}{
 			"name": {}, "type": {}, "server": {}, "port": {},
 		}
+		if typ == "vless" {
+			skip["sni"] = struct{}{}
+		}
 		if typ == "ss" {
 			skip["cipher"] = struct{}{}
 			skip["password"] = struct{}{}
@@ -833,11 +937,17 @@ func buildShadowrocket(items []subProxy, skipped []subSkip) string {
 			if pass == "" {
 				continue
 			}
-			lines = append(lines, "anytls://"+url.QueryEscape(pass)+"@"+it.Server+":"+strconv.Itoa(it.Port)+"#"+url.QueryEscape(it.Name))
+			lines = append(lines, "anytls://"+url.QueryEscape(pass)+"@"+it.Server+":"+strconv.Itoa(it.Port)+anytlsURIQuery(params)+"#"+url.QueryEscape(it.Name))
 		case "socks5":
 			lines = append(lines, "socks5://"+it.Server+":"+strconv.Itoa(it.Port)+"#"+url.QueryEscape(it.Name))
 		case "http", "https":
 			lines = append(lines, it.Type+"://"+it.Server+":"+strconv.Itoa(it.Port)+"#"+url.QueryEscape(it.Name))
+		case "vless", "trojan", "hysteria2":
+			if raw := paramString(params, "uri"); raw != "" {
+				lines = append(lines, raw)
+			} else if uri := buildV2rayURI(typ, it, params); uri != "" {
+				lines = append(lines, uri)
+			}
 		default:
 			if raw := paramString(params, "uri"); raw != "" {
 				lines = append(lines, raw)
@@ -848,7 +958,22 @@ func buildShadowrocket(items []subProxy, skipped []subSkip) string {
 	return appendSkipComments(out, skipped, "#")
 }
 
+// surgeSupportedItems moves proxies Surge cannot express (VLESS) to skipped so
+// that neither the proxy list nor the groups reference them.
+func surgeSupportedItems(items []subProxy, skipped []subSkip) ([]subProxy, []subSkip) {
+	out := make([]subProxy, 0, len(items))
+	for _, it := range items {
+		if normalizeProtocol(it.Type) == "vless" {
+			skipped = append(skipped, subSkip{ID: it.ID, Name: it.Name, Group: it.Group, Reason: "Surge 不支持 VLESS"})
+			continue
+		}
+		out = append(out, it)
+	}
+	return out, skipped
+}
+
 func buildSurgeConfig(items []subProxy, skipped []subSkip, ver string, sourceURL string) string {
+	items, skipped = surgeSupportedItems(items, skipped)
 	if custom := strings.TrimSpace(getConfigString(cfgSubSurgeTemplate)); custom != "" {
 		out := buildSurgeConfigWithTemplate(custom, items)
 		if strings.TrimSpace(out) != "" {
@@ -959,11 +1084,11 @@ func buildSurgeConfigLegacy(items []subProxy, skipped []subSkip, ver string, sou
 			if pass == "" {
 				continue
 			}
-			buf.WriteString(fmt.Sprintf("%s = anytls, %s, %d, password=%s, tfo=true, skip-cert-verify=true\n", it.Name, it.Server, it.Port, pass))
+			buf.WriteString(fmt.Sprintf("%s = anytls, %s, %d, password=%s, tfo=true, %s\n", it.Name, it.Server, it.Port, pass, surgeAnyTLSTLS(params)))
 		case "socks5":
 			buf.WriteString(fmt.Sprintf("%s = socks5, %s, %d\n", it.Name, it.Server, it.Port))
 		case "http", "https":
-			buf.WriteString(fmt.Sprintf("%s = %s, %s, %d\n", it.Name, it.Server, it.Port))
+			buf.WriteString(fmt.Sprintf("%s = %s, %s, %d\n", it.Name, it.Type, it.Server, it.Port))
 		default:
 			line := buildSurgeGenericLine(typ, it, params)
 			if line != "" {
@@ -1045,10 +1170,18 @@ func renderClashProxies(items []subProxy) (string, map[string][]string) {
 			buf.WriteString("    udp: true\n")
 		case "anytls":
 			writeClashAnyTLS(&buf, it, params)
+		case "vless":
+			// Clash names the vless SNI "servername"
+			if sni := paramString(params, "sni"); sni != "" && paramString(params, "servername") == "" {
+				buf.WriteString("    servername: " + yamlQuote(sni) + "\n")
+			}
 		}
 		skip := map[string]struct{}{
 			"name": {}, "type": {}, "server": {}, "port": {},
 		}
+		if typ == "vless" {
+			skip["sni"] = struct{}{}
+		}
 		if typ == "ss" {
 			skip["cipher"] = struct{}{}
 			skip["password"] = struct{}{}
@@ -1222,7 +1355,7 @@ func renderSurgeProxies(items []subProxy) (string, map[string][]string) {
 				if pass == "" {
 					continue
 				}
-				line = fmt.Sprintf("%s = anytls, %s, %d, password=%s, tfo=true, skip-cert-verify=true\n", it.Name, it.Server, it.Port, pass)
+				line = fmt.Sprintf("%s = anytls, %s, %d, password=%s, tfo=true, %s\n", it.Name, it.Server, it.Port, pass, surgeAnyTLSTLS(params))
 			case "socks5":
 				line = fmt.Sprintf("%s = socks5, %s, %d\n", it.Name, it.Server, it.Port)
 			case "http", "https":
@@ -2242,7 +2375,7 @@ func buildSingboxOutbound(typ string, it subProxy, params map[string]interface{}
 		if sni == "" {
 			sni = "www.apple.com"
 		}
-		insecure := paramBool(params, "skip-cert-verify") || paramBool(params, "insecure")
+		insecure := paramBoolDefault(params, "skip-cert-verify", true) || paramBool(params, "insecure")
 		base["tls"] = map[string]interface{}{
 			"enabled":     true,
 			"server_name": sni,
@@ -2380,7 +2513,7 @@ func buildSingboxOutbound(typ string, it subProxy, params map[string]interface{}
 				tlsCfg["server_name"] = "www.apple.com"
 			}
 			if _, ok := tlsCfg["insecure"]; !ok {
-				tlsCfg["insecure"] = true
+				tlsCfg["insecure"] = paramBoolDefault(params, "skip-cert-verify", true)
 			}
 		}
 		if alpn := paramString(params, "alpn"); alpn != "" {
@@ -2390,17 +2523,21 @@ func buildSingboxOutbound(typ string, it subProxy, params map[string]interface{}
 			tlsCfg["insecure"] = true
 		}
 		if real, ok := params["reality-opts"].(map[string]interface{}); ok {
-			tlsCfg["enabled"] = true
-			tlsCfg["reality"] = true
+			reality := map[string]interface{}{"enabled": true}
 			if v := paramString(real, "public-key"); v != "" {
-				tlsCfg["public_key"] = v
+				reality["public_key"] = v
 			}
 			if v := paramString(real, "short-id"); v != "" {
-				tlsCfg["short_id"] = v
+				reality["short_id"] = v
 			}
-			if v := paramString(real, "fingerprint"); v != "" {
-				tlsCfg["fingerprint"] = v
+			tlsCfg["enabled"] = true
+			tlsCfg["reality"] = reality
+			// Reality needs a uTLS client hello
+			fp := paramString(real, "fingerprint")
+			if fp == "" {
+				fp = paramStringDefault(params, "client-fingerprint", "chrome")
 			}
+			tlsCfg["utls"] = map[string]interface{}{"enabled": true, "fingerprint": fp}
 		}
 		base["tls"] = tlsCfg
 	}
@@ -2508,6 +2645,12 @@ func buildV2rayURI(typ string, it subProxy, params map[string]interface{}) strin
 		}
 		if paramBool(params, "tls") || paramString(params, "sni") != "" {
 			q.Set("security", "tls")
+			if paramBool(params, "skip-cert-verify") {
+				q.Set("allowInsecure", "1")
+			}
+		}
+		if v := paramString(params, "client-fingerprint"); v != "" {
+			q.Set("fp", v)
 		}
 		if netw := paramString(params, "network"); netw != "" {
 			q.Set("type", netw)
@@ -2546,6 +2689,10 @@ func buildV2rayURI(typ string, it subProxy, params map[string]interface{}) strin
 		q := url.Values{}
 		if sni := paramString(params, "sni"); sni != "" {
 			q.Set("peer", sni)
+			q.Set("sni", sni)
+		}
+		if paramBool(params, "skip-cert-verify") {
+			q.Set("allowInsecure", "1")
 		}
 		if netw := paramString(params, "network"); netw != "" {
 			q.Set("type", netw)
@@ -2587,6 +2734,9 @@ func buildV2rayURI(typ string, it subProxy, params map[string]interface{}) strin
 		if v := paramString(params, "obfs-password"); v != "" {
 			q.Set("obfs-password", v)
 		}
+		if paramBool(params, "skip-cert-verify") {
+			q.Set("insecure", "1")
+		}
 		return fmt.Sprintf("hysteria2://%s@%s:%d?%s#%s", url.QueryEscape(pass), it.Server, it.Port, q.Encode(), url.QueryEscape(it.Name))
 	case "tuic":
 		uuid := paramString(params, "uuid")
@@ -2613,7 +2763,7 @@ func buildV2rayURI(typ string, it subProxy, params map[string]interface{}) strin
 		if pass == "" {
 			return ""
 		}
-		return fmt.Sprintf("anytls://%s@%s:%d#%s", url.QueryEscape(pass), it.Server, it.Port, url.QueryEscape(it.Name))
+		return fmt.Sprintf("anytls://%s@%s:%d%s#%s", url.QueryEscape(pass), it.Server, it.Port, anytlsURIQuery(params), url.QueryEscape(it.Name))
 	default:
 		return ""
 	}
@@ -2716,21 +2866,34 @@ func buildQXLine(typ string, it subProxy, params map[string]interface{}) string
 		}
 		parts := []string{
 			fmt.Sprintf("vless=%s:%d", it.Server, it.Port),
-			"username=" + uuid,
-			"tag=" + it.Name,
+			"method=none",
+			"password=" + uuid,
+		}
+		_, reality := params["reality-opts"].(map[string]interface{})
+		tls := reality || paramBool(params, "tls") || paramString(params, "sni") != ""
+		switch {
+		case paramString(params, "network") == "ws" && tls:
+			parts = append(parts, "obfs=wss")
+		case paramString(params, "network") == "ws":
+			parts = append(parts, "obfs=ws")
+		case tls:
+			parts = append(parts, "obfs=over-tls")
 		}
 		if sni := paramString(params, "sni"); sni != "" {
-			parts = append(parts, "sni="+sni)
-		}
-		if paramBool(params, "tls") {
-			parts = append(parts, "tls=true")
+			parts = append(parts, "obfs-host="+sni)
 		}
-		if netw := paramString(params, "network"); netw == "ws" {
-			parts = append(parts, "obfs=ws")
+		if real, ok := params["reality-opts"].(map[string]interface{}); ok {
+			parts = append(parts, "reality-base64-pubkey="+paramString(real, "public-key"))
+			if v := paramString(real, "short-id"); v != "" {
+				parts = append(parts, "reality-hex-shortid="+v)
+			}
+		} else if tls {
+			parts = append(parts, "tls-verification="+strconv.FormatBool(!paramBool(params, "skip-cert-verify")))
 		}
 		if flow := paramString(params, "flow"); flow != "" {
-			parts = append(parts, "flow="+flow)
+			parts = append(parts, "vless-flow="+flow)
 		}
+		parts = append(parts, "udp-relay="+strconv.FormatBool(paramBoolDefault(params, "udp", true)), "tag="+it.Name)
 		return strings.Join(parts, ",")
 	case "trojan":
 		pass := it.Password
@@ -2743,11 +2906,13 @@ func buildQXLine(typ string, it subProxy, params map[string]interface{}) string
 		parts := []string{
 			fmt.Sprintf("trojan=%s:%d", it.Server, it.Port),
 			"password=" + pass,
-			"tag=" + it.Name,
+			"over-tls=true",
 		}
 		if sni := paramString(params, "sni"); sni != "" {
-			parts = append(parts, "sni="+sni)
+			parts = append(parts, "tls-host="+sni)
 		}
+		parts = append(parts, "tls-verification="+strconv.FormatBool(!paramBool(params, "skip-cert-verify")))
+		parts = append(parts, "udp-relay="+strconv.FormatBool(paramBoolDefault(params, "udp", true)), "tag="+it.Name)
 		return strings.Join(parts, ",")
 	case "anytls":
 		pass := it.Password
@@ -2757,6 +2922,12 @@ func buildQXLine(typ string, it subProxy, params map[string]interface{}) string
 		if pass == "" {
 			return ""
 		}
+		if sni := anytlsVerifiedSNI(params); sni != "" {
+			return fmt.Sprintf("anytls=%s:%d,password=%s,tls-host=%s,tls-verification=true,tag=%s", it.Server, it.Port, pass, sni, it.Name)
+		}
+		if sni := paramString(params, "sni"); sni != "" {
+			return fmt.Sprintf("anytls=%s:%d,password=%s,tls-host=%s,tls-verification=false,tag=%s", it.Server, it.Port, pass, sni, it.Name)
+		}
 		return fmt.Sprintf("anytls=%s:%d,password=%s,tag=%s", it.Server, it.Port, pass, it.Name)
 	case "http", "https":
 		return fmt.Sprintf("%s=%s:%d,tag=%s", typ, it.Server, it.Port, it.Name)
@@ -251,6 +251,10 @@ type SwaggerNodeExitReq struct {
 	Method    string   `json:"method" example:"AEAD_CHACHA20_POLY1305"`
 	ExitIP    string   `json:"exitIp,omitempty" example:"1.2.3.4"`
 	AllowFallback *bool `json:"allowFallback,omitempty" example:"true"`
+	DecoyMode *string `json:"decoyMode,omitempty" example:"proxy"`
+	DecoyURL  *string `json:"decoyUrl,omitempty" example:"http://127.0.0.1:8080"`
+	RuleSetID *int64  `json:"ruleSetId,omitempty" example:"1"`
+	PaddingScheme *string `json:"paddingScheme,omitempty" example:"stop=8\n0=30-30\n1=100-400"`
 	Observer  *string  `json:"observer" example:"console"`
 	Limiter   *string  `json:"limiter" example:"5mbps"`
 	RLimiter  *string  `json:"rlimiter" example:""`
@@ -2,6 +2,7 @@ package controller
 
 import (
     "encoding/json"
+    "errors"
     "fmt"
     "net/http"
     "strconv"
@@ -31,37 +32,43 @@ func TunnelCreate(c *gin.Context) {
 		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
 		return
 	}
+	if _, err := createTunnel(req, c.GetInt64("user_id"), c.GetInt("role_id")); err != nil {
+		replyErr(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, response.OkMsg("隧道创建成功"))
+}
+
+// createTunnel saves a new tunnel owned by uid; non-admin roles are held to
+// their tunnel quota.
+func createTunnel(req dto.TunnelDto, uid int64, role int) (model.Tunnel, error) {
 	// unique name
 	var cnt int64
 	db.DB.Model(&model.Tunnel{}).Where("name = ?", req.Name).Count(&cnt)
 	if cnt > 0 {
-		c.JSON(http.StatusOK, response.ErrMsg("隧道名称已存在"))
-		return
+		return model.Tunnel{}, errors.New("隧道名称已存在")
 	}
 	// in node exists
 	var in model.Node
 	if err := db.DB.First(&in, req.InNodeID).Error; err != nil {
-		c.JSON(http.StatusOK, response.ErrMsg("入口节点不存在"))
-		return
+		return model.Tunnel{}, errors.New("入口节点不存在")
 	}
 	// set entity
 	now := time.Now().UnixMilli()
 	status := 1
     var owner *int64
-    if uidInf, ok := c.Get("user_id"); ok { tmp := uidInf.(int64); owner = &tmp }
+    if uid != 0 { owner = &uid }
     t := model.Tunnel{BaseEntity: model.BaseEntity{CreatedTime: now, UpdatedTime: now, Status: &status},
         Name: req.Name, OwnerID: owner, InNodeID: req.InNodeID, InIP: in.IP, Type: req.Type, Flow: req.Flow,
         Protocol: req.Protocol, TrafficRatio: req.TrafficRatio, TCPListenAddr: req.TCPListenAddr, UDPListenAddr: req.UDPListenAddr, InterfaceName: req.InterfaceName,
     }
 	if req.OutNodeID != nil && req.OutExitID != nil {
-		c.JSON(http.StatusOK, response.ErrMsg("出口节点与外部出口不可同时选择"))
-		return
+		return model.Tunnel{}, errors.New("出口节点与外部出口不可同时选择")
 	}
 	if req.OutExitID != nil {
 		var ext model.ExitNodeExternal
 		if err := db.DB.First(&ext, *req.OutExitID).Error; err != nil {
-			c.JSON(http.StatusOK, response.ErrMsg("外部出口节点不存在"))
-			return
+			return model.Tunnel{}, errors.New("外部出口节点不存在")
 		}
 		t.OutExitID = req.OutExitID
 		t.OutNodeID = nil
@@ -75,21 +82,19 @@ func TunnelCreate(c *gin.Context) {
 		}
 	}
     // enforce tunnel quota for non-admin
-    if roleInf, ok := c.Get("role_id"); ok && roleInf != 0 {
-        uidInf, _ := c.Get("user_id"); uid := uidInf.(int64)
+    if role != 0 {
         var cfg model.ViteConfig; db.DB.Where("name=?","registration_default_num").First(&cfg)
         limit := 10; if n,err := strconv.Atoi(strings.TrimSpace(cfg.Value)); err==nil && n>0 { limit = n }
         var cnt int64
         db.DB.Model(&model.Tunnel{}).Where("owner_id=?", uid).Count(&cnt)
         if int(cnt) >= limit {
-            c.JSON(http.StatusOK, response.ErrMsg("超出隧道数量上限")); return
+            return model.Tunnel{}, errors.New("超出隧道数量上限")
         }
     }
     if err := db.DB.Create(&t).Error; err != nil {
-        c.JSON(http.StatusOK, response.ErrMsg("隧道创建失败"))
-        return
+        return model.Tunnel{}, errors.New("隧道创建失败")
     }
-	c.JSON(http.StatusOK, response.OkMsg("隧道创建成功"))
+	return t, nil
 }
 
 // TunnelList 隧道列表
@@ -162,22 +167,28 @@ func TunnelUpdate(c *gin.Context) {
 		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
 		return
 	}
+	if err := updateTunnel(req, c.GetInt64("user_id"), c.GetInt("role_id")); err != nil {
+		replyErr(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, response.OkMsg("隧道更新成功"))
+}
+
+// updateTunnel saves a tunnel's settings; non-admin roles may only change
+// their own tunnels.
+func updateTunnel(req dto.TunnelUpdateDto, uid int64, role int) error {
     var t model.Tunnel
     if err := db.DB.First(&t, req.ID).Error; err != nil {
-        c.JSON(http.StatusOK, response.ErrMsg("隧道不存在"))
-        return
+        return errors.New("隧道不存在")
     }
-    if roleInf, ok := c.Get("role_id"); ok && roleInf != 0 {
-        if uidInf, ok2 := c.Get("user_id"); ok2 {
-            if t.OwnerID == nil || *t.OwnerID != uidInf.(int64) { c.JSON(http.StatusForbidden, response.ErrMsg("无权限")); return }
-        }
+    if role != 0 && (t.OwnerID == nil || *t.OwnerID != uid) {
+        return errNoPermission
     }
 	// name unique
 	var cnt int64
 	db.DB.Model(&model.Tunnel{}).Where("name = ? AND id <> ?", req.Name, req.ID).Count(&cnt)
 	if cnt > 0 {
-		c.JSON(http.StatusOK, response.ErrMsg("隧道名称已存在"))
-		return
+		return errors.New("隧道名称已存在")
 	}
 	t.Name = req.Name
 	t.Flow = int(req.Flow)
@@ -185,8 +196,7 @@ func TunnelUpdate(c *gin.Context) {
 	t.UpdatedTime = time.Now().UnixMilli()
 	if req.OutNodeID != nil || req.OutExitID != nil {
 		if req.OutNodeID != nil && req.OutExitID != nil {
-			c.JSON(http.StatusOK, response.ErrMsg("出口节点与外部出口不可同时选择"))
-			return
+			return errors.New("出口节点与外部出口不可同时选择")
 		}
 		if req.OutExitID != nil {
 			if *req.OutExitID <= 0 {
@@ -194,8 +204,7 @@ func TunnelUpdate(c *gin.Context) {
 			} else {
 				var ext model.ExitNodeExternal
 				if err := db.DB.First(&ext, *req.OutExitID).Error; err != nil {
-					c.JSON(http.StatusOK, response.ErrMsg("外部出口节点不存在"))
-					return
+					return errors.New("外部出口节点不存在")
 				}
 				t.OutExitID = req.OutExitID
 				t.OutNodeID = nil
@@ -216,10 +225,9 @@ func TunnelUpdate(c *gin.Context) {
 		}
 	}
 	if err := db.DB.Save(&t).Error; err != nil {
-		c.JSON(http.StatusOK, response.ErrMsg("隧道更新失败"))
-		return
+		return errors.New("隧道更新失败")
 	}
-	c.JSON(http.StatusOK, response.OkMsg("隧道更新成功"))
+	return nil
 }
 
 // TunnelDelete 删除隧道
@@ -238,32 +246,37 @@ func TunnelDelete(c *gin.Context) {
 		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
 		return
 	}
+	if err := deleteTunnel(p.ID, c.GetInt64("user_id"), c.GetInt("role_id")); err != nil {
+		replyErr(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, response.OkMsg("隧道删除成功"))
+}
+
+// deleteTunnel removes a tunnel no forward or user assignment refers to;
+// non-admin roles may only remove their own tunnels.
+func deleteTunnel(id, uid int64, role int) error {
     // usage: forwards and user_tunnel
 	var cnt int64
-	db.DB.Model(&model.Forward{}).Where("tunnel_id = ?", p.ID).Count(&cnt)
+	db.DB.Model(&model.Forward{}).Where("tunnel_id = ?", id).Count(&cnt)
 	if cnt > 0 {
-		c.JSON(http.StatusOK, response.ErrMsg("该隧道还有转发在使用，请先删除相关转发"))
-		return
+		return errors.New("该隧道还有转发在使用，请先删除相关转发")
 	}
-	db.DB.Model(&model.UserTunnel{}).Where("tunnel_id = ?", p.ID).Count(&cnt)
+	db.DB.Model(&model.UserTunnel{}).Where("tunnel_id = ?", id).Count(&cnt)
 	if cnt > 0 {
-		c.JSON(http.StatusOK, response.ErrMsg("该隧道还有用户权限关联，请先取消用户权限分配"))
-		return
+		return errors.New("该隧道还有用户权限关联，请先取消用户权限分配")
 	}
     // permission
-    if roleInf, ok := c.Get("role_id"); ok && roleInf != 0 {
+    if role != 0 {
         var tt model.Tunnel
-        if db.DB.First(&tt, p.ID).Error == nil {
-            if uidInf, ok2 := c.Get("user_id"); ok2 {
-                if tt.OwnerID == nil || *tt.OwnerID != uidInf.(int64) { c.JSON(http.StatusForbidden, response.ErrMsg("无权限")); return }
-            }
+        if db.DB.First(&tt, id).Error == nil {
+            if tt.OwnerID == nil || *tt.OwnerID != uid { return errNoPermission }
         }
     }
-    if err := db.DB.Delete(&model.Tunnel{}, p.ID).Error; err != nil {
-        c.JSON(http.StatusOK, response.ErrMsg("隧道删除失败"))
-        return
+    if err := db.DB.Delete(&model.Tunnel{}, id).Error; err != nil {
+        return errors.New("隧道删除失败")
     }
-	c.JSON(http.StatusOK, response.OkMsg("隧道删除成功"))
+	return nil
 }
 
 // TunnelUserTunnel 获取用户可见隧道
@@ -301,18 +314,25 @@ func TunnelUserAssign(c *gin.Context) {
 		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
 		return
 	}
+	if _, err := assignUserTunnel(req); err != nil {
+		replyErr(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, response.OkMsg("用户隧道权限分配成功"))
+}
+
+// assignUserTunnel grants a user access to a tunnel.
+func assignUserTunnel(req dto.UserTunnelDto) (model.UserTunnel, error) {
 	var cnt int64
 	db.DB.Model(&model.UserTunnel{}).Where("user_id=? and tunnel_id=?", req.UserID, req.TunnelID).Count(&cnt)
 	if cnt > 0 {
-		c.JSON(http.StatusOK, response.ErrMsg("该用户已拥有此隧道权限"))
-		return
+		return model.UserTunnel{}, errors.New("该用户已拥有此隧道权限")
 	}
 	ut := model.UserTunnel{UserID: req.UserID, TunnelID: req.TunnelID, Flow: req.Flow, Num: req.Num, FlowResetTime: req.FlowResetTime, ExpTime: req.ExpTime, SpeedID: req.SpeedID, Status: val(req.Status, 1)}
 	if err := db.DB.Create(&ut).Error; err != nil {
-		c.JSON(http.StatusOK, response.ErrMsg("用户隧道权限分配失败"))
-		return
+		return model.UserTunnel{}, errors.New("用户隧道权限分配失败")
 	}
-	c.JSON(http.StatusOK, response.OkMsg("用户隧道权限分配成功"))
+	return ut, nil
 }
 
 // TunnelUserList 用户隧道权限列表
@@ -361,15 +381,24 @@ func TunnelUserRemove(c *gin.Context) {
 		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
 		return
 	}
+	if err := removeUserTunnel(p.ID); err != nil {
+		replyErr(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, response.OkMsg("用户隧道权限删除成功"))
+}
+
+// removeUserTunnel revokes a user's tunnel access along with the user's
+// forwards on that tunnel.
+func removeUserTunnel(id int64) error {
 	// delete user forwards on this tunnel
 	var ut model.UserTunnel
-	if err := db.DB.First(&ut, p.ID).Error; err != nil {
-		c.JSON(http.StatusOK, response.ErrMsg("未找到对应的用户隧道权限记录"))
-		return
+	if err := db.DB.First(&ut, id).Error; err != nil {
+		return errors.New("未找到对应的用户隧道权限记录")
 	}
 	db.DB.Where("user_id = ? and tunnel_id = ?", ut.UserID, ut.TunnelID).Delete(&model.Forward{})
 	db.DB.Delete(&ut)
-	c.JSON(http.StatusOK, response.OkMsg("用户隧道权限删除成功"))
+	return nil
 }
 
 // TunnelUserUpdate 更新用户隧道权限
@@ -386,10 +415,18 @@ func TunnelUserUpdate(c *gin.Context) {
 		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
 		return
 	}
+	if err := updateUserTunnel(req); err != nil {
+		replyErr(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, response.OkMsg("用户隧道权限更新成功"))
+}
+
+// updateUserTunnel saves a user's quota and limits on a tunnel.
+func updateUserTunnel(req dto.UserTunnelUpdateDto) error {
 	var ut model.UserTunnel
 	if err := db.DB.First(&ut, req.ID).Error; err != nil {
-		c.JSON(http.StatusOK, response.ErrMsg("用户隧道权限不存在"))
-		return
+		return errors.New("用户隧道权限不存在")
 	}
 	ut.Flow, ut.Num = req.Flow, req.Num
 	if req.FlowResetTime != nil {
@@ -403,10 +440,9 @@ func TunnelUserUpdate(c *gin.Context) {
 	}
 	ut.SpeedID = req.SpeedID
 	if err := db.DB.Save(&ut).Error; err != nil {
-		c.JSON(http.StatusOK, response.ErrMsg("用户隧道权限更新失败"))
-		return
+		return errors.New("用户隧道权限更新失败")
 	}
-	c.JSON(http.StatusOK, response.OkMsg("用户隧道权限更新成功"))
+	return nil
 }
 
 // TunnelDiagnose 诊断隧道
@@ -667,7 +703,7 @@ func TunnelDiagnoseStep(c *gin.Context) {
 		if t.OutNodeID != nil {
 			exitInProbe = getTunnelBindMap(t.ID)[*t.OutNodeID]
 		}
-		overlayExit := strings.HasPrefix(prevOut, "10.126.126.") && strings.HasPrefix(exitInProbe, "10.126.126.")
+		overlayExit := isOverlayIP(prevOut) && isOverlayIP(exitInProbe)
 
 		// 读取出口节点端口范围（非 overlay 情况使用）
 		minP, maxP := 10000, 65535
@@ -750,7 +786,7 @@ func TunnelDiagnoseStep(c *gin.Context) {
 			if i > 0 {
 				prevOut := ifaceMap[fNodes[i-1]]
 				thisIn := bindMap[nid]
-                if strings.HasPrefix(prevOut, "10.126.126.") && strings.HasPrefix(thisIn, "10.126.126.") {
+                if isOverlayIP(prevOut) && isOverlayIP(thisIn) {
                     // 叠加网络优化也保持端口 >=10000
                     p := findFreePortOnNodeAny(nid, 10000, 10000)
                     if p != 0 { tmpPorts[i] = p } else { tmpPorts[i] = 10000 }
@@ -779,7 +815,7 @@ func TunnelDiagnoseStep(c *gin.Context) {
 				} else if t.OutNodeID != nil {
 					nextIn = bindMap[*t.OutNodeID]
 				}
-                if strings.HasPrefix(prevOut, "10.126.126.") && strings.HasPrefix(nextIn, "10.126.126.") {
+                if isOverlayIP(prevOut) && isOverlayIP(nextIn) {
                     // 叠加网络优化也保持端口 >=10000
                     p := findFreePortOnNodeAny(nid, 10000, 10000)
                     if p != 0 { tmpPorts[i] = p } else { tmpPorts[i] = 10000 }
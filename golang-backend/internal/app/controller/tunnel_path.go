@@ -56,10 +56,17 @@ func TunnelPathSet(c *gin.Context) {
         c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
         return
     }
+    saved := setTunnelPath(p.TunnelID, p.Path, p.LinkModes)
+    c.JSON(http.StatusOK, response.Ok(map[string]any{"saved": saved}))
+}
+
+// setTunnelPath stores the tunnel's mid-hop nodes (unknown nodes dropped) and
+// link modes, and returns how many nodes were kept.
+func setTunnelPath(tunnelID int64, path []int64, linkModes []string) int {
     // de-dup and validate nodes exist
-    uniq := make([]int64, 0, len(p.Path))
+    uniq := make([]int64, 0, len(path))
     seen := map[int64]struct{}{}
-    for _, id := range p.Path {
+    for _, id := range path {
         if id <= 0 { continue }
         if _, ok := seen[id]; ok { continue }
         var n model.Node
@@ -70,7 +77,7 @@ func TunnelPathSet(c *gin.Context) {
     }
     // persist to ViteConfig
     b, _ := json.Marshal(uniq)
-    key := tunnelPathKey(p.TunnelID)
+    key := tunnelPathKey(tunnelID)
     now := time.Now().UnixMilli()
     var cfg model.ViteConfig
     if err := dbpkg.DB.Where("name = ?", key).First(&cfg).Error; err == nil {
@@ -80,10 +87,10 @@ func TunnelPathSet(c *gin.Context) {
     } else {
         _ = dbpkg.DB.Create(&model.ViteConfig{Name: key, Value: string(b), Time: now}).Error
     }
-    if len(p.LinkModes) > 0 {
-        modes := normalizeLinkModes(p.LinkModes, len(uniq)+1, "direct")
+    if len(linkModes) > 0 {
+        modes := normalizeLinkModes(linkModes, len(uniq)+1, "direct")
         mb, _ := json.Marshal(modes)
-        mkey := tunnelLinkModeKey(p.TunnelID)
+        mkey := tunnelLinkModeKey(tunnelID)
         var mc model.ViteConfig
         if err := dbpkg.DB.Where("name = ?", mkey).First(&mc).Error; err == nil {
             mc.Value = string(mb)
@@ -95,12 +102,12 @@ func TunnelPathSet(c *gin.Context) {
     }
     // 使用 Web API 动态配置，无需重启；重连或编辑保存时会按路径自动下发服务
     var t model.Tunnel
-    _ = dbpkg.DB.First(&t, p.TunnelID).Error
+    _ = dbpkg.DB.First(&t, tunnelID).Error
     nodes := make([]int64, 0, 2+len(uniq))
     nodes = append(nodes, t.InNodeID)
     nodes = append(nodes, uniq...)
     if t.OutNodeID != nil { nodes = append(nodes, *t.OutNodeID) }
-    c.JSON(http.StatusOK, response.Ok(map[string]any{"saved": len(uniq)}))
+    return len(uniq)
 }
 
 func tunnelPathKey(tid int64) string { return "tunnel_path_" + strconv.FormatInt(tid, 10) }
@@ -326,6 +326,7 @@ func attemptRestart(out map[string]any, proxyPrefix string, logf func(string, ..
 						logf("未检测到 launcher，准备直接 exec 重启")
 						return "exec", errs, func() {
 							time.Sleep(800 * time.Millisecond)
+							FlushFlowBuffer()
 							_ = syscall.Exec("/app/server", os.Args, os.Environ())
 							_ = exec.Command("/app/server", os.Args[1:]...).Start()
 							os.Exit(0)
@@ -339,6 +340,7 @@ func attemptRestart(out map[string]any, proxyPrefix string, logf func(string, ..
 		logf("Docker 环境无法直接替换二进制，尝试自我重启: %s", exe)
 		return "docker-exit", errs, func() {
 			time.Sleep(800 * time.Millisecond)
+			FlushFlowBuffer()
 			_ = syscall.Exec(exe, os.Args, os.Environ())
 			_ = exec.Command(exe, os.Args[1:]...).Start()
 			os.Exit(0)
@@ -323,6 +323,10 @@ func UserDelete(c *gin.Context) {
 	dbpkg.DB.Where("user_id = ?", p.ID).Delete(&model.Forward{})
 	dbpkg.DB.Where("user_id = ?", p.ID).Delete(&model.UserTunnel{})
 	dbpkg.DB.Where("user_id = ?", p.ID).Delete(&model.StatisticsFlow{})
+	dbpkg.DB.Where("user_id = ?", p.ID).Delete(&model.UserNotifyContact{})
+	dbpkg.DB.Where("user_id = ?", p.ID).Delete(&model.UserNotifySent{})
+	dbpkg.DB.Where("user_id = ?", p.ID).Delete(&model.SubToken{})
+	dbpkg.DB.Where("user_id = ?", p.ID).Delete(&model.SubAccessLog{})
 	if err := dbpkg.DB.Delete(&u).Error; err != nil {
 		c.JSON(http.StatusOK, response.ErrMsg("用户删除失败"))
 		return
@@ -530,10 +534,13 @@ func UserReset(c *gin.Context) {
 	if req.Type == 1 {
 		// reset user flow
 		dbpkg.DB.Model(&model.User{}).Where("id = ?", req.ID).Updates(map[string]any{"in_flow": 0, "out_flow": 0})
+		clearUserQuotaNotified("user", req.ID)
 	} else if req.Type == 2 {
 		dbpkg.DB.Model(&model.UserTunnel{}).Where("id = ?", req.ID).Updates(map[string]any{"in_flow": 0, "out_flow": 0})
+		clearUserQuotaNotified("tunnel", req.ID)
 	} else if req.Type == 3 {
 		dbpkg.DB.Model(&model.UserNode{}).Where("id = ?", req.ID).Updates(map[string]any{"in_flow": 0, "out_flow": 0})
+		clearUserQuotaNotified("node", req.ID)
 	}
 	c.JSON(http.StatusOK, response.OkNoData())
 }
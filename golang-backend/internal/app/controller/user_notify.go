@@ -0,0 +1,671 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"network-panel/golang-backend/internal/app/model"
+	"network-panel/golang-backend/internal/app/response"
+	dbpkg "network-panel/golang-backend/internal/db"
+)
+
+// User quota / expiry reminders.
+//
+// A periodic checker compares each user's usage against the configured percent
+// thresholds of User.Flow, UserTunnel.Flow and UserNode.Flow, and the remaining
+// days against ExpTime. Every threshold fires once per billing cycle (the cycle
+// starts on FlowResetTime; expiry cycles are keyed by the ExpTime value, so a
+// renewal re-arms them). Messages go to the user's own contacts; email and
+// Telegram reuse the server/bot of an admin notify channel.
+
+const userNotifyMaxContacts = 5
+
+var tgChatIDRe = regexp.MustCompile(`^(-?\d+|@[A-Za-z0-9_]{5,})$`)
+
+type userNotifySettingsView struct {
+	Enabled           bool  `json:"enabled"`
+	QuotaThresholds   []int `json:"quotaThresholds"`
+	ExpiryDays        []int `json:"expiryDays"`
+	SMTPChannelID     int   `json:"smtpChannelId"`
+	TelegramChannelID int   `json:"telegramChannelId"`
+}
+
+func userNotifySettings() userNotifySettingsView {
+	return userNotifySettingsView{
+		Enabled:           getCfg("user_notify_enabled") != "0",
+		QuotaThresholds:   parseIntList(getCfg("user_notify_quota_thresholds"), []int{80, 95, 100}),
+		ExpiryDays:        parseIntList(getCfg("user_notify_expiry_days"), []int{7, 3, 1}),
+		SMTPChannelID:     getCfgInt("user_notify_smtp_channel_id", 0),
+		TelegramChannelID: getCfgInt("user_notify_telegram_channel_id", 0),
+	}
+}
+
+// parseIntList parses "80,95,100" into a sorted, de-duplicated list of positive ints.
+func parseIntList(s string, def []int) []int {
+	if strings.TrimSpace(s) == "" {
+		return def
+	}
+	seen := map[int]bool{}
+	out := []int{}
+	for _, p := range strings.Split(s, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(p))
+		if err != nil || n <= 0 || seen[n] {
+			continue
+		}
+		seen[n] = true
+		out = append(out, n)
+	}
+	sort.Ints(out)
+	return out
+}
+
+func joinIntList(v []int) string {
+	parts := make([]string, 0, len(v))
+	for _, n := range v {
+		parts = append(parts, itoa(n))
+	}
+	return strings.Join(parts, ",")
+}
+
+// cfgIntList normalizes a list for storage; "0" keeps an explicitly empty list
+// from falling back to the defaults.
+func cfgIntList(v []int) string {
+	if s := joinIntList(parseIntList(joinIntList(v), nil)); s != "" {
+		return s
+	}
+	return "0"
+}
+
+var userNotifyOnce sync.Once
+
+// StartUserNotifier launches the quota/expiry reminder loop (USER_NOTIFY_CHECK_SEC, default 300).
+func StartUserNotifier() {
+	userNotifyOnce.Do(func() {
+		go func() {
+			sec := 300
+			if v := os.Getenv("USER_NOTIFY_CHECK_SEC"); v != "" {
+				if n, err := strconv.Atoi(v); err == nil && n > 0 {
+					sec = n
+				}
+			}
+			ticker := time.NewTicker(time.Duration(sec) * time.Second)
+			defer ticker.Stop()
+			for range ticker.C {
+				checkUserNotifyOnce()
+			}
+		}()
+	})
+}
+
+// flowCycleKey returns the start date (UTC+8) of the current billing cycle for
+// a monthly reset day, or "-" when the quota never resets automatically.
+func flowCycleKey(resetDay int64, now time.Time) string {
+	if resetDay <= 0 || resetDay > 31 {
+		return "-"
+	}
+	t := now.In(time.FixedZone("UTC+8", 8*3600))
+	anchor := func(y int, m time.Month) time.Time {
+		last := time.Date(y, m+1, 0, 0, 0, 0, 0, t.Location()).Day()
+		d := int(resetDay)
+		if d > last {
+			d = last
+		}
+		return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+	}
+	start := anchor(t.Year(), t.Month())
+	if t.Before(start) {
+		start = anchor(t.Year(), t.Month()-1)
+	}
+	return start.Format("2006-01-02")
+}
+
+// markUserNotified records (scope, ref, threshold, cycle); false if it was already recorded.
+func markUserNotified(userID int64, scope string, refID int64, threshold int, cycle string) bool {
+	var n int64
+	dbpkg.DB.Model(&model.UserNotifySent{}).
+		Where("user_id = ? AND scope = ? AND ref_id = ? AND threshold = ? AND cycle = ?", userID, scope, refID, threshold, cycle).
+		Count(&n)
+	if n > 0 {
+		return false
+	}
+	rec := model.UserNotifySent{UserID: userID, Scope: scope, RefID: refID, Threshold: threshold, Cycle: cycle, TimeMs: time.Now().UnixMilli()}
+	return dbpkg.DB.Create(&rec).Error == nil
+}
+
+// clearUserQuotaNotified re-arms quota reminders after a manual flow reset.
+func clearUserQuotaNotified(scope string, refID int64) {
+	_ = dbpkg.DB.Where("scope = ? AND ref_id = ?", scope, refID).Delete(&model.UserNotifySent{}).Error
+}
+
+// crossedThreshold returns the highest threshold reached (0 if none).
+func crossedThreshold(thresholds []int, pct float64) int {
+	hit := 0
+	for _, t := range thresholds {
+		if pct >= float64(t) {
+			hit = t
+		}
+	}
+	return hit
+}
+
+// claimThreshold marks hit and every lower threshold of the cycle; returns
+// true when hit itself had not been sent yet. Lower thresholds skipped in a
+// single jump are marked silently to avoid a burst of stale reminders.
+func claimThreshold(userID int64, scope string, refID int64, thresholds []int, hit int, cycle string) bool {
+	fresh := markUserNotified(userID, scope, refID, hit, cycle)
+	if fresh {
+		for _, t := range thresholds {
+			if t < hit {
+				markUserNotified(userID, scope, refID, t, cycle)
+			}
+		}
+	}
+	return fresh
+}
+
+func checkUserNotifyOnce() {
+	st := userNotifySettings()
+	if !st.Enabled {
+		return
+	}
+	var contacts []model.UserNotifyContact
+	dbpkg.DB.Where("enabled = ?", true).Find(&contacts)
+	byUser := map[int64][]model.UserNotifyContact{}
+	for _, ct := range contacts {
+		byUser[ct.UserID] = append(byUser[ct.UserID], ct)
+	}
+	now := time.Now()
+	for uid, cts := range byUser {
+		var u model.User
+		if err := dbpkg.DB.First(&u, uid).Error; err != nil {
+			continue
+		}
+		for _, msg := range collectUserNotifications(u, st, now) {
+			for _, ct := range cts {
+				ch, err := userContactChannel(u, ct)
+				if err != nil {
+					jlog(map[string]any{"event": "user_notify_skip", "userId": uid, "contactId": ct.ID, "error": err.Error()})
+					continue
+				}
+				go deliverNotify(ch, msg)
+			}
+		}
+	}
+}
+
+// notifyUserContacts delivers one message to all enabled contacts of a user.
+func notifyUserContacts(u model.User, msg notifyMessage) {
+	var cts []model.UserNotifyContact
+	dbpkg.DB.Where("user_id = ? AND enabled = ?", u.ID, true).Find(&cts)
+	for _, ct := range cts {
+		ch, err := userContactChannel(u, ct)
+		if err != nil {
+			jlog(map[string]any{"event": "user_notify_skip", "userId": u.ID, "contactId": ct.ID, "error": err.Error()})
+			continue
+		}
+		go deliverNotify(ch, msg)
+	}
+}
+
+// collectUserNotifications returns the reminders due for one user and marks them sent.
+func collectUserNotifications(u model.User, st userNotifySettingsView, now time.Time) []notifyMessage {
+	var out []notifyMessage
+	quota := func(scope string, refID int64, name string, flowGB, used int64, resetDay int64) {
+		limit := flowGB * 1024 * 1024 * 1024
+		if limit <= 0 || len(st.QuotaThresholds) == 0 {
+			return
+		}
+		pct := float64(used) * 100 / float64(limit)
+		hit := crossedThreshold(st.QuotaThresholds, pct)
+		if hit == 0 || !claimThreshold(u.ID, scope, refID, st.QuotaThresholds, hit, flowCycleKey(resetDay, now)) {
+			return
+		}
+		out = append(out, buildUserNotifyMessage("user_quota", u, name, map[string]any{
+			"scope": scope, "refId": refID, "threshold": hit, "percent": pct, "usedBytes": used, "limitBytes": limit,
+			"message": fmt.Sprintf("%s 流量已使用 %.1f%%（%s / %d GB）", name, pct, formatBytes(used), flowGB),
+		}))
+	}
+	expiry := func(scope string, refID int64, name string, exp *int64) {
+		if exp == nil || *exp <= now.UnixMilli() || len(st.ExpiryDays) == 0 {
+			return
+		}
+		remain := *exp - now.UnixMilli()
+		// smallest configured day count the remaining time falls into
+		hit := 0
+		for i := len(st.ExpiryDays) - 1; i >= 0; i-- {
+			if remain <= int64(st.ExpiryDays[i])*86400000 {
+				hit = st.ExpiryDays[i]
+			}
+		}
+		if hit == 0 {
+			return
+		}
+		cycle := strconv.FormatInt(*exp, 10)
+		if !markUserNotified(u.ID, scope, refID, hit, cycle) {
+			return
+		}
+		for _, d := range st.ExpiryDays {
+			if d > hit {
+				markUserNotified(u.ID, scope, refID, d, cycle)
+			}
+		}
+		out = append(out, buildUserNotifyMessage("user_expiry", u, name, map[string]any{
+			"scope": scope, "refId": refID, "threshold": hit, "expTime": *exp, "remainMs": remain,
+			"message": fmt.Sprintf("%s 将于 %s 到期", name, time.UnixMilli(*exp).Format("2006-01-02 15:04")),
+		}))
+	}
+
+	quota("user", u.ID, "账户", u.Flow, u.InFlow+u.OutFlow, u.FlowResetTime)
+	expiry("user_exp", u.ID, "账户", u.ExpTime)
+
+	var uts []struct {
+		model.UserTunnel
+		TunnelName string `gorm:"column:tunnel_name"`
+	}
+	dbpkg.DB.Table("user_tunnel ut").Select("ut.*, t.name as tunnel_name").
+		Joins("left join tunnel t on t.id = ut.tunnel_id").Where("ut.user_id = ?", u.ID).Scan(&uts)
+	for _, ut := range uts {
+		name := "隧道 " + ut.TunnelName
+		quota("tunnel", ut.ID, name, ut.Flow, ut.InFlow+ut.OutFlow, valOr0(ut.FlowResetTime))
+		expiry("tunnel_exp", ut.ID, name, ut.ExpTime)
+	}
+	var uns []struct {
+		model.UserNode
+		NodeName string `gorm:"column:node_name"`
+	}
+	dbpkg.DB.Table("user_node un").Select("un.*, n.name as node_name").
+		Joins("left join node n on n.id = un.node_id").Where("un.user_id = ?", u.ID).Scan(&uns)
+	for _, un := range uns {
+		name := "节点 " + un.NodeName
+		quota("node", un.ID, name, un.Flow, un.InFlow+un.OutFlow, valOr0(un.FlowResetTime))
+		expiry("node_exp", un.ID, name, un.ExpTime)
+	}
+	return out
+}
+
+func buildUserNotifyMessage(event string, u model.User, subject string, extra map[string]any) notifyMessage {
+	now := time.Now().UnixMilli()
+	payload := map[string]any{"event": event, "userId": u.ID, "user": u.User, "subject": subject, "time": now}
+	for k, v := range extra {
+		payload[k] = v
+	}
+	title := notifyEventTitles[event]
+	lines := []string{"用户: " + u.User}
+	if m, ok := extra["message"].(string); ok && m != "" {
+		lines = append(lines, m)
+	}
+	lines = append(lines, "时间: "+time.UnixMilli(now).Format("2006-01-02 15:04:05"))
+	sev := "warning"
+	if t, _ := extra["threshold"].(int); event == "user_quota" && t >= 100 {
+		sev = "critical"
+	}
+	return notifyMessage{Event: event, Title: title, Text: strings.Join(lines, "\n"), Severity: sev, TimeMs: now, Payload: payload, Extra: extra}
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.2f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// baseNotifyChannel picks the admin channel whose server/bot is reused for
+// user contacts: the configured id, else the first enabled channel of the type.
+func baseNotifyChannel(typ string, id int) (model.NotifyChannel, bool) {
+	var ch model.NotifyChannel
+	if id > 0 && dbpkg.DB.Where("id = ? AND type = ?", id, typ).First(&ch).Error == nil {
+		return ch, true
+	}
+	if dbpkg.DB.Where("type = ? AND enabled = ?", typ, true).Order("id asc").First(&ch).Error == nil {
+		return ch, true
+	}
+	return ch, false
+}
+
+// userContactChannel turns a user contact into a sendable channel.
+func userContactChannel(u model.User, ct model.UserNotifyContact) (model.NotifyChannel, error) {
+	st := userNotifySettings()
+	ch := model.NotifyChannel{Name: fmt.Sprintf("用户 %s · %s", u.User, ct.Type)}
+	cfg := map[string]any{}
+	switch ct.Type {
+	case "webhook":
+		ch.Type = "webhook"
+		cfg["url"] = ct.Target
+		cfg["publicOnly"] = true
+	case "telegram":
+		base, ok := baseNotifyChannel("telegram", st.TelegramChannelID)
+		if !ok {
+			return ch, errors.New("管理员未配置 Telegram 机器人")
+		}
+		_ = json.Unmarshal([]byte(base.Config), &cfg)
+		ch.Type = "telegram"
+		cfg["chatId"] = ct.Target
+	case "email":
+		base, ok := baseNotifyChannel("smtp", st.SMTPChannelID)
+		if !ok {
+			return ch, errors.New("管理员未配置邮件服务器")
+		}
+		_ = json.Unmarshal([]byte(base.Config), &cfg)
+		ch.Type = "smtp"
+		cfg["to"] = ct.Target
+	default:
+		return ch, fmt.Errorf("不支持的联系方式: %s", ct.Type)
+	}
+	b, _ := json.Marshal(cfg)
+	ch.Config = string(b)
+	return ch, nil
+}
+
+func validateUserContact(typ, target string) string {
+	switch typ {
+	case "email":
+		if a, err := mail.ParseAddress(target); err != nil || a.Address != target {
+			return "邮箱格式错误"
+		}
+	case "telegram":
+		if !tgChatIDRe.MatchString(target) {
+			return "Telegram Chat ID 格式错误"
+		}
+	case "webhook":
+		if pu, err := url.Parse(target); err != nil || (pu.Scheme != "http" && pu.Scheme != "https") || pu.Host == "" {
+			return "Webhook 地址需为 http(s) URL"
+		} else if msg := checkPublicHost(pu.Hostname()); msg != "" {
+			// user-supplied URLs are fetched by the panel; keep them off internal
+			// addresses (re-checked at dial time, see publicNotifyClient)
+			return msg
+		}
+	default:
+		return "联系方式仅支持 email/telegram/webhook"
+	}
+	return ""
+}
+
+var cgnatNet = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+func isPublicIP(ip net.IP) bool {
+	return !(ip.IsPrivate() || ip.IsLoopback() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsMulticast() || cgnatNet.Contains(ip))
+}
+
+func checkPublicHost(host string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil || len(addrs) == 0 {
+		return "Webhook 地址无法解析"
+	}
+	for _, a := range addrs {
+		if !isPublicIP(a.IP) {
+			return "Webhook 地址不能指向内网"
+		}
+	}
+	return ""
+}
+
+// publicNotifyClient delivers to user-supplied URLs. The address is checked
+// after DNS resolution on every dial, so a name re-pointed at an internal IP
+// is refused, and redirects are not followed. No proxy is used so the check
+// applies to the real destination.
+var publicNotifyClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{Timeout: 10 * time.Second, Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+				return fmt.Errorf("拒绝连接内网地址 %s", host)
+			}
+			return nil
+		}}).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+	},
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return errors.New("不允许重定向")
+	},
+}
+
+// ---- API ----
+
+type userContactReq struct {
+	ID      int64   `json:"id"`
+	Type    *string `json:"type"`
+	Target  *string `json:"target"`
+	Enabled *bool   `json:"enabled"`
+}
+
+func (p userContactReq) applyTo(ct *model.UserNotifyContact) string {
+	if p.Type != nil {
+		ct.Type = strings.TrimSpace(*p.Type)
+	}
+	if p.Target != nil {
+		ct.Target = strings.TrimSpace(*p.Target)
+	}
+	if p.Enabled != nil {
+		ct.Enabled = *p.Enabled
+	}
+	return validateUserContact(ct.Type, ct.Target)
+}
+
+func loadOwnContact(c *gin.Context, id int64) (model.UserNotifyContact, bool) {
+	var ct model.UserNotifyContact
+	uid := c.GetInt64("user_id")
+	if id == 0 || dbpkg.DB.Where("id = ? AND user_id = ?", id, uid).First(&ct).Error != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("联系方式不存在"))
+		return ct, false
+	}
+	return ct, true
+}
+
+// UserNotifyContactList 当前用户的提醒联系方式
+// @Summary 当前用户的提醒联系方式
+// @Tags user
+// @Produce json
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/user/notify/contacts/list [post]
+func UserNotifyContactList(c *gin.Context) {
+	uid := c.GetInt64("user_id")
+	var list []model.UserNotifyContact
+	dbpkg.DB.Where("user_id = ?", uid).Order("id asc").Find(&list)
+	st := userNotifySettings()
+	_, hasSMTP := baseNotifyChannel("smtp", st.SMTPChannelID)
+	_, hasTG := baseNotifyChannel("telegram", st.TelegramChannelID)
+	types := []map[string]any{
+		{"type": "email", "available": hasSMTP},
+		{"type": "telegram", "available": hasTG},
+		{"type": "webhook", "available": true},
+	}
+	c.JSON(http.StatusOK, response.Ok(map[string]any{"contacts": list, "types": types, "enabled": st.Enabled,
+		"quotaThresholds": st.QuotaThresholds, "expiryDays": st.ExpiryDays}))
+}
+
+// UserNotifyContactCreate 新增提醒联系方式
+// @Summary 新增提醒联系方式
+// @Tags user
+// @Accept json
+// @Produce json
+// @Param data body object true "{type: email|telegram|webhook, target, enabled?}"
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/user/notify/contacts/create [post]
+func UserNotifyContactCreate(c *gin.Context) {
+	var p userContactReq
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	uid := c.GetInt64("user_id")
+	var n int64
+	dbpkg.DB.Model(&model.UserNotifyContact{}).Where("user_id = ?", uid).Count(&n)
+	if n >= userNotifyMaxContacts {
+		c.JSON(http.StatusOK, response.ErrMsg(fmt.Sprintf("最多添加 %d 个联系方式", userNotifyMaxContacts)))
+		return
+	}
+	now := time.Now().UnixMilli()
+	ct := model.UserNotifyContact{UserID: uid, Enabled: true, CreatedTime: now, UpdatedTime: now}
+	if msg := p.applyTo(&ct); msg != "" {
+		c.JSON(http.StatusOK, response.ErrMsg(msg))
+		return
+	}
+	if err := dbpkg.DB.Create(&ct).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("保存失败"))
+		return
+	}
+	c.JSON(http.StatusOK, response.Ok(ct))
+}
+
+// UserNotifyContactUpdate 更新提醒联系方式
+// @Summary 更新提醒联系方式
+// @Tags user
+// @Accept json
+// @Produce json
+// @Param data body object true "{id, type?, target?, enabled?}"
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/user/notify/contacts/update [post]
+func UserNotifyContactUpdate(c *gin.Context) {
+	var p userContactReq
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	ct, ok := loadOwnContact(c, p.ID)
+	if !ok {
+		return
+	}
+	if msg := p.applyTo(&ct); msg != "" {
+		c.JSON(http.StatusOK, response.ErrMsg(msg))
+		return
+	}
+	ct.UpdatedTime = time.Now().UnixMilli()
+	if err := dbpkg.DB.Save(&ct).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("保存失败"))
+		return
+	}
+	c.JSON(http.StatusOK, response.Ok(ct))
+}
+
+// UserNotifyContactDelete 删除提醒联系方式
+// @Summary 删除提醒联系方式
+// @Tags user
+// @Accept json
+// @Produce json
+// @Param data body object true "{id}"
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/user/notify/contacts/delete [post]
+func UserNotifyContactDelete(c *gin.Context) {
+	var p struct {
+		ID int64 `json:"id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	_ = dbpkg.DB.Where("id = ? AND user_id = ?", p.ID, c.GetInt64("user_id")).Delete(&model.UserNotifyContact{}).Error
+	c.JSON(http.StatusOK, response.OkNoData())
+}
+
+// UserNotifyContactTest 向联系方式发送测试提醒（同步，不重试）
+// @Summary 发送测试提醒
+// @Tags user
+// @Accept json
+// @Produce json
+// @Param data body object true "{id}"
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/user/notify/contacts/test [post]
+func UserNotifyContactTest(c *gin.Context) {
+	var p struct {
+		ID int64 `json:"id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("参数错误"))
+		return
+	}
+	ct, ok := loadOwnContact(c, p.ID)
+	if !ok {
+		return
+	}
+	var u model.User
+	if err := dbpkg.DB.First(&u, ct.UserID).Error; err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("用户不存在"))
+		return
+	}
+	ch, err := userContactChannel(u, ct)
+	if err == nil {
+		msg := buildUserNotifyMessage("test", u, "", map[string]any{"message": "这是一条测试提醒"})
+		msg.Severity = ""
+		err = sendNotify(ch, msg)
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, response.ErrMsg("发送失败: "+err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, response.OkMsg("发送成功"))
+}
+
+// UserNotifySettings 用户配额/到期提醒设置（管理员，不带参数时仅返回当前设置）
+// @Summary 用户配额/到期提醒设置
+// @Tags notify
+// @Accept json
+// @Produce json
+// @Param data body object false "{enabled?, quotaThresholds?: [80,95,100], expiryDays?: [7,3,1], smtpChannelId?, telegramChannelId?}"
+// @Success 200 {object} BaseSwaggerResp
+// @Router /api/v1/notify/user/settings [post]
+func UserNotifySettings(c *gin.Context) {
+	var p struct {
+		Enabled           *bool `json:"enabled"`
+		QuotaThresholds   []int `json:"quotaThresholds"`
+		ExpiryDays        []int `json:"expiryDays"`
+		SMTPChannelID     *int  `json:"smtpChannelId"`
+		TelegramChannelID *int  `json:"telegramChannelId"`
+	}
+	_ = c.ShouldBindJSON(&p)
+	for _, t := range p.QuotaThresholds {
+		if t < 1 || t > 100 {
+			c.JSON(http.StatusOK, response.ErrMsg("配额阈值范围 1-100"))
+			return
+		}
+	}
+	for _, d := range p.ExpiryDays {
+		if d < 1 || d > 365 {
+			c.JSON(http.StatusOK, response.ErrMsg("到期提醒天数范围 1-365"))
+			return
+		}
+	}
+	if p.Enabled != nil {
+		setCfg("user_notify_enabled", ifThen(*p.Enabled, "1", "0"))
+	}
+	if p.QuotaThresholds != nil {
+		setCfg("user_notify_quota_thresholds", cfgIntList(p.QuotaThresholds))
+	}
+	if p.ExpiryDays != nil {
+		setCfg("user_notify_expiry_days", cfgIntList(p.ExpiryDays))
+	}
+	if p.SMTPChannelID != nil {
+		setCfg("user_notify_smtp_channel_id", itoa(*p.SMTPChannelID))
+	}
+	if p.TelegramChannelID != nil {
+		setCfg("user_notify_telegram_channel_id", itoa(*p.TelegramChannelID))
+	}
+	c.JSON(http.StatusOK, response.Ok(userNotifySettings()))
+}
@@ -0,0 +1,43 @@
+package controller
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestValidateUserContactWebhook(t *testing.T) {
+	for _, u := range []string{"http://127.0.0.1/hook", "http://localhost:8080/hook", "https://10.0.0.1/", "http://[::1]/", "http://100.64.1.1/", "ftp://example.com/"} {
+		if validateUserContact("webhook", u) == "" {
+			t.Errorf("%s accepted", u)
+		}
+	}
+	if msg := validateUserContact("webhook", "https://93.184.215.14/hook"); msg != "" {
+		t.Errorf("public address rejected: %s", msg)
+	}
+}
+
+func TestPublicNotifyClientRefusesInternal(t *testing.T) {
+	hits := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { hits++ }))
+	defer srv.Close()
+	msg := notifyMessage{Event: "test", Title: "t"}
+	if err := sendWebhookNotify(map[string]any{"url": srv.URL}, msg); err != nil {
+		t.Fatal(err)
+	}
+	if err := sendWebhookNotify(map[string]any{"url": srv.URL, "publicOnly": true}, msg); err == nil {
+		t.Fatal("user webhook reached a loopback address")
+	}
+	if hits != 1 {
+		t.Fatalf("expected 1 delivery, got %d", hits)
+	}
+	if publicNotifyClient.CheckRedirect(nil, nil) == nil {
+		t.Fatal("redirects must be refused")
+	}
+	for _, ip := range []string{"127.0.0.1", "192.168.1.1", "169.254.169.254", "::1", "fd00::1", "0.0.0.0"} {
+		if isPublicIP(net.ParseIP(ip)) {
+			t.Errorf("%s treated as public", ip)
+		}
+	}
+}
@@ -232,6 +232,8 @@ func SystemInfoWS(c *gin.Context) {
 		_ = dbpkg.DB.Save(&node).Error
 		// auto join easytier if enabled
 		go ensureEasyTierAutoJoinFor(node.ID)
+		// nftables state is lost on reboot; restore overlay ACLs
+		go reapplyOverlayACLFor(node.ID)
 		// close an open disconnect log if any
 		var lastLog model.NodeDisconnectLog
 		if err := dbpkg.DB.Where("node_id = ? AND up_at_ms IS NULL", node.ID).Order("down_at_ms desc").First(&lastLog).Error; err == nil && lastLog.ID > 0 {
@@ -253,14 +255,7 @@ func SystemInfoWS(c *gin.Context) {
 		broadcastToAdmins(map[string]interface{}{"id": node.ID, "type": "status", "data": 1})
 
 		// auto-upgrade agent if version mismatch (expected strictly follows backend version)
-		sv := appver.Get()
-		if strings.HasPrefix(sv, "server-") {
-			sv = strings.TrimPrefix(sv, "server-")
-		}
-		expected := "go-agent-" + sv
-		if role == "agent2" {
-			expected = "go-agent2-" + sv
-		}
+		expected := expectedAgentVersion(role)
 		if version != "" && expected != "" && version != expected {
 			jlog(map[string]interface{}{"event": "agent_upgrade_trigger", "nodeId": node.ID, "from": version, "to": expected, "role": role})
 			_ = sendWSCommand(node.ID, "UpgradeAgent", map[string]any{"to": expected})
@@ -364,7 +359,7 @@ func SystemInfoWS(c *gin.Context) {
 						}
 						continue
 					}
-				} else if ok && (t == "RunScriptResult" || t == "WriteFileResult" || t == "RestartServiceResult" || t == "StopServiceResult" || t == "AddServiceResult" || t == "SetAnyTLSResult") {
+				} else if ok && (t == "RunScriptResult" || t == "WriteFileResult" || t == "RestartServiceResult" || t == "StopServiceResult" || t == "AddServiceResult" || t == "SetAnyTLSResult" || t == "SetNativeExitsResult") {
 					if reqID, ok := generic["requestId"].(string); ok {
 						opMu.Lock()
 						ch := opWaiters[reqID]
@@ -459,6 +454,16 @@ func SystemInfoWS(c *gin.Context) {
 	}
 }
 
+// expectedAgentVersion is the agent version matching this backend; role is
+// "agent2" for the secondary agent, anything else for the main one.
+func expectedAgentVersion(role string) string {
+	sv := strings.TrimPrefix(appver.Get(), "server-")
+	if role == "agent2" {
+		return "go-agent2-" + sv
+	}
+	return "go-agent-" + sv
+}
+
 // sendWSCommand sends a command to a node by ID: {type: ..., data: ...}
 func sendWSCommand(nodeID int64, cmdType string, data interface{}) error {
 	nodeConnMu.RLock()
@@ -817,97 +822,6 @@ func tokenAdminStillValid(token string) bool {
 	return true
 }
 
-// notifyCallback sends a simple callback to configured URL on events (GET or POST)
-func notifyCallback(event string, node model.Node, extra map[string]any) {
-	// read from vite_config
-	var urlC, methodC, hdrC, bodyTpl model.ViteConfig
-	dbpkg.DB.Where("name = ?", "callback_url").First(&urlC)
-	if urlC.Value == "" {
-		return
-	}
-	dbpkg.DB.Where("name = ?", "callback_method").First(&methodC)
-	dbpkg.DB.Where("name = ?", "callback_headers").First(&hdrC)
-	dbpkg.DB.Where("name = ?", "callback_template").First(&bodyTpl)
-
-	method := strings.ToUpper(methodC.Value)
-	if method != "GET" && method != "POST" {
-		method = "POST"
-	}
-	headers := map[string]string{}
-	if hdrC.Value != "" {
-		var m map[string]string
-		if json.Unmarshal([]byte(hdrC.Value), &m) == nil {
-			headers = m
-		}
-	}
-	payload := map[string]any{"event": event, "nodeId": node.ID, "name": node.Name, "time": time.Now().UnixMilli()}
-	for k, v := range extra {
-		payload[k] = v
-	}
-	b, _ := json.Marshal(payload)
-
-	// apply template helpers
-	apply := func(s string) string {
-		if s == "" {
-			return s
-		}
-		out := s
-		repl := map[string]string{
-			"{event}":  event,
-			"{nodeId}": fmt.Sprintf("%d", node.ID),
-			"{name}":   node.Name,
-			"{time}":   fmt.Sprintf("%d", payload["time"]),
-		}
-		if v, ok := extra["downAtMs"]; ok {
-			repl["{downAt}"] = fmt.Sprintf("%v", v)
-		}
-		if v, ok := extra["upAtMs"]; ok {
-			repl["{upAt}"] = fmt.Sprintf("%v", v)
-		}
-		if v, ok := extra["durationS"]; ok {
-			repl["{duration}"] = fmt.Sprintf("%v", v)
-		}
-		for k, v := range repl {
-			out = strings.ReplaceAll(out, k, v)
-		}
-		return out
-	}
-
-	go func() {
-		client := &http.Client{Timeout: 5 * time.Second}
-		u := urlC.Value
-		// If template provided, apply to query/body
-		if bodyTpl.Value != "" {
-			t := apply(bodyTpl.Value)
-			if method == "GET" {
-				if strings.Contains(u, "?") {
-					u = u + "&" + t
-				} else {
-					u = u + "?" + t
-				}
-			} else {
-				b = []byte(t)
-			}
-		}
-		if method == "GET" {
-			req, _ := http.NewRequest("GET", u, nil)
-			for k, v := range headers {
-				req.Header.Set(k, v)
-			}
-			_, _ = client.Do(req)
-			return
-		}
-		req, _ := http.NewRequest("POST", u, strings.NewReader(string(b)))
-		for k, v := range headers {
-			req.Header.Set(k, v)
-		}
-		if req.Header.Get("Content-Type") == "" {
-			req.Header.Set("Content-Type", "application/json")
-		}
-		_, _ = client.Do(req)
-	}()
-}
-
 // TriggerCallback exposes the callback hook to other packages (e.g., scheduler)
 func TriggerCallback(event string, node model.Node, extra map[string]any) {
 	notifyCallback(event, node, extra)
@@ -180,6 +180,9 @@ type UserNodeDto struct {
 	FlowResetTime *int64 `json:"flowResetTime"`
 	ExpTime       *int64 `json:"expTime"`
 	SpeedMbps     *int   `json:"speedMbps"`
+	MaxSessions   *int   `json:"maxSessions"`
+	MaxIPs        *int   `json:"maxIps"`
+	LimitMode     string `json:"limitMode"`
 	Status        *int   `json:"status"`
 }
 
@@ -195,6 +198,9 @@ type UserNodeUpdateDto struct {
 	FlowResetTime *int64  `json:"flowResetTime"`
 	ExpTime       *int64  `json:"expTime"`
 	SpeedMbps     *int    `json:"speedMbps"`
+	MaxSessions   *int    `json:"maxSessions"`
+	MaxIPs        *int    `json:"maxIps"`
+	LimitMode     *string `json:"limitMode"`
 	Status        *int    `json:"status"`
 }
 
@@ -0,0 +1,72 @@
+package model
+
+// AlertRule is a threshold rule evaluated periodically by the panel.
+// Metric: cpu | mem | bw_in | bw_out (Mbps) | probe_rtt | probe_loss |
+// gost_down | forward_down | offline. The condition must hold for
+// DurationSec before an incident fires; it resolves after the condition has
+// been false for ResolveSec.
+type AlertRule struct {
+	ID          int64   `gorm:"primaryKey;column:id" json:"id"`
+	Name        string  `gorm:"column:name;type:varchar(128)" json:"name"`
+	Metric      string  `gorm:"column:metric;type:varchar(32)" json:"metric"`
+	Op          string  `gorm:"column:op;type:varchar(4)" json:"op"` // > >= < <=
+	Threshold   float64 `gorm:"column:threshold" json:"threshold"`
+	DurationSec int     `gorm:"column:duration_sec" json:"durationSec"`
+	ResolveSec  int     `gorm:"column:resolve_sec" json:"resolveSec"`
+	Severity    string  `gorm:"column:severity;type:varchar(16)" json:"severity"` // info | warning | critical
+	// JSON array of node ids; empty means all nodes
+	NodeIDs *string `gorm:"column:node_ids;type:text" json:"nodeIds,omitempty"`
+	// node group; members are added to NodeIDs
+	GroupID *int64 `gorm:"column:group_id" json:"groupId,omitempty"`
+	// probe_rtt/probe_loss: restrict to one probe target
+	TargetID    *int64 `gorm:"column:target_id" json:"targetId,omitempty"`
+	Enabled     bool   `gorm:"column:enabled" json:"enabled"`
+	Note        string `gorm:"column:note;type:varchar(255)" json:"note"`
+	CreatedTime int64  `gorm:"column:created_time" json:"createdTime"`
+	UpdatedTime int64  `gorm:"column:updated_time" json:"updatedTime"`
+}
+
+func (AlertRule) TableName() string { return "alert_rule" }
+
+// AlertIncident is one firing of a rule for a (node, subject). DedupKey is
+// unique among open incidents so a persistent condition fires only once.
+type AlertIncident struct {
+	ID         int64   `gorm:"primaryKey;column:id" json:"id"`
+	RuleID     int64   `gorm:"column:rule_id;index" json:"ruleId"`
+	RuleName   string  `gorm:"column:rule_name;type:varchar(128)" json:"ruleName"`
+	Metric     string  `gorm:"column:metric;type:varchar(32)" json:"metric"`
+	Severity   string  `gorm:"column:severity;type:varchar(16)" json:"severity"`
+	NodeID     int64   `gorm:"column:node_id;index" json:"nodeId"`
+	NodeName   string  `gorm:"column:node_name;type:varchar(128)" json:"nodeName"`
+	Subject    string  `gorm:"column:subject;type:varchar(128)" json:"subject"` // e.g. target:3, forward:12
+	DedupKey   string  `gorm:"column:dedup_key;type:varchar(191);index" json:"dedupKey"`
+	Status     string  `gorm:"column:status;type:varchar(16);index" json:"status"` // firing | resolved
+	Value      float64 `gorm:"column:value" json:"value"`
+	Threshold  float64 `gorm:"column:threshold" json:"threshold"`
+	Message    string  `gorm:"column:message;type:varchar(512)" json:"message"`
+	StartedMs  int64   `gorm:"column:started_ms" json:"startedMs"`
+	LastSeenMs int64   `gorm:"column:last_seen_ms" json:"lastSeenMs"`
+	ResolvedMs *int64  `gorm:"column:resolved_ms" json:"resolvedMs,omitempty"`
+	AckedBy    *int64  `gorm:"column:acked_by" json:"ackedBy,omitempty"`
+	AckedMs    *int64  `gorm:"column:acked_ms" json:"ackedMs,omitempty"`
+	AckNote    string  `gorm:"column:ack_note;type:varchar(255)" json:"ackNote,omitempty"`
+	Silenced   bool    `gorm:"column:silenced" json:"silenced"`
+}
+
+func (AlertIncident) TableName() string { return "alert_incident" }
+
+// AlertSilence suppresses notifications for matching incidents between
+// StartMs and EndMs. Nil/empty matchers match everything.
+type AlertSilence struct {
+	ID          int64  `gorm:"primaryKey;column:id" json:"id"`
+	RuleID      *int64 `gorm:"column:rule_id" json:"ruleId,omitempty"`
+	NodeID      *int64 `gorm:"column:node_id" json:"nodeId,omitempty"`
+	Metric      string `gorm:"column:metric;type:varchar(32)" json:"metric,omitempty"`
+	StartMs     int64  `gorm:"column:start_ms" json:"startMs"`
+	EndMs       int64  `gorm:"column:end_ms" json:"endMs"`
+	Reason      string `gorm:"column:reason;type:varchar(255)" json:"reason"`
+	CreatedBy   int64  `gorm:"column:created_by" json:"createdBy"`
+	CreatedTime int64  `gorm:"column:created_time" json:"createdTime"`
+}
+
+func (AlertSilence) TableName() string { return "alert_silence" }
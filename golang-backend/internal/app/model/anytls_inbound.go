@@ -0,0 +1,20 @@
+package model
+
+// AnyTLSInbound is an extra AnyTLS listener on an exit node next to the
+// primary one in AnyTLSSetting. It shares the node's certificate, decoy and
+// outbound rules but has its own port, base password, padding scheme and
+// user subset; subscriptions emit one proxy per inbound.
+type AnyTLSInbound struct {
+	ID            int64  `gorm:"primaryKey;column:id" json:"id"`
+	NodeID        int64  `gorm:"column:node_id;index" json:"nodeId"`
+	Name          string `gorm:"column:name;type:varchar(64)" json:"name"`
+	Port          int    `gorm:"column:port" json:"port"`
+	Password      string `gorm:"column:password;type:varchar(255)" json:"password"`
+	SNI           string `gorm:"column:sni;type:varchar(255)" json:"sni"`
+	PaddingScheme string `gorm:"column:padding_scheme;type:text" json:"paddingScheme"`
+	UserIDs       string `gorm:"column:user_ids;type:text" json:"userIds"` // JSON array; empty: every user assigned to the node
+	CreatedTime   int64  `gorm:"column:created_time" json:"createdTime"`
+	UpdatedTime   int64  `gorm:"column:updated_time" json:"updatedTime"`
+}
+
+func (AnyTLSInbound) TableName() string { return "anytls_inbound" }
@@ -0,0 +1,30 @@
+package model
+
+// ExitCert assigns a domain to an exit node. The agent obtains and renews an
+// ACME certificate for it and reports the state back; subscriptions switch to
+// the real SNI with verification once Status is "ok".
+type ExitCert struct {
+	ID             int64  `gorm:"primaryKey;column:id" json:"id"`
+	NodeID         int64  `gorm:"column:node_id;uniqueIndex" json:"nodeId"`
+	Domain         string `gorm:"column:domain;type:varchar(255)" json:"domain"`
+	Email          string `gorm:"column:email;type:varchar(255)" json:"email"`
+	CA             string `gorm:"column:ca;type:varchar(512)" json:"ca"`              // ACME directory URL, empty: Let's Encrypt
+	CARoot         string `gorm:"column:ca_root;type:text" json:"caRoot"`             // PEM roots for a private ACME server
+	Challenge      string `gorm:"column:challenge;type:varchar(16)" json:"challenge"` // http | tls-alpn | dns, empty: http + tls-alpn
+	DNSProvider    string `gorm:"column:dns_provider;type:varchar(32)" json:"dnsProvider"`
+	DNSCredentials string `gorm:"column:dns_credentials;type:text" json:"-"` // JSON object, never returned
+	AltHTTPPort    int    `gorm:"column:alt_http_port" json:"altHttpPort"`
+	AltTLSALPNPort int    `gorm:"column:alt_tls_alpn_port" json:"altTlsAlpnPort"`
+	// reported by the agent: pending | ok | error
+	Status       string `gorm:"column:status;type:varchar(16)" json:"status"`
+	NotAfter     int64  `gorm:"column:not_after" json:"notAfter"`
+	Issuer       string `gorm:"column:issuer;type:varchar(255)" json:"issuer"`
+	Error        string `gorm:"column:error;type:varchar(512)" json:"error"`
+	CertPath     string `gorm:"column:cert_path;type:varchar(255)" json:"certPath"`
+	KeyPath      string `gorm:"column:key_path;type:varchar(255)" json:"keyPath"`
+	ReportedTime int64  `gorm:"column:reported_time" json:"reportedTime"`
+	CreatedTime  int64  `gorm:"column:created_time" json:"createdTime"`
+	UpdatedTime  int64  `gorm:"column:updated_time" json:"updatedTime"`
+}
+
+func (ExitCert) TableName() string { return "exit_cert" }
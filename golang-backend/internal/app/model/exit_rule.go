@@ -0,0 +1,17 @@
+package model
+
+// ExitRuleSet is a named set of outbound rules for exit nodes. AnyTLS exits
+// apply it in flux-agent; shadowsocks exits get it as gost bypass/chain config.
+type ExitRuleSet struct {
+	ID           int64  `gorm:"primaryKey;column:id" json:"id"`
+	Name         string `gorm:"column:name;type:varchar(64);uniqueIndex" json:"name"`
+	Description  string `gorm:"column:description;type:varchar(255)" json:"description"`
+	BlockPrivate bool   `gorm:"column:block_private" json:"blockPrivate"`
+	BlockPorts   string `gorm:"column:block_ports;type:text" json:"blockPorts"` // JSON array: "25", "6881-6889"
+	Block        string `gorm:"column:block;type:text" json:"block"`            // JSON array of domains / IPs / CIDRs
+	Routes       string `gorm:"column:routes;type:text" json:"routes"`          // JSON array of {match, exitIp | socks5}
+	CreatedTime  int64  `gorm:"column:created_time" json:"createdTime"`
+	UpdatedTime  int64  `gorm:"column:updated_time" json:"updatedTime"`
+}
+
+func (ExitRuleSet) TableName() string { return "exit_rule_set" }
@@ -110,7 +110,11 @@ type UserNode struct {
 	SpeedMbps     int    `gorm:"column:speed_mbps" json:"speedMbps"`
 	Num           int    `gorm:"column:num" json:"num"`
 	PortRanges    string `gorm:"column:port_ranges" json:"portRanges"`
-	Status        int    `gorm:"column:status" json:"status"`
+	// AnyTLS per-user limits enforced by the agent (0 = unlimited)
+	MaxSessions int    `gorm:"column:max_sessions" json:"maxSessions"`
+	MaxIPs      int    `gorm:"column:max_ips" json:"maxIps"`
+	LimitMode   string `gorm:"column:limit_mode;type:varchar(16)" json:"limitMode"` // reject | evict
+	Status      int    `gorm:"column:status" json:"status"`
 }
 
 func (UserNode) TableName() string { return "user_node" }
@@ -162,6 +166,34 @@ type FlowTimeseries struct {
 
 func (FlowTimeseries) TableName() string { return "flow_timeseries" }
 
+// FlowReportSeq is the highest spooled flow batch applied per node and agent;
+// agents replay batches until acknowledged, anything at or below it is a duplicate.
+type FlowReportSeq struct {
+	NodeID      int64  `gorm:"primaryKey;autoIncrement:false;column:node_id" json:"nodeId"`
+	Source      string `gorm:"primaryKey;column:source;type:varchar(16)" json:"source"`
+	LastSeq     int64  `gorm:"column:last_seq" json:"lastSeq"`
+	UpdatedTime int64  `gorm:"column:updated_time" json:"updatedTime"`
+}
+
+func (FlowReportSeq) TableName() string { return "flow_report_seq" }
+
+// AnyTLSViolation aggregates AnyTLS session/IP limit hits reported by an agent
+// for one user over a report window.
+type AnyTLSViolation struct {
+	ID      int64  `gorm:"primaryKey;column:id" json:"id"`
+	NodeID  int64  `gorm:"column:node_id;index:anytls_viol_node_idx" json:"nodeId"`
+	UserID  int64  `gorm:"column:user_id;index" json:"userId"`
+	Kind    string `gorm:"column:kind;type:varchar(16)" json:"kind"`     // sessions | ips
+	Action  string `gorm:"column:action;type:varchar(16)" json:"action"` // rejected | evicted
+	Limit   int    `gorm:"column:limit_value" json:"limit"`
+	Count   int64  `gorm:"column:count" json:"count"`
+	LastIP  string `gorm:"column:last_ip;type:varchar(64)" json:"lastIp"`
+	FirstMs int64  `gorm:"column:first_ms" json:"firstMs"`
+	TimeMs  int64  `gorm:"column:time_ms;index:anytls_viol_node_idx" json:"timeMs"`
+}
+
+func (AnyTLSViolation) TableName() string { return "anytls_violation" }
+
 // NQResult stores streaming NodeQuality test output per request/node
 type NQResult struct {
 	ID          int64  `gorm:"primaryKey;column:id" json:"id"`
@@ -237,6 +269,8 @@ type ExitSetting struct {
 	BaseUserID *int64 `gorm:"column:base_user_id" json:"baseUserId,omitempty"`
 	// Metadata is a JSON string storing arbitrary key-values for handler.metadata
 	Metadata *string `gorm:"column:metadata" json:"metadata,omitempty"`
+	// RuleSetID selects the outbound rules (ExitRuleSet) of this exit
+	RuleSetID *int64 `gorm:"column:rule_set_id" json:"ruleSetId,omitempty"`
 }
 
 func (ExitSetting) TableName() string { return "exit_setting" }
@@ -248,6 +282,13 @@ type AnyTLSSetting struct {
 	Port       int   `gorm:"column:port" json:"port"`
 	Password   string `gorm:"column:password" json:"password"`
 	BaseUserID *int64 `gorm:"column:base_user_id" json:"baseUserId,omitempty"`
+	// fallback for clients failing authentication: static (default) | proxy | close
+	DecoyMode string `gorm:"column:decoy_mode;type:varchar(16)" json:"decoyMode"`
+	DecoyURL  string `gorm:"column:decoy_url;type:varchar(512)" json:"decoyUrl"`
+	// RuleSetID selects the outbound rules (ExitRuleSet) of this exit
+	RuleSetID *int64 `gorm:"column:rule_set_id" json:"ruleSetId,omitempty"`
+	// PaddingScheme of the primary listener; empty: the AnyTLS default
+	PaddingScheme string `gorm:"column:padding_scheme;type:text" json:"paddingScheme,omitempty"`
 }
 
 func (AnyTLSSetting) TableName() string { return "anytls_setting" }
@@ -264,7 +305,8 @@ type ExitNodeExternal struct {
 
 func (ExitNodeExternal) TableName() string { return "exit_node_external" }
 
-// ProbeTarget: global list of IPs to ping
+// ProbeTarget: probe target executed by agents.
+// Type: icmp | tcp | http | dns. IP is the host for icmp/tcp and the resolver for dns.
 type ProbeTarget struct {
 	ID          int64  `gorm:"primaryKey;column:id" json:"id"`
 	CreatedTime int64  `gorm:"column:created_time" json:"createdTime"`
@@ -272,6 +314,22 @@ type ProbeTarget struct {
 	Status      int    `gorm:"column:status" json:"status"`
 	Name        string `gorm:"column:name" json:"name"`
 	IP          string `gorm:"column:ip" json:"ip"`
+	Type        string `gorm:"column:probe_type;type:varchar(16);default:icmp" json:"type"`
+	Port        int    `gorm:"column:port" json:"port,omitempty"`
+	URL         string `gorm:"column:url;type:varchar(512)" json:"url,omitempty"`
+	// http: expected status code (0 = any 2xx/3xx) and body substring
+	ExpectStatus int    `gorm:"column:expect_status" json:"expectStatus,omitempty"`
+	ExpectBody   string `gorm:"column:expect_body;type:varchar(255)" json:"expectBody,omitempty"`
+	// dns: query name and record type (A/AAAA)
+	DNSName     string `gorm:"column:dns_name;type:varchar(255)" json:"dnsName,omitempty"`
+	DNSType     string `gorm:"column:dns_type;type:varchar(8)" json:"dnsType,omitempty"`
+	Count       int    `gorm:"column:count" json:"count"`
+	IntervalSec int    `gorm:"column:interval_sec" json:"intervalSec"`
+	TimeoutMs   int    `gorm:"column:timeout_ms" json:"timeoutMs"`
+	// JSON array of node ids; empty means all nodes
+	NodeIDs *string `gorm:"column:node_ids;type:text" json:"nodeIds,omitempty"`
+	// node group; members are added to NodeIDs
+	GroupID *int64 `gorm:"column:group_id" json:"groupId,omitempty"`
 }
 
 func (ProbeTarget) TableName() string { return "probe_target" }
@@ -284,10 +342,31 @@ type NodeProbeResult struct {
 	RTTMs    int   `gorm:"column:rtt_ms" json:"rttMs"`
 	OK       int   `gorm:"column:ok" json:"ok"` // 1 ok, 0 fail
 	TimeMs   int64 `gorm:"column:time_ms" json:"timeMs"`
+	// loss percentage over the probe's packet count, RTT jitter and failure reason
+	LossPct  float64 `gorm:"column:loss_pct" json:"lossPct"`
+	JitterMs float64 `gorm:"column:jitter_ms" json:"jitterMs"`
+	Error    string  `gorm:"column:error_text;type:varchar(255)" json:"error,omitempty"`
 }
 
 func (NodeProbeResult) TableName() string { return "node_probe_result" }
 
+// NodeMeshResult: node-to-node latency sample. Path is "public" or "overlay" (EasyTier).
+type NodeMeshResult struct {
+	ID        int64   `gorm:"primaryKey;column:id" json:"id"`
+	SrcNodeID int64   `gorm:"column:src_node_id;index:idx_mesh_pair_time" json:"srcNodeId"`
+	DstNodeID int64   `gorm:"column:dst_node_id;index:idx_mesh_pair_time" json:"dstNodeId"`
+	Path      string  `gorm:"column:path;type:varchar(16)" json:"path"`
+	IP        string  `gorm:"column:ip;type:varchar(64)" json:"ip"`
+	RTTMs     int     `gorm:"column:rtt_ms" json:"rttMs"`
+	OK        int     `gorm:"column:ok" json:"ok"`
+	LossPct   float64 `gorm:"column:loss_pct" json:"lossPct"`
+	JitterMs  float64 `gorm:"column:jitter_ms" json:"jitterMs"`
+	Error     string  `gorm:"column:error_text;type:varchar(255)" json:"error,omitempty"`
+	TimeMs    int64   `gorm:"column:time_ms;index:idx_mesh_pair_time" json:"timeMs"`
+}
+
+func (NodeMeshResult) TableName() string { return "node_mesh_result" }
+
 // NodeDisconnectLog: records node offline/online durations
 type NodeDisconnectLog struct {
 	ID        int64  `gorm:"primaryKey;column:id" json:"id"`
@@ -0,0 +1,27 @@
+package model
+
+// NativeExit is a VLESS, Trojan or Hysteria2 inbound served by flux-agent
+// (through the embedded sing-box) on an exit node. Users assigned to the node
+// authenticate with credentials derived from Secret; VLESS may use Reality
+// instead of the node certificate.
+type NativeExit struct {
+	ID                int64  `gorm:"primaryKey;column:id" json:"id"`
+	NodeID            int64  `gorm:"column:node_id;index" json:"nodeId"`
+	Name              string `gorm:"column:name;type:varchar(64)" json:"name"`
+	Protocol          string `gorm:"column:protocol;type:varchar(16)" json:"protocol"` // vless | trojan | hysteria2
+	Port              int    `gorm:"column:port" json:"port"`
+	Secret            string `gorm:"column:secret;type:varchar(255)" json:"secret"` // base UUID (vless) or password
+	Flow              string `gorm:"column:flow;type:varchar(32)" json:"flow"`
+	SNI               string `gorm:"column:sni;type:varchar(255)" json:"sni"`
+	RealityHandshake  string `gorm:"column:reality_handshake;type:varchar(255)" json:"realityHandshake"` // host:port; empty: no Reality
+	RealityPrivateKey string `gorm:"column:reality_private_key;type:varchar(64)" json:"-"`
+	RealityPublicKey  string `gorm:"column:reality_public_key;type:varchar(64)" json:"realityPublicKey"`
+	RealityShortID    string `gorm:"column:reality_short_id;type:varchar(16)" json:"realityShortId"`
+	ObfsPassword      string `gorm:"column:obfs_password;type:varchar(255)" json:"obfsPassword"`
+	UpMbps            int    `gorm:"column:up_mbps" json:"upMbps"`
+	DownMbps          int    `gorm:"column:down_mbps" json:"downMbps"`
+	CreatedTime       int64  `gorm:"column:created_time" json:"createdTime"`
+	UpdatedTime       int64  `gorm:"column:updated_time" json:"updatedTime"`
+}
+
+func (NativeExit) TableName() string { return "native_exit" }
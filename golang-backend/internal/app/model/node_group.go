@@ -0,0 +1,26 @@
+package model
+
+// NodeLabel tags a node with a key/value pair. Well-known keys are region,
+// provider and role (entry | relay | exit); other keys are free-form.
+type NodeLabel struct {
+	ID     int64  `gorm:"primaryKey;column:id" json:"-"`
+	NodeID int64  `gorm:"column:node_id;uniqueIndex:idx_node_label_key" json:"nodeId"`
+	Key    string `gorm:"column:label_key;type:varchar(32);uniqueIndex:idx_node_label_key" json:"key"`
+	Value  string `gorm:"column:label_value;type:varchar(64)" json:"value"`
+}
+
+func (NodeLabel) TableName() string { return "node_label" }
+
+// NodeGroup is a named set of nodes: the listed members plus, when Selector
+// is set, every node carrying all of its labels.
+type NodeGroup struct {
+	ID          int64  `gorm:"primaryKey;column:id" json:"id"`
+	Name        string `gorm:"column:name;type:varchar(64);uniqueIndex" json:"name"`
+	Description string `gorm:"column:description;type:varchar(255)" json:"description"`
+	NodeIDs     string `gorm:"column:node_ids;type:text" json:"nodeIds"`  // JSON array of node ids
+	Selector    string `gorm:"column:selector;type:text" json:"selector"` // JSON object label -> value
+	CreatedTime int64  `gorm:"column:created_time" json:"createdTime"`
+	UpdatedTime int64  `gorm:"column:updated_time" json:"updatedTime"`
+}
+
+func (NodeGroup) TableName() string { return "node_group" }
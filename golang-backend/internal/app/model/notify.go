@@ -0,0 +1,36 @@
+package model
+
+// NotifyChannel is a notification destination.
+// Type: webhook | telegram | smtp | bark | dingtalk | feishu | wecom | discord | slack.
+// Config is a type-specific JSON object; Events is a JSON array of event names
+// (trailing * allowed, empty = all events).
+type NotifyChannel struct {
+	ID          int64  `gorm:"primaryKey;column:id" json:"id"`
+	Name        string `gorm:"column:name;type:varchar(128)" json:"name"`
+	Type        string `gorm:"column:type;type:varchar(16)" json:"type"`
+	Config      string `gorm:"column:config;type:text" json:"config"`
+	Events      string `gorm:"column:events;type:text" json:"events"`
+	MinSeverity string `gorm:"column:min_severity;type:varchar(16)" json:"minSeverity"`
+	Enabled     bool   `gorm:"column:enabled" json:"enabled"`
+	CreatedTime int64  `gorm:"column:created_time" json:"createdTime"`
+	UpdatedTime int64  `gorm:"column:updated_time" json:"updatedTime"`
+}
+
+func (NotifyChannel) TableName() string { return "notify_channel" }
+
+// NotifyDelivery records one notification sent (or attempted) through a channel.
+type NotifyDelivery struct {
+	ID          int64  `gorm:"primaryKey;column:id" json:"id"`
+	ChannelID   int64  `gorm:"column:channel_id;index" json:"channelId"`
+	ChannelName string `gorm:"column:channel_name;type:varchar(128)" json:"channelName"`
+	ChannelType string `gorm:"column:channel_type;type:varchar(16)" json:"channelType"`
+	Event       string `gorm:"column:event;type:varchar(64)" json:"event"`
+	Title       string `gorm:"column:title;type:varchar(255)" json:"title"`
+	Status      string `gorm:"column:status;type:varchar(16)" json:"status"` // success | failed
+	Attempts    int    `gorm:"column:attempts" json:"attempts"`
+	Error       string `gorm:"column:error_text;type:varchar(512)" json:"error,omitempty"`
+	DurationMs  int64  `gorm:"column:duration_ms" json:"durationMs"`
+	TimeMs      int64  `gorm:"column:time_ms;index" json:"timeMs"`
+}
+
+func (NotifyDelivery) TableName() string { return "notify_delivery" }
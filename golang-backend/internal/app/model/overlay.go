@@ -0,0 +1,70 @@
+package model
+
+// OverlayAddress stores the EasyTier overlay IPv4 allocated to a node.
+// One row per node; Reserved marks addresses pinned manually by an admin.
+type OverlayAddress struct {
+	ID          int64  `gorm:"primaryKey;column:id" json:"id"`
+	NodeID      int64  `gorm:"column:node_id;uniqueIndex" json:"nodeId"`
+	IP          string `gorm:"column:ip;type:varchar(64);uniqueIndex" json:"ip"`
+	Reserved    bool   `gorm:"column:reserved" json:"reserved"`
+	Note        string `gorm:"column:note;type:varchar(255)" json:"note"`
+	CreatedTime int64  `gorm:"column:created_time" json:"createdTime"`
+	UpdatedTime int64  `gorm:"column:updated_time" json:"updatedTime"`
+}
+
+func (OverlayAddress) TableName() string { return "overlay_address" }
+
+// EasyTierPeerStat is one peer/route row reported by a member's easytier-cli.
+// PeerNodeID is resolved from the overlay address (0 when it is not a panel node).
+type EasyTierPeerStat struct {
+	ID          int64   `gorm:"primaryKey;column:id" json:"id"`
+	NodeID      int64   `gorm:"column:node_id;index:idx_etpeer_node_time" json:"nodeId"`
+	PeerNodeID  int64   `gorm:"column:peer_node_id" json:"peerNodeId"`
+	PeerIP      string  `gorm:"column:peer_ip;type:varchar(64)" json:"peerIp"`
+	Hostname    string  `gorm:"column:hostname;type:varchar(128)" json:"hostname"`
+	Direct      bool    `gorm:"column:direct" json:"direct"`
+	Cost        string  `gorm:"column:cost;type:varchar(32)" json:"cost"`
+	LatencyMs   float64 `gorm:"column:latency_ms" json:"latencyMs"`
+	LossPct     float64 `gorm:"column:loss_pct" json:"lossPct"`
+	RouteCost   int     `gorm:"column:route_cost" json:"routeCost"`
+	NextHopIP   string  `gorm:"column:next_hop_ip;type:varchar(64)" json:"nextHopIp"`
+	TunnelProto string  `gorm:"column:tunnel_proto;type:varchar(32)" json:"tunnelProto"`
+	NatType     string  `gorm:"column:nat_type;type:varchar(32)" json:"natType"`
+	TimeMs      int64   `gorm:"column:time_ms;index:idx_etpeer_node_time" json:"timeMs"`
+}
+
+func (EasyTierPeerStat) TableName() string { return "easytier_peer_stat" }
+
+// OverlayACLGroup is a named set of EasyTier members usable as ACL source/destination.
+type OverlayACLGroup struct {
+	ID          int64  `gorm:"primaryKey;column:id" json:"id"`
+	Name        string `gorm:"column:name;type:varchar(100)" json:"name"`
+	NodeIDs     string `gorm:"column:node_ids;type:text" json:"nodeIds"` // JSON array of node ids
+	CreatedTime int64  `gorm:"column:created_time" json:"createdTime"`
+	UpdatedTime int64  `gorm:"column:updated_time" json:"updatedTime"`
+}
+
+func (OverlayACLGroup) TableName() string { return "overlay_acl_group" }
+
+// OverlayACLRule filters overlay traffic between members. Rules are evaluated
+// by ascending priority (then id); the first match decides, otherwise the
+// network's default policy applies. Src/Dst type: any | node | group | cidr.
+type OverlayACLRule struct {
+	ID          int64  `gorm:"primaryKey;column:id" json:"id"`
+	Network     string `gorm:"column:network;type:varchar(64);index" json:"network"`
+	Name        string `gorm:"column:name;type:varchar(100)" json:"name"`
+	Priority    int    `gorm:"column:priority" json:"priority"`
+	Action      string `gorm:"column:action;type:varchar(16)" json:"action"` // allow | deny
+	SrcType     string `gorm:"column:src_type;type:varchar(16)" json:"srcType"`
+	SrcValue    string `gorm:"column:src_value;type:varchar(255)" json:"srcValue"`
+	DstType     string `gorm:"column:dst_type;type:varchar(16)" json:"dstType"`
+	DstValue    string `gorm:"column:dst_value;type:varchar(255)" json:"dstValue"`
+	Protocol    string `gorm:"column:protocol;type:varchar(16)" json:"protocol"` // any | tcp | udp | icmp
+	Ports       string `gorm:"column:ports;type:varchar(255)" json:"ports"`      // "22,80,8000-8100"
+	Enabled     bool   `gorm:"column:enabled" json:"enabled"`
+	Note        string `gorm:"column:note;type:varchar(255)" json:"note"`
+	CreatedTime int64  `gorm:"column:created_time" json:"createdTime"`
+	UpdatedTime int64  `gorm:"column:updated_time" json:"updatedTime"`
+}
+
+func (OverlayACLRule) TableName() string { return "overlay_acl_rule" }
@@ -0,0 +1,47 @@
+package model
+
+// GaugeRollup aggregates the samples of one gauge series within a time bucket.
+// Series: cpu | mem (node_sysinfo), probe_rtt | probe_loss | probe_up
+// (node_probe_result, RefID = probe target id). One table per resolution.
+type GaugeRollup struct {
+	ID       int64   `gorm:"primaryKey;column:id" json:"-"`
+	BucketMs int64   `gorm:"column:bucket_ms;uniqueIndex:,composite:bucket" json:"t"`
+	NodeID   int64   `gorm:"column:node_id;uniqueIndex:,composite:bucket" json:"nodeId"`
+	Series   string  `gorm:"column:series;type:varchar(16);uniqueIndex:,composite:bucket" json:"series"`
+	RefID    int64   `gorm:"column:ref_id;uniqueIndex:,composite:bucket" json:"refId"`
+	Count    int     `gorm:"column:count" json:"count"`
+	Min      float64 `gorm:"column:min_v" json:"min"`
+	Avg      float64 `gorm:"column:avg_v" json:"avg"`
+	Max      float64 `gorm:"column:max_v" json:"max"`
+	P95      float64 `gorm:"column:p95_v" json:"p95"`
+}
+
+type GaugeRollup5m struct{ GaugeRollup }
+type GaugeRollup1h struct{ GaugeRollup }
+type GaugeRollup1d struct{ GaugeRollup }
+
+func (GaugeRollup5m) TableName() string { return "rollup_gauge_5m" }
+func (GaugeRollup1h) TableName() string { return "rollup_gauge_1h" }
+func (GaugeRollup1d) TableName() string { return "rollup_gauge_1d" }
+
+// TrafficRollup sums traffic within a time bucket.
+// Kind: user (flow_timeseries, RefID = user id) | node (positive deltas of the
+// node_sysinfo interface counters, RefID = node id).
+type TrafficRollup struct {
+	ID          int64  `gorm:"primaryKey;column:id" json:"-"`
+	BucketMs    int64  `gorm:"column:bucket_ms;uniqueIndex:,composite:bucket" json:"t"`
+	Kind        string `gorm:"column:kind;type:varchar(8);uniqueIndex:,composite:bucket" json:"kind"`
+	RefID       int64  `gorm:"column:ref_id;uniqueIndex:,composite:bucket" json:"refId"`
+	InBytes     int64  `gorm:"column:in_bytes" json:"inBytes"`
+	OutBytes    int64  `gorm:"column:out_bytes" json:"outBytes"`
+	BilledBytes int64  `gorm:"column:billed_bytes" json:"billedBytes"`
+	Samples     int    `gorm:"column:samples" json:"samples"`
+}
+
+type TrafficRollup5m struct{ TrafficRollup }
+type TrafficRollup1h struct{ TrafficRollup }
+type TrafficRollup1d struct{ TrafficRollup }
+
+func (TrafficRollup5m) TableName() string { return "rollup_traffic_5m" }
+func (TrafficRollup1h) TableName() string { return "rollup_traffic_1h" }
+func (TrafficRollup1d) TableName() string { return "rollup_traffic_1d" }
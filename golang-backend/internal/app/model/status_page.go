@@ -0,0 +1,35 @@
+package model
+
+// StatusPageItem publishes one node or tunnel on the public status page under
+// a display name; nothing else about the object (ip, ports, ids) is exposed.
+type StatusPageItem struct {
+	ID          int64  `gorm:"primaryKey;column:id" json:"id"`
+	Kind        string `gorm:"column:kind;type:varchar(16);uniqueIndex:idx_status_item_ref" json:"kind"` // node | tunnel
+	RefID       int64  `gorm:"column:ref_id;uniqueIndex:idx_status_item_ref" json:"refId"`
+	DisplayName string `gorm:"column:display_name;type:varchar(128)" json:"displayName"`
+	Description string `gorm:"column:description;type:varchar(255)" json:"description"`
+	GroupName   string `gorm:"column:group_name;type:varchar(64)" json:"group"`
+	SortOrder   int    `gorm:"column:sort_order" json:"sortOrder"`
+	CreatedTime int64  `gorm:"column:created_time" json:"createdTime"`
+	UpdatedTime int64  `gorm:"column:updated_time" json:"updatedTime"`
+}
+
+func (StatusPageItem) TableName() string { return "status_page_item" }
+
+// StatusNotice is an admin-posted notice. Maintenance windows affecting an
+// item are excluded from its uptime. EndMs 0 means open-ended.
+type StatusNotice struct {
+	ID      int64  `gorm:"primaryKey;column:id" json:"id"`
+	Type    string `gorm:"column:type;type:varchar(16)" json:"type"` // maintenance | incident | info
+	Title   string `gorm:"column:title;type:varchar(128)" json:"title"`
+	Content string `gorm:"column:content;type:text" json:"content"`
+	// JSON array of status item ids; empty means all items
+	ItemIDs     *string `gorm:"column:item_ids;type:text" json:"itemIds,omitempty"`
+	StartMs     int64   `gorm:"column:start_ms;index" json:"startMs"`
+	EndMs       int64   `gorm:"column:end_ms" json:"endMs"`
+	CreatedBy   int64   `gorm:"column:created_by" json:"createdBy"`
+	CreatedTime int64   `gorm:"column:created_time" json:"createdTime"`
+	UpdatedTime int64   `gorm:"column:updated_time" json:"updatedTime"`
+}
+
+func (StatusNotice) TableName() string { return "status_notice" }
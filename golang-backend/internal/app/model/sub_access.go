@@ -0,0 +1,16 @@
+package model
+
+// SubAccessLog records one subscription fetch. TokenID is 0 when the caller
+// used a login JWT instead of a subscription token.
+type SubAccessLog struct {
+	ID        int64  `gorm:"primaryKey;column:id" json:"id"`
+	UserID    int64  `gorm:"column:user_id;index:idx_sub_access_user_time" json:"userId"`
+	TokenID   int64  `gorm:"column:token_id" json:"tokenId"`
+	Format    string `gorm:"column:format;type:varchar(16)" json:"format"`
+	UserAgent string `gorm:"column:user_agent;type:varchar(255)" json:"userAgent"`
+	IP        string `gorm:"column:ip;type:varchar(64)" json:"ip"`
+	Country   string `gorm:"column:country;type:varchar(8)" json:"country"`
+	TimeMs    int64  `gorm:"column:time_ms;index:idx_sub_access_user_time;index" json:"timeMs"`
+}
+
+func (SubAccessLog) TableName() string { return "sub_access_log" }
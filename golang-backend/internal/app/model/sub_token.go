@@ -0,0 +1,17 @@
+package model
+
+// SubToken is a read-only credential for the subscription endpoints. It is
+// independent of the login JWT, never expires and can be reset per device.
+// Only its SHA-256 is stored; the token itself is shown once when issued.
+type SubToken struct {
+	ID           int64  `gorm:"primaryKey;column:id" json:"id"`
+	UserID       int64  `gorm:"column:user_id;index" json:"userId"`
+	Token        string `gorm:"column:token;type:varchar(64);uniqueIndex" json:"-"` // hex SHA-256
+	Hint         string `gorm:"column:hint;type:varchar(16)" json:"hint"`           // first characters, to tell tokens apart
+	Label        string `gorm:"column:label;type:varchar(64)" json:"label"`
+	LastUsedTime int64  `gorm:"column:last_used_time" json:"lastUsedTime"`
+	CreatedTime  int64  `gorm:"column:created_time" json:"createdTime"`
+	UpdatedTime  int64  `gorm:"column:updated_time" json:"updatedTime"`
+}
+
+func (SubToken) TableName() string { return "sub_token" }
@@ -0,0 +1,33 @@
+package model
+
+// UserNotifyContact is a user-owned destination for quota/expiry reminders.
+// Type: email | telegram | webhook; Target is the address, chat ID or URL.
+type UserNotifyContact struct {
+	ID          int64  `gorm:"primaryKey;column:id" json:"id"`
+	UserID      int64  `gorm:"column:user_id;index" json:"userId"`
+	Type        string `gorm:"column:type;type:varchar(16)" json:"type"`
+	Target      string `gorm:"column:target;type:varchar(512)" json:"target"`
+	Enabled     bool   `gorm:"column:enabled" json:"enabled"`
+	CreatedTime int64  `gorm:"column:created_time" json:"createdTime"`
+	UpdatedTime int64  `gorm:"column:updated_time" json:"updatedTime"`
+}
+
+func (UserNotifyContact) TableName() string { return "user_notify_contact" }
+
+// UserNotifySent marks a threshold that already fired in a billing cycle so it
+// is sent only once. Scope: user | tunnel | node (quota) or user_exp |
+// tunnel_exp | node_exp (expiry); RefID is the user_tunnel / user_node id (or
+// user id); Threshold is a percent for quota scopes and days for expiry scopes.
+// Scope sub_ips flags a subscription token fetched from too many IPs in a day:
+// RefID is the sub_token id (0 for login JWTs) and Cycle the date.
+type UserNotifySent struct {
+	ID        int64  `gorm:"primaryKey;column:id" json:"id"`
+	UserID    int64  `gorm:"column:user_id;uniqueIndex:idx_user_notify_sent" json:"userId"`
+	Scope     string `gorm:"column:scope;type:varchar(16);uniqueIndex:idx_user_notify_sent" json:"scope"`
+	RefID     int64  `gorm:"column:ref_id;uniqueIndex:idx_user_notify_sent" json:"refId"`
+	Threshold int    `gorm:"column:threshold;uniqueIndex:idx_user_notify_sent" json:"threshold"`
+	Cycle     string `gorm:"column:cycle;type:varchar(32);uniqueIndex:idx_user_notify_sent" json:"cycle"`
+	TimeMs    int64  `gorm:"column:time_ms" json:"timeMs"`
+}
+
+func (UserNotifySent) TableName() string { return "user_notify_sent" }
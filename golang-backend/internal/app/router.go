@@ -16,6 +16,7 @@ func RegisterRoutes(r *gin.Engine) {
 	r.Use(middleware.CORS())
 	// health
 	r.GET("/health", func(c *gin.Context) { c.String(200, "ok") })
+	r.GET("/metrics", controller.Metrics)
 	// serve install script for nodes
 	r.GET("/install.sh", controller.InstallScript)
 	// serve easytier installer and templates
@@ -56,6 +57,7 @@ func RegisterRoutes(r *gin.Engine) {
 		conf.POST("/get", controller.ConfigGet)
 		conf.POST("/update", middleware.RequireRole(), controller.ConfigUpdate)
 		conf.POST("/update-single", middleware.RequireRole(), controller.ConfigUpdateSingle)
+		conf.POST("/apply", middleware.RequireRole(), controller.ConfigApply)
 	}
 
 	// user
@@ -66,6 +68,36 @@ func RegisterRoutes(r *gin.Engine) {
 		user.POST("/package", middleware.AuthOptional(), controller.UserPackage)
 		user.POST("/updatePassword", middleware.Auth(), controller.UserUpdatePassword)
 
+		// own quota/expiry reminder contacts
+		userNotify := user.Group("/notify/contacts")
+		userNotify.Use(middleware.Auth())
+		{
+			userNotify.POST("/list", controller.UserNotifyContactList)
+			userNotify.POST("/create", controller.UserNotifyContactCreate)
+			userNotify.POST("/update", controller.UserNotifyContactUpdate)
+			userNotify.POST("/delete", controller.UserNotifyContactDelete)
+			userNotify.POST("/test", controller.UserNotifyContactTest)
+		}
+
+		// own read-only subscription tokens (admins may manage any user's)
+		subTokens := user.Group("/sub-tokens")
+		subTokens.Use(middleware.Auth())
+		{
+			subTokens.POST("/list", controller.SubTokenList)
+			subTokens.POST("/create", controller.SubTokenCreate)
+			subTokens.POST("/update", controller.SubTokenUpdate)
+			subTokens.POST("/reset", controller.SubTokenReset)
+			subTokens.POST("/delete", controller.SubTokenDelete)
+		}
+
+		// subscription fetch history (own; admins may see all users)
+		subAccess := user.Group("/sub-access")
+		subAccess.Use(middleware.Auth())
+		{
+			subAccess.POST("/list", controller.SubAccessList)
+			subAccess.POST("/settings", middleware.RequireRole(), controller.SubAccessSettingsAPI)
+		}
+
 		userAdmin := user.Group("")
 		userAdmin.Use(middleware.RequireRole())
 		{
@@ -81,6 +113,7 @@ func RegisterRoutes(r *gin.Engine) {
 	api.Any("/flow/upload", controller.FlowUpload)
 	api.Any("/flow/anytls", controller.FlowAnyTLSUpload)
 	api.Any("/flow/exit", controller.FlowExitUpload)
+	api.POST("/flow/batch", controller.FlowBatchUpload)
 
 	// node
 	// all users: see permitted nodes for forwarding
@@ -125,10 +158,39 @@ func RegisterRoutes(r *gin.Engine) {
 		nodeAdm.Use(middleware.RequireRole())
 		{
 			nodeAdm.POST("/user/assign", controller.NodeUserAssign)
+			// node-to-node latency mesh
+			nodeAdm.POST("/mesh/matrix", controller.NodeMeshMatrix)
+			nodeAdm.POST("/mesh/history", controller.NodeMeshHistory)
+			nodeAdm.POST("/mesh/settings", controller.NodeMeshSettings)
 			nodeAdm.POST("/user/list", controller.NodeUserList)
 			nodeAdm.POST("/user/usage", controller.NodeUserUsageByNode)
 			nodeAdm.POST("/user/remove", controller.NodeUserRemove)
 			nodeAdm.POST("/user/update", controller.NodeUserUpdate)
+			nodeAdm.POST("/user/anytls-violations", controller.NodeUserAnyTLSViolations)
+			// exit outbound rule sets (AnyTLS / SS exits)
+			nodeAdm.POST("/exit-rules/list", controller.ExitRuleSetList)
+			nodeAdm.POST("/exit-rules/create", controller.ExitRuleSetCreate)
+			nodeAdm.POST("/exit-rules/update", controller.ExitRuleSetUpdate)
+			nodeAdm.POST("/exit-rules/delete", controller.ExitRuleSetDelete)
+			nodeAdm.POST("/exit-cert/get", controller.NodeExitCertGet)
+			nodeAdm.POST("/exit-cert/set", controller.NodeExitCertSet)
+			nodeAdm.POST("/anytls-inbound/list", controller.AnyTLSInboundList)
+			nodeAdm.POST("/anytls-inbound/create", controller.AnyTLSInboundCreate)
+			nodeAdm.POST("/anytls-inbound/update", controller.AnyTLSInboundUpdate)
+			nodeAdm.POST("/anytls-inbound/delete", controller.AnyTLSInboundDelete)
+			nodeAdm.POST("/native-exit/list", controller.NativeExitList)
+			nodeAdm.POST("/native-exit/create", controller.NativeExitCreate)
+			nodeAdm.POST("/native-exit/update", controller.NativeExitUpdate)
+			nodeAdm.POST("/native-exit/delete", controller.NativeExitDelete)
+			// node groups / labels and group-wide operations
+			nodeAdm.POST("/groups/list", controller.NodeGroupList)
+			nodeAdm.POST("/groups/create", controller.NodeGroupCreate)
+			nodeAdm.POST("/groups/update", controller.NodeGroupUpdate)
+			nodeAdm.POST("/groups/delete", controller.NodeGroupDelete)
+			nodeAdm.POST("/labels/set", controller.NodeLabelSet)
+			nodeAdm.POST("/labels/bulk", controller.NodeLabelBulk)
+			nodeAdm.POST("/labels/keys", controller.NodeLabelKeys)
+			nodeAdm.POST("/bulk", controller.NodeBulkAction)
 		}
 	}
 	// Terminal WS: 自带 token/admin 校验，不使用 Auth 中间件
@@ -237,6 +299,16 @@ func RegisterRoutes(r *gin.Engine) {
 	{
 		stats.POST("/heartbeat", controller.HeartbeatReport)
 		stats.GET("/heartbeat/summary", middleware.RequireRole(), controller.HeartbeatSummary)
+		stats.POST("/metrics-token", middleware.RequireRole(), controller.MetricsToken)
+	}
+
+	// time-series rollups (5m/1h/1d)
+	rollup := api.Group("/rollup")
+	rollup.Use(middleware.Auth())
+	{
+		rollup.POST("/gauge", middleware.RequireRole(), controller.RollupGauge)
+		rollup.POST("/traffic", controller.RollupTraffic)
+		rollup.POST("/settings", middleware.RequireRole(), controller.RollupSettings)
 	}
 
 	// version
@@ -252,6 +324,25 @@ func RegisterRoutes(r *gin.Engine) {
 		share.POST("/network-stats", controller.ShareNetworkStats)
 	}
 
+	// public status page (read-only) and its admin side
+	status := api.Group("/status")
+	{
+		status.POST("/page", controller.StatusPage)
+		status.POST("/item", controller.StatusItem)
+		status.POST("/incidents", controller.StatusIncidents)
+	}
+	statusAdm := api.Group("/status/admin")
+	statusAdm.Use(middleware.RequireRole())
+	{
+		statusAdm.POST("/items", controller.StatusItemList)
+		statusAdm.POST("/items/save", controller.StatusItemSave)
+		statusAdm.POST("/items/delete", controller.StatusItemDelete)
+		statusAdm.POST("/notices", controller.StatusNoticeList)
+		statusAdm.POST("/notices/save", controller.StatusNoticeSave)
+		statusAdm.POST("/notices/delete", controller.StatusNoticeDelete)
+		statusAdm.POST("/settings", controller.StatusPageSettings)
+	}
+
 	// migrate (admin only)
 	api.POST("/migrate", middleware.RequireRole(), controller.MigrateFrom)
 	api.POST("/migrate/test", middleware.RequireRole(), controller.MigrateTest)
@@ -264,10 +355,37 @@ func RegisterRoutes(r *gin.Engine) {
 	r.Any("/flow/upload", controller.FlowUpload)
 	r.Any("/flow/anytls", controller.FlowAnyTLSUpload)
 	r.Any("/flow/exit", controller.FlowExitUpload)
+	r.POST("/flow/batch", controller.FlowBatchUpload)
 	// limiter plugin endpoint for gost HTTP plugin data source
 	r.POST("/plugin/limiter", controller.LimiterPlugin)
 	// alerts
 	api.POST("/alerts/recent", middleware.RequireRole(), controller.AlertsRecent)
+	alerts := api.Group("/alerts")
+	alerts.Use(middleware.RequireRole())
+	{
+		alerts.POST("/rules/list", controller.AlertRuleList)
+		alerts.POST("/rules/create", controller.AlertRuleCreate)
+		alerts.POST("/rules/update", controller.AlertRuleUpdate)
+		alerts.POST("/rules/delete", controller.AlertRuleDelete)
+		alerts.POST("/incidents", controller.AlertIncidentList)
+		alerts.POST("/incidents/ack", controller.AlertIncidentAck)
+		alerts.POST("/silences/list", controller.AlertSilenceList)
+		alerts.POST("/silences/create", controller.AlertSilenceCreate)
+		alerts.POST("/silences/delete", controller.AlertSilenceDelete)
+	}
+
+	// notification channels (admin)
+	notify := api.Group("/notify")
+	notify.Use(middleware.RequireRole())
+	{
+		notify.POST("/channels/list", controller.NotifyChannelList)
+		notify.POST("/channels/create", controller.NotifyChannelCreate)
+		notify.POST("/channels/update", controller.NotifyChannelUpdate)
+		notify.POST("/channels/delete", controller.NotifyChannelDelete)
+		notify.POST("/channels/test", controller.NotifyChannelTest)
+		notify.POST("/deliveries", controller.NotifyDeliveryList)
+		notify.POST("/user/settings", controller.UserNotifySettings)
+	}
 
 	// probe targets (admin)
 	probe := api.Group("/probe")
@@ -308,6 +426,11 @@ func RegisterRoutes(r *gin.Engine) {
 		agent.POST("/reconcile-node", middleware.RequireRole(), controller.AgentReconcileNode)
 		agent.POST("/probe-targets", controller.AgentProbeTargets)
 		agent.POST("/report-probe", controller.AgentReportProbe)
+		agent.POST("/mesh-targets", controller.AgentMeshTargets)
+		agent.POST("/report-mesh", controller.AgentReportMesh)
+		agent.POST("/report-easytier-peers", controller.AgentReportEasyTierPeers)
+		agent.POST("/report-anytls-violations", controller.AgentReportAnyTLSViolations)
+		agent.POST("/report-cert", controller.AgentReportCert)
 	}
 	// easytier stream from agent (secret-auth)
 	api.POST("/easytier/stream", controller.EasyTierStreamPush)
@@ -333,6 +456,25 @@ func RegisterRoutes(r *gin.Engine) {
 		easy.POST("/log", controller.EasyTierLog)
 		easy.GET("/log/stream", controller.EasyTierLogStream)
 		easy.GET("/ghproxy/*path", controller.EasyTierProxy)
+		// overlay address management
+		easy.POST("/ipam/list", controller.EasyTierIPAMList)
+		easy.POST("/ipam/cidr", controller.EasyTierIPAMSetCIDR)
+		easy.POST("/ipam/reserve", controller.EasyTierIPAMReserve)
+		easy.POST("/ipam/unreserve", controller.EasyTierIPAMUnreserve)
+		easy.POST("/ipam/conflicts", controller.EasyTierIPAMConflicts)
+		easy.POST("/peers", controller.EasyTierPeers)
+		easy.POST("/peers/history", controller.EasyTierPeerHistory)
+		easy.POST("/health", controller.EasyTierHealth)
+		easy.POST("/acl/list", controller.EasyTierACLList)
+		easy.POST("/acl/settings", controller.EasyTierACLSettings)
+		easy.POST("/acl/create", controller.EasyTierACLCreate)
+		easy.POST("/acl/update", controller.EasyTierACLUpdate)
+		easy.POST("/acl/delete", controller.EasyTierACLDelete)
+		easy.POST("/acl/group/save", controller.EasyTierACLGroupSave)
+		easy.POST("/acl/group/delete", controller.EasyTierACLGroupDelete)
+		easy.POST("/acl/evaluate", controller.EasyTierACLEvaluate)
+		easy.POST("/acl/preview", controller.EasyTierACLPreview)
+		easy.POST("/acl/apply", controller.EasyTierACLApply)
 	}
 
 	// swagger (serve doc.json + simple CDN-based UI)
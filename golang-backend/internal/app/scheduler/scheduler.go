@@ -24,6 +24,9 @@ func Start() {
 	go controllerHeartbeat()
 	go pruneOldData()
 	controller.StartNodeOfflineMonitor()
+	controller.StartAlertEngine()
+	controller.StartUserNotifier()
+	controller.StartRollupJob()
 }
 
 func billingChecker() {
@@ -199,16 +202,50 @@ func pruneOldData() {
 	clean := func(table any, col string) {
 		_ = dbpkg.DB.Where(col+" < ?", cutoff()).Delete(table).Error
 	}
+	// raw series feeding the rollups are only dropped once they are rolled up
+	cleanRolledUp := func(table any) {
+		cut := cutoff()
+		if wm := controller.RollupWatermark(); wm < cut {
+			cut = wm
+		}
+		_ = dbpkg.DB.Where("time_ms < ?", cut).Delete(table).Error
+	}
 	for {
+		controller.RunRollups()
 		clean(&model.NodeOpLog{}, "time_ms")
-		clean(&model.NodeProbeResult{}, "time_ms")
-		clean(&model.NodeSysInfo{}, "time_ms")
-		clean(&model.FlowTimeseries{}, "time_ms")
+		cleanRolledUp(&model.NodeProbeResult{})
+		cleanRolledUp(&model.NodeMeshResult{})
+		cleanRolledUp(&model.NodeSysInfo{})
+		cleanRolledUp(&model.FlowTimeseries{})
+		// NodeQuality output is a text report, not a series; it is not rolled up
 		clean(&model.NQResult{}, "time_ms")
+		cleanRolledUp(&model.EasyTierPeerStat{})
+		clean(&model.NotifyDelivery{}, "time_ms")
+		clean(&model.AnyTLSViolation{}, "time_ms")
+		// event history: closed entries only, kept event_retention_days (default 400)
+		evCut := time.Now().AddDate(0, 0, -getEventRetentionDays()).UnixMilli()
+		_ = dbpkg.DB.Where("status = ? AND resolved_ms < ?", "resolved", evCut).Delete(&model.AlertIncident{}).Error
+		_ = dbpkg.DB.Where("up_at_ms IS NOT NULL AND up_at_ms < ?", evCut).Delete(&model.NodeDisconnectLog{}).Error
+		// cycle "-" marks quotas that never reset; those records must stay to suppress repeats
+		_ = dbpkg.DB.Where("cycle <> ? AND time_ms < ?", "-", evCut).Delete(&model.UserNotifySent{}).Error
+		// subscription access history has its own retention (sub_access_retention_days)
+		_ = dbpkg.DB.Where("time_ms < ?", controller.SubAccessCutoff()).Delete(&model.SubAccessLog{}).Error
 		<-ticker.C
 	}
 }
 
+// getEventRetentionDays covers at least a year so yearly SLA views and
+// expiry reminders (up to 365 days ahead) are not affected.
+func getEventRetentionDays() int {
+	var cfg model.ViteConfig
+	if err := dbpkg.DB.Where("name = ?", "event_retention_days").First(&cfg).Error; err == nil {
+		if n, err := strconv.Atoi(strings.TrimSpace(cfg.Value)); err == nil && n >= 30 {
+			return n
+		}
+	}
+	return 400
+}
+
 func getPruneWindowHours() int {
 	if v := strings.TrimSpace(os.Getenv("PRUNE_HOURS")); v != "" {
 		if n, err := strconv.Atoi(v); err == nil && n > 0 {
@@ -105,17 +105,47 @@ func Init() error {
 		&model.ExitNodeExternal{},
 		&model.ProbeTarget{},
 		&model.NodeProbeResult{},
+		&model.NodeMeshResult{},
 		&model.NodeDisconnectLog{},
 		&model.Alert{},
+		&model.AlertRule{},
+		&model.AlertIncident{},
+		&model.AlertSilence{},
+		&model.NotifyChannel{},
+		&model.NotifyDelivery{},
+		&model.UserNotifyContact{},
+		&model.UserNotifySent{},
+		&model.GaugeRollup5m{},
+		&model.GaugeRollup1h{},
+		&model.GaugeRollup1d{},
+		&model.TrafficRollup5m{},
+		&model.TrafficRollup1h{},
+		&model.TrafficRollup1d{},
+		&model.StatusPageItem{},
+		&model.StatusNotice{},
+		&model.NodeLabel{},
+		&model.NodeGroup{},
 		&model.NodeSysInfo{},
 		&model.NodeRuntime{},
 		&model.NodeOpLog{},
 		&model.ForwardMidPort{},
 		&model.HeartbeatRecord{},
 		&model.FlowTimeseries{},
+		&model.FlowReportSeq{},
+		&model.AnyTLSViolation{},
+		&model.ExitRuleSet{},
+		&model.ExitCert{},
+		&model.AnyTLSInbound{},
+		&model.NativeExit{},
+		&model.SubToken{},
+		&model.SubAccessLog{},
 		&model.EasyTierResult{},
 		&model.NQResult{},
 		&model.NodeDiagResult{},
+		&model.OverlayAddress{},
+		&model.EasyTierPeerStat{},
+		&model.OverlayACLGroup{},
+		&model.OverlayACLRule{},
 	); err != nil {
 		return err
 	}
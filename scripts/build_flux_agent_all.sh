@@ -83,7 +83,7 @@ build_one() {
   fi
   (cd "$ROOT_DIR" && \
     env CGO_ENABLED=0 GOOS="$os" ${envs[@]} \
-    go build -trimpath -buildvcs=false -ldflags "${ldflags[*]}" -o "$OUT_DIR/$out_name$ext" "$MAIN_PKG")
+    go build -trimpath -tags with_utls -buildvcs=false -ldflags "${ldflags[*]}" -o "$OUT_DIR/$out_name$ext" "$MAIN_PKG")
 
   # Also produce agent2 variant (same binary, different name triggers agent2 role by argv0)
   local out_name2="flux-agent2-${os}-${out_arch}"